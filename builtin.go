@@ -1,9 +1,12 @@
 package main
 
 import (
+	_ "github.com/CN-TU/go-flows/modules/dissectors/dns"
+	_ "github.com/CN-TU/go-flows/modules/dissectors/quic"
 	_ "github.com/CN-TU/go-flows/modules/exporters/csv"
 	_ "github.com/CN-TU/go-flows/modules/exporters/ipfix"
 	_ "github.com/CN-TU/go-flows/modules/exporters/null"
+	_ "github.com/CN-TU/go-flows/modules/features/control"
 	_ "github.com/CN-TU/go-flows/modules/features/custom"
 	_ "github.com/CN-TU/go-flows/modules/features/iana"
 	_ "github.com/CN-TU/go-flows/modules/features/nta"
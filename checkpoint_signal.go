@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyCheckpoint relays checkpoint requests (SIGUSR1) to c
+func notifyCheckpoint(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// notifyCheckpoint does nothing, since there is no SIGUSR1 on windows. Use -checkpointInterval instead.
+func notifyCheckpoint(c chan<- os.Signal) {
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+
+	"github.com/CN-TU/go-flows/packet"
+)
+
+func init() {
+	addCommand("dissectors", "List available application layer dissectors", listDissectors)
+}
+
+func listDissectors(string, []string) {
+	packet.ListDissectors(os.Stdout)
+}
@@ -68,6 +68,53 @@ onto the different "table"-pipelines according to flow-key.
 
 WARNING: Due to this concurrent processing flow output is neither order nor deterministic (without sorting)!
 To ensure deterministic output, flow output can be order by start time, stop time (default), or export time.
+Additionally, -deterministic uses the packet number of the first packet as flowId instead of a per table counter. This
+results in identical output regardless of the number of tables.
+Sorting might hold back the output for a long time (e.g. a long lasting flow in case of start time). With -watermark,
+records are released as soon as no earlier record can arrive, and -maxSortDelay limits the time an active flow can hold
+back the output. Records released due to this limit are not sorted anymore.
+
+Long running jobs can be checkpointed with -checkpoint file. A checkpoint containing the active flows, the feature
+state, the timers, and the position in the sources is written on SIGUSR1, every -checkpointInterval, and on interrupt,
+which also stops processing. The job can then be continued with -resume file, which needs the same specification,
+sources, and arguments. Records exported before the checkpoint are not exported again; therefore, the output of the
+resumed run must be appended to the previous one. Sorting only applies to records within one run. Live captures just
+continue capturing and -expireWindow can't be used with checkpoints.
+
+Packets can be sampled with -sampling mode and -samplingInterval n after filtering. "deterministic" uses every n-th
+packet, "random" uses every packet with a probability of 1/n (see -samplingSeed), and "hash" uses every flow with a
+probability of 1/n based on the CRC-32 of the flow key, i.e. flows are either kept or dropped completely. The features
+samplingInterval and selectorAlgorithm (IANA PSAMP codes: 1 deterministic, 4 random, 8 hash) export the sampling
+configuration; the deprecated samplingAlgorithm only knows deterministic (1) and random (2) sampling and is 0 for
+hash sampling. With -scaleSampled the flow features octetTotalCount and packetTotalCount are multiplied by n.
+
+With -passiveDNS, the A and AAAA records of all the DNS answers (from port 53) are collected in a passive DNS cache,
+which is shared by all tables. The features _sourceDomainName and _destinationDomainName contain the most recent name
+resolved to the source or destination address by an answer before the first packet of the flow, unless the TTL of
+this answer expired. Addresses reached via CNAME records get the queried name. Since answers are matched by packet
+number, the result doesn't depend on -n.
+
+Multiple feature specifications (e.g. several features commands, or features -all for every flow of a v2 file) can use
+different flow keys, directions, timeouts, and flow options. Specifications sharing all of those share one group of
+"table"-pipelines. Every packet is parsed once and shared by all the groups; only the flow key is computed per group.
+Labels are assigned to the packets before they are handed to the groups, so every group sees the same labels. At most
+256 tables (n times the number of groups) are possible.
+
+Exported records can be aggregated into second-level records (hierarchical flows) with "features -from <i>", where i is
+the index of a preceding feature specification on the command line (counting from 0). Instead of packets, the records
+exported by this specification are the events of a separate flow table with its own key, timeouts, and features. The
+fields of the source records can be used as key features and as features, e.g. the following computes per-host
+statistics from 5-tuple flows (key_features of hosts.json: ["sourceIPAddress"]):
+
+	go-flows run features flows.json export csv flows.csv features -from 0 hosts.json export csv hosts.csv source ...
+
+	"features": ["sourceIPAddress", {"count": ["destinationTransportPort"]}, {"sum": ["octetTotalCount"]},
+		{"distinct": ["destinationTransportPort"]}, "flowStartNanoseconds", "flowEndNanoseconds"]
+
+Within functions, fields are per-record values, otherwise the value of the first record is used. flowStart*, flowEnd*,
+flowEndReason, flowId, and flowDurationNanoseconds refer to the aggregated flow, where the timestamps of the events are
+the export times of the source records. Aggregated flows are always unidirectional and can't be used with checkpoints.
+With sorted output, they must be exported separately from feature specifications with other keys or options.
 
 Specification
 
@@ -87,7 +134,11 @@ Simpleformat specification:
 		"_filter_features": [...],
 		"_per_packet": <bool>,
 		"_allow_zero": <bool>,
-		"_expire_TCP": <bool>
+		"_expire_TCP": <bool>,
+		"_timeouts": [...],
+		"_tcp_timeouts": {...},
+		"_interim": {...},
+		"_context": {...}
 	}
 
 V2-formated file:
@@ -112,11 +163,106 @@ Only single pass operations can ever be supported due to design restrictions in
 In addition to the features specified in the nta-meta-analysis, two addional types of features are present:
 Filter features which can exclude packets from a whole flow, and control features which can change flow
 behaviour like exporting the flow before the end, restarting the flow, or discarding the flow.
+Both lists use the same syntax as features. _filter_features can contain filter features, or expressions returning
+a boolean packet feature (only packets where the expression is true are part of the flow), e.g.
+{"greater": ["ipTotalLength", 100]}. _control_features can contain control features, which can have constant
+arguments. The control features in modules/features/control (e.g. _exportEveryPackets, _restartOnSYN,
+_endOnDNSResponse, _minPackets, _octetLimit) take their parameter as argument (e.g. {"_minPackets": [2]}) or
+read it from the key with the same name (see examples/control.json).
 _per_packet allows exporting one flow per packet. If _allow_zero is true, then packets are accepted, where
 one of the parts of the flow key would be zero (e.g. non-IP packets for flow keys that contain IP-Addresses).
 If _expire_TCP is set to false, no TCP-based expiry is carried out (e.g. RST packets). TCP expiry is
 only carried out if at least the five-tuple is part of the flow key.
 
+_timeouts is a list of rules overriding active_timeout and idle_timeout for some flows. A rule can match the
+protocol (IP protocol number), the port (source or destination), and the TCP state ("new" if the first packet
+has the SYN flag set, or "midstream" otherwise), and contains an "active" and/or "idle" timeout. The first
+matching rule is applied when a flow is created. E.g. the following rule uses an idle timeout of 10s for DNS
+flows:
+
+	"_timeouts": [{"protocol": 17, "port": 53, "idle": 10}]
+
+With TCP expiry, TCP flows follow the connection state (SYN_SENT, SYN_RECEIVED, ESTABLISHED, FIN_WAIT,
+TIME_WAIT, CLOSED). A RST ends the flow, and a new SYN on a closed or closing connection ends the flow and
+starts a new one. _tcp_timeouts overrides the idle timeout depending on this state with the keys "syn_sent"
+(used until the handshake is finished), "established", "fin_wait", and "time_wait" (the time a flow is kept
+after both FINs were acknowledged; by default the flow ends immediately). Missing keys keep the idle timeout
+of the flow. The feature _tcpState exports the connection state at the end of the flow. E.g.:
+
+	"_tcp_timeouts": {"syn_sent": 5, "established": 3600, "fin_wait": 30, "time_wait": 2}
+
+_interim exports the records of a flow before the flow ends, without changing the flow or the feature state. "packets"
+is an ascending list of packet counts after which a record is exported, and "interval" exports a record every interval
+seconds after the flow started. Interim records have a flowEndReason of 6 and are followed by the final record of the
+flow. Interim records are computed from a copy of the feature state (made with the checkpoint functions of the
+features), so the final record is the same as without interim exports.
+E.g. the following exports records after 5, 10, and 20 packets, and every minute:
+
+	"_interim": {"packets": [5, 10, 20], "interval": 60}
+
+_context enables the connection context for KDD-style host and service features. Every new flow is recorded as a
+connection (source address, destination address, and service = protocol + destination port) in a state, which is
+shared by all the processing tables of the flow specification. "window" is the length of the time window in seconds
+(default 2), "connections" is the number of connections in the count window (default 100), and "evaluate" ("start"
+or "end"; default "start") specifies if the features are computed when the flow starts or when it ends. Both windows
+include the connection of the flow itself. The features _srcHostConnCount, _srcHostDstCount, _dstHostConnCount,
+_srvConnCount, and _sameSrvRate use the time window, and _dstHostCount, _dstHostSrvCount, and _dstHostSameSrvRate use
+the count window (see examples/context.json). E.g.:
+
+	"_context": {"window": 2, "connections": 100, "evaluate": "end"}
+
+HTTP/1.0 and HTTP/1.1 messages are parsed from the reassembled TCP streams, including pipelined requests, chunked
+bodies, and bodies delimited by Content-Length or the end of the connection. httpRequestMethod, httpRequestTarget,
+httpRequestHost, httpUserAgent, httpMessageVersion, httpStatusCode, httpReasonPhrase, and httpContentType are the values
+of the first request or response containing them, or of every message as argument of functions like set or distinct.
+_httpRequestCount, _httpResponseCount, _httpRequestBodyOctetCount, and _httpResponseBodyOctetCount count the messages
+and body bytes of the flow (see examples/http.json).
+_HTTPLines returns the start line and the header lines of every request and response of the connection; previously, it
+only returned the lines of the first request. httpLines still extracts the lines from a payload string, e.g.
+{"httpLines": ["_tcpReorderPayload"]}, but parses every message of the payload as well.
+
+TLS handshakes are parsed from the reassembled TCP streams, so ClientHello and ServerHello messages spanning several
+records or segments are supported. _tlsServerName, _tlsClientALPN, _tlsClientVersion, _tlsClientSupportedVersions,
+_tlsClientCipherSuites, _tlsClientExtensions, _tlsSupportedGroups, and _tlsSignatureAlgorithms are taken from the
+ClientHello, _tlsALPN, _tlsVersion, _tlsCipherSuite, and _tlsServerExtensions from the ServerHello. _tlsJA3String,
+_tlsJA3, _tlsJA3SString, _tlsJA3S, and _tlsJA4 are the JA3, JA3S, and JA4 fingerprints of the handshake; GREASE values
+are ignored (see examples/tls.json).
+
+_tls<Direction><Content>RecordCount and _tls<Direction><Content>OctetCount count the TLS records and their lengths per
+direction (Client or Server) and content type (Handshake, ApplicationData, or Alert). Encrypted TLS 1.3 handshake
+records are counted as handshake records. _tlsTimeToApplicationDataNanoseconds and
+_tlsTimeToApplicationDataMilliseconds are the time from the start of the flow to the first application data record.
+_tlsCertificateSubjectCommonName, _tlsCertificateIssuer, _tlsCertificateNotBefore, _tlsCertificateNotAfter,
+_tlsCertificateSANCount, _tlsCertificateSelfSigned, and _tlsCertificateKeyType describe the first server certificate,
+which is only available up to TLS 1.2.
+
+QUIC packets are decoded by the quic dissector on UDP port 443, and long header packets of known versions on any port.
+_quicVersion, _quicDCIDLength, _quicSCIDLength, and _quicPacketType are taken from the packet headers, and
+_quicLongHeaderPacketCount and _quicShortHeaderPacketCount count the packets of the flow. The Initial packets are
+decrypted to get the ClientHello and ServerHello, which can span several packets: _quicServerName, _quicALPN,
+_quicCipherSuite, and _quicJA4 (see examples/quic.json).
+
+The _dns features (e.g. _dnsDomain or _dnsResponseCode) work on DNS messages from UDP packets and from the reassembled
+TCP streams, where messages can span several segments. _dnsQueryCount, _dnsResponseCount, _dnsResponseOctetCount,
+_dnsUnansweredQueryCount, and _dnsNXDomainRatio summarize the messages of a flow. Responses are matched to queries by
+ID, which needs bidirectional flows. _dnsResponseTimeNanoseconds and _dnsResponseTimeMilliseconds are the time between
+a query and its response, and _dnsResponseLength is the length of every response. They can be aggregated with
+functions like mean or max (see examples/dns.json).
+
+SSH connections are parsed from the reassembled TCP streams up to the end of the key exchange. _sshClientBanner and
+_sshServerBanner are the protocol version lines. _ssh<Direction><List> are the name-lists of the first KEXINIT of the
+client or server (Direction Client or Server; List KexAlgorithms, HostKeyAlgorithms, EncryptionAlgorithms,
+MACAlgorithms, or CompressionAlgorithms), and _sshHASSH, _sshHASSHString, _sshHASSHServer, and _sshHASSHServerString
+their HASSH fingerprints. _sshAuthAttempts estimates the number of authentication requests from the sizes of the
+encrypted request and response bursts after the key exchange (see examples/ssh.json).
+
+Modbus/TCP and DNP3 are parsed from the reassembled TCP streams. _modbusTransactionID, _modbusUnitID,
+_modbusFunctionCode, _modbusExceptionCode, _modbusReadAddress, _modbusReadQuantity, _modbusWriteAddress, and
+_modbusWriteQuantity are the values of the first message, or of every message as argument of functions like set,
+distinct, or mode. _modbusRequestCount, _modbusResponseCount, and _modbusExceptionCount count the messages of the flow.
+The DNP3 features _dnp3Source, _dnp3Destination, _dnp3LinkFunctionCode, _dnp3FunctionCode, _dnp3InternalIndications,
+and _dnp3ObjectGroup work the same way on DNP3 frames (see examples/ics.json).
+
 A list of supported features can be queried with "./go-flows features"
 
 Example usage
@@ -155,7 +301,7 @@ the needed methods:
 
   * Start(*EventContext) gets called when a flow starts. Do cleanup here (features might be reused!). MUST call flows.BaseFeature.Start from this function!
   * Event(interface{}, *EventContext, interface{}) gets called for every packet belonging to the current flow
-  * Stop(FlowEndReason, *EventContext) gets called when a flow finishes (before export)
+  * Stop(FlowEndReason, *EventContext) gets called when a flow finishes (before export). For interim exports, it gets called with FlowEndReasonInterim on a copy of the feature restored with Checkpoint
 
   * SetValue(new interface{}, when *EventContext, self interface{}) Call this one for setting a value. It stores the new value and forwards it to all dependent features.
 
@@ -164,6 +310,13 @@ Less commonly used functions
   * Variant() gets called to determine which variant to use, if a feature can different types depending on data (see sourceIPAddress for an example)
   * SetArguments(args []int, all []Feature) gets called during instantiation if a feature expects constant arguments (args contains indizes of all; see select_slice for an example)
 
+Application layer features over TCP should not buffer packets themselves, but take the packet feature __tcpStream as
+argument (e.g. as composite feature ["myFeature", "__tcpStream"]). __tcpStream reassembles both directions of the
+connection (see features.TCPStream) and emits *features.StreamChunk values with the ordered bytes of one direction,
+the number of missing bytes in front of them (gap), and the end of the direction (FIN or RST). Retransmissions are
+dropped, overlapping out of order segments keep the first received bytes, and at most 1 MiB is buffered per
+direction. Buffered bytes are flushed when the flow ends.
+
 See also documentation of subpackage flows for more details about which base to choose.
 
 A simple example is the protocolIdentifier:
@@ -261,6 +414,21 @@ Labels must implement the packet.Label interface:
 
 This function can return an arbitrary value as label for the packet (can also be nil for no label). If the label source is empty io.EOF must be returned.
 
+Implementing dissectors
+
+Dissectors decode the payload of TCP or UDP packets into an application layer (e.g. *layers.DNS), which features
+get with ApplicationLayer() of the packet. They register themselves by port or with a heuristic:
+
+	func init() {
+		packet.RegisterPortDissector("name", "short description", layers.LayerTypeUDP, []uint16{53}, dissectX)
+		packet.RegisterHeuristicDissector("name", "short description", layers.LayerTypeTCP, looksLikeX, dissectX)
+	}
+
+dissectX has the signature func(payload []byte, transport gopacket.LayerType) gopacket.ApplicationLayer and must
+return nil if the payload can't be decoded. Dissectors for the destination port are tried first, then the ones for the
+source port, and then the heuristic ones. A packet is decoded at most once, when ApplicationLayer() is called for the
+first time, and the result is cached in the packet. "./go-flows dissectors" lists the available dissectors.
+
 Implementing exporters
 
 Exporters must implement the flow.Exporter interface:
@@ -281,5 +449,15 @@ the actual features values, and an export time.
 Finish will be called after all packets and flows have been processed. This function must flush data and wait for
 this process to finish.
 
+If encoding the records is expensive, exporters can additionally implement the flow.EncodingExporter interface:
+
+	Encode([]byte, Template, []interface{}, DateTimeNanoseconds) []byte
+	Write([]byte)
+
+If more than one encoder is requested with the -encoders argument of run, Encode gets called concurrently from
+multiple goroutines and must append the encoded record to the given buffer. The encoded batches are then handed
+to Write in the order given by the chosen sort order. Write is never called concurrently. The csv exporter serves
+as an example.
+
 */
 package main
@@ -0,0 +1,351 @@
+package flows
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+/*
+Aggregation (hierarchical flows):
+
+Records exported by a source record (e.g. 5-tuple flows) can be used as input (RawFlow) for an aggregated record
+(e.g. per-host statistics) with its own flow table, key, and timeouts:
+
+	source table -> source record -> ExportPipeline -> aggregator.Export() -> aggregated table -> aggregated record -> ExportPipeline -> ...
+
+The fields of the source record are available as features for the aggregated record: As PacketFeature, every
+RecordEvent results in the value of the field, and as FlowFeature, the value of the field from the first RecordEvent is
+used. The aggregator is an additional exporter of the source pipeline and runs within the exporter goroutine of this
+pipeline. Timestamps of RecordEvents are the export times of the source records.
+*/
+
+// RecordEvent is a record exported by a source record, which is used as event for an aggregated record
+type RecordEvent struct {
+	template Template
+	features []interface{}
+	when     DateTimeNanoseconds
+	key      string
+	nr       uint64
+	window   uint64
+}
+
+// Timestamp returns the export time of the record
+func (r *RecordEvent) Timestamp() DateTimeNanoseconds { return r.when }
+
+// Key returns the flow key of the aggregated flow
+func (r *RecordEvent) Key() string { return r.key }
+
+// LowToHigh returns true, since aggregated flows are always unidirectional
+func (r *RecordEvent) LowToHigh() bool { return true }
+
+// SetWindow sets the window id
+func (r *RecordEvent) SetWindow(window uint64) { r.window = window }
+
+// Window returns the window id
+func (r *RecordEvent) Window() uint64 { return r.window }
+
+// EventNr returns the number of the record
+func (r *RecordEvent) EventNr() uint64 { return r.nr }
+
+// Template returns the template of the record
+func (r *RecordEvent) Template() Template { return r.template }
+
+// Features returns the values of the record
+func (r *RecordEvent) Features() []interface{} { return r.features }
+
+// NewRecordFlow creates a new flow for aggregated records (see FlowCreator)
+func NewRecordFlow(event Event, table *FlowTable, key string, lowToHigh bool, context *EventContext, id uint64) Flow {
+	ret := new(BaseFlow)
+	ret.Init(table, key, lowToHigh, context, id)
+	return ret
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type recordFieldPacket struct {
+	BaseFeature
+	field    int
+	variants []ipfix.InformationElement
+	variant  int
+}
+
+func (f *recordFieldPacket) setVariant(record *RecordEvent) {
+	if len(f.variants) == 0 {
+		return
+	}
+	ie := record.template.InformationElements()[f.field]
+	for i := range f.variants {
+		if f.variants[i] == ie {
+			f.variant = i
+			return
+		}
+	}
+}
+
+func (f *recordFieldPacket) Event(new interface{}, context *EventContext, src interface{}) {
+	record := new.(*RecordEvent)
+	f.setVariant(record)
+	f.SetValue(record.features[f.field], context, f)
+}
+
+func (f *recordFieldPacket) Variant() int {
+	if len(f.variants) == 0 {
+		return NoVariant
+	}
+	return f.variant
+}
+
+type recordFieldFlow struct {
+	recordFieldPacket
+}
+
+func (f *recordFieldFlow) Event(new interface{}, context *EventContext, src interface{}) {
+	if f.Value() == nil {
+		f.recordFieldPacket.Event(new, context, src)
+	}
+}
+
+func (f *recordFieldFlow) Checkpoint(c *Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Int(&f.variant)
+}
+
+// leafTemplates returns all the leaf templates of t
+func leafTemplates(t Template) []Template {
+	switch tt := t.(type) {
+	case *leafTemplate:
+		return []Template{tt}
+	case *multiTemplate:
+		var ret []Template
+		for _, sub := range tt.templates {
+			ret = append(ret, leafTemplates(sub)...)
+		}
+		return ret
+	}
+	return nil
+}
+
+// registerFields registers the fields of this record as features with RawFlow input. The returned function removes
+// the features from the registry again.
+func (rm *RecordMaker) registerFields() (unregister func()) {
+	leaves := leafTemplates(rm.template)
+	type registered struct {
+		ret  FeatureType
+		name string
+		n    int
+	}
+	var undo []registered
+	for field, name := range rm.fields {
+		var ies []ipfix.InformationElement
+	LEAVES:
+		for _, leaf := range leaves {
+			ie := leaf.InformationElements()[field]
+			for _, existing := range ies {
+				if existing == ie {
+					continue LEAVES
+				}
+			}
+			ies = append(ies, ie)
+		}
+		maker := featureMaker{
+			arguments:   []FeatureType{RawFlow},
+			description: "field of the source record",
+		}
+		field := field
+		if len(ies) == 1 {
+			maker.ie = ies[0]
+		} else {
+			maker.ie = ipfix.InformationElement{Type: ipfix.IllegalType}
+			maker.variants = ies
+		}
+		variants := maker.variants
+		for _, ret := range []FeatureType{PacketFeature, FlowFeature} {
+			maker.ret = ret
+			if ret == PacketFeature {
+				maker.make = func() Feature { return &recordFieldPacket{field: field, variants: variants} }
+			} else {
+				maker.make = func() Feature { return &recordFieldFlow{recordFieldPacket{field: field, variants: variants}} }
+			}
+			undo = append(undo, registered{ret, name, len(featureRegistry[ret][name])})
+			featureRegistry[ret][name] = append(featureRegistry[ret][name], maker)
+		}
+	}
+	return func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			r := undo[i]
+			if r.n == 0 {
+				delete(featureRegistry[r.ret], r.name)
+			} else {
+				featureRegistry[r.ret][r.name] = featureRegistry[r.ret][r.name][:r.n]
+			}
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Aggregator feeds the records exported by a source record into the flow table of aggregated records. It is an
+// additional exporter of the export pipeline of the source record.
+type Aggregator struct {
+	table      *FlowTable
+	template   Template
+	source     map[int]bool
+	key        []int
+	expire     DateTimeNanoseconds
+	nextExpire DateTimeNanoseconds
+	last       DateTimeNanoseconds
+	records    uint64
+	eof        bool
+	keyBuffer  []byte
+}
+
+// Aggregate feeds the records exported by the record with index source into table. The flow key of table consists
+// of the given fields of the source record. Expired flows in table are checked with the given period. Must be called
+// before Init.
+func (rl RecordListMaker) Aggregate(source int, table *FlowTable, key []string, expire DateTimeNanoseconds) (*Aggregator, error) {
+	if source < 0 || source >= len(rl.list) {
+		return nil, fmt.Errorf("unknown source record %d", source)
+	}
+	if len(key) == 0 {
+		return nil, errors.New("aggregation needs a flow key")
+	}
+	rm := rl.list[source]
+	ret := &Aggregator{
+		table:    table,
+		template: rm.template,
+		source:   make(map[int]bool),
+		expire:   expire,
+	}
+KEY:
+	for _, k := range key {
+		for i, field := range rm.fields {
+			if field == k {
+				ret.key = append(ret.key, i)
+				continue KEY
+			}
+		}
+		return nil, fmt.Errorf("key feature '%s' is not a field of the source record (%s)", k, strings.Join(rm.fields, ", "))
+	}
+	rm.export.exporter = append(rm.export.exporter, ret)
+	return ret, nil
+}
+
+// ID returns the id of the aggregator
+func (a *Aggregator) ID() string { return fmt.Sprintf("aggregate|%p", a) }
+
+// Init does nothing (the aggregator is initialized by Aggregate)
+func (a *Aggregator) Init() {}
+
+// Fields does nothing, since fields are resolved during Aggregate
+func (a *Aggregator) Fields([]string) {}
+
+// Export hands a record to the flow table of the aggregated records, if it was exported by the source record
+func (a *Aggregator) Export(template Template, features []interface{}, when DateTimeNanoseconds) {
+	id := template.ID()
+	isSource, ok := a.source[id]
+	if !ok {
+		isSource = findTemplate(a.template, id) != nil
+		a.source[id] = isSource
+	}
+	if !isSource {
+		return
+	}
+	// export times of sorted output can be slightly out of order
+	if when < a.last {
+		when = a.last
+	}
+	a.last = when
+	a.records++
+
+	a.keyBuffer = a.keyBuffer[:0]
+	for _, field := range a.key {
+		a.keyBuffer = appendKeyValue(a.keyBuffer, features[field])
+	}
+	a.table.Event(&RecordEvent{
+		template: template,
+		features: features,
+		when:     when,
+		key:      string(a.keyBuffer),
+		nr:       a.records,
+	})
+	if when > a.nextExpire {
+		if a.nextExpire != 0 {
+			a.table.Expire(when)
+		}
+		a.nextExpire = when + a.expire
+	}
+}
+
+// appendKeyValue appends value to the flow key. Every value starts with its kind, and values of variable length are
+// length prefixed, so different combinations of values never result in the same key. Integers of different sizes with
+// the same value share the same encoding, while e.g. the string "1" and the number 1 or IPv4 and IPv6 addresses differ.
+func appendKeyValue(key []byte, value interface{}) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return append(key, 'n')
+	case reflect.Bool:
+		if v.Bool() {
+			return append(key, 'b', 1)
+		}
+		return append(key, 'b', 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := v.Int(); n < 0 {
+			key = append(key, 'i')
+			return append(key, scratch[:binary.PutVarint(scratch[:], n)]...)
+		}
+		key = append(key, 'u')
+		return append(key, scratch[:binary.PutUvarint(scratch[:], uint64(v.Int()))]...)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		key = append(key, 'u')
+		return append(key, scratch[:binary.PutUvarint(scratch[:], v.Uint())]...)
+	case reflect.Float32, reflect.Float64:
+		key = append(key, 'f')
+		return append(key, scratch[:binary.PutUvarint(scratch[:], math.Float64bits(v.Float()))]...)
+	case reflect.String:
+		key = append(key, 's')
+		key = append(key, scratch[:binary.PutUvarint(scratch[:], uint64(v.Len()))]...)
+		return append(key, v.String()...)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// byte slices like addresses; the length keeps IPv4 and IPv6 addresses apart
+			key = append(key, 'y')
+			key = append(key, scratch[:binary.PutUvarint(scratch[:], uint64(v.Len()))]...)
+			return append(key, v.Bytes()...)
+		}
+	}
+	s := fmt.Sprintf("%T:%v", value, value)
+	key = append(key, 'v')
+	key = append(key, scratch[:binary.PutUvarint(scratch[:], uint64(len(s)))]...)
+	return append(key, s...)
+}
+
+// Finish does nothing; the remaining aggregated flows are exported during RecordListMaker.Flush
+func (a *Aggregator) Finish() {}
+
+// flush exports all the remaining aggregated flows. Must be called after the source pipeline is finished.
+func (a *Aggregator) flush() {
+	if a.eof {
+		return
+	}
+	a.eof = true
+	a.table.EOF(a.last)
+}
+
+// PrintStats writes aggregation statistics to w
+func (a *Aggregator) PrintStats(w io.Writer) {
+	fmt.Fprintf(w,
+		`Aggregation statistics:
+	records: %d
+	flows: %d
+	peak flows: %d
+`, a.records, a.table.Stats.Flows, a.table.Stats.Maxflows)
+}
@@ -0,0 +1,94 @@
+package flows_test
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+)
+
+var hostFeatures = []packet_test.EngineSpec{{
+	Features: []interface{}{"sourceIPAddress", "destinationTransportPort", "packetTotalCount"},
+}, {
+	Features:  []interface{}{"sourceIPAddress", []interface{}{"sum", "packetTotalCount"}, []interface{}{"count", "destinationTransportPort"}, "flowEndReason"},
+	Aggregate: []string{"sourceIPAddress"},
+	From:      0,
+}}
+
+func TestAggregate(t *testing.T) {
+	// IPv4 and IPv4-mapped IPv6 addresses print the same, but are different hosts
+	hosts := []struct {
+		src, dst net.IP
+		packets  []int // per destination port
+	}{
+		{net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, []int{1, 2}},
+		{net.ParseIP("::ffff:10.0.0.1"), net.ParseIP("::ffff:10.0.0.2"), []int{4}},
+	}
+	source := &packet_test.MemorySource{}
+	when := flows.DateTimeNanoseconds(1)
+	for _, host := range hosts {
+		for port, packets := range host.packets {
+			for i := 0; i < packets; i++ {
+				when += flows.MillisecondsInNanoseconds
+				var err error
+				udp := &layers.UDP{SrcPort: 1000, DstPort: layers.UDPPort(80 + port)}
+				if len(host.src) == net.IPv4len {
+					err = source.AppendLayers(when, &layers.IPv4{Version: 4, TTL: 64, SrcIP: host.src, DstIP: host.dst, Protocol: layers.IPProtocolUDP}, udp)
+				} else {
+					err = source.AppendLayers(when, &layers.IPv6{Version: 6, HopLimit: 64, SrcIP: host.src, DstIP: host.dst, NextHeader: layers.IPProtocolUDP}, udp)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	for _, order := range []flows.SortType{flows.SortTypeNone, flows.SortTypeStopTime} {
+		lines := packet_test.RunEngine(t, source, hostFeatures, packet_test.EngineOptions{Sort: order})
+		var aggregated []string
+		for _, line := range lines {
+			if fields := strings.Split(line, ","); len(fields) == 5 {
+				aggregated = append(aggregated, strings.Join(fields[1:], ","))
+			}
+		}
+		// flowEndReason 4: end of the source
+		expected := []string{"10.0.0.1,3,2,4", "10.0.0.1,4,1,4"}
+		if !reflect.DeepEqual(sortedLines(aggregated), expected) {
+			t.Errorf("sort order %d: expected aggregated records %v, but got %v (all records: %v)", order, expected, aggregated, lines)
+		}
+	}
+}
+
+func TestAggregateFields(t *testing.T) {
+	pipeline := func() *flows.ExportPipeline {
+		ret, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1, 1, false)
+		return ret
+	}
+	var recordList flows.RecordListMaker
+	source := pipeline()
+	if err := recordList.AppendRecord([]interface{}{"sourceIPAddress", "destinationTransportPort", "packetTotalCount"}, nil, nil, source, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordList.AppendRecord([]interface{}{"sourceIPAddress", "octetTotalCount"}, nil, nil, pipeline(), false); err != nil {
+		t.Fatal(err)
+	}
+	// fields of the source record are usable in aggregated records
+	if err := recordList.AppendAggregateRecord(0, []interface{}{"sourceIPAddress", []interface{}{"sum", "packetTotalCount"}}, nil, nil, pipeline(), false); err != nil {
+		t.Fatalf("Couldn't use fields of the source record: %s", err)
+	}
+	// fields of other records are not
+	if err := recordList.AppendAggregateRecord(1, []interface{}{"sourceIPAddress", []interface{}{"sum", "packetTotalCount"}}, nil, nil, pipeline(), false); err == nil {
+		t.Error("Fields of a previous source record are still registered")
+	}
+	// features shadowed by the fields work again for packet records
+	if err := recordList.AppendRecord([]interface{}{"sourceIPAddress", "packetTotalCount", []interface{}{"sum", "ipTotalLength"}}, nil, nil, pipeline(), false); err != nil {
+		t.Errorf("Features aren't restored after aggregated records: %s", err)
+	}
+	if err := recordList.AppendAggregateRecord(0, []interface{}{"sourceIPAddress"}, nil, nil, source, false); err == nil {
+		t.Error("Aggregated records can be exported with their source")
+	}
+}
@@ -29,7 +29,7 @@ func (f FeatureError) Error() string {
 AST is built using following steps:
 1. parsing
 	this splits the feature specification into fragments:
-	- astCall: used for all features (features without input get an astRawPacket as input)
+	- astCall: used for all features (features without input get an astRawPacket, or astRawFlow for aggregated records, as input)
 	- astConstant: for numbers
 2. composite expansion
 	replaces astCalls that are found in the composite table with the expanded version
@@ -322,10 +322,47 @@ func (a *astRawPacket) build(ret FeatureType) error {
 	return nil
 }
 
+type astRawFlow struct {
+	astRaw
+}
+
+func (a *astRawFlow) Returns() FeatureType {
+	return RawFlow
+}
+
+func (a *astRawFlow) Name() string {
+	return "RawFlow"
+}
+
+func (a *astRawFlow) ExportName() string {
+	return "RawFlow"
+}
+
+func (a *astRawFlow) MakeExportName() string {
+	return "RawFlow"
+}
+
+func (a *astRawFlow) String() string {
+	return "RawFlow"
+}
+
+func (a *astRawFlow) Copy() astFragment {
+	return &astRawFlow{}
+}
+
+func (a *astRawFlow) build(ret FeatureType) error {
+	if ret != RawFlow {
+		return errInput
+	}
+	return nil
+}
+
 func makeASTRaw(input FeatureType) (astFragment, error) {
 	switch input {
 	case RawPacket:
 		return &astRawPacket{}, nil
+	case RawFlow:
+		return &astRawFlow{}, nil
 	default:
 		return nil, fmt.Errorf("%s input not implemented", input)
 	}
@@ -478,9 +515,22 @@ func (me multiError) Error() string {
 
 func (a *astCall) build(ret FeatureType) error {
 	if a.control {
-		candidates := getFeatures(a.name, ControlFeature, 1)
+		candidates := getFeatures(a.name, ControlFeature, len(a.args))
 		if len(candidates) == 0 {
-			return fmt.Errorf("couldn't find control feature '%s'", a.name)
+			return fmt.Errorf("couldn't find control feature '%s' with %d argument(s)", a.name, len(a.args)-1)
+		}
+		// control features only support constant arguments (+ the input)
+		for _, arg := range a.args[:len(a.args)-1] {
+			if _, ok := arg.(*astConstant); !ok {
+				return fmt.Errorf("control feature '%s' needs constant arguments, but got %s", a.name, arg)
+			}
+			if err := arg.build(Const); err != nil {
+				return err
+			}
+		}
+		input := candidates[0].arguments[len(candidates[0].arguments)-1]
+		if err := a.args[len(a.args)-1].build(input); err != nil {
+			return fmt.Errorf("control feature '%s' needs %s as input", a.name, input)
 		}
 		a.feature = candidates[0]
 		a.ret = ControlFeature
@@ -524,6 +574,9 @@ CANDIDATES:
 		}
 		return nil
 	}
+	if len(a.args) == 1 && a.args[0].IsRaw() && a.args[0].Returns() == RawFlow {
+		return fmt.Errorf("'%s' is neither a field of the source record nor a feature returning %s from RawFlow", a.name, ret)
+	}
 	return errs
 }
 
@@ -648,18 +701,33 @@ type ast struct {
 	ret            FeatureType
 	input          FeatureType
 	filter         []string
+	filterSpec     []interface{}
 	filterFeatures []MakeFeature
 	fragments      []astFragment
+	names          []string // export names of the simplified fragments; indexed by register
 	exporter       []Exporter
 }
 
 // makeAST builds a basic ast for the given feature specification (no verification done yet)
-func makeAST(features []interface{}, control, filter []string, exporter []Exporter, input, ret FeatureType) (*ast, error) {
+func makeAST(features []interface{}, control, filter []interface{}, exporter []Exporter, input, ret FeatureType) (*ast, error) {
 	r := &ast{
-		ret:      ret,
-		input:    input,
-		filter:   filter,
-		exporter: exporter,
+		ret:        ret,
+		input:      input,
+		filterSpec: filter,
+		exporter:   exporter,
+	}
+
+	r.filter = make([]string, len(filter))
+	for i := range filter {
+		if name, ok := filter[i].(string); ok {
+			r.filter[i] = name
+			continue
+		}
+		frag, err := makeASTFragment(filter[i], input, i+1)
+		if err != nil {
+			return r, FeatureError{i + 1, fmt.Sprint(filter[i]), fmt.Errorf("filter: %s", err)}
+		}
+		r.filter[i] = frag.String()
 	}
 
 	r.fragments = make([]astFragment, len(features))
@@ -673,10 +741,22 @@ func makeAST(features []interface{}, control, filter []string, exporter []Export
 	}
 	for i, feature := range control {
 		frag, err := makeASTFragment(feature, input, i+1)
-		frag.SetControl(true)
 		if err != nil {
-			return r, FeatureError{frag.ID(), fmt.Sprint(frag), err}
+			return r, FeatureError{i + 1, fmt.Sprint(feature), err}
+		}
+		c, ok := frag.(*astCall)
+		if !ok {
+			return r, FeatureError{i + 1, fmt.Sprint(frag), errors.New("control features must be a feature name or a call")}
+		}
+		if len(c.args) == 0 || !c.args[len(c.args)-1].IsRaw() {
+			// control features always get the input as last argument
+			source, err := makeASTRaw(input)
+			if err != nil {
+				return r, FeatureError{i + 1, fmt.Sprint(frag), err}
+			}
+			c.args = append(c.args, source)
 		}
+		frag.SetControl(true)
 		r.fragments = append(r.fragments, frag)
 	}
 
@@ -734,12 +814,18 @@ func (a *ast) build() error {
 	}
 	if len(a.filter) > 0 {
 		a.filterFeatures = make([]MakeFeature, len(a.filter))
-		for i, filter := range a.filter {
-			candidates := getFeatures(filter, RawPacket, 1)
-			if len(candidates) == 0 {
-				return fmt.Errorf("couldn't find filter feature '%s'", filter)
+		for i, filter := range a.filterSpec {
+			if name, ok := filter.(string); ok {
+				if candidates := getFeatures(name, a.input, 1); len(candidates) > 0 {
+					a.filterFeatures[i] = candidates[0].make
+					continue
+				}
+			}
+			var err error
+			a.filterFeatures[i], err = makeExpressionFilter(filter, a.input, i+1)
+			if err != nil {
+				return err
 			}
-			a.filterFeatures[i] = candidates[0].make
 		}
 	}
 	return nil
@@ -897,14 +983,14 @@ func (a *ast) resolve() error {
 	return nil
 }
 
-func simplifyFragments(fragment astFragment, subtrees map[string]astFragment, out *[]astFragment, register *int) error {
+func simplifyFragments(fragment astFragment, subtrees map[string]astFragment, out *[]astFragment, names *[]string, register *int) error {
 	if fragment.IsRaw() {
 		return nil
 	}
 
 	if c, ok := fragment.(*astCall); ok {
 		for _, arg := range c.args {
-			err := simplifyFragments(arg, subtrees, out, register)
+			err := simplifyFragments(arg, subtrees, out, names, register)
 			if err != nil {
 				return err
 			}
@@ -937,6 +1023,12 @@ func simplifyFragments(fragment astFragment, subtrees map[string]astFragment, ou
 		cpy.SetType(fragment.Variants().simplify())
 	}
 	*out = append(*out, cpy)
+	// arguments of the copy are registers now; names must be taken from the original fragment
+	if fragment.Export() {
+		*names = append(*names, fragment.ExportName())
+	} else {
+		*names = append(*names, fragment.MakeExportName())
+	}
 	return nil
 }
 
@@ -944,14 +1036,16 @@ func simplifyFragments(fragment astFragment, subtrees map[string]astFragment, ou
 func (a *ast) simplify() error {
 	subtrees := make(map[string]astFragment)
 	var out []astFragment
+	var names []string
 	register := 0
 	for _, fragment := range a.fragments {
-		err := simplifyFragments(fragment, subtrees, &out, &register)
+		err := simplifyFragments(fragment, subtrees, &out, &names, &register)
 		if err != nil {
 			return makeExpandedError(fragment, err)
 		}
 	}
 	a.fragments = out
+	a.names = names
 	return nil
 }
 
@@ -1009,6 +1103,26 @@ func (a *ast) compile(verbose bool) error {
 	return nil
 }
 
+// checkArguments instantiates the converted features once and verifies the arguments of the features supporting it
+// (see FeatureWithArgumentCheck)
+func (a *ast) checkArguments(featureMakers []MakeFeature, args [][]int) error {
+	features := make([]Feature, len(featureMakers))
+	for i, maker := range featureMakers {
+		features[i] = maker()
+	}
+	for i, arg := range args {
+		if len(arg) == 0 {
+			continue
+		}
+		if f, ok := features[i].(FeatureWithArgumentCheck); ok {
+			if err := f.CheckArguments(arg, features); err != nil {
+				return makeFeatureError(a.fragments[i], err)
+			}
+		}
+	}
+	return nil
+}
+
 // convert builds MakeFeature lists, used to instantiate features upon flow creation, from an ast
 func (a *ast) convert() (features []MakeFeature, filters []MakeFeature, args [][]int, tocall [][]int, ctrl *control) {
 	ctrl = &control{}
@@ -1019,6 +1133,11 @@ func (a *ast) convert() (features []MakeFeature, filters []MakeFeature, args [][
 		features = append(features, fm.make)
 		if fragment.Control() {
 			ctrl.control = append(ctrl.control, fragment.Register())
+			for _, arg := range fragment.Arguments() {
+				if !arg.IsRaw() {
+					args[fragment.Register()] = append(args[fragment.Register()], arg.Register())
+				}
+			}
 			continue
 		}
 
@@ -0,0 +1,841 @@
+package flows
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+)
+
+// FeatureWithCheckpoint represents a feature that can save and restore its state to and from a checkpoint.
+//
+// Checkpoint support is explicit: The base structs (NoopFeature, EmptyBaseFeature, BaseFeature, and the MultiBase
+// features) don't implement this interface. Features, which consist only of one of those, are checkpointed without it
+// (the value in case of BaseFeature). Features with additional fields must implement this interface, call
+// CheckpointValue of an embedded BaseFeature, and handle every additional field. Registering a feature with additional
+// fields, which doesn't implement this interface, panics. Features embedding another feature inherit its Checkpoint
+// and must override it, if they add fields.
+type FeatureWithCheckpoint interface {
+	// Checkpoint saves or restores the state of the feature. The same function is used for both directions: The
+	// field pointers provided to Checkpoint are written during save, and overwritten during restore (see Checkpoint.Loading).
+	Checkpoint(*Checkpoint)
+}
+
+const (
+	checkpointMagic   = "go-flows checkpoint"
+	checkpointVersion = 1
+)
+
+// value tags for Checkpoint.Value
+const (
+	checkpointNil byte = iota
+	checkpointBool
+	checkpointInt
+	checkpointInt8
+	checkpointInt16
+	checkpointInt32
+	checkpointInt64
+	checkpointUint
+	checkpointUint8
+	checkpointUint16
+	checkpointUint32
+	checkpointUint64
+	checkpointFloat32
+	checkpointFloat64
+	checkpointString
+	checkpointBytes
+	checkpointIP
+	checkpointMAC
+	checkpointFlowEndReason
+	checkpointDateTimeSeconds
+	checkpointDateTimeMilliseconds
+	checkpointDateTimeMicroseconds
+	checkpointDateTimeNanoseconds
+	checkpointSlice
+	checkpointStrings
+	checkpointGob
+)
+
+// gobValue is used for encoding values of unknown type with encoding/gob. Such types must be registered with gob.Register.
+type gobValue struct {
+	V interface{}
+}
+
+// Checkpoint writes or reads the state of the flow tables, flows, and features to or from a checkpoint.
+//
+// Errors are sticky: after the first error every further call is a no-op and Err returns the error.
+type Checkpoint struct {
+	w       *bufio.Writer
+	r       *bufio.Reader
+	enc     *gob.Encoder
+	dec     *gob.Decoder
+	err     error
+	scratch [binary.MaxVarintLen64]byte
+	// size is the size of the restored checkpoint (-1 if unknown) and input the number of bytes read from it so far
+	size  int64
+	input countingReader
+	// exports holds the position of every saved exportRecord of the current table; exportList is the restored counterpart
+	exports    map[*exportRecord]int
+	exportList []*exportRecord
+	// memory holds the copied state, if this checkpoint copies state in memory (see copyFeatures)
+	memory *memoryCheckpoint
+}
+
+// memoryCheckpoint holds the values of a checkpoint, which copies state in memory instead of encoding it
+type memoryCheckpoint struct {
+	values  []interface{}
+	pos     int
+	loading bool
+}
+
+// push saves v
+func (m *memoryCheckpoint) push(v interface{}) {
+	m.values = append(m.values, v)
+}
+
+// pop restores the next value
+func (c *Checkpoint) pop() interface{} {
+	if c.memory.pos == len(c.memory.values) {
+		c.Fail(errors.New("more values restored than saved"))
+		return nil
+	}
+	v := c.memory.values[c.memory.pos]
+	c.memory.pos++
+	return v
+}
+
+// copyFeatures copies the state of the features from into the features to, which must be made by the same record
+// maker. The state is transferred with the checkpoint functions of the features, but without encoding it.
+func copyFeatures(to, from []Feature) error {
+	c := &Checkpoint{memory: &memoryCheckpoint{}, size: -1}
+	for _, feature := range from {
+		c.Feature(feature)
+	}
+	c.memory.loading = true
+	for _, feature := range to {
+		c.Feature(feature)
+	}
+	if c.err == nil && c.memory.pos != len(c.memory.values) {
+		c.Fail(fmt.Errorf("%d values saved, but only %d restored", len(c.memory.values), c.memory.pos))
+	}
+	return c.err
+}
+
+// NewCheckpointWriter returns a Checkpoint that saves state to w. Flush must be called after everything was written.
+func NewCheckpointWriter(w io.Writer) *Checkpoint {
+	ret := &Checkpoint{w: bufio.NewWriter(w)}
+	ret.header()
+	return ret
+}
+
+// NewCheckpointReader returns a Checkpoint that restores state from r. If the size of r is known (e.g., a regular
+// file), lengths read from the checkpoint are checked against the remaining input.
+func NewCheckpointReader(r io.Reader) *Checkpoint {
+	ret := &Checkpoint{size: inputSize(r)}
+	ret.input.r = r
+	ret.r = bufio.NewReader(&ret.input)
+	ret.header()
+	return ret
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// inputSize returns the number of bytes that can be read from r, or -1 if this is unknown
+func inputSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len())
+	case *os.File:
+		info, err := v.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		pos, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - pos
+	}
+	return -1
+}
+
+// remaining returns the number of bytes left in the checkpoint, or -1 if this is unknown
+func (c *Checkpoint) remaining() int64 {
+	if c.size < 0 {
+		return -1
+	}
+	return c.size - c.input.n + int64(c.r.Buffered())
+}
+
+// length restores a length. Every element needs at least one byte; therefore, lengths exceeding the remaining input
+// are the result of a corrupt checkpoint and result in an error.
+func (c *Checkpoint) length(n uint64) int {
+	if c.err != nil {
+		return 0
+	}
+	if remaining := c.remaining(); (remaining >= 0 && n > uint64(remaining)) || n > math.MaxInt32 {
+		c.Fail(fmt.Errorf("corrupt checkpoint: length %d exceeds remaining input", n))
+		return 0
+	}
+	return int(n)
+}
+
+func (c *Checkpoint) header() {
+	magic := checkpointMagic
+	c.String(&magic)
+	if magic != checkpointMagic {
+		c.Fail(errors.New("not a checkpoint file"))
+		return
+	}
+	version := checkpointVersion
+	c.Int(&version)
+	if version != checkpointVersion {
+		c.Fail(fmt.Errorf("unsupported checkpoint version %d", version))
+	}
+}
+
+// Loading returns true if the state is restored from the checkpoint and false if the state is saved.
+func (c *Checkpoint) Loading() bool {
+	if c.memory != nil {
+		return c.memory.loading
+	}
+	return c.r != nil
+}
+
+// Err returns the first error that occured
+func (c *Checkpoint) Err() error {
+	return c.err
+}
+
+// Fail marks the checkpoint as failed with the given error, if no error happened so far.
+func (c *Checkpoint) Fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// Flush writes outstanding data to the underlying writer
+func (c *Checkpoint) Flush() error {
+	if c.err == nil && c.w != nil {
+		c.err = c.w.Flush()
+	}
+	return c.err
+}
+
+// Check verifies that the restored value equals the current value. Can be used to detect incompatible configurations.
+// Values are compared by their string representation.
+func (c *Checkpoint) Check(what string, value interface{}) {
+	current := fmt.Sprint(value)
+	saved := current
+	c.String(&saved)
+	if c.err == nil && saved != current {
+		c.Fail(fmt.Errorf("%s of checkpoint (%s) doesn't match current %s (%s)", what, saved, what, current))
+	}
+}
+
+func (c *Checkpoint) uvarint(v uint64) uint64 {
+	if c.err != nil {
+		return 0
+	}
+	if c.memory != nil {
+		return c.memoryValue(v).(uint64)
+	}
+	if c.Loading() {
+		v, c.err = binary.ReadUvarint(c.r)
+		return v
+	}
+	n := binary.PutUvarint(c.scratch[:], v)
+	_, c.err = c.w.Write(c.scratch[:n])
+	return v
+}
+
+func (c *Checkpoint) varint(v int64) int64 {
+	if c.err != nil {
+		return 0
+	}
+	if c.memory != nil {
+		return c.memoryValue(v).(int64)
+	}
+	if c.Loading() {
+		v, c.err = binary.ReadVarint(c.r)
+		return v
+	}
+	n := binary.PutVarint(c.scratch[:], v)
+	_, c.err = c.w.Write(c.scratch[:n])
+	return v
+}
+
+func (c *Checkpoint) byte(v byte) byte {
+	if c.err != nil {
+		return 0
+	}
+	if c.memory != nil {
+		return c.memoryValue(v).(byte)
+	}
+	if c.Loading() {
+		v, c.err = c.r.ReadByte()
+		return v
+	}
+	c.err = c.w.WriteByte(v)
+	return v
+}
+
+func (c *Checkpoint) raw(v []byte) []byte {
+	if c.memory != nil {
+		if c.Loading() {
+			return c.memoryValue([]byte(nil)).([]byte)
+		}
+		if len(v) > 0 {
+			c.memory.push(append([]byte(nil), v...))
+		} else {
+			c.memory.push([]byte(nil))
+		}
+		return v
+	}
+	if !c.Loading() {
+		c.uvarint(uint64(len(v)))
+		if c.err == nil {
+			_, c.err = c.w.Write(v)
+		}
+		return v
+	}
+	n := c.length(c.uvarint(0))
+	if c.err != nil {
+		return nil
+	}
+	if v == nil && n == 0 {
+		return nil
+	}
+	if c.size < 0 {
+		// unknown size -> grow the buffer while reading instead of trusting the length
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, c.r, int64(n)); err != nil {
+			c.Fail(fmt.Errorf("corrupt checkpoint: %s", err))
+			return nil
+		}
+		return append(v[:0], buf.Bytes()...)
+	}
+	v = make([]byte, n)
+	_, c.err = io.ReadFull(c.r, v)
+	return v
+}
+
+// Len saves the given length or returns the restored length. Use this for slices and maps.
+//
+// Restored lengths are at most the remaining size of the checkpoint (see NewCheckpointReader), since every element
+// needs at least one byte.
+func (c *Checkpoint) Len(n int) int {
+	if c.Loading() {
+		return c.length(c.uvarint(0))
+	}
+	return int(c.uvarint(uint64(n)))
+}
+
+// Bool saves or restores a bool
+func (c *Checkpoint) Bool(v *bool) {
+	var b byte
+	if *v {
+		b = 1
+	}
+	if c.Loading() {
+		*v = c.byte(b) != 0
+	} else {
+		c.byte(b)
+	}
+}
+
+// Uint8 saves or restores an uint8
+func (c *Checkpoint) Uint8(v *uint8) {
+	if c.Loading() {
+		*v = c.byte(*v)
+	} else {
+		c.byte(*v)
+	}
+}
+
+// Uint16 saves or restores an uint16
+func (c *Checkpoint) Uint16(v *uint16) {
+	if c.Loading() {
+		*v = uint16(c.uvarint(uint64(*v)))
+	} else {
+		c.uvarint(uint64(*v))
+	}
+}
+
+// Uint32 saves or restores an uint32
+func (c *Checkpoint) Uint32(v *uint32) {
+	if c.Loading() {
+		*v = uint32(c.uvarint(uint64(*v)))
+	} else {
+		c.uvarint(uint64(*v))
+	}
+}
+
+// Uint64 saves or restores an uint64
+func (c *Checkpoint) Uint64(v *uint64) {
+	if c.Loading() {
+		*v = c.uvarint(*v)
+	} else {
+		c.uvarint(*v)
+	}
+}
+
+// Int saves or restores an int
+func (c *Checkpoint) Int(v *int) {
+	if c.Loading() {
+		*v = int(c.varint(int64(*v)))
+	} else {
+		c.varint(int64(*v))
+	}
+}
+
+// Int64 saves or restores an int64
+func (c *Checkpoint) Int64(v *int64) {
+	if c.Loading() {
+		*v = c.varint(*v)
+	} else {
+		c.varint(*v)
+	}
+}
+
+// Float64 saves or restores a float64
+func (c *Checkpoint) Float64(v *float64) {
+	if c.Loading() {
+		*v = math.Float64frombits(c.uvarint(math.Float64bits(*v)))
+	} else {
+		c.uvarint(math.Float64bits(*v))
+	}
+}
+
+// Time saves or restores a DateTimeNanoseconds
+func (c *Checkpoint) Time(v *DateTimeNanoseconds) {
+	if c.Loading() {
+		*v = DateTimeNanoseconds(c.uvarint(uint64(*v)))
+	} else {
+		c.uvarint(uint64(*v))
+	}
+}
+
+// String saves or restores a string
+func (c *Checkpoint) String(v *string) {
+	if c.Loading() {
+		*v = string(c.raw(nil))
+	} else {
+		c.raw([]byte(*v))
+	}
+}
+
+// Bytes saves or restores a byte slice. nil and empty slices are restored as nil.
+func (c *Checkpoint) Bytes(v *[]byte) {
+	if c.Loading() {
+		*v = c.raw(nil)
+	} else {
+		c.raw(*v)
+	}
+}
+
+// Value saves or restores a value as returned by Feature.Value (i.e., numbers, time, net.IP, strings, byte slices,
+// and slices of those). Values of other types are handled with encoding/gob and must be registered with gob.Register.
+func (c *Checkpoint) Value(v *interface{}) {
+	if c.err != nil {
+		return
+	}
+	if c.memory != nil {
+		if c.Loading() {
+			*v = c.memoryValue(nil)
+		} else {
+			c.memory.push(c.copyValue(*v))
+		}
+		return
+	}
+	if c.Loading() {
+		*v = c.loadValue()
+	} else {
+		c.saveValue(*v)
+	}
+}
+
+// memoryValue saves v, or restores a value of the same type as v (which is returned on errors) of a memory checkpoint
+func (c *Checkpoint) memoryValue(v interface{}) interface{} {
+	if !c.memory.loading {
+		c.memory.push(v)
+		return v
+	}
+	ret := c.pop()
+	if c.err != nil {
+		return v
+	}
+	if v != nil && reflect.TypeOf(ret) != reflect.TypeOf(v) {
+		c.Fail(fmt.Errorf("restored %T instead of %T", ret, v))
+		return v
+	}
+	return ret
+}
+
+// copyValue returns a copy of v, which doesn't share memory with v
+func (c *Checkpoint) copyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, string,
+		FlowEndReason, DateTimeSeconds, DateTimeMilliseconds, DateTimeMicroseconds, DateTimeNanoseconds:
+		return v
+	case []byte:
+		return append([]byte{}, val...)
+	case net.IP:
+		return append(net.IP{}, val...)
+	case net.HardwareAddr:
+		return append(net.HardwareAddr{}, val...)
+	case []string:
+		return append([]string{}, val...)
+	case []interface{}:
+		ret := make([]interface{}, len(val))
+		for i := range val {
+			ret[i] = c.copyValue(val[i])
+		}
+		return ret
+	}
+	ret, err := deepCopy(reflect.ValueOf(v))
+	if err != nil {
+		c.Fail(fmt.Errorf("can't copy value of type %T: %s", v, err))
+		return nil
+	}
+	return ret.Interface()
+}
+
+// deepCopy returns a copy of v, which doesn't share memory with v. Like with encoding/gob, only exported fields of
+// structs can be copied, and channels and functions are not supported.
+func deepCopy(v reflect.Value) (reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return v, fmt.Errorf("unsupported type %s", v.Type())
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return v, nil
+		}
+		elem, err := deepCopy(v.Elem())
+		if err != nil {
+			return v, err
+		}
+		if v.Kind() == reflect.Interface {
+			ret := reflect.New(v.Type()).Elem()
+			ret.Set(elem)
+			return ret, nil
+		}
+		ret := reflect.New(v.Type().Elem())
+		ret.Elem().Set(elem)
+		return ret, nil
+	case reflect.Slice, reflect.Array:
+		var ret reflect.Value
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return v, nil
+			}
+			ret = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		} else {
+			ret = reflect.New(v.Type()).Elem()
+		}
+		for i := 0; i < v.Len(); i++ {
+			elem, err := deepCopy(v.Index(i))
+			if err != nil {
+				return v, err
+			}
+			ret.Index(i).Set(elem)
+		}
+		return ret, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return v, nil
+		}
+		ret := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			elem, err := deepCopy(v.MapIndex(key))
+			if err != nil {
+				return v, err
+			}
+			ret.SetMapIndex(key, elem)
+		}
+		return ret, nil
+	case reflect.Struct:
+		ret := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				return v, fmt.Errorf("unexported field %s of %s", v.Type().Field(i).Name, v.Type())
+			}
+			field, err := deepCopy(v.Field(i))
+			if err != nil {
+				return v, err
+			}
+			ret.Field(i).Set(field)
+		}
+		return ret, nil
+	}
+	ret := reflect.New(v.Type()).Elem()
+	ret.Set(v)
+	return ret, nil
+}
+
+func (c *Checkpoint) saveValue(v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		c.byte(checkpointNil)
+	case bool:
+		c.byte(checkpointBool)
+		c.Bool(&val)
+	case int:
+		c.byte(checkpointInt)
+		c.varint(int64(val))
+	case int8:
+		c.byte(checkpointInt8)
+		c.varint(int64(val))
+	case int16:
+		c.byte(checkpointInt16)
+		c.varint(int64(val))
+	case int32:
+		c.byte(checkpointInt32)
+		c.varint(int64(val))
+	case int64:
+		c.byte(checkpointInt64)
+		c.varint(val)
+	case uint:
+		c.byte(checkpointUint)
+		c.uvarint(uint64(val))
+	case uint8:
+		c.byte(checkpointUint8)
+		c.byte(val)
+	case uint16:
+		c.byte(checkpointUint16)
+		c.uvarint(uint64(val))
+	case uint32:
+		c.byte(checkpointUint32)
+		c.uvarint(uint64(val))
+	case uint64:
+		c.byte(checkpointUint64)
+		c.uvarint(val)
+	case float32:
+		c.byte(checkpointFloat32)
+		c.uvarint(uint64(math.Float32bits(val)))
+	case float64:
+		c.byte(checkpointFloat64)
+		c.uvarint(math.Float64bits(val))
+	case string:
+		c.byte(checkpointString)
+		c.raw([]byte(val))
+	case []byte:
+		c.byte(checkpointBytes)
+		c.raw(val)
+	case net.IP:
+		c.byte(checkpointIP)
+		c.raw(val)
+	case net.HardwareAddr:
+		c.byte(checkpointMAC)
+		c.raw(val)
+	case FlowEndReason:
+		c.byte(checkpointFlowEndReason)
+		c.byte(byte(val))
+	case DateTimeSeconds:
+		c.byte(checkpointDateTimeSeconds)
+		c.uvarint(uint64(val))
+	case DateTimeMilliseconds:
+		c.byte(checkpointDateTimeMilliseconds)
+		c.uvarint(uint64(val))
+	case DateTimeMicroseconds:
+		c.byte(checkpointDateTimeMicroseconds)
+		c.uvarint(uint64(val))
+	case DateTimeNanoseconds:
+		c.byte(checkpointDateTimeNanoseconds)
+		c.uvarint(uint64(val))
+	case []interface{}:
+		c.byte(checkpointSlice)
+		c.Len(len(val))
+		for _, elem := range val {
+			c.saveValue(elem)
+		}
+	case []string:
+		c.byte(checkpointStrings)
+		c.Len(len(val))
+		for _, elem := range val {
+			c.raw([]byte(elem))
+		}
+	default:
+		c.byte(checkpointGob)
+		if c.enc == nil {
+			c.enc = gob.NewEncoder(c.w)
+		}
+		if err := c.enc.Encode(gobValue{v}); err != nil {
+			c.Fail(fmt.Errorf("can't save value of type %T: %s", v, err))
+		}
+	}
+}
+
+func (c *Checkpoint) loadValue() interface{} {
+	tag := c.byte(0)
+	if c.err != nil {
+		return nil
+	}
+	switch tag {
+	case checkpointNil:
+		return nil
+	case checkpointBool:
+		return c.byte(0) != 0
+	case checkpointInt:
+		return int(c.varint(0))
+	case checkpointInt8:
+		return int8(c.varint(0))
+	case checkpointInt16:
+		return int16(c.varint(0))
+	case checkpointInt32:
+		return int32(c.varint(0))
+	case checkpointInt64:
+		return c.varint(0)
+	case checkpointUint:
+		return uint(c.uvarint(0))
+	case checkpointUint8:
+		return c.byte(0)
+	case checkpointUint16:
+		return uint16(c.uvarint(0))
+	case checkpointUint32:
+		return uint32(c.uvarint(0))
+	case checkpointUint64:
+		return c.uvarint(0)
+	case checkpointFloat32:
+		return math.Float32frombits(uint32(c.uvarint(0)))
+	case checkpointFloat64:
+		return math.Float64frombits(c.uvarint(0))
+	case checkpointString:
+		return string(c.raw(nil))
+	case checkpointBytes:
+		return c.raw([]byte{})
+	case checkpointIP:
+		return net.IP(c.raw([]byte{}))
+	case checkpointMAC:
+		return net.HardwareAddr(c.raw([]byte{}))
+	case checkpointFlowEndReason:
+		return FlowEndReason(c.byte(0))
+	case checkpointDateTimeSeconds:
+		return DateTimeSeconds(c.uvarint(0))
+	case checkpointDateTimeMilliseconds:
+		return DateTimeMilliseconds(c.uvarint(0))
+	case checkpointDateTimeMicroseconds:
+		return DateTimeMicroseconds(c.uvarint(0))
+	case checkpointDateTimeNanoseconds:
+		return DateTimeNanoseconds(c.uvarint(0))
+	case checkpointSlice:
+		ret := make([]interface{}, c.Len(0))
+		for i := range ret {
+			ret[i] = c.loadValue()
+		}
+		return ret
+	case checkpointStrings:
+		ret := make([]string, c.Len(0))
+		for i := range ret {
+			ret[i] = string(c.raw(nil))
+		}
+		return ret
+	case checkpointGob:
+		if c.dec == nil {
+			c.dec = gob.NewDecoder(c.r)
+		}
+		var ret gobValue
+		if err := c.dec.Decode(&ret); err != nil {
+			c.Fail(fmt.Errorf("can't restore value: %s", err))
+		}
+		return ret.V
+	}
+	c.Fail(fmt.Errorf("unknown value type %d in checkpoint", tag))
+	return nil
+}
+
+// featureWithValue is implemented by features embedding BaseFeature
+type featureWithValue interface {
+	CheckpointValue(*Checkpoint)
+}
+
+var baseFeatureTypes = map[reflect.Type]bool{
+	reflect.TypeOf(NoopFeature{}):      true,
+	reflect.TypeOf(EmptyBaseFeature{}): true,
+	reflect.TypeOf(BaseFeature{}):      true,
+	// the arguments are restored from the specification, and event tracking is reset after every event
+	reflect.TypeOf(MultiBasePacketFeature{}): true,
+	reflect.TypeOf(MultiBaseFlowFeature{}):   true,
+}
+
+// featureState returns the first field of the feature type t, which is not part of an embedded base struct, or ""
+func featureState(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return t.String()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.Anonymous {
+			return t.String() + "." + field.Name
+		}
+		if baseFeatureTypes[field.Type] {
+			continue
+		}
+		if state := featureState(field.Type); state != "" {
+			return state
+		}
+	}
+	return ""
+}
+
+var checkpointSupport sync.Map // reflect.Type -> error
+
+// checkCheckpointSupport returns an error, if the feature holds state besides the embedded base struct, but doesn't
+// implement FeatureWithCheckpoint
+func checkCheckpointSupport(f Feature) error {
+	if _, ok := f.(FeatureWithCheckpoint); ok {
+		return nil
+	}
+	t := reflect.TypeOf(f)
+	if err, ok := checkpointSupport.Load(t); ok {
+		if err == nil {
+			return nil
+		}
+		return err.(error)
+	}
+	var err error
+	if state := featureState(t); state != "" {
+		err = fmt.Errorf("feature %s holds state in %s, but doesn't implement FeatureWithCheckpoint", t, state)
+	}
+	checkpointSupport.Store(t, err)
+	return err
+}
+
+// Feature saves or restores the state of the given feature. Fails if the feature holds state, but doesn't implement
+// FeatureWithCheckpoint.
+func (c *Checkpoint) Feature(f Feature) {
+	if c.err != nil {
+		return
+	}
+	if cf, ok := f.(FeatureWithCheckpoint); ok {
+		cf.Checkpoint(c)
+		return
+	}
+	if err := checkCheckpointSupport(f); err != nil {
+		c.Fail(err)
+		return
+	}
+	if vf, ok := f.(featureWithValue); ok {
+		vf.CheckpointValue(c)
+	}
+}
@@ -0,0 +1,270 @@
+package flows_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet_test"
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/google/gopacket/layers"
+
+	_ "github.com/CN-TU/go-flows/modules/features/control"
+	_ "github.com/CN-TU/go-flows/modules/features/custom"
+	_ "github.com/CN-TU/go-flows/modules/features/iana"
+	_ "github.com/CN-TU/go-flows/modules/features/nta"
+	_ "github.com/CN-TU/go-flows/modules/features/operations"
+	_ "github.com/CN-TU/go-flows/modules/features/staging"
+)
+
+var checkpointValues = []interface{}{
+	nil, true, int(-1), int8(-2), int16(-3), int32(-4), int64(-5),
+	uint(1), uint8(2), uint16(3), uint32(4), uint64(5), float32(1.5), float64(2.5),
+	"string", []byte{1, 2, 3}, net.IP{10, 0, 0, 1}, net.HardwareAddr{1, 2, 3, 4, 5, 6},
+	flows.FlowEndReasonIdle, flows.DateTimeSeconds(1), flows.DateTimeMilliseconds(2), flows.DateTimeMicroseconds(3),
+	flows.DateTimeNanoseconds(4), []interface{}{uint8(1), "a", []interface{}{nil}}, []string{"a", "b"},
+}
+
+func writeCheckpointValues(t *testing.T) []byte {
+	var buf bytes.Buffer
+	c := flows.NewCheckpointWriter(&buf)
+	for i := range checkpointValues {
+		c.Value(&checkpointValues[i])
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheckpointValues(t *testing.T) {
+	c := flows.NewCheckpointReader(bytes.NewReader(writeCheckpointValues(t)))
+	for _, value := range checkpointValues {
+		var restored interface{}
+		c.Value(&restored)
+		if !reflect.DeepEqual(value, restored) {
+			t.Errorf("restored %#v instead of %#v", restored, value)
+		}
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hiddenSize hides the size of the underlying reader from the checkpoint
+type hiddenSize struct {
+	io.Reader
+}
+
+func TestCheckpointTruncated(t *testing.T) {
+	data := writeCheckpointValues(t)
+	for i := 0; i < len(data); i++ {
+		for _, r := range []io.Reader{bytes.NewReader(data[:i]), hiddenSize{bytes.NewReader(data[:i])}} {
+			c := flows.NewCheckpointReader(r)
+			for range checkpointValues {
+				var restored interface{}
+				c.Value(&restored)
+			}
+			if c.Err() == nil {
+				t.Fatalf("checkpoint truncated to %d bytes restored without error", i)
+			}
+		}
+	}
+}
+
+func TestCheckpointCorruptLength(t *testing.T) {
+	var buf bytes.Buffer
+	c := flows.NewCheckpointWriter(&buf)
+	c.Flush()
+	header := buf.Bytes()
+	var scratch [binary.MaxVarintLen64]byte
+	for _, length := range []uint64{1 << 30, 1 << 62} {
+		data := append(append([]byte(nil), header...), scratch[:binary.PutUvarint(scratch[:], length)]...)
+		data = append(data, 1, 2, 3)
+		for _, r := range []io.Reader{bytes.NewReader(data), hiddenSize{bytes.NewReader(data)}} {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			c := flows.NewCheckpointReader(r)
+			var s string
+			c.String(&s)
+			runtime.ReadMemStats(&after)
+			if c.Err() == nil {
+				t.Errorf("string with length %d restored without error", length)
+			}
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+				t.Errorf("restoring string with length %d allocated %d bytes", length, alloc)
+			}
+			c = flows.NewCheckpointReader(bytes.NewReader(data))
+			if n := c.Len(0); n != 0 || c.Err() == nil {
+				t.Errorf("length %d restored as %d without error", length, n)
+			}
+		}
+	}
+}
+
+func TestCheckpointFeatures(t *testing.T) {
+	// every registered feature must restore exactly what it saved
+	features := flows.RegisteredFeatures()
+	var buf bytes.Buffer
+	c := flows.NewCheckpointWriter(&buf)
+	for _, feature := range features {
+		c.Feature(feature.Make())
+		c.Check("feature", feature.Name)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	c = flows.NewCheckpointReader(&buf)
+	for _, feature := range features {
+		c.Feature(feature.Make())
+		c.Check("feature", feature.Name)
+		if err := c.Err(); err != nil {
+			t.Fatalf("feature %s: %s", feature.Name, err)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes left after restoring all features", buf.Len())
+	}
+}
+
+func TestCopyFeatures(t *testing.T) {
+	// every registered feature must copy exactly what it saved
+	var from, to []flows.Feature
+	for _, feature := range flows.RegisteredFeatures() {
+		from = append(from, feature.Make())
+		to = append(to, feature.Make())
+	}
+	if err := flows.CopyFeatures(to, from); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// copyValue is a value, which can only be copied with reflection
+type copyValue struct {
+	Counts map[string][]int
+	Next   *copyValue
+}
+
+func TestCopyFeatureValues(t *testing.T) {
+	bytesFeature, structFeature := &valueFeature{}, &valueFeature{}
+	bytesFeature.SetValue([]byte{1, 2, 3}, nil, bytesFeature)
+	structFeature.SetValue(copyValue{Counts: map[string][]int{"a": {1}}, Next: &copyValue{}}, nil, structFeature)
+	from := []flows.Feature{bytesFeature, structFeature}
+	to := []flows.Feature{&valueFeature{}, &valueFeature{}}
+	if err := flows.CopyFeatures(to, from); err != nil {
+		t.Fatal(err)
+	}
+	// the copies must not share memory with the original
+	bytesFeature.Value().([]byte)[0] = 0
+	structFeature.Value().(copyValue).Counts["a"][0] = 0
+	structFeature.Value().(copyValue).Next.Counts = map[string][]int{}
+	want := []interface{}{[]byte{1, 2, 3}, copyValue{Counts: map[string][]int{"a": {1}}, Next: &copyValue{}}}
+	for i := range to {
+		if !reflect.DeepEqual(to[i].Value(), want[i]) {
+			t.Errorf("copied value %#v instead of %#v", to[i].Value(), want[i])
+		}
+	}
+
+	// unexported fields can't be copied
+	unexported := &valueFeature{}
+	unexported.SetValue(struct{ count int }{1}, nil, unexported)
+	if err := flows.CopyFeatures([]flows.Feature{&valueFeature{}}, []flows.Feature{unexported}); err == nil {
+		t.Error("value with unexported field was copied")
+	}
+	if err := flows.CopyFeatures([]flows.Feature{&valueFeature{}}, []flows.Feature{&countFeature{}}); err == nil {
+		t.Error("feature with unsupported state was copied")
+	}
+}
+
+// valueFeature holds only the value of BaseFeature
+type valueFeature struct {
+	flows.BaseFeature
+}
+
+// countFeature holds state, but doesn't support checkpoints
+type countFeature struct {
+	flows.BaseFeature
+	count int
+}
+
+func TestCheckpointFeatureState(t *testing.T) {
+	var buf bytes.Buffer
+	c := flows.NewCheckpointWriter(&buf)
+	saved := &valueFeature{}
+	saved.SetValue(uint64(42), nil, saved)
+	c.Feature(saved)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	restored := &valueFeature{}
+	c = flows.NewCheckpointReader(&buf)
+	c.Feature(restored)
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Value() != uint64(42) {
+		t.Errorf("restored value %v instead of 42", restored.Value())
+	}
+
+	c = flows.NewCheckpointWriter(&buf)
+	c.Feature(&countFeature{})
+	if c.Err() == nil {
+		t.Error("feature with unsupported state was checkpointed")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("feature with unsupported state was registered")
+		}
+	}()
+	flows.RegisterTemporaryFeature("_checkpointCount", "", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &countFeature{} }, flows.RawPacket)
+}
+
+func TestCheckpointResume(t *testing.T) {
+	source := &packet_test.MemorySource{}
+	for i := 0; i < 3000; i++ {
+		port := layers.TCPPort(1000 + i%37)
+		err := source.AppendLayers(flows.DateTimeNanoseconds(i)*1e7+1,
+			&layers.IPv4{Version: 4, TTL: 64, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolTCP},
+			&layers.TCP{SrcPort: port, DstPort: 80, SYN: i < 37, ACK: i >= 37, PSH: i%3 == 0, Window: uint16(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	specs := []packet_test.EngineSpec{{
+		Features: []interface{}{"sourceTransportPort", "flowStartNanoseconds", "flowEndNanoseconds", "packetTotalCount",
+			"octetTotalCount", "tcpPshTotalCount", "flowEndReason",
+			[]interface{}{"mean", "tcpWindowSize"}, []interface{}{"median", "tcpWindowSize"}, []interface{}{"mode", "tcpWindowSize"},
+			[]interface{}{"min", "tcpWindowSize"}, []interface{}{"max", "tcpWindowSize"}, []interface{}{"stdev", "tcpWindowSize"},
+			[]interface{}{"distinct", "tcpWindowSize"}, []interface{}{"set", "tcpWindowSize"}, []interface{}{"get", 2, "tcpWindowSize"},
+			[]interface{}{"concatenate", "tcpWindowSize"}, []interface{}{"max", "_interPacketTimeNanoseconds"}},
+		Options: flows.FlowOptions{ActiveTimeout: 5 * flows.SecondsInNanoseconds, IdleTimeout: 2 * flows.SecondsInNanoseconds},
+	}}
+	file := filepath.Join(t.TempDir(), "checkpoint")
+	for _, order := range []flows.SortType{flows.SortTypeNone, flows.SortTypeStartTime, flows.SortTypeExpiryTime} {
+		for _, tables := range []int{1, 2} {
+			opt := packet_test.EngineOptions{Sort: order, Tables: tables, Deterministic: true}
+			full := packet_test.RunEngine(t, source, specs, opt)
+			for _, after := range []int{1, 1000, 2999} {
+				first := opt
+				first.Checkpoint = file
+				first.CheckpointAfter = after
+				resumed := packet_test.RunEngine(t, source, specs, first)
+				second := opt
+				second.Resume = file
+				resumed = append(resumed, packet_test.RunEngine(t, source, specs, second)...)
+				if order == flows.SortTypeNone {
+					resumed, full = sortedLines(resumed), sortedLines(full)
+				}
+				if !reflect.DeepEqual(full, resumed) {
+					t.Errorf("sort order %d, %d tables: output resumed after %d packets differs from uninterrupted run", order, tables, after)
+				}
+			}
+		}
+	}
+}
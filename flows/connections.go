@@ -0,0 +1,428 @@
+package flows
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Connection context (KDD-style host and service counts):
+
+Every flow created by a table with a connection context is recorded as a connection (source host, destination host,
+and service = protocol + destination port). Context features query the number of connections with the same hosts or
+service within two windows:
+
+	time window:  the connections started within the last Window nanoseconds
+	count window: the last Connections connections
+
+Both windows include the connection of the queried flow. The context is shared by all the tables of a table group,
+since the hash sharding distributes the connections of a single host over all the tables. Tables run concurrently,
+which is why connections are added as pending connections and committed to the windows in event order: A query at
+event n waits until every other table handled all its events before n (or up to n, if n is not an event of this
+table; see FlowTable.Progress), commits all the pending connections up to n, and ignores connections of later events. This results in the same counts regardless of
+the number of tables.
+*/
+
+// ContextOptions configures the connection context of a table
+type ContextOptions struct {
+	// Window is the length of the time window in nanoseconds
+	Window DateTimeNanoseconds
+	// Connections is the size of the count window
+	Connections int
+	// AtEnd evaluates context features at the end of a flow instead of at the start
+	AtEnd bool
+}
+
+// Enabled returns true if a connection context is configured
+func (o ContextOptions) Enabled() bool {
+	return o.Window != 0 || o.Connections != 0
+}
+
+// Connection identifies the hosts and the service of a connection
+type Connection struct {
+	// Src is the source address as raw bytes
+	Src string
+	// Dst is the destination address as raw bytes
+	Dst string
+	// Service is the protocol and the destination port (protocol<<16 | port)
+	Service uint32
+}
+
+// ConnectionEvent is an event that can be recorded as connection in a connection context. Events not implementing
+// this interface are ignored.
+type ConnectionEvent interface {
+	// ConnectionInfo returns the addresses, the IP protocol, and the ports of the event. ok is false for non-ip events.
+	ConnectionInfo() (src, dst []byte, proto uint8, srcPort, dstPort uint16, ok bool)
+}
+
+// ConnectionOf returns the connection of event in the direction of the flow (forward = event is in flow direction)
+func ConnectionOf(event interface{}, forward bool) (ret Connection, ok bool) {
+	ce, ok := event.(ConnectionEvent)
+	if !ok {
+		return
+	}
+	src, dst, proto, srcPort, dstPort, ok := ce.ConnectionInfo()
+	if !ok {
+		return
+	}
+	if !forward {
+		src, dst, dstPort = dst, src, srcPort
+	}
+	return Connection{
+		Src:     string(src),
+		Dst:     string(dst),
+		Service: uint32(proto)<<16 | uint32(dstPort),
+	}, true
+}
+
+// ConnectionCounts holds the result of a query to a connection context
+type ConnectionCounts struct {
+	// SrcHostConn is the number of connections from the same source host (time window)
+	SrcHostConn uint64
+	// SrcHostDst is the number of distinct destination hosts contacted by the same source host (time window)
+	SrcHostDst uint64
+	// DstHostConn is the number of connections to the same destination host (time window)
+	DstHostConn uint64
+	// DstHostSameSrv is the number of connections to the same destination host and service (time window)
+	DstHostSameSrv uint64
+	// SrvConn is the number of connections to the same service (time window)
+	SrvConn uint64
+	// DstHost is the number of connections to the same destination host (count window)
+	DstHost uint64
+	// DstHostSrv is the number of connections to the same destination host and service (count window)
+	DstHostSrv uint64
+}
+
+type hostService struct {
+	host    string
+	service uint32
+}
+
+type contextEntry struct {
+	Connection
+	when DateTimeNanoseconds
+	nr   uint64
+}
+
+// ConnectionContext holds the recent connections of a table group. It is safe for concurrent use.
+type ConnectionContext struct {
+	ContextOptions
+	mutex sync.Mutex
+	cond  *sync.Cond
+	// progress holds the number of the last event handled by each table; waiting is the number of waiting queries
+	progress []uint64
+	waiting  int32
+	// pending holds the connections of events after cursor sorted by event number
+	pending []contextEntry
+	cursor  uint64
+	entries []contextEntry
+	// timeStart and countStart are the first entries in the time and count window
+	timeStart  int
+	countStart int
+	last       DateTimeNanoseconds
+	// counters of the time window
+	src    map[string]uint64
+	srcDst map[string]map[string]uint64
+	dst    map[string]uint64
+	dstSrv map[hostService]uint64
+	srv    map[uint32]uint64
+	// counters of the count window
+	countDst    map[string]uint64
+	countDstSrv map[hostService]uint64
+}
+
+// NewConnectionContext returns a new connection context with the given windows
+func NewConnectionContext(options ContextOptions) *ConnectionContext {
+	ret := &ConnectionContext{
+		ContextOptions: options,
+	}
+	ret.cond = sync.NewCond(&ret.mutex)
+	ret.reset()
+	return ret
+}
+
+// register adds a table to the context and returns the id of the table. Must be called before any events are handled.
+func (ctx *ConnectionContext) register() int {
+	ctx.progress = append(ctx.progress, 0)
+	return len(ctx.progress) - 1
+}
+
+// setProgress marks every event up to nr as handled by table id
+func (ctx *ConnectionContext) setProgress(id int, nr uint64) {
+	atomic.StoreUint64(&ctx.progress[id], nr)
+	if atomic.LoadInt32(&ctx.waiting) > 0 {
+		ctx.mutex.Lock()
+		ctx.cond.Broadcast()
+		ctx.mutex.Unlock()
+	}
+}
+
+// reached returns true if every other table handled at least the events handled by table id. Must be called with the
+// mutex held.
+func (ctx *ConnectionContext) reached(id int) bool {
+	nr := atomic.LoadUint64(&ctx.progress[id])
+	for i := range ctx.progress {
+		if atomic.LoadUint64(&ctx.progress[i]) < nr {
+			return false
+		}
+	}
+	return true
+}
+
+func (ctx *ConnectionContext) reset() {
+	ctx.pending = nil
+	ctx.cursor = 0
+	ctx.entries = nil
+	ctx.timeStart = 0
+	ctx.countStart = 0
+	ctx.src = make(map[string]uint64)
+	ctx.srcDst = make(map[string]map[string]uint64)
+	ctx.dst = make(map[string]uint64)
+	ctx.dstSrv = make(map[hostService]uint64)
+	ctx.srv = make(map[uint32]uint64)
+	ctx.countDst = make(map[string]uint64)
+	ctx.countDstSrv = make(map[hostService]uint64)
+}
+
+// String returns the configuration of the context (used for comparing flow options in checkpoints)
+func (ctx *ConnectionContext) String() string {
+	return fmt.Sprintf("%+v", ctx.ContextOptions)
+}
+
+func decrement(m map[string]uint64, key string) {
+	if m[key] <= 1 {
+		delete(m, key)
+	} else {
+		m[key]--
+	}
+}
+
+func decrementHostService(m map[hostService]uint64, key hostService) {
+	if m[key] <= 1 {
+		delete(m, key)
+	} else {
+		m[key]--
+	}
+}
+
+func (ctx *ConnectionContext) addTime(e *contextEntry) {
+	ctx.src[e.Src]++
+	dsts := ctx.srcDst[e.Src]
+	if dsts == nil {
+		dsts = make(map[string]uint64)
+		ctx.srcDst[e.Src] = dsts
+	}
+	dsts[e.Dst]++
+	ctx.dst[e.Dst]++
+	ctx.dstSrv[hostService{e.Dst, e.Service}]++
+	ctx.srv[e.Service]++
+}
+
+func (ctx *ConnectionContext) removeTime(e *contextEntry) {
+	decrement(ctx.src, e.Src)
+	dsts := ctx.srcDst[e.Src]
+	decrement(dsts, e.Dst)
+	if len(dsts) == 0 {
+		delete(ctx.srcDst, e.Src)
+	}
+	decrement(ctx.dst, e.Dst)
+	decrementHostService(ctx.dstSrv, hostService{e.Dst, e.Service})
+	if ctx.srv[e.Service] <= 1 {
+		delete(ctx.srv, e.Service)
+	} else {
+		ctx.srv[e.Service]--
+	}
+}
+
+func (ctx *ConnectionContext) addCount(e *contextEntry) {
+	ctx.countDst[e.Dst]++
+	ctx.countDstSrv[hostService{e.Dst, e.Service}]++
+}
+
+func (ctx *ConnectionContext) removeCount(e *contextEntry) {
+	decrement(ctx.countDst, e.Dst)
+	decrementHostService(ctx.countDstSrv, hostService{e.Dst, e.Service})
+}
+
+// expire removes the connections, which left the windows with the connection started at now. Must be called with the
+// mutex held.
+func (ctx *ConnectionContext) expire(now DateTimeNanoseconds) {
+	// connections of different tables might have slightly out of order timestamps
+	if now < ctx.last {
+		now = ctx.last
+	}
+	ctx.last = now
+	for len(ctx.entries)-ctx.countStart > ctx.Connections {
+		ctx.removeCount(&ctx.entries[ctx.countStart])
+		ctx.countStart++
+	}
+	for ctx.timeStart < len(ctx.entries) && ctx.entries[ctx.timeStart].when+ctx.Window <= now {
+		ctx.removeTime(&ctx.entries[ctx.timeStart])
+		ctx.timeStart++
+	}
+	first := ctx.first()
+	if first > 64 && first > len(ctx.entries)/2 {
+		n := copy(ctx.entries, ctx.entries[first:])
+		for i := n; i < len(ctx.entries); i++ {
+			ctx.entries[i] = contextEntry{}
+		}
+		ctx.entries = ctx.entries[:n]
+		ctx.timeStart -= first
+		ctx.countStart -= first
+	}
+}
+
+// first returns the first entry, which is still part of a window
+func (ctx *ConnectionContext) first() int {
+	if ctx.countStart < ctx.timeStart {
+		return ctx.countStart
+	}
+	return ctx.timeStart
+}
+
+// add records a connection started at event nr with time when
+func (ctx *ConnectionContext) add(nr uint64, when DateTimeNanoseconds, conn Connection) {
+	ctx.mutex.Lock()
+	i := len(ctx.pending)
+	for i > 0 && ctx.pending[i-1].nr > nr {
+		i--
+	}
+	ctx.pending = append(ctx.pending, contextEntry{})
+	copy(ctx.pending[i+1:], ctx.pending[i:])
+	ctx.pending[i] = contextEntry{conn, when, nr}
+	ctx.mutex.Unlock()
+}
+
+// commit adds all the pending connections up to event nr to the windows. Must be called with the mutex held.
+func (ctx *ConnectionContext) commit(nr uint64) {
+	n := 0
+	for ; n < len(ctx.pending) && ctx.pending[n].nr <= nr; n++ {
+		ctx.entries = append(ctx.entries, ctx.pending[n])
+		e := &ctx.entries[len(ctx.entries)-1]
+		if ctx.Window != 0 {
+			ctx.addTime(e)
+		} else {
+			ctx.timeStart = len(ctx.entries)
+		}
+		ctx.addCount(e)
+		ctx.expire(e.when)
+	}
+	if n > 0 {
+		m := copy(ctx.pending, ctx.pending[n:])
+		for i := m; i < len(ctx.pending); i++ {
+			ctx.pending[i] = contextEntry{}
+		}
+		ctx.pending = ctx.pending[:m]
+	}
+	if nr > ctx.cursor {
+		ctx.cursor = nr
+	}
+}
+
+// query returns the number of connections related to conn at event nr with time now for table id
+func (ctx *ConnectionContext) query(id int, nr uint64, now DateTimeNanoseconds, conn Connection) (ret ConnectionCounts) {
+	dstSrv := hostService{conn.Dst, conn.Service}
+	ctx.mutex.Lock()
+	if nr > ctx.cursor {
+		atomic.AddInt32(&ctx.waiting, 1)
+		for !ctx.reached(id) {
+			ctx.cond.Wait()
+		}
+		atomic.AddInt32(&ctx.waiting, -1)
+		ctx.commit(nr)
+	}
+	ret.SrcHostConn = ctx.src[conn.Src]
+	ret.SrcHostDst = uint64(len(ctx.srcDst[conn.Src]))
+	ret.DstHostConn = ctx.dst[conn.Dst]
+	ret.DstHostSameSrv = ctx.dstSrv[dstSrv]
+	ret.SrvConn = ctx.srv[conn.Service]
+	ret.DstHost = ctx.countDst[conn.Dst]
+	ret.DstHostSrv = ctx.countDstSrv[dstSrv]
+	// The windows only move with committed connections, since queries of different tables arrive in arbitrary
+	// order. Connections leaving the time window between the last connection and now are subtracted here.
+	var removed map[string]uint64
+	for i := ctx.timeStart; i < len(ctx.entries) && ctx.entries[i].when+ctx.Window <= now; i++ {
+		e := &ctx.entries[i]
+		if e.Src == conn.Src {
+			ret.SrcHostConn--
+			if removed == nil {
+				removed = make(map[string]uint64)
+			}
+			removed[e.Dst]++
+		}
+		if e.Dst == conn.Dst {
+			ret.DstHostConn--
+			if e.Service == conn.Service {
+				ret.DstHostSameSrv--
+			}
+		}
+		if e.Service == conn.Service {
+			ret.SrvConn--
+		}
+	}
+	dsts := ctx.srcDst[conn.Src]
+	for dst, n := range removed {
+		if dsts[dst] == n {
+			ret.SrcHostDst--
+		}
+	}
+	ctx.mutex.Unlock()
+	return
+}
+
+// Checkpoint saves or restores the connections of the context. Must not be called concurrently with events.
+func (ctx *ConnectionContext) Checkpoint(c *Checkpoint) {
+	ctx.mutex.Lock()
+	defer ctx.mutex.Unlock()
+	first := ctx.first()
+	n := c.Len(len(ctx.entries) - first)
+	timeStart := c.Len(ctx.timeStart - first)
+	countStart := c.Len(ctx.countStart - first)
+	pending := c.Len(len(ctx.pending))
+	if !c.Loading() {
+		c.Time(&ctx.last)
+		c.Uint64(&ctx.cursor)
+		for i := first; i < len(ctx.entries); i++ {
+			ctx.entries[i].checkpoint(c)
+		}
+		for i := range ctx.pending {
+			ctx.pending[i].checkpoint(c)
+		}
+		return
+	}
+	ctx.reset()
+	c.Time(&ctx.last)
+	c.Uint64(&ctx.cursor)
+	ctx.entries = make([]contextEntry, n)
+	for i := range ctx.entries {
+		if c.Err() != nil {
+			return
+		}
+		e := &ctx.entries[i]
+		e.checkpoint(c)
+		if i >= timeStart {
+			ctx.addTime(e)
+		}
+		if i >= countStart {
+			ctx.addCount(e)
+		}
+	}
+	ctx.timeStart = timeStart
+	ctx.countStart = countStart
+	ctx.pending = make([]contextEntry, pending)
+	for i := range ctx.pending {
+		ctx.pending[i].checkpoint(c)
+	}
+	for i := range ctx.progress {
+		ctx.progress[i] = ctx.cursor
+	}
+}
+
+func (e *contextEntry) checkpoint(c *Checkpoint) {
+	c.String(&e.Src)
+	c.String(&e.Dst)
+	c.Uint32(&e.Service)
+	c.Time(&e.when)
+	c.Uint64(&e.nr)
+}
@@ -0,0 +1,106 @@
+package flows
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func contextConnection(src, dst byte, port uint16) Connection {
+	return Connection{Src: string([]byte{10, 0, 0, src}), Dst: string([]byte{10, 0, 0, dst}), Service: 17<<16 | uint32(port)}
+}
+
+func TestConnectionContextWindows(t *testing.T) {
+	ctx := NewConnectionContext(ContextOptions{Window: 2 * SecondsInNanoseconds, Connections: 3})
+	id := ctx.register()
+	a9, a8, b9, c9 := contextConnection(1, 9, 80), contextConnection(1, 8, 80), contextConnection(2, 9, 22), contextConnection(3, 9, 80)
+	ctx.add(1, 0, a9)
+	ctx.add(2, 1*SecondsInNanoseconds, a8)
+	ctx.add(3, 1500*MillisecondsInNanoseconds, b9)
+	got := ctx.query(id, 3, 1500*MillisecondsInNanoseconds, a9)
+	want := ConnectionCounts{SrcHostConn: 2, SrcHostDst: 2, DstHostConn: 2, DstHostSameSrv: 1, SrvConn: 2, DstHost: 2, DstHostSrv: 1}
+	if got != want {
+		t.Errorf("query at 1.5s: got %+v, expected %+v", got, want)
+	}
+	// the connection at 0s leaves the time window, and the count window holds the last three connections
+	ctx.add(4, 2500*MillisecondsInNanoseconds, c9)
+	got = ctx.query(id, 4, 2500*MillisecondsInNanoseconds, a9)
+	want = ConnectionCounts{SrcHostConn: 1, SrcHostDst: 1, DstHostConn: 2, DstHostSameSrv: 1, SrvConn: 2, DstHost: 2, DstHostSrv: 1}
+	if got != want {
+		t.Errorf("query at 2.5s: got %+v, expected %+v", got, want)
+	}
+	// without new connections, queries subtract the connections, which left the time window since the last connection
+	got = ctx.query(id, 4, 3200*MillisecondsInNanoseconds, a9)
+	want = ConnectionCounts{SrcHostConn: 0, SrcHostDst: 0, DstHostConn: 2, DstHostSameSrv: 1, SrvConn: 1, DstHost: 2, DstHostSrv: 1}
+	if got != want {
+		t.Errorf("query at 3.2s: got %+v, expected %+v", got, want)
+	}
+
+	// checkpoints restore the windows
+	var buf bytes.Buffer
+	c := NewCheckpointWriter(&buf)
+	ctx.Checkpoint(c)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewConnectionContext(ctx.ContextOptions)
+	id = restored.register()
+	c = NewCheckpointReader(&buf)
+	restored.Checkpoint(c)
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.query(id, 4, 3200*MillisecondsInNanoseconds, a9); got != want {
+		t.Errorf("restored query at 3.2s: got %+v, expected %+v", got, want)
+	}
+}
+
+func TestConnectionContextLimit(t *testing.T) {
+	// only a count window
+	ctx := NewConnectionContext(ContextOptions{Connections: 2})
+	id := ctx.register()
+	conn := contextConnection(1, 9, 80)
+	for nr := uint64(1); nr <= 100; nr++ {
+		ctx.add(nr, DateTimeNanoseconds(nr), conn)
+	}
+	want := ConnectionCounts{DstHost: 2, DstHostSrv: 2}
+	if got := ctx.query(id, 100, 100, conn); got != want {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+	if len(ctx.entries) == 100 {
+		t.Error("connections, which left the windows, are kept")
+	}
+}
+
+func TestConnectionContextTables(t *testing.T) {
+	// events 1 and 3 are handled by table 1, event 2 by table 0. A query of table 0 at event 2 must wait for table 1
+	// and must only count the connections up to event 2.
+	ctx := NewConnectionContext(ContextOptions{Window: SecondsInNanoseconds, Connections: 10})
+	table0, table1 := ctx.register(), ctx.register()
+	conn := contextConnection(1, 9, 80)
+	ctx.add(2, 2, conn)
+	ctx.setProgress(table0, 2)
+	result := make(chan ConnectionCounts)
+	go func() {
+		result <- ctx.query(table0, 2, 2, conn)
+	}()
+	select {
+	case got := <-result:
+		t.Fatalf("query returned %+v before the other table reached the event", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+	ctx.add(1, 1, conn)
+	ctx.setProgress(table1, 1)
+	ctx.add(3, 3, conn)
+	ctx.setProgress(table1, 3)
+	want := ConnectionCounts{SrcHostConn: 2, SrcHostDst: 1, DstHostConn: 2, DstHostSameSrv: 2, SrvConn: 2, DstHost: 2, DstHostSrv: 2}
+	if got := <-result; got != want {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+	// the connection of event 3 is committed by the next query
+	ctx.setProgress(table0, 3)
+	want = ConnectionCounts{SrcHostConn: 3, SrcHostDst: 1, DstHostConn: 3, DstHostSameSrv: 3, SrvConn: 3, DstHost: 3, DstHostSrv: 3}
+	if got := ctx.query(table1, 3, 3, conn); got != want {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+}
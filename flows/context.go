@@ -14,6 +14,8 @@ type EventContext struct {
 	keep    bool
 	hard    bool
 	forward bool
+	// profileChild accumulates time spent in nested features during profiling
+	profileChild int64
 }
 
 // initFlow sets the flow. This must be called in a flow before passing the context to features.
@@ -20,6 +20,7 @@ The following FeatureTypes can be used:
 
  * Const: A constant value (can only be used as argument)
  * RawPacket: An input event from a packet source (can only be used as argument)
+ * RawFlow: A record exported by another flow table for aggregated records (can only be used as argument; see aggregate.go)
  * PacketFeature: A per-packet feature (return or argument)
  * FlowFeature: A per-flow feature (return or argument)
  * MatchType: Return type matches the argument type (Must be used as argument and return)
@@ -37,6 +38,17 @@ Features must be registered with one of the Register* functions.
 
 For examples of features have a look at the already built in features.
 
+Checkpoints
+
+The state of flow tables can be written to a Checkpoint and restored later (see FlowTable.Checkpoint). Features,
+which consist only of a base struct, are saved without further work (the current value in case of BaseFeature).
+Features with additional fields must implement FeatureWithCheckpoint, call CheckpointValue of the embedded
+BaseFeature, and save or restore their state with the Checkpoint methods (e.g. Uint64 or Value). Registering a
+feature with additional fields, which doesn't implement FeatureWithCheckpoint, panics. The same function is used for
+saving and restoring; Loading can be used to tell those apart.
+State derived from arguments (e.g. in SetArguments) must not be saved, since it is restored from the specification.
+Flows created by a custom FlowCreator must be registered with RegisterFlowType.
+
 Data Types
 
 Data types in this flow implementation are based on the ipfix data types. Convenience functions
@@ -66,6 +78,7 @@ Events are forwarded in the following way:
  7. Record: Forward event to every feature
  8. Record: If control feature demands it, handle export (call stop, export, continue with 9), restart (call start, continue with 9)
  9. Flow: If no Record is active, kill the flow
+ 10. Flow: If FlowOptions.InterimPackets is reached, copy every active Record with the checkpoint functions of the features, call stop of every feature of the copy with FlowEndReasonInterim, and export the copy
 
  During Stop control features can prevent an eventual export by calling context.Stop()
 */
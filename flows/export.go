@@ -1,5 +1,7 @@
 package flows
 
+import "fmt"
+
 /*
 Output sorting supports 4 different modes:
 	- none
@@ -49,12 +51,13 @@ start/stop/expiry:
 		these sorted lists are merged in sortorder unto p.sorted, which is then multiplexed to the exporters
 
 	less function for sorting compares the following fields in order:
-		start/stop: packetID, recordID
-		expiry: expiryTime, packetID, recordID
+		start/stop: packetID, recordIndex, flowID
+		expiry: expiryTime, packetID, recordIndex, flowID
+	recordIndex is the index of the record in the complete record list, which is unique across all tables sharing the
+	pipeline
 
 	if a flow is removed it calles r.Destroy(), which unlinks the *exportRecord in case it is still there (= was never exported)
 
-
 	start/stop:
 	tab[n]: t.flush*() -> p.in[n] -> mergeTreeWorker() -> p.sorted -> p.exportSplicer() -> p.out[a] -> exportWorker() -> e.Export()
 	tab[m]: t.flush*() -> p.in[m] /                                                     \> p.out[b] -> exportWorker() -> e.Export()
@@ -64,30 +67,65 @@ start/stop/expiry:
 	tab[n]: t.flush*() -> p.in[n] -> sortWorker() -> unmerged[n] -> mergeTreeWorker() -> p.sorted -> p.exportSplicer() -> p.out[a] -> exportWorker() -> e.Export()
 	tab[m]: t.flush*() -> p.in[m] -> sortWorker() -> unmerged[m] /                                                     \> p.out[b] -> exportWorker() -> e.Export()
 	....
+
+	watermark (in addition to one of start/stop/expiry):
+		after flushing, tables additionally send a single element list containing a watermark *exportRecord. The key of
+		the watermark is lower or equal to the key of every record this table can still export:
+			start/stop: packetID of the oldest unexported record, or the last packetID seen
+			expiry: the current time
+		if the maximum delay is exceeded for the oldest unexported record (start/stop only), it is skipped while flushing
+		and does not hold back the watermark. Once exported, this record is late and results in out of order output.
+
+		instead of the merge tree, a single watermarkWorker merges the lists from all tables. A record is released as
+		soon as every table, which is still running, has sent a watermark greater or equal to this record. Outstanding
+		records of a table don't release records of other tables, since lists of records sharing a pipeline can
+		overlap (i.e., a table might still send a record with a lower key).
+
+	tab[n]: t.flush*() -> p.in[n] -> [sortWorker() -> unmerged[n]] -> watermarkWorker() -> p.sorted -> p.exportSplicer() -> ...
+	tab[m]: t.flush*() -> p.in[m] -> [sortWorker() -> unmerged[m]] /
+	....
 */
 
 type exportKey struct {
 	packetID   uint64
 	expiryTime DateTimeNanoseconds
-	recordID   int
+	recordID   int // index of the record in the record list of the table
+	// index of the record in the complete record list; unlike recordID unique across tables with different record
+	// lists (i.e., several specifications sharing a pipeline)
+	recordIndex int
+	flowID      uint64
 }
 
 // exportRecord contains a list of records to export
 type exportRecord struct {
 	exportKey
 	exportTime DateTimeNanoseconds
+	eventTime  DateTimeNanoseconds // time of the event that last set packetID
 	next       *exportRecord
 	prev       *exportRecord
 	template   Template
 	features   []interface{}
+	watermark  bool // this is not a record, but a watermark
+}
+
+func (e *exportRecord) less(b *exportRecord, order SortType) bool {
+	if order == SortTypeExpiryTime {
+		return e.lessExpiry(b)
+	}
+	return e.lessPacket(b)
+}
+
+// lessID is the final tie breaker for records with the same packetID
+func (e *exportRecord) lessID(b *exportRecord) bool {
+	if e.recordIndex == b.recordIndex {
+		return e.flowID < b.flowID
+	}
+	return e.recordIndex < b.recordIndex
 }
 
 func (e *exportRecord) lessPacket(b *exportRecord) bool {
 	if e.packetID == b.packetID {
-		if e.recordID < b.recordID {
-			return true
-		}
-		return false
+		return e.lessID(b)
 	}
 	if e.packetID < b.packetID {
 		return true
@@ -98,10 +136,7 @@ func (e *exportRecord) lessPacket(b *exportRecord) bool {
 func (e *exportRecord) lessExpiry(b *exportRecord) bool {
 	if e.expiryTime == b.expiryTime {
 		if e.packetID == b.packetID {
-			if e.recordID < b.recordID {
-				return true
-			}
-			return false
+			return e.lessID(b)
 		}
 		if e.packetID < b.packetID {
 			return true
@@ -145,3 +180,39 @@ func (e *exportRecord) insert(after *exportRecord) {
 func (e *exportRecord) exported() bool {
 	return len(e.features) != 0
 }
+
+// checkpoint saves or restores the export record. Exported features are restored together with their template.
+func (e *exportRecord) checkpoint(c *Checkpoint, table *FlowTable) {
+	c.Uint64(&e.packetID)
+	c.Time(&e.expiryTime)
+	c.Int(&e.recordID)
+	c.Int(&e.recordIndex)
+	c.Uint64(&e.flowID)
+	c.Time(&e.exportTime)
+	c.Time(&e.eventTime)
+	n := c.Len(len(e.features))
+	if n == 0 {
+		return
+	}
+	if c.Loading() {
+		e.features = make([]interface{}, n)
+	}
+	for i := range e.features {
+		c.Value(&e.features[i])
+	}
+	var id int
+	if !c.Loading() {
+		id = e.template.ID()
+	}
+	c.Int(&id)
+	if c.Loading() && c.Err() == nil {
+		if e.recordID < 0 || e.recordID >= len(table.records.list) {
+			c.Fail(fmt.Errorf("invalid record %d in checkpoint", e.recordID))
+			return
+		}
+		e.template = findTemplate(table.records.list[e.recordID].template, id)
+		if e.template == nil {
+			c.Fail(fmt.Errorf("unknown template %d in checkpoint", id))
+		}
+	}
+}
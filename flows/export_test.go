@@ -0,0 +1,178 @@
+package flows_test
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	_ "github.com/CN-TU/go-flows/modules/features/staging"
+	"github.com/CN-TU/go-flows/packet"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+)
+
+var sortFeatures = []packet_test.EngineSpec{{
+	Features: []interface{}{"sourceTransportPort", "flowStartNanoseconds", "flowEndNanoseconds", "packetTotalCount", "flowEndReason"},
+	Options:  flows.FlowOptions{ActiveTimeout: 50 * flows.SecondsInNanoseconds, IdleTimeout: 5 * flows.SecondsInNanoseconds},
+}}
+
+// makeUDPSource creates a source with n udp packets 1ms apart, which belong to random ports. Every 100th packet
+// belongs to the long lived port 65000. If step is larger than one, step consecutive packets share the same timestamp.
+func makeUDPSource(t *testing.T, n, step int) *packet_test.MemorySource {
+	source := &packet_test.MemorySource{}
+	rnd := uint64(1)
+	for i := 0; i < n; i++ {
+		rnd = rnd*6364136223846793005 + 1442695040888963407
+		port := layers.UDPPort((rnd >> 33) % 3000)
+		if i%100 == 0 {
+			port = 65000
+		}
+		err := source.AppendLayers(flows.DateTimeNanoseconds(i/step)*1e6+1,
+			&layers.IPv4{Version: 4, TTL: 64, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP},
+			&layers.UDP{SrcPort: port, DstPort: 80})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source
+}
+
+func sortedLines(lines []string) []string {
+	ret := append([]string(nil), lines...)
+	sort.Strings(ret)
+	return ret
+}
+
+func TestWatermarkSort(t *testing.T) {
+	source := makeUDPSource(t, 50000, 1)
+	for _, order := range []flows.SortType{flows.SortTypeStartTime, flows.SortTypeStopTime, flows.SortTypeExpiryTime} {
+		serial := packet_test.RunEngine(t, source, sortFeatures, packet_test.EngineOptions{Sort: order})
+		if len(serial) < 1000 {
+			t.Fatalf("expected more than 1000 flows, got %d", len(serial))
+		}
+		merged := packet_test.RunEngine(t, source, sortFeatures, packet_test.EngineOptions{Sort: order, Tables: 4})
+		watermark := packet_test.RunEngine(t, source, sortFeatures, packet_test.EngineOptions{Sort: order, Tables: 4, Watermark: true})
+		if !reflect.DeepEqual(serial, merged) {
+			t.Errorf("sort order %d: output of 4 tables differs from a single table", order)
+		}
+		if !reflect.DeepEqual(serial, watermark) {
+			t.Errorf("sort order %d: output with watermarks differs from output without watermarks", order)
+		}
+	}
+}
+
+// indexOfLongLived returns the position of the flow with port 65000 starting at the first packet
+func indexOfLongLived(lines []string) int {
+	for i, line := range lines {
+		if fields := strings.Split(line, ","); fields[1] == "65000" && fields[2] == "1" {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestWatermarkMaxSortDelay(t *testing.T) {
+	source := makeUDPSource(t, 20000, 1)
+	opt := packet_test.EngineOptions{Sort: flows.SortTypeStartTime, Tables: 4, Watermark: true}
+	sorted := packet_test.RunEngine(t, source, sortFeatures, opt)
+	opt.MaxSortDelay = flows.SecondsInNanoseconds
+	delayed := packet_test.RunEngine(t, source, sortFeatures, opt)
+
+	// the long lived flow starts with the first packet and holds back every other flow, unless it is late
+	if i := indexOfLongLived(sorted); i != 0 {
+		t.Errorf("expected long lived flow first in sorted output, but is at position %d", i)
+	}
+	if i := indexOfLongLived(delayed); i <= 0 {
+		t.Errorf("expected late long lived flow after other flows in delayed output, but is at position %d", i)
+	}
+	if !reflect.DeepEqual(sortedLines(sorted), sortedLines(delayed)) {
+		t.Error("delayed output contains different records than sorted output")
+	}
+}
+
+func TestWatermarkTiedTimers(t *testing.T) {
+	// 50 packets share the same timestamp -> the idle timers of many flows expire at the same time
+	source := makeUDPSource(t, 50000, 50)
+	for _, order := range []flows.SortType{flows.SortTypeStopTime, flows.SortTypeExpiryTime} {
+		serial := packet_test.RunEngine(t, source, sortFeatures, packet_test.EngineOptions{Sort: order})
+		for _, watermark := range []bool{false, true} {
+			parallel := packet_test.RunEngine(t, source, sortFeatures, packet_test.EngineOptions{Sort: order, Tables: 4, Watermark: watermark})
+			if !reflect.DeepEqual(serial, parallel) {
+				t.Errorf("sort order %d (watermark %t): output of 4 tables differs from a single table", order, watermark)
+			}
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	source := makeUDPSource(t, 30000, 1)
+	// both specifications start a flow with the same packet -> records from different tables tie in packetID and flowID
+	specs := []packet_test.EngineSpec{
+		{
+			Features: []interface{}{"flowId", "sourceTransportPort", "flowStartNanoseconds", "flowEndNanoseconds", "packetTotalCount", "flowEndReason"},
+			Options:  flows.FlowOptions{ActiveTimeout: 50 * flows.SecondsInNanoseconds, IdleTimeout: 5 * flows.SecondsInNanoseconds},
+		},
+		{
+			Features: []interface{}{"flowId", "sourceTransportPort", "packetTotalCount"},
+			Key:      []string{"sourceTransportPort"},
+			Options:  flows.FlowOptions{ActiveTimeout: 10 * flows.SecondsInNanoseconds, IdleTimeout: 2 * flows.SecondsInNanoseconds},
+		},
+	}
+	for _, order := range []flows.SortType{flows.SortTypeStartTime, flows.SortTypeStopTime, flows.SortTypeExpiryTime} {
+		serial := packet_test.RunEngine(t, source, specs, packet_test.EngineOptions{Sort: order, Deterministic: true})
+		for _, tables := range []int{2, 4} {
+			for _, watermark := range []bool{false, true} {
+				parallel := packet_test.RunEngine(t, source, specs, packet_test.EngineOptions{Sort: order, Deterministic: true, Tables: tables, Watermark: watermark})
+				if !reflect.DeepEqual(serial, parallel) {
+					t.Errorf("sort order %d (watermark %t): output of %d tables differs from a single table", order, watermark, tables)
+				}
+			}
+		}
+	}
+}
+
+// packetNrLabel labels every packet with its packet number modulo 5
+type packetNrLabel struct{}
+
+func (packetNrLabel) ID() string { return "packetnr" }
+func (packetNrLabel) Init()      {}
+func (packetNrLabel) GetLabel(p packet.Buffer) (interface{}, error) {
+	return p.PacketNr() % 5, nil
+}
+
+func TestGroups(t *testing.T) {
+	source := makeUDPSource(t, 20000, 1)
+	specs := []packet_test.EngineSpec{
+		{
+			Features: []interface{}{"sourceTransportPort", "flowStartNanoseconds", "packetTotalCount", []interface{}{"set", "__label"}},
+			Options:  flows.FlowOptions{ActiveTimeout: 50 * flows.SecondsInNanoseconds, IdleTimeout: 5 * flows.SecondsInNanoseconds},
+		},
+		{
+			Features: []interface{}{"destinationTransportPort", "flowStartNanoseconds", "packetTotalCount", []interface{}{"set", "__label"}},
+			Key:      []string{"destinationTransportPort"},
+			Options:  flows.FlowOptions{ActiveTimeout: 2 * flows.SecondsInNanoseconds, IdleTimeout: 2 * flows.SecondsInNanoseconds},
+		},
+	}
+	for _, tables := range []int{1, 2} {
+		opt := packet_test.EngineOptions{Tables: tables, Labels: packet.Labels{packetNrLabel{}}}
+		var separate []string
+		for _, spec := range specs {
+			lines := packet_test.RunEngine(t, source, []packet_test.EngineSpec{spec}, opt)
+			if len(lines) == 0 {
+				t.Fatalf("%d tables: no flows exported", tables)
+			}
+			for _, line := range lines {
+				if labels := line[strings.LastIndex(line, ",")+1:]; labels == "[]" || !strings.HasPrefix(labels, "[") {
+					t.Fatalf("%d tables: expected labels in %q", tables, line)
+				}
+			}
+			separate = append(separate, lines...)
+		}
+		shared := packet_test.RunEngine(t, source, specs, opt)
+		if !reflect.DeepEqual(sortedLines(separate), sortedLines(shared)) {
+			t.Errorf("%d tables: output of two groups differs from running the groups separately", tables)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package flows
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/CN-TU/go-flows/util"
 )
@@ -13,6 +14,7 @@ const debugElements = false
 
 const exportQueueDepth = 100
 const exportQueueWorkerDepth = 10
+const exportEncodeBatchSize = 128
 
 type exportQueue chan *exportRecord
 
@@ -294,6 +296,120 @@ DONE:
 	close(result)
 }
 
+type watermarkInput struct {
+	table int
+	list  *exportRecord // nil if the input was closed
+}
+
+// watermarkWorker merges the sorted exportRecords from in and sends them sorted to result. Records are held back
+// until every table sent a watermark greater or equal to the record. Records arriving after a greater record was
+// already sent are forwarded immediately and counted in late.
+func watermarkWorker(in []exportQueue, result exportQueue, order SortType, late *uint64) {
+	inputs := make(chan watermarkInput, exportQueueWorkerDepth)
+	for i, q := range in {
+		go func(table int, q exportQueue) {
+			for elem := range q {
+				inputs <- watermarkInput{table, elem}
+			}
+			inputs <- watermarkInput{table, nil}
+		}(i, q)
+	}
+
+	head := make([]*exportRecord, len(in))
+	tail := make([]*exportRecord, len(in))
+	watermarks := make([]*exportRecord, len(in))
+	closed := make([]bool, len(in))
+	running := len(in)
+	var last *exportRecord
+
+	for running > 0 {
+		input := <-inputs
+		t := input.table
+		switch {
+		case input.list == nil:
+			closed[t] = true
+			running--
+		case input.list.watermark:
+			watermarks[t] = input.list
+		case head[t] == nil:
+			head[t] = input.list
+			tail[t] = input.list.prev
+		case !input.list.less(tail[t], order):
+			tail[t].next = input.list
+			tail[t] = input.list.prev
+		default:
+			// multiple records share this pipeline -> lists can overlap
+			head[t], tail[t] = mergeSorted(head[t], input.list, order)
+		}
+
+		var publish, publishTail *exportRecord
+		for {
+			min := -1
+			for i, elem := range head {
+				if elem != nil && (min == -1 || elem.less(head[min], order)) {
+					min = i
+				}
+			}
+			if min == -1 {
+				break
+			}
+			elem := head[min]
+			for i, watermark := range watermarks {
+				if !closed[i] && (watermark == nil || watermark.less(elem, order)) {
+					goto PUBLISH
+				}
+			}
+			head[min] = elem.next
+			if head[min] == nil {
+				tail[min] = nil
+			}
+			if last != nil && elem.less(last, order) {
+				atomic.AddUint64(late, 1)
+			} else {
+				last = elem
+			}
+			elem.next = nil
+			if publish == nil {
+				publish = elem
+			} else {
+				elem.prev = nil
+				publishTail.next = elem
+			}
+			publishTail = elem
+		}
+	PUBLISH:
+		if publish != nil {
+			publish.prev = publishTail
+			result <- publish
+		}
+	}
+	close(result)
+}
+
+// mergeSorted merges the two sorted lists a and b and returns head and tail of the result
+func mergeSorted(a, b *exportRecord, order SortType) (head, tail *exportRecord) {
+	for a != nil || b != nil {
+		var elem *exportRecord
+		if b == nil || (a != nil && !b.less(a, order)) {
+			elem = a
+			a = a.next
+		} else {
+			elem = b
+			b = b.next
+		}
+		if head == nil {
+			head = elem
+		} else {
+			tail.next = elem
+		}
+		elem.prev = nil
+		tail = elem
+	}
+	tail.next = nil
+	head.prev = tail
+	return
+}
+
 // ExportPipeline contains all functionality for merging results from multiple tables and exporting
 type ExportPipeline struct {
 	exporter  []Exporter
@@ -303,11 +419,25 @@ type ExportPipeline struct {
 	finished  *sync.WaitGroup
 	sortOrder SortType
 	tables    int
+	encoders  int
+	watermark bool
+	late      uint64
+	// inputs maps table ids to the index of the input queue (see addTable)
+	inputs []int
+	closed bool
+}
+
+// MakeExportPipeline creates an ExportPipeline for a list of Exporters. numTables is the number of flow tables
+// exporting records to this pipeline. If encoders is larger than one, exporters implementing EncodingExporter encode
+// the records with this number of parallel workers. If watermark is true, sorted output is merged based on watermarks
+// sent by the tables (see FlowOptions.SortWatermark).
+func MakeExportPipeline(exporter []Exporter, sortOrder SortType, numTables uint, encoders uint, watermark bool) (*ExportPipeline, error) {
+	return &ExportPipeline{exporter: exporter, sortOrder: sortOrder, tables: int(numTables), encoders: int(encoders), watermark: watermark}, nil
 }
 
-// MakeExportPipeline creates an ExportPipeline for a list of Exporters
-func MakeExportPipeline(exporter []Exporter, sortOrder SortType, numTables uint) (*ExportPipeline, error) {
-	return &ExportPipeline{exporter: exporter, sortOrder: sortOrder, tables: int(numTables)}, nil
+// Late returns the number of records, which were exported out of order due to the maximum sort delay
+func (e *ExportPipeline) Late() uint64 {
+	return atomic.LoadUint64(&e.late)
 }
 
 func exporterWorker(q exportQueue, exporter Exporter, wg *sync.WaitGroup) {
@@ -321,6 +451,73 @@ func exporterWorker(q exportQueue, exporter Exporter, wg *sync.WaitGroup) {
 	wg.Done()
 }
 
+type encodeBatch struct {
+	records []*exportRecord
+	result  chan []byte
+}
+
+func encodeWorker(jobs chan *encodeBatch, free chan []byte, exporter EncodingExporter) {
+	for batch := range jobs {
+		var buf []byte
+		select {
+		case buf = <-free:
+		default:
+		}
+		for _, e := range batch.records {
+			buf = exporter.Encode(buf, e.template, e.features, e.exportTime)
+		}
+		batch.result <- buf
+	}
+}
+
+// encodingExporterWorker splits the records from q into batches, which are encoded by several encodeWorkers.
+// The encoded batches are written in the same order as they were received from q.
+func encodingExporterWorker(q exportQueue, exporter EncodingExporter, encoders int, wg *sync.WaitGroup) {
+	jobs := make(chan *encodeBatch, encoders)
+	pending := make(chan *encodeBatch, encoders*2)
+	free := make(chan []byte, encoders*3)
+	for i := 0; i < encoders; i++ {
+		go encodeWorker(jobs, free, exporter)
+	}
+	go func() {
+		for batch := range pending {
+			buf := <-batch.result
+			exporter.Write(buf)
+			select {
+			case free <- buf[:0]:
+			default:
+			}
+		}
+		wg.Done()
+	}()
+
+	batch := &encodeBatch{}
+	dispatch := func() {
+		batch.result = make(chan []byte, 1)
+		// pending must be filled before jobs to keep the order
+		pending <- batch
+		jobs <- batch
+		batch = &encodeBatch{records: make([]*exportRecord, 0, exportEncodeBatchSize)}
+	}
+	for r := range q {
+		for e := r; e != nil; e = e.next {
+			batch.records = append(batch.records, e)
+			if len(batch.records) == exportEncodeBatchSize {
+				dispatch()
+			}
+		}
+		// don't hold back records if nothing else is waiting
+		if len(q) == 0 && len(batch.records) > 0 {
+			dispatch()
+		}
+	}
+	if len(batch.records) > 0 {
+		dispatch()
+	}
+	close(jobs)
+	close(pending)
+}
+
 func (e *ExportPipeline) exportSplicer(q exportQueue) {
 	for r := range q {
 		for _, out := range e.out {
@@ -538,7 +735,34 @@ func makeSortWorker(in, out exportQueue, id int) {
 	go sortWorker(in, out, id)
 }
 
+// addTable assigns an input queue to the table with the given id. Must be called for every table using this pipeline.
+func (e *ExportPipeline) addTable(id uint8) {
+	for len(e.inputs) <= int(id) {
+		e.inputs = append(e.inputs, -1)
+	}
+	if e.inputs[id] != -1 {
+		return
+	}
+	input := 0
+	for _, i := range e.inputs {
+		if i >= input {
+			input = i + 1
+		}
+	}
+	if input >= e.tables {
+		panic(fmt.Sprintf("export pipeline is used by more than %d tables", e.tables))
+	}
+	e.inputs[id] = input
+}
+
+// init starts the pipeline. Further calls (from records sharing this pipeline) only forward the fields to the exporters.
 func (e *ExportPipeline) init(fields []string) {
+	if e.out != nil {
+		for _, exporter := range e.exporter {
+			exporter.Fields(fields)
+		}
+		return
+	}
 	e.out = make([]exportQueue, len(e.exporter))
 	e.finished = &sync.WaitGroup{}
 	for i, exporter := range e.exporter {
@@ -551,7 +775,11 @@ func (e *ExportPipeline) init(fields []string) {
 		}
 		e.out[i] = q
 		e.finished.Add(1)
-		go exporterWorker(q, exporter, e.finished)
+		if encoder, ok := exporter.(EncodingExporter); ok && e.encoders > 1 {
+			go encodingExporterWorker(q, encoder, e.encoders, e.finished)
+		} else {
+			go exporterWorker(q, exporter, e.finished)
+		}
 	}
 	if e.sortOrder == SortTypeNone {
 		e.sorted = make(exportQueue, exportQueueDepth)
@@ -570,7 +798,10 @@ func (e *ExportPipeline) init(fields []string) {
 		} else {
 			unmerged = e.in
 		}
-		if e.tables == 1 {
+		if e.watermark {
+			e.sorted = make(exportQueue, exportQueueWorkerDepth)
+			go watermarkWorker(unmerged, e.sorted, e.sortOrder, &e.late)
+		} else if e.tables == 1 {
 			e.sorted = unmerged[0]
 		} else {
 			e.sorted = makeMergeTree(unmerged, e.sortOrder)[0]
@@ -584,10 +815,14 @@ func (e *ExportPipeline) export(record *exportRecord, tableid int) {
 		e.sorted <- record
 		return
 	}
-	e.in[tableid] <- record
+	e.in[e.inputs[tableid]] <- record
 }
 
 func (e *ExportPipeline) shutdown() {
+	if e.closed {
+		return
+	}
+	e.closed = true
 	if e.sortOrder == SortTypeNone {
 		close(e.sorted)
 		return
@@ -621,6 +856,19 @@ type Exporter interface {
 	Finish()
 }
 
+// EncodingExporter is an Exporter, which splits exporting into encoding and writing the records. This allows
+// encoding records in parallel, while the output order is kept.
+//
+// If multiple encoders are requested for the export pipeline, Encode gets called concurrently from several goroutines
+// with batches of records. The encoded batches are handed to Write in export order. Export is not used in this case.
+type EncodingExporter interface {
+	Exporter
+	// Encode appends the encoded record to buf and returns the extended buffer. Must be safe for concurrent use.
+	Encode(buf []byte, template Template, features []interface{}, when DateTimeNanoseconds) []byte
+	// Write writes a batch of encoded records. Gets called from a single goroutine.
+	Write([]byte)
+}
+
 // RegisterExporter registers an exporter (see module system in util)
 func RegisterExporter(name, desc string, new util.ModuleCreator, help util.ModuleHelp) {
 	util.RegisterModule(exporterName, name, desc, new, help)
@@ -30,6 +30,22 @@ type FeatureWithArguments interface {
 	SetArguments(arguments []int, features []Feature)
 }
 
+// FeatureWithArgumentCheck represents a FeatureWithArguments that verifies its arguments (e.g. constant parameters)
+type FeatureWithArgumentCheck interface {
+	FeatureWithArguments
+	// CheckArguments gets called once when the feature specification is compiled with the same arguments as
+	// SetArguments. Returns an error if the arguments are invalid.
+	CheckArguments(arguments []int, features []Feature) error
+}
+
+// FeatureWithSettings represents a feature that reads parameters from the flow specification (see
+// FlowOptions.CustomSettings)
+type FeatureWithSettings interface {
+	// CheckSettings gets called once with the settings of every table the feature is used in (see
+	// RecordListMaker.CheckSettings). Returns an error if a needed parameter is missing or invalid.
+	CheckSettings(settings map[string]interface{}) error
+}
+
 // NoVariant represents the value returned from Variant if this Feature has only a single type.
 const NoVariant = -1
 
@@ -141,6 +157,10 @@ func (f *BaseFeature) SetValue(new interface{}, context *EventContext, self inte
 	}
 }
 
+// CheckpointValue saves or restores the current value. You must call this in Checkpoint if your feature implements
+// FeatureWithCheckpoint!
+func (f *BaseFeature) CheckpointValue(c *Checkpoint) { c.Value(&f.value) }
+
 // For speed purposes, features with multiple arguments are split into 3 cathegories:
 // - singleMultiEvent: one non const argument
 // - dualMultiEvent: two non const arguments
@@ -45,8 +45,19 @@ func init() {
 	ipfix.LoadIANASpec() //for RegisterStandardFeature
 }
 
+// checkFeature panics, if features made by make don't support checkpoints (see FeatureWithCheckpoint)
+func checkFeature(name string, make MakeFeature) {
+	if make == nil {
+		return
+	}
+	if err := checkCheckpointSupport(make()); err != nil {
+		panic(fmt.Sprintf("Can't register feature %s: %s", name, err))
+	}
+}
+
 // RegisterFeature registers a new feature with the given IE.
 func RegisterFeature(ie ipfix.InformationElement, description string, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	checkFeature(ie.Name, make)
 	featureRegistry[ret][ie.Name] = append(featureRegistry[ret][ie.Name],
 		featureMaker{
 			ret:         ret,
@@ -65,6 +76,7 @@ func RegisterFeature(ie ipfix.InformationElement, description string, ret Featur
 // type, and n-argument functions, where the return type is the maximum numeric type resolved from the
 // arguments (e.g. add)
 func RegisterFunction(name string, description string, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	checkFeature(name, make)
 	ie := ipfix.InformationElement{Type: ipfix.IllegalType}
 	featureRegistry[ret][name] = append(featureRegistry[ret][name],
 		featureMaker{
@@ -79,6 +91,7 @@ func RegisterFunction(name string, description string, ret FeatureType, make Mak
 
 // RegisterTypedFunction registers a function that has a specific return type.
 func RegisterTypedFunction(name string, description string, t ipfix.Type, tl uint16, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	checkFeature(name, make)
 	ie := ipfix.NewInformationElement("", 0, 0, t, tl)
 	featureRegistry[ret][name] = append(featureRegistry[ret][name],
 		featureMaker{
@@ -93,6 +106,7 @@ func RegisterTypedFunction(name string, description string, t ipfix.Type, tl uin
 
 // RegisterCustomFunction registers a function that needs custom type resolution to get the return type.
 func RegisterCustomFunction(name string, description string, resolver TypeResolver, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	checkFeature(name, make)
 	ie := ipfix.InformationElement{Type: ipfix.IllegalType}
 	featureRegistry[ret][name] = append(featureRegistry[ret][name],
 		featureMaker{
@@ -108,6 +122,7 @@ func RegisterCustomFunction(name string, description string, resolver TypeResolv
 
 // RegisterVariantFeature registers a feature that represents more than one information element depending on the data.
 func RegisterVariantFeature(name string, description string, ies []ipfix.InformationElement, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	checkFeature(name, make)
 	ie := ipfix.InformationElement{Type: ipfix.IllegalType}
 	featureRegistry[ret][name] = append(featureRegistry[ret][name],
 		featureMaker{
@@ -122,6 +137,7 @@ func RegisterVariantFeature(name string, description string, ies []ipfix.Informa
 
 // RegisterStandardVariantFeature registers a feature that represents more than one information element depending on the data and is part of the iana ipfix list (e.g. sourceIpv4Address/sourceIpv6Address)
 func RegisterStandardVariantFeature(name string, description string, ies []ipfix.InformationElement, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	checkFeature(name, make)
 	ie := ipfix.InformationElement{Type: ipfix.IllegalType}
 	featureRegistry[ret][name] = append(featureRegistry[ret][name],
 		featureMaker{
@@ -0,0 +1,30 @@
+package flows
+
+import "sort"
+
+// RegisteredFeature is a registered feature for tests
+type RegisteredFeature struct {
+	Name string
+	Make MakeFeature
+}
+
+// RegisteredFeatures returns all registered features, which can be made, in name order
+func RegisteredFeatures() []RegisteredFeature {
+	var ret []RegisteredFeature
+	for _, registry := range featureRegistry {
+		for name, list := range registry {
+			for _, maker := range list {
+				if maker.make != nil {
+					ret = append(ret, RegisteredFeature{name, maker.make})
+				}
+			}
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// CopyFeatures copies the feature state like interim exports do
+func CopyFeatures(to, from []Feature) error {
+	return copyFeatures(to, from)
+}
@@ -20,6 +20,7 @@ func (f *constantFeature) Variant() int                                     { re
 func (f *constantFeature) Emit(interface{}, *EventContext, interface{})     {}
 func (f *constantFeature) setDependent([]int)                               {}
 func (f *constantFeature) IsConstant() bool                                 { return true }
+func (f *constantFeature) Checkpoint(*Checkpoint)                           {}
 
 var _ Feature = (*constantFeature)(nil)
 
@@ -67,6 +68,8 @@ type selectF struct {
 
 func (f *selectF) Start(*EventContext) { f.sel = false }
 
+func (f *selectF) Checkpoint(c *Checkpoint) { c.Bool(&f.sel) }
+
 func (f *selectF) Event(new interface{}, context *EventContext, src interface{}) {
 	/* If src is not nil we got an event from the argument -> Store the boolean value (This always happens before events from the flow)
 	   otherwise we have an event from the flow -> forward it in case we should and reset sel
@@ -92,6 +95,8 @@ func (f *selectS) SetArguments(arguments []int, features []Feature) {
 }
 func (f *selectS) Start(*EventContext) { f.current = 0 }
 
+func (f *selectS) Checkpoint(c *Checkpoint) { c.Int64(&f.current) }
+
 func (f *selectS) Event(new interface{}, context *EventContext, src interface{}) {
 	if f.current >= f.start && f.current < f.stop {
 		f.Emit(new, context, nil)
@@ -104,3 +109,120 @@ func init() {
 	RegisterFunction("select_slice", "selects a slice from the first value to the second value, with Python-like indexing (if a <selection is not provided, default to selecting everything)", Selection, func() Feature { return &selectS{} }, Const, Const)
 	RegisterFunction("select_slice", "selects a slice from the first value to the second value, with Python-like indexing (if a <selection is not provided, default to selecting everything)", Selection, func() Feature { return &selectS{} }, Const, Const, Selection)
 }
+
+// expression filters; filters that are given as feature expression returning a boolean (e.g. ["greater", "ipTotalLength", 100])
+
+// filterSink receives the result of a filter expression
+type filterSink struct {
+	NoopFeature
+	sel bool
+}
+
+func (f *filterSink) Event(new interface{}, context *EventContext, src interface{}) {
+	f.sel, _ = new.(bool)
+}
+
+// Checkpoint is empty, since sel is only valid during an event
+func (f *filterSink) Checkpoint(*Checkpoint) {}
+
+// exprFilter forwards only events for which the filter expression returned true
+type exprFilter struct {
+	NoopFeature
+	record *record
+	sink   *filterSink
+}
+
+func (f *exprFilter) Start(context *EventContext) {
+	for _, feature := range f.record.features {
+		feature.Start(context)
+	}
+}
+
+func (f *exprFilter) Event(new interface{}, context *EventContext, src interface{}) {
+	// the features of the expression dispatch events via context.record
+	outer := context.record
+	context.record = f.record
+	f.sink.sel = false
+	for _, feature := range f.record.control.event {
+		f.record.features[feature].Event(new, context, nil)
+	}
+	for _, feature := range f.record.control.event {
+		f.record.features[feature].FinishEvent(context)
+	}
+	context.record = outer
+	if f.sink.sel {
+		context.Event(new, context, src)
+	}
+}
+
+// Stop stops the features of the expression like the features of a record. Values emitted by them only reach the sink,
+// since no event is forwarded after Stop.
+func (f *exprFilter) Stop(reason FlowEndReason, context *EventContext) {
+	outer := context.record
+	context.record = f.record
+	for _, feature := range f.record.features {
+		feature.Stop(reason, context)
+	}
+	context.record = outer
+}
+
+func (f *exprFilter) Checkpoint(c *Checkpoint) {
+	for _, feature := range f.record.features {
+		c.Feature(feature)
+	}
+}
+
+// makeExpressionFilter compiles a filter expression, which must return a boolean packet feature, into a filter feature
+func makeExpressionFilter(spec interface{}, input FeatureType, id int) (MakeFeature, error) {
+	fragment, err := makeASTFragment(spec, input, id)
+	if err != nil {
+		return nil, FeatureError{id, fmt.Sprint(spec), fmt.Errorf("filter: %s", err)}
+	}
+	fragment.SetExport(fragment.MakeExportName())
+	tree := &ast{
+		ret:       PacketFeature,
+		input:     input,
+		fragments: []astFragment{fragment},
+	}
+	if err := tree.compile(false); err != nil {
+		if fe, ok := err.(FeatureError); ok {
+			fe.err = fmt.Errorf("filter: %s", fe.err)
+			return nil, fe
+		}
+		return nil, err
+	}
+
+	var result astFragment
+	for _, fragment := range tree.fragments {
+		if fragment.Export() {
+			result = fragment
+		}
+	}
+	if _, ok := result.Type().(*variant); ok || result.Type().IE().Type != ipfix.BooleanType {
+		return nil, FeatureError{id, result.ExportName(), fmt.Errorf("filter: expression must return a boolean, but returns %v", result.Type())}
+	}
+
+	featureMakers, _, args, tocall, ctrl := tree.convert()
+	if err := tree.checkArguments(featureMakers, args); err != nil {
+		fe := err.(FeatureError)
+		fe.err = fmt.Errorf("filter: %s", fe.err)
+		return nil, fe
+	}
+	sink := len(featureMakers)
+	featureMakers = append(featureMakers, func() Feature { return &filterSink{} })
+	args = append(args, nil)
+	tocall = append(tocall, nil)
+	root := result.Register()
+	tocall[root] = append(append([]int(nil), tocall[root]...), sink)
+
+	return func() Feature {
+		features := makeFeatures(featureMakers, args, tocall)
+		return &exprFilter{
+			record: &record{
+				features: features,
+				control:  ctrl,
+			},
+			sink: features[sink].(*filterSink),
+		}
+	}, nil
+}
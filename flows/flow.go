@@ -1,5 +1,10 @@
 package flows
 
+import (
+	"fmt"
+	"reflect"
+)
+
 // FlowEndReason holds the flowEndReason as specified by RFC5102
 type FlowEndReason byte
 
@@ -14,6 +19,8 @@ const (
 	FlowEndReasonForcedEnd FlowEndReason = 4
 	// FlowEndReasonLackOfResources lack of resources as specified by RFC5102
 	FlowEndReasonLackOfResources FlowEndReason = 5
+	// FlowEndReasonInterim interim export of a flow, which continues afterwards (not part of RFC5102)
+	FlowEndReasonInterim FlowEndReason = 6
 )
 
 // Flow interface is the primary object for flows. This gets created in the flow table for non-existing
@@ -55,6 +62,16 @@ type Flow interface {
 	//// ------------------------------------------------------------------
 	// Init gets called by the flow table to provide the flow table, a key, and a flow id
 	Init(*FlowTable, string, bool, *EventContext, uint64)
+	// StartsNewFlow gets called by the flow table before Event and returns true if the event doesn't belong to this flow
+	// anymore (e.g. a tcp SYN after the connection was closed). In this case, the flow gets exported with
+	// FlowEndReasonEnd and the event starts a new flow.
+	StartsNewFlow(Event, *EventContext) bool
+
+	//// Functions for checkpoints
+	//// ------------------------------------------------------------------
+	// Checkpoint saves or restores the flow state including timers and features. Flows holding additional state must
+	// overload this and call BaseFlow.Checkpoint. Flow types must be registered with RegisterFlowType.
+	Checkpoint(*Checkpoint)
 
 	// internal function which returns the point in time the next event will happen
 	nextEvent() DateTimeNanoseconds
@@ -62,6 +79,24 @@ type Flow interface {
 	expire(*EventContext)
 	// firstLowToHigh returns the direction of the first packet
 	firstLowToHigh() bool
+	// setTable sets the table of a flow restored from a checkpoint
+	setTable(*FlowTable)
+	// setOuter sets the flow embedding BaseFlow, which gets passed to features via the EventContext
+	setOuter(Flow)
+	// checkTimers returns an error if an active timer has no callback after restoring a checkpoint
+	checkTimers() error
+}
+
+var flowTypes = make(map[string]func() Flow)
+
+func flowTypeName(flow Flow) string {
+	return reflect.TypeOf(flow).String()
+}
+
+// RegisterFlowType registers a flow type, which is needed for restoring flows from checkpoints. make must return a new
+// uninitialized flow of this type.
+func RegisterFlowType(make func() Flow) {
+	flowTypes[flowTypeName(make())] = make
 }
 
 //FlowOptions applying to each flow
@@ -70,28 +105,59 @@ type FlowOptions struct {
 	ActiveTimeout DateTimeNanoseconds
 	// IdleTimeout is the idle timeout in nanoseconds
 	IdleTimeout DateTimeNanoseconds
+	// TimeoutRules override ActiveTimeout and IdleTimeout for flows matching a rule (first match wins)
+	TimeoutRules []TimeoutRule
 	// WindowExpiry specifies if all packets should be expired after a window ended
 	WindowExpiry bool
 	// PerPacket specifies single flow per packet
 	PerPacket bool
 	// TCPExpiry specifies if tcp expiry is wanted (only works if the key contains at least the five tuple)
 	TCPExpiry bool
+	// TCPTimeouts override the idle timeout depending on the tcp connection state (only used with TCPExpiry)
+	TCPTimeouts TCPTimeouts
 	// SortOutput specifies how the output should be sorted
 	SortOutput SortType
+	// SortWatermark specifies if sorted output should be released based on watermarks instead of waiting for all tables
+	SortWatermark bool
+	// SortMaxDelay is the maximum time a record is held back by an older active flow with SortWatermark (0 = unlimited).
+	// Records released due to this delay might be out of order.
+	SortMaxDelay DateTimeNanoseconds
+	// Deterministic uses the number of the first event of a flow as flow ID. This results in flow IDs, which are
+	// globally ordered by the first packet and independent of the number of tables.
+	Deterministic bool
+	// InterimPackets is an ascending list of packet counts. After each of those counts the records of a flow are exported
+	// with FlowEndReasonInterim, while the flow continues unchanged.
+	InterimPackets []uint64
+	// InterimInterval exports the records of a flow with FlowEndReasonInterim every InterimInterval nanoseconds after
+	// the flow started (0 = disabled).
+	InterimInterval DateTimeNanoseconds
+	// Sampling is the sampling applied to the events of the table. Features can use this to scale counters.
+	Sampling Sampling
+	// Context configures the connection context used by context features (see ConnectionContext)
+	Context ContextOptions
+	// ConnectionContext holds the recent connections of the table group, if Context is enabled. It is shared by all
+	// the tables of the group.
+	ConnectionContext *ConnectionContext
 	// CustomSettings contains a map with all the settings read from the flow specification
 	CustomSettings map[string]interface{}
 }
 
 // BaseFlow holds the base information a flow needs. Needs to be embedded into every flow. The actual features are help in one or more records.
 type BaseFlow struct {
-	key          string
-	table        *FlowTable
-	timers       funcEntries
-	expireNext   DateTimeNanoseconds
-	records      Record
-	id           uint64
-	active       bool
-	firstForward bool
+	key           string
+	table         *FlowTable
+	timers        funcEntries
+	expireNext    DateTimeNanoseconds
+	activeTimeout DateTimeNanoseconds
+	idleTimeout   DateTimeNanoseconds
+	records       Record
+	id            uint64
+	active        bool
+	firstForward  bool
+	events        uint64
+	nextInterim   int
+	// outer is the flow embedding this BaseFlow (e.g. a tcp flow)
+	outer Flow
 }
 
 // Stop destroys the resources associated with this flow. Call this to cancel the flow without exporting it or notifying the features.
@@ -103,6 +169,16 @@ func (flow *BaseFlow) Stop() {
 
 func (flow *BaseFlow) nextEvent() DateTimeNanoseconds { return flow.expireNext }
 func (flow *BaseFlow) firstLowToHigh() bool           { return flow.firstForward }
+func (flow *BaseFlow) setTable(table *FlowTable)      { flow.table = table }
+func (flow *BaseFlow) setOuter(outer Flow)            { flow.outer = outer }
+
+// self returns the flow embedding this BaseFlow, or the BaseFlow itself during Init
+func (flow *BaseFlow) self() Flow {
+	if flow.outer != nil {
+		return flow.outer
+	}
+	return flow
+}
 
 // Active returns if the flow is still active.
 func (flow *BaseFlow) Active() bool { return flow.active }
@@ -153,7 +229,7 @@ func (flow *BaseFlow) ExportWithoutContext(reason FlowEndReason, expire, now Dat
 	context := &EventContext{
 		when: expire,
 	}
-	context.initFlow(flow)
+	context.initFlow(flow.self())
 	flow.Export(reason, context, now)
 }
 
@@ -163,6 +239,37 @@ func (flow *BaseFlow) idleEvent(expires, now DateTimeNanoseconds) {
 func (flow *BaseFlow) activeEvent(expires, now DateTimeNanoseconds) {
 	flow.ExportWithoutContext(FlowEndReasonActive, expires, now)
 }
+func (flow *BaseFlow) interimEvent(expires, now DateTimeNanoseconds) {
+	if !flow.active {
+		return
+	}
+	context := &EventContext{
+		when: expires,
+	}
+	context.initFlow(flow.self())
+	flow.records.interim(context, now, flow.table, 0)
+	flow.AddTimer(TimerInterim, flow.interimEvent, expires+flow.table.InterimInterval)
+}
+
+// Interim exports the features of the flow with FlowEndReasonInterim at the current time. The flow and the features
+// continue unchanged afterwards.
+func (flow *BaseFlow) Interim(context *EventContext) {
+	if !flow.active {
+		return
+	}
+	flow.records.interim(context, context.when, flow.table, 0)
+}
+
+// StartsNewFlow returns false, since events always belong to the flow. Overload this if the flow can detect the start of
+// a new flow (e.g. tcp).
+func (flow *BaseFlow) StartsNewFlow(Event, *EventContext) bool { return false }
+
+// IdleTimeout returns the current idle timeout of this flow
+func (flow *BaseFlow) IdleTimeout() DateTimeNanoseconds { return flow.idleTimeout }
+
+// SetIdleTimeout changes the idle timeout of this flow. The new timeout is used starting with the next event. A timeout
+// of 0 disables the idle timeout, but doesn't remove an already running idle timer.
+func (flow *BaseFlow) SetIdleTimeout(timeout DateTimeNanoseconds) { flow.idleTimeout = timeout }
 
 // EOF stops the flow with forced end reason.
 func (flow *BaseFlow) EOF(context *EventContext) {
@@ -171,38 +278,102 @@ func (flow *BaseFlow) EOF(context *EventContext) {
 
 // Event handles the given event and the active and idle timers.
 func (flow *BaseFlow) Event(event Event, context *EventContext) {
-	context.initFlow(flow)
-	if flow.table.IdleTimeout != 0 {
-		flow.AddTimer(TimerIdle, flow.idleEvent, context.when+flow.table.IdleTimeout)
+	context.initFlow(flow.self())
+	if flow.idleTimeout != 0 {
+		flow.AddTimer(TimerIdle, flow.idleEvent, context.when+flow.idleTimeout)
 	}
 	flow.records.Event(event, context, flow.table, 0)
 	if !flow.records.Active() {
 		flow.Stop()
 		return
 	}
+	if flow.nextInterim < len(flow.table.InterimPackets) {
+		flow.events++
+		if flow.events == flow.table.InterimPackets[flow.nextInterim] {
+			flow.nextInterim++
+			flow.Interim(context)
+		}
+	}
 	if flow.table.PerPacket {
 		flow.Export(FlowEndReasonEnd, context, context.when)
 	}
 }
 
-// ID returns the flow ID within the flow table
+// ID returns the flow ID within the flow table, or the globally unique flow ID with FlowOptions.Deterministic
 func (flow *BaseFlow) ID() uint64 {
 	return flow.id
 }
 
 // Init initializes the flow and correspoding features. The associated table, key, and current time need to be provided.
+// The timeouts are taken from the first matching timeout rule of the event creating the flow.
 func (flow *BaseFlow) Init(table *FlowTable, key string, forward bool, context *EventContext, id uint64) {
 	flow.key = key
 	flow.table = table
-	if flow.table.ActiveTimeout+flow.table.IdleTimeout != 0 {
+	flow.activeTimeout, flow.idleTimeout = table.newActiveTimeout, table.newIdleTimeout
+	if flow.activeTimeout+flow.idleTimeout != 0 {
 		flow.timers = makeFuncEntries()
 	}
 	flow.active = true
 	flow.firstForward = forward
 	flow.records = table.records.make()
 	flow.id = id
-	context.initFlow(flow)
-	if flow.table.ActiveTimeout != 0 {
-		flow.AddTimer(TimerActive, flow.activeEvent, context.when+flow.table.ActiveTimeout)
+	context.initFlow(flow.self())
+	if flow.activeTimeout != 0 {
+		flow.AddTimer(TimerActive, flow.activeEvent, context.when+flow.activeTimeout)
+	}
+	if table.InterimInterval != 0 {
+		flow.AddTimer(TimerInterim, flow.interimEvent, context.when+table.InterimInterval)
+	}
+}
+
+// Checkpoint saves or restores the state of the flow, the timers, and the features.
+func (flow *BaseFlow) Checkpoint(c *Checkpoint) {
+	c.String(&flow.key)
+	c.Uint64(&flow.id)
+	c.Bool(&flow.firstForward)
+	c.Time(&flow.expireNext)
+	c.Time(&flow.activeTimeout)
+	c.Time(&flow.idleTimeout)
+	c.Uint64(&flow.events)
+	c.Int(&flow.nextInterim)
+	n := c.Len(len(flow.timers))
+	if c.Loading() {
+		flow.active = true
+		if n > 0 {
+			flow.timers = make(funcEntries, n)
+		}
+		flow.records = flow.table.records.make()
+	}
+	for i := range flow.timers {
+		c.Time(&flow.timers[i].expires)
+		if !c.Loading() || flow.timers[i].expires == 0 {
+			continue
+		}
+		switch TimerID(i) {
+		case TimerIdle:
+			flow.timers[i].function = flow.idleEvent
+		case TimerActive:
+			flow.timers[i].function = flow.activeEvent
+		case TimerInterim:
+			flow.timers[i].function = flow.interimEvent
+		}
+	}
+	flow.records.checkpoint(c, flow.table, 0)
+}
+
+// RestoreTimer sets the callback of a timer restored from a checkpoint. Flows using additional timers must call this
+// for every additional timer in Checkpoint after calling BaseFlow.Checkpoint.
+func (flow *BaseFlow) RestoreTimer(id TimerID, f TimerCallback) {
+	if int(id) < len(flow.timers) && flow.timers[id].expires != 0 {
+		flow.timers[id].function = f
+	}
+}
+
+func (flow *BaseFlow) checkTimers() error {
+	for i, timer := range flow.timers {
+		if timer.expires != 0 && timer.function == nil {
+			return fmt.Errorf("can't restore timer %d", i)
+		}
 	}
+	return nil
 }
@@ -0,0 +1,67 @@
+package flows_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	_ "github.com/CN-TU/go-flows/modules/features/nta"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// finalRecords returns the lines, which are not interim records (flowEndReason is the last field), and the packet
+// counts of the interim records
+func finalRecords(lines []string) (final []string, interim map[string]bool) {
+	interim = make(map[string]bool)
+	for _, line := range lines {
+		if strings.HasSuffix(line, fmt.Sprintf(",%d", flows.FlowEndReasonInterim)) {
+			interim[strings.Split(line, ",")[2]] = true
+		} else {
+			final = append(final, line)
+		}
+	}
+	return
+}
+
+func TestInterimFinal(t *testing.T) {
+	source := &packet_test.MemorySource{}
+	for i := 0; i < 3000; i++ {
+		// bursts of 40 packets, which are 1.5s apart -> several on and off times per flow
+		when := flows.DateTimeNanoseconds(i)*3e7 + flows.DateTimeNanoseconds(i/40)*15e8 + 1
+		timestamp := []byte{0, 0, byte(i >> 8), byte(i), 0, 0, 0, 0}
+		err := source.AppendLayers(when,
+			&layers.IPv4{Version: 4, TTL: 64, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolTCP},
+			&layers.TCP{SrcPort: layers.TCPPort(1000 + i%7), DstPort: 80, ACK: true, Window: uint16(i),
+				Options: []layers.TCPOption{{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: timestamp}}},
+			gopacket.Payload(make([]byte, i%13)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	features := []interface{}{"sourceTransportPort", "packetTotalCount", "octetTotalCount", "__NTAPorts", "__NTATData",
+		[]interface{}{"max", []interface{}{"__NTATOn", "__NTASecWindow"}}, []interface{}{"min", []interface{}{"__NTATOn", "__NTASecWindow"}},
+		[]interface{}{"count", []interface{}{"__NTATOn", "__NTASecWindow"}}, []interface{}{"mean", "_tcpTimestampsPerSeconds"},
+		[]interface{}{"mean", "tcpWindowSize"}, []interface{}{"set", "tcpWindowSize"}, "flowEndReason"}
+	options := flows.FlowOptions{ActiveTimeout: 60 * flows.SecondsInNanoseconds, IdleTimeout: 10 * flows.SecondsInNanoseconds}
+	for _, order := range []flows.SortType{flows.SortTypeNone, flows.SortTypeStartTime} {
+		opt := packet_test.EngineOptions{Sort: order}
+		plain := packet_test.RunEngine(t, source, []packet_test.EngineSpec{{Features: features, Options: options}}, opt)
+		interimOptions := options
+		interimOptions.InterimPackets = []uint64{1, 5, 50}
+		interimOptions.InterimInterval = 3 * flows.SecondsInNanoseconds
+		lines := packet_test.RunEngine(t, source, []packet_test.EngineSpec{{Features: features, Options: interimOptions}}, opt)
+		final, interim := finalRecords(lines)
+		for _, packets := range []string{"1", "5", "50"} {
+			if !interim[packets] {
+				t.Errorf("sort order %d: no interim record after %s packets", order, packets)
+			}
+		}
+		if !reflect.DeepEqual(sortedLines(plain), sortedLines(final)) {
+			t.Errorf("sort order %d: final records with interim exports differ from records without interim exports", order)
+		}
+	}
+}
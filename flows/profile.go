@@ -0,0 +1,173 @@
+package flows
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// profileNode holds the accumulated runtime statistics of a single compiled feature node.
+// Nodes are shared between all flow tables and therefore only accessed atomically.
+type profileNode struct {
+	self   int64  // time spent in the feature itself (excluding dependent features) in ns
+	total  int64  // time spent in the feature including dependent features in ns
+	events uint64 // number of Event calls
+	name   string
+}
+
+// enter must be called before handing control to the profiled feature. The returned values must be handed to leave.
+func (p *profileNode) enter(context *EventContext) (time.Time, int64) {
+	child := context.profileChild
+	context.profileChild = 0
+	return time.Now(), child
+}
+
+// leave accounts the time spent since enter. Time spent in nested profiled features is subtracted from self time
+// and added to the outer feature.
+func (p *profileNode) leave(context *EventContext, start time.Time, child int64) {
+	elapsed := int64(time.Since(start))
+	atomic.AddInt64(&p.self, elapsed-context.profileChild)
+	atomic.AddInt64(&p.total, elapsed)
+	context.profileChild = child + elapsed
+}
+
+// profiledFeature wraps a feature and records time spent in the feature functions.
+//
+// The wrapper only replaces the entries in record.features and record.filter, which are used for dispatching.
+// Features get the unwrapped features as arguments, so identity checks of the emitting feature keep working.
+type profiledFeature struct {
+	Feature
+	node *profileNode
+}
+
+func (f *profiledFeature) Event(new interface{}, context *EventContext, src interface{}) {
+	atomic.AddUint64(&f.node.events, 1)
+	start, child := f.node.enter(context)
+	f.Feature.Event(new, context, src)
+	f.node.leave(context, start, child)
+}
+
+func (f *profiledFeature) FinishEvent(context *EventContext) {
+	start, child := f.node.enter(context)
+	f.Feature.FinishEvent(context)
+	f.node.leave(context, start, child)
+}
+
+func (f *profiledFeature) Start(context *EventContext) {
+	start, child := f.node.enter(context)
+	f.Feature.Start(context)
+	f.node.leave(context, start, child)
+}
+
+func (f *profiledFeature) Stop(reason FlowEndReason, context *EventContext) {
+	start, child := f.node.enter(context)
+	f.Feature.Stop(reason, context)
+	f.node.leave(context, start, child)
+}
+
+func (f *profiledFeature) Checkpoint(c *Checkpoint) {
+	c.Feature(f.Feature)
+}
+
+// recordProfile holds the profile nodes of a single record.
+type recordProfile struct {
+	features []*profileNode // indexed by register; nil for constants
+	filter   []*profileNode
+}
+
+func newRecordProfile(a *ast, recordID int) *recordProfile {
+	ret := &recordProfile{
+		features: make([]*profileNode, len(a.fragments)),
+		filter:   make([]*profileNode, len(a.filter)),
+	}
+	for _, fragment := range a.fragments {
+		if fragment.Data() != nil {
+			continue
+		}
+		name := a.names[fragment.Register()]
+		if fragment.Control() {
+			name = "control " + name
+		}
+		ret.features[fragment.Register()] = &profileNode{name: fmt.Sprintf("%d: %s", recordID, name)}
+	}
+	for i, filter := range a.filter {
+		ret.filter[i] = &profileNode{name: fmt.Sprintf("%d: filter %s", recordID, filter)}
+	}
+	return ret
+}
+
+func (rp *recordProfile) wrap(features, filter []Feature) {
+	for i, node := range rp.features {
+		if node != nil {
+			features[i] = &profiledFeature{features[i], node}
+		}
+	}
+	for i, node := range rp.filter {
+		filter[i] = &profiledFeature{filter[i], node}
+	}
+}
+
+func (p *profileNode) String() string {
+	self := time.Duration(atomic.LoadInt64(&p.self))
+	events := atomic.LoadUint64(&p.events)
+	if events == 0 {
+		return self.String()
+	}
+	return fmt.Sprintf("%s (%d events)", self, events)
+}
+
+// EnableProfiling turns on recording of time spent and number of events per feature node. Must be called before AppendRecord.
+//
+// Profiling adds a considerable overhead to every feature call. The results can be printed with PrintProfile and are
+// added to CallGraph.
+func (rl *RecordListMaker) EnableProfiling() {
+	rl.profile = true
+}
+
+// PrintProfile writes the per feature profile ranked by the time spent in the features to w.
+func (rl RecordListMaker) PrintProfile(w io.Writer) {
+	var nodes []*profileNode
+	var sum int64
+	for _, record := range rl.list {
+		if record.profile == nil {
+			continue
+		}
+		for _, node := range append(record.profile.filter, record.profile.features...) {
+			if node != nil {
+				nodes = append(nodes, node)
+				sum += atomic.LoadInt64(&node.self)
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		return
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return atomic.LoadInt64(&nodes[i].self) > atomic.LoadInt64(&nodes[j].self)
+	})
+	if sum == 0 {
+		sum = 1
+	}
+	fmt.Fprintln(w, "Feature profile:")
+	t := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(t, "self\tself%\ttotal\tevents\tns/event\t\t")
+	for _, node := range nodes {
+		self := atomic.LoadInt64(&node.self)
+		events := atomic.LoadUint64(&node.events)
+		perEvent := "-"
+		if events > 0 {
+			perEvent = fmt.Sprint(self / int64(events))
+		}
+		fmt.Fprintf(t, "%s\t%.2f%%\t%s\t%d\t%s\t\t%s\n",
+			time.Duration(self),
+			float64(self)/float64(sum)*100,
+			time.Duration(atomic.LoadInt64(&node.total)),
+			events,
+			perEvent,
+			node.name)
+	}
+	t.Flush()
+}
@@ -0,0 +1,84 @@
+package flows_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet"
+	"github.com/google/gopacket/layers"
+
+	_ "github.com/CN-TU/go-flows/modules/features/iana"
+	_ "github.com/CN-TU/go-flows/modules/features/operations"
+)
+
+type fieldsExporter struct {
+	fields []string
+}
+
+func (e *fieldsExporter) Fields(fields []string)                                          { e.fields = fields }
+func (e *fieldsExporter) Export(flows.Template, []interface{}, flows.DateTimeNanoseconds) {}
+func (e *fieldsExporter) Finish()                                                         {}
+func (e *fieldsExporter) ID() string                                                      { return "fields" }
+func (e *fieldsExporter) Init()                                                           {}
+
+// profileControl is a control feature without any effect
+type profileControl struct {
+	flows.BaseFeature
+}
+
+func init() {
+	flows.RegisterControlFeature("_profileControl", "control feature of the profile test", func() flows.Feature { return &profileControl{} })
+}
+
+func TestPrintProfile(t *testing.T) {
+	exporter := &fieldsExporter{}
+	pipe, _ := flows.MakeExportPipeline([]flows.Exporter{exporter}, flows.SortTypeNone, 1, 1, false)
+	var f flows.RecordListMaker
+	f.EnableProfiling()
+	features := []interface{}{
+		"sourceTransportPort",
+		[]interface{}{"mean", "octetTotalCount"},
+		[]interface{}{"add", []interface{}{"mean", "octetTotalCount"}, int64(1)},
+	}
+	if err := f.AppendRecord(features, []interface{}{"_profileControl"}, nil, pipe, false); err != nil {
+		t.Fatal(err)
+	}
+	f.Init()
+	table := flows.NewFlowTable(f, packet.NewFlow, flows.FlowOptions{ActiveTimeout: 1e12, IdleTimeout: 1e12}, true, 0)
+	selector := packet.MakeDynamicKeySelector([]string{"sourceTransportPort"}, true, true)
+	for i := 0; i < 100; i++ {
+		data := packet.BufferFromLayers(flows.DateTimeNanoseconds(i), &layers.UDP{SrcPort: layers.UDPPort(i % 3), DstPort: 80})
+		key, fw, _ := selector.Key(data)
+		data.SetInfo(key, fw)
+		table.Event(data)
+	}
+	table.EOF(100)
+	pipe.Flush()
+
+	report := &strings.Builder{}
+	f.PrintProfile(report)
+	lines := strings.Split(report.String(), "\n")
+	events := make(map[string]string)
+	for _, line := range lines[2:] {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		events[strings.Join(fields[5:], " ")] = fields[3]
+	}
+	if len(exporter.fields) != 3 {
+		t.Fatalf("expected 3 exported fields, got %v", exporter.fields)
+	}
+	for _, field := range exporter.fields {
+		if _, ok := events["0: "+field]; !ok {
+			t.Errorf("exported field %s missing in profile:\n%s", field, report)
+		}
+	}
+	if events["0: octetTotalCount"] != "100" {
+		t.Errorf("expected 100 events for octetTotalCount, got %s:\n%s", events["0: octetTotalCount"], report)
+	}
+	if _, ok := events["0: control _profileControl"]; !ok {
+		t.Errorf("control feature missing in profile:\n%s", report)
+	}
+}
@@ -1,6 +1,7 @@
 package flows
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -18,6 +19,10 @@ type Record interface {
 	Export(FlowEndReason, *EventContext, DateTimeNanoseconds, *FlowTable, int)
 	// Returns true if this record is still active
 	Active() bool
+	// checkpoint saves or restores the state of this record
+	checkpoint(*Checkpoint, *FlowTable, int)
+	// interim exports this record without stopping it
+	interim(*EventContext, DateTimeNanoseconds, *FlowTable, int)
 }
 
 type control struct {
@@ -60,9 +65,12 @@ func (r *record) start(data Event, context *EventContext, table *FlowTable, reco
 	}
 	r.export = &exportRecord{
 		exportKey: exportKey{
-			packetID: data.EventNr(),
-			recordID: recordID,
+			packetID:    data.EventNr(),
+			recordID:    recordID,
+			recordIndex: table.records.list[recordID].index,
+			flowID:      context.flow.ID(),
 		},
+		eventTime: context.When(),
 	}
 	table.pushExport(recordID, r.export)
 }
@@ -124,6 +132,7 @@ RESTART:
 	}
 	if table.SortOutput == SortTypeStopTime || table.SortOutput == SortTypeExpiryTime {
 		r.export.packetID = data.EventNr()
+		r.export.eventTime = context.When()
 		table.pushExport(recordID, r.export)
 	}
 	if !context.now {
@@ -195,15 +204,108 @@ func (r *record) Export(reason FlowEndReason, context *EventContext, now DateTim
 		r.export.features = export
 		if table.SortOutput == SortTypeExpiryTime {
 			table.pushExport(recordID, r.export)
+		} else if table.SortWatermark && r.export.prev == nil {
+			// this record was held back too long and was therefore removed from the export list
+			table.pushLate(recordID, r.export)
 		}
 		r.export = nil
 	}
 }
 
+// snapshot returns a copy of this record with the same feature state. The state is copied with the checkpoint
+// functions of the features.
+func (r *record) snapshot(table *FlowTable, recordID int) *record {
+	ret := table.records.list[recordID].make()
+	if err := copyFeatures(ret.features, r.features); err != nil {
+		panic(fmt.Sprintf("Can't create interim export: %s", err))
+	}
+	return ret
+}
+
+// interim exports the current values of the features with FlowEndReasonInterim. The features of a snapshot of the
+// record are stopped and exported, while the record itself continues unchanged. Filters are not consulted. Control
+// features can cancel the interim export with Stop.
+func (r *record) interim(context *EventContext, now DateTimeNanoseconds, table *FlowTable, recordID int) {
+	if !r.active {
+		return
+	}
+	snapshot := r.snapshot(table, recordID)
+	context.record = snapshot
+	defer func() { context.record = r }()
+	for _, feature := range r.control.control {
+		context.stop = false
+		snapshot.features[feature].Stop(FlowEndReasonInterim, context)
+		if context.stop {
+			context.stop = false
+			return
+		}
+	}
+	for _, feature := range snapshot.features {
+		feature.Stop(FlowEndReasonInterim, context)
+	}
+
+	record := table.records.list[recordID]
+	template := record.template
+	for _, variant := range r.control.variant {
+		template = template.subTemplate(snapshot.features[variant].Variant())
+	}
+	export := make([]interface{}, len(r.control.export))
+	for i := range export {
+		export[i] = snapshot.features[r.control.export[i]].Value()
+	}
+
+	if table.SortOutput == SortTypeNone {
+		record.export.export(&exportRecord{
+			exportTime: now,
+			template:   template,
+			features:   export,
+		}, int(table.id))
+		return
+	}
+	// the interim record gets the position of the record in the export list, but is sorted before it
+	e := &exportRecord{
+		exportKey:  r.export.exportKey,
+		eventTime:  r.export.eventTime,
+		exportTime: now,
+		template:   template,
+		features:   export,
+	}
+	e.expiryTime = context.When()
+	if r.export.prev == nil {
+		// the record was held back too long and was therefore removed from the export list
+		table.pushLate(recordID, e)
+	} else {
+		e.insert(r.export)
+	}
+}
+
 func (r *record) Active() bool {
 	return r.active || r.alive
 }
 
+func (r *record) checkpoint(c *Checkpoint, table *FlowTable, recordID int) {
+	c.Bool(&r.active)
+	c.Bool(&r.alive)
+	for _, feature := range r.features {
+		c.Feature(feature)
+	}
+	for _, feature := range r.filter {
+		c.Feature(feature)
+	}
+	export := r.export != nil
+	c.Bool(&export)
+	if !export {
+		return
+	}
+	if c.Loading() {
+		r.export = &exportRecord{}
+		c.exportList = append(c.exportList, r.export)
+	} else {
+		c.exports[r.export] = len(c.exports)
+	}
+	r.export.checkpoint(c, table)
+}
+
 type recordList []*record
 
 func (r recordList) Destroy() {
@@ -224,6 +326,12 @@ func (r recordList) Export(reason FlowEndReason, context *EventContext, now Date
 	}
 }
 
+func (r recordList) interim(context *EventContext, now DateTimeNanoseconds, table *FlowTable, recordID int) {
+	for i, record := range r {
+		record.interim(context, now, table, i)
+	}
+}
+
 func (r recordList) Active() bool {
 	for _, record := range r {
 		if record.Active() {
@@ -233,10 +341,17 @@ func (r recordList) Active() bool {
 	return false
 }
 
+func (r recordList) checkpoint(c *Checkpoint, table *FlowTable, recordID int) {
+	for i, record := range r {
+		record.checkpoint(c, table, i)
+	}
+}
+
 // RecordListMaker holds metadata for instantiating a list of records with included features
 type RecordListMaker struct {
 	list      []RecordMaker
 	templates int
+	profile   bool
 }
 
 func (rl RecordListMaker) make() Record {
@@ -252,6 +367,52 @@ func (rl RecordListMaker) make() Record {
 	return ret
 }
 
+// Len returns the number of records in the list
+func (rl RecordListMaker) Len() int {
+	return len(rl.list)
+}
+
+// Subset returns a record list containing only the records with the given indices (in the order they were appended).
+// Template ids stay unique across all subsets of a record list.
+func (rl RecordListMaker) Subset(indices []int) RecordListMaker {
+	ret := RecordListMaker{
+		list:      make([]RecordMaker, len(indices)),
+		templates: rl.templates,
+		profile:   rl.profile,
+	}
+	for i, index := range indices {
+		ret.list[i] = rl.list[index]
+	}
+	return ret
+}
+
+// CheckSettings verifies the parameters in settings for the features, which read them from the flow specification
+// (see FeatureWithSettings). Must be called with the settings of the table before Clean.
+func (rl RecordListMaker) CheckSettings(settings map[string]interface{}) error {
+	return rl.CheckFeatures(func(feature Feature) error {
+		if f, ok := feature.(FeatureWithSettings); ok {
+			return f.CheckSettings(settings)
+		}
+		return nil
+	})
+}
+
+// CheckFeatures calls check for every feature of the records and returns the first error as FeatureError. Must be
+// called before Clean.
+func (rl RecordListMaker) CheckFeatures(check func(Feature) error) error {
+	for _, record := range rl.list {
+		for i, feature := range record.make().features {
+			if p, ok := feature.(*profiledFeature); ok {
+				feature = p.Feature
+			}
+			if err := check(feature); err != nil {
+				return makeFeatureError(record.ast.fragments[i], err)
+			}
+		}
+	}
+	return nil
+}
+
 // Init must be called after instantiating a record list
 func (rl RecordListMaker) Init() {
 	for _, record := range rl.list {
@@ -268,11 +429,58 @@ func (rl RecordListMaker) Clean() {
 
 // Flush flushes all outstanding exports and waits for them to finish. Must be called before exporters can be shut down
 func (rl RecordListMaker) Flush() {
+	aggregated := false
 	for _, record := range rl.list {
-		record.export.shutdown()
+		aggregated = aggregated || record.source >= 0
 	}
+	if !aggregated {
+		for _, record := range rl.list {
+			record.export.shutdown()
+		}
+		for _, record := range rl.list {
+			record.export.wait()
+		}
+		return
+	}
+	// aggregated records come after their source: finish the pipelines in order and export the remaining aggregated
+	// flows as soon as their source pipeline is finished
 	for _, record := range rl.list {
-		record.export.wait()
+		record.export.Flush()
+		for _, exporter := range record.export.exporter {
+			if aggregator, ok := exporter.(*Aggregator); ok {
+				aggregator.flush()
+			}
+		}
+	}
+}
+
+// checkpoint verifies that the restored feature specification matches the current one
+func (rl RecordListMaker) checkpoint(c *Checkpoint) {
+	fields := make([][]string, len(rl.list))
+	for i, record := range rl.list {
+		fields[i] = record.fields
+	}
+	c.Check("feature specification", fields)
+}
+
+// PrintStats writes export statistics to w
+func (rl RecordListMaker) PrintStats(w io.Writer) {
+	seen := make(map[*ExportPipeline]bool)
+	var late uint64
+	watermark := false
+	for _, record := range rl.list {
+		if seen[record.export] {
+			continue
+		}
+		seen[record.export] = true
+		watermark = watermark || record.export.watermark
+		late += record.export.Late()
+	}
+	if watermark {
+		fmt.Fprintf(w,
+			`Export statistics:
+	late records: %d
+`, late)
 	}
 }
 
@@ -282,6 +490,9 @@ type RecordMaker struct {
 	template Template
 	fields   []string
 	ast      *ast
+	profile  *recordProfile
+	source   int // index of the source record for aggregated records, or -1
+	index    int // index of this record in the complete record list (stays the same in subsets)
 	make     func() *record
 }
 
@@ -290,10 +501,49 @@ func (rm *RecordMaker) Init() {
 	rm.export.init(rm.fields)
 }
 
+// makeFeatures instantiates the features of a converted ast
+func makeFeatures(featureMakers []MakeFeature, args, tocall [][]int) []Feature {
+	features := make([]Feature, len(featureMakers))
+	features = features[:len(featureMakers)] //BCE
+	for i, maker := range featureMakers {
+		features[i] = maker()
+	}
+	for i, arg := range args {
+		if len(arg) > 0 {
+			if f, ok := features[i].(FeatureWithArguments); ok {
+				f.SetArguments(arg, features)
+			}
+		}
+	}
+	for i, tocall := range tocall {
+		features[i].setDependent(tocall)
+	}
+	return features
+}
+
 // AppendRecord creates a internal representation needed for instantiating records from a feature
 // specification, a list of exporters and a needed base (only FlowFeature supported so far)
-func (rl *RecordListMaker) AppendRecord(features []interface{}, control, filter []string, exporter *ExportPipeline, verbose bool) error {
-	tree, err := makeAST(features, control, filter, exporter.exporter, RawPacket, FlowFeature) // only packets -> flows for now
+func (rl *RecordListMaker) AppendRecord(features []interface{}, control, filter []interface{}, exporter *ExportPipeline, verbose bool) error {
+	return rl.appendRecord(features, control, filter, exporter, RawPacket, -1, verbose)
+}
+
+// AppendAggregateRecord creates a record like AppendRecord, which uses the records exported by the record with index
+// source as input (RawFlow) instead of packets. The fields of the source record can be used as features. The records
+// must be connected with Aggregate and must not share the export pipeline of the source.
+func (rl *RecordListMaker) AppendAggregateRecord(source int, features []interface{}, control, filter []interface{}, exporter *ExportPipeline, verbose bool) error {
+	if source < 0 || source >= len(rl.list) {
+		return fmt.Errorf("unknown source record %d", source)
+	}
+	if rl.list[source].export == exporter {
+		return errors.New("aggregated records can't be exported together with their source records")
+	}
+	unregister := rl.list[source].registerFields()
+	defer unregister()
+	return rl.appendRecord(features, control, filter, exporter, RawFlow, source, verbose)
+}
+
+func (rl *RecordListMaker) appendRecord(features []interface{}, control, filter []interface{}, exporter *ExportPipeline, input FeatureType, source int, verbose bool) error {
+	tree, err := makeAST(features, control, filter, exporter.exporter, input, FlowFeature) // only flows for now
 	if err != nil {
 		return err
 	}
@@ -308,30 +558,27 @@ func (rl *RecordListMaker) AppendRecord(features []interface{}, control, filter
 	}
 
 	featureMakers, filterMakers, args, tocall, ctrl := tree.convert()
+	if err := tree.checkArguments(featureMakers, args); err != nil {
+		return err
+	}
 
 	hasfilter := len(filterMakers) > 0
 
+	var profile *recordProfile
+	if rl.profile {
+		profile = newRecordProfile(tree, len(rl.list))
+	}
+
 	rl.list = append(rl.list, RecordMaker{
 		exporter,
 		template,
 		fields,
 		tree,
+		profile,
+		source,
+		len(rl.list),
 		func() *record {
-			features := make([]Feature, len(featureMakers))
-			features = features[:len(featureMakers)] //BCE
-			for i, maker := range featureMakers {
-				features[i] = maker()
-			}
-			for i, arg := range args {
-				if len(arg) > 0 {
-					if f, ok := features[i].(FeatureWithArguments); ok {
-						f.SetArguments(arg, features)
-					}
-				}
-			}
-			for i, tocall := range tocall {
-				features[i].setDependent(tocall)
-			}
+			features := makeFeatures(featureMakers, args, tocall)
 			var filter []Feature
 			if hasfilter {
 				filter = make([]Feature, len(filterMakers))
@@ -340,6 +587,9 @@ func (rl *RecordListMaker) AppendRecord(features []interface{}, control, filter
 					filter[i] = feature()
 				}
 			}
+			if profile != nil {
+				profile.wrap(features, filter)
+			}
 
 			return &record{
 				features: features,
@@ -371,6 +621,7 @@ var graphTemplate = template.Must(template.New("callgraph").Parse(`digraph callg
 `))
 
 // CallGraph generates a call graph in the graphviz language and writes the result to w.
+// If profiling is enabled, the nodes are annotated with the time spent in the features and the number of events.
 func (rl RecordListMaker) CallGraph(w io.Writer) {
 	styles := map[FeatureType][][]string{
 		FlowFeature: {
@@ -410,25 +661,35 @@ func (rl RecordListMaker) CallGraph(w io.Writer) {
 
 	for listID, fl := range rl.list {
 		var nodes []Node
-		export := make([]Node, len(fl.export.exporter))
-		for i, exporter := range fl.export.exporter {
-			export[i] = Node{Name: fmt.Sprintf("%p", exporter), Label: exporter.ID()}
+		export := make([]Node, 0, len(fl.export.exporter))
+		for _, exporter := range fl.export.exporter {
+			if _, ok := exporter.(*Aggregator); ok {
+				continue
+			}
+			export = append(export, Node{Name: fmt.Sprintf("%p", exporter), Label: exporter.ID()})
 		}
 
 		raw := fmt.Sprintf("%draw", listID)
 
 		nodes = append(nodes, Node{
 			Name:  raw,
-			Label: "RawPacket",
+			Label: fl.ast.input.String(),
 		})
 
+		source := "source"
+		if fl.source >= 0 {
+			source = fmt.Sprintf("export%d", fl.source)
+		}
 		data.Edges = append(data.Edges, Edge{
-			Start: "source",
+			Start: source,
 			Stop:  raw,
 		})
 
 		for i, filter := range fl.ast.filter {
 			f := fmt.Sprintf("%d,fi%d", listID, i)
+			if fl.profile != nil {
+				filter = fmt.Sprintf("%s\\n%s", filter, fl.profile.filter[i])
+			}
 			nodes = append(nodes, Node{
 				Name:  f,
 				Label: filter,
@@ -448,6 +709,9 @@ func (rl RecordListMaker) CallGraph(w io.Writer) {
 				node.Name = fmt.Sprintf("%d,c%d", listID, fragment.Register())
 				node.Label = fragment.Name()
 				node.Style = [][]string{{"shape", "doubleoctagon"}}
+				if fl.profile != nil {
+					node.Label = fmt.Sprintf("%s\\n%s", node.Label, fl.profile.features[fragment.Register()])
+				}
 
 				data.Edges = append(data.Edges, Edge{
 					Start: raw,
@@ -519,6 +783,9 @@ func (rl RecordListMaker) CallGraph(w io.Writer) {
 						Stop:  fmt.Sprintf("export%d", listID),
 					})
 				}
+				if fl.profile != nil && fl.profile.features[fragment.Register()] != nil {
+					node.Label = fmt.Sprintf("%s\\n%s", node.Label, fl.profile.features[fragment.Register()])
+				}
 				if html != nil {
 					node.HTML = html()
 					node.Label = ""
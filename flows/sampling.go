@@ -0,0 +1,91 @@
+package flows
+
+import "fmt"
+
+// SamplingMode specifies how packets are sampled
+type SamplingMode uint8
+
+const (
+	// SamplingNone uses every packet
+	SamplingNone SamplingMode = iota
+	// SamplingDeterministic uses every n-th packet
+	SamplingDeterministic
+	// SamplingRandom uses every packet with a probability of 1/n
+	SamplingRandom
+	// SamplingHash uses every flow with a probability of 1/n based on the CRC-32 of the flow key (either all packets
+	// of a flow are used or none)
+	SamplingHash
+)
+
+// AtoSamplingMode converts a string to a sampling mode
+func AtoSamplingMode(s string) (SamplingMode, error) {
+	switch s {
+	case "", "none":
+		return SamplingNone, nil
+	case "deterministic":
+		return SamplingDeterministic, nil
+	case "random":
+		return SamplingRandom, nil
+	case "hash":
+		return SamplingHash, nil
+	}
+	return SamplingNone, fmt.Errorf("unknown sampling mode '%s'", s)
+}
+
+func (m SamplingMode) String() string {
+	switch m {
+	case SamplingDeterministic:
+		return "deterministic"
+	case SamplingRandom:
+		return "random"
+	case SamplingHash:
+		return "hash"
+	}
+	return "none"
+}
+
+// Sampling holds the sampling configuration
+type Sampling struct {
+	// Mode is the sampling mode
+	Mode SamplingMode
+	// Interval is the sampling interval n (one out of n packets or flows is used)
+	Interval uint32
+	// Seed is the seed for random and hash sampling
+	Seed uint64
+	// Scale specifies if counters (e.g. octetTotalCount and packetTotalCount) should be scaled up by Interval
+	Scale bool
+}
+
+// Algorithm returns the samplingAlgorithm as defined by IPFIX (1 = deterministic, 2 = random). Unsampled and hash
+// sampled flows return 0, since samplingAlgorithm only defines packet sampling (see SelectorAlgorithm).
+func (s Sampling) Algorithm() uint8 {
+	switch s.Mode {
+	case SamplingDeterministic:
+		return 1
+	case SamplingRandom:
+		return 2
+	}
+	return 0
+}
+
+// SelectorAlgorithm returns the selectorAlgorithm as defined by the IANA PSAMP registry (1 = systematic count-based
+// sampling, 4 = uniform probabilistic sampling, 8 = hash-based filtering using CRC). Unsampled flows return 0.
+func (s Sampling) SelectorAlgorithm() uint16 {
+	switch s.Mode {
+	case SamplingDeterministic:
+		return 1
+	case SamplingRandom:
+		return 4
+	case SamplingHash:
+		return 8
+	}
+	return 0
+}
+
+// ScaleFactor returns the factor counters must be multiplied with to estimate the unsampled value
+func (s Sampling) ScaleFactor() uint64 {
+	if !s.Scale || s.Mode == SamplingNone || s.Interval == 0 {
+		return 1
+	}
+	return uint64(s.Interval)
+}
@@ -0,0 +1,106 @@
+package flows_test
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+)
+
+var samplingFeatures = []packet_test.EngineSpec{{
+	Features: []interface{}{"sourceTransportPort", "packetTotalCount", "octetTotalCount", "samplingInterval", "samplingAlgorithm", "selectorAlgorithm"},
+	Options:  flows.FlowOptions{ActiveTimeout: 1000 * flows.SecondsInNanoseconds, IdleTimeout: 1000 * flows.SecondsInNanoseconds},
+}}
+
+// samplingPacketLength is the ip length of the packets in makeSamplingSource
+const samplingPacketLength = 28
+
+// makeSamplingSource creates a source with 10 rounds of one udp packet for each of the given number of ports
+func makeSamplingSource(t *testing.T, ports int) *packet_test.MemorySource {
+	source := &packet_test.MemorySource{}
+	for i := 0; i < 10*ports; i++ {
+		err := source.AppendLayers(flows.DateTimeNanoseconds(i)*1e6+1,
+			&layers.IPv4{Version: 4, TTL: 64, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP},
+			&layers.UDP{SrcPort: layers.UDPPort(1000 + i%ports), DstPort: 80})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source
+}
+
+type sampledFlow struct {
+	packets, octets uint64
+	config          string
+}
+
+// runSampling returns the sampled flows by source port
+func runSampling(t *testing.T, source *packet_test.MemorySource, sampling flows.Sampling) map[string]sampledFlow {
+	ret := make(map[string]sampledFlow)
+	for _, line := range packet_test.RunEngine(t, source, samplingFeatures, packet_test.EngineOptions{Sampling: sampling}) {
+		fields := strings.Split(line, ",")
+		packets, _ := strconv.ParseUint(fields[2], 10, 64)
+		octets, _ := strconv.ParseUint(fields[3], 10, 64)
+		if _, ok := ret[fields[1]]; ok {
+			t.Fatalf("flow with port %s exported twice", fields[1])
+		}
+		ret[fields[1]] = sampledFlow{packets, octets, strings.Join(fields[4:], ",")}
+	}
+	return ret
+}
+
+func TestSamplingDeterministic(t *testing.T) {
+	source := makeSamplingSource(t, 100)
+	for _, scale := range []bool{false, true} {
+		result := runSampling(t, source, flows.Sampling{Mode: flows.SamplingDeterministic, Interval: 10, Scale: scale})
+		// every 10th packet is used -> only every 10th port is seen with all of its packets
+		factor := uint64(1)
+		if scale {
+			factor = 10
+		}
+		expected := make(map[string]sampledFlow)
+		for port := 1000; port < 1100; port += 10 {
+			expected[fmt.Sprint(port)] = sampledFlow{10 * factor, 10 * samplingPacketLength * factor, "10,1,1"}
+		}
+		if fmt.Sprint(result) != fmt.Sprint(expected) {
+			t.Errorf("scale %t: got flows %v, expected %v", scale, result, expected)
+		}
+	}
+}
+
+func TestSamplingHash(t *testing.T) {
+	source := makeSamplingSource(t, 1000)
+	for _, seed := range []uint64{0, 1} {
+		result := runSampling(t, source, flows.Sampling{Mode: flows.SamplingHash, Interval: 10, Seed: seed, Scale: true})
+		// about one out of 10 flows is used with all of its packets
+		if len(result) < 50 || len(result) > 150 {
+			t.Errorf("seed %d: expected about 100 flows, got %d", seed, len(result))
+		}
+		for port, flow := range result {
+			if expected := (sampledFlow{100, 100 * samplingPacketLength, "10,0,8"}); flow != expected {
+				t.Errorf("seed %d: flow with port %s is %v, expected %v", seed, port, flow, expected)
+			}
+		}
+		if again := runSampling(t, source, flows.Sampling{Mode: flows.SamplingHash, Interval: 10, Seed: seed, Scale: true}); fmt.Sprint(again) != fmt.Sprint(result) {
+			t.Errorf("seed %d: hash sampling selected different flows in the second run", seed)
+		}
+	}
+	if fmt.Sprint(runSampling(t, source, flows.Sampling{Mode: flows.SamplingHash, Interval: 10, Seed: 0})) ==
+		fmt.Sprint(runSampling(t, source, flows.Sampling{Mode: flows.SamplingHash, Interval: 10, Seed: 1})) {
+		t.Error("different seeds selected the same flows")
+	}
+}
+
+func TestSamplingRandom(t *testing.T) {
+	result := runSampling(t, makeSamplingSource(t, 1), flows.Sampling{Mode: flows.SamplingRandom, Interval: 2, Seed: 1})
+	if flow := result["1000"]; flow.packets == 0 || flow.packets == 10 || flow.config != "2,2,4" {
+		t.Errorf("expected a part of the 10 packets with interval 2 and algorithm 2/4, got %v", flow)
+	}
+	if result := runSampling(t, makeSamplingSource(t, 1), flows.Sampling{}); result["1000"] != (sampledFlow{10, 10 * samplingPacketLength, "1,0,0"}) {
+		t.Errorf("expected all packets without sampling, got %v", result["1000"])
+	}
+}
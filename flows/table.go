@@ -1,6 +1,9 @@
 package flows
 
-import "log"
+import (
+	"fmt"
+	"log"
+)
 
 // FlowCreator is responsible for creating new flows. Supplied values are event, the flowtable, a flow key, and the current time.
 type FlowCreator func(Event, *FlowTable, string, bool, *EventContext, uint64) Flow
@@ -32,10 +35,27 @@ type FlowTable struct {
 	fivetuple bool
 	eof       bool
 	expiring  bool
+	// eventNr is the number of the last event handed to this table
+	eventNr uint64
+	// watermarkTime is the time the last watermark was sent
+	watermarkTime DateTimeNanoseconds
+	watermarks    []exportKey
+	// contextID is the id of this table in the ConnectionContext
+	contextID int
+	// newActiveTimeout and newIdleTimeout are the timeouts for the next flow created (see TimeoutRules)
+	newActiveTimeout DateTimeNanoseconds
+	newIdleTimeout   DateTimeNanoseconds
 }
 
+// watermarkInterval is the minimum time between two watermarks, if no records were exported
+const watermarkInterval = SecondsInNanoseconds
+
 // NewFlowTable returns a new flow table utilizing features, the newflow function called for unknown flows, and the active and idle timeout.
+// The id must be unique among all tables sharing an ExportPipeline.
 func NewFlowTable(records RecordListMaker, newflow FlowCreator, options FlowOptions, fivetuple bool, id uint8) *FlowTable {
+	for _, record := range records.list {
+		record.export.addTable(id)
+	}
 	var exports []*exportRecord
 	if options.SortOutput != SortTypeNone {
 		exports = make([]*exportRecord, len(records.list))
@@ -52,6 +72,15 @@ func NewFlowTable(records RecordListMaker, newflow FlowCreator, options FlowOpti
 		context:     &EventContext{},
 		exports:     exports,
 		id:          id,
+
+		newActiveTimeout: options.ActiveTimeout,
+		newIdleTimeout:   options.IdleTimeout,
+	}
+	if options.SortWatermark {
+		ret.watermarks = make([]exportKey, len(exports))
+	}
+	if options.ConnectionContext != nil {
+		ret.contextID = options.ConnectionContext.register()
 	}
 	return ret
 }
@@ -67,6 +96,23 @@ func (tab *FlowTable) pushExport(r int, e *exportRecord) {
 	e.insert(tab.exports[r])
 }
 
+// pushLate moves an exportRecord, which was skipped due to SortMaxDelay, to the back of the export list
+func (tab *FlowTable) pushLate(r int, e *exportRecord) {
+	e.insert(tab.exports[r].prev)
+}
+
+func (tab *FlowTable) flush() {
+	switch {
+	case tab.SortOutput == SortTypeNone:
+	case tab.SortWatermark:
+		tab.flushWatermarkExports()
+	case tab.SortOutput == SortTypeExpiryTime:
+		tab.flushAllExports()
+	default:
+		tab.flushExports()
+	}
+}
+
 // Expire expires all unhandled timer events. Can be called periodically to conserve memory.
 func (tab *FlowTable) Expire(when DateTimeNanoseconds) {
 	if when < tab.context.when {
@@ -83,17 +129,38 @@ func (tab *FlowTable) Expire(when DateTimeNanoseconds) {
 		}
 	}
 	tab.expiring = false
-	if tab.SortOutput == SortTypeExpiryTime {
-		tab.flushAllExports()
-	} else if tab.SortOutput != SortTypeNone {
-		tab.flushExports()
+	tab.flush()
+}
+
+// Progress informs the table, that every event up to eventNr has been handed to the table. This allows watermark based
+// sorting and queries to a shared connection context to continue, if this table doesn't receive events. Only needed
+// with SortWatermark or a ConnectionContext shared by several tables.
+func (tab *FlowTable) Progress(eventNr uint64) {
+	if eventNr > tab.eventNr {
+		tab.eventNr = eventNr
+	}
+	if tab.ConnectionContext != nil {
+		tab.ConnectionContext.setProgress(tab.contextID, tab.eventNr)
 	}
+	if tab.SortWatermark && (tab.SortOutput == SortTypeStartTime || tab.SortOutput == SortTypeStopTime) {
+		tab.flushWatermarkExports()
+	}
+}
+
+// ConnectionCounts returns the number of connections related to conn in the connection context at the current
+// event. Must only be called if the table has a ConnectionContext.
+func (tab *FlowTable) ConnectionCounts(now DateTimeNanoseconds, conn Connection) ConnectionCounts {
+	return tab.ConnectionContext.query(tab.contextID, tab.eventNr, now, conn)
 }
 
 // Event needs to be called for every event (e.g., a received packet). Handles flow expiry if the event belongs to a flow, flow creation, and forwarding the event to the flow.
 func (tab *FlowTable) Event(event Event) {
 	tab.Stats.Packets++
+	tab.eventNr = event.EventNr()
 	when := event.Timestamp()
+	if tab.ConnectionContext != nil {
+		tab.ConnectionContext.setProgress(tab.contextID, tab.eventNr-1)
+	}
 	key := event.Key()
 	lowToHigh := event.LowToHigh()
 
@@ -117,14 +184,31 @@ func (tab *FlowTable) Event(event Event) {
 			}
 			if ok {
 				tab.context.forward = lowToHigh == elem.firstLowToHigh()
-				elem.Event(event, tab.context)
+				if elem.StartsNewFlow(event, tab.context) {
+					tab.context.initFlow(elem)
+					elem.Export(FlowEndReasonEnd, tab.context, when)
+					ok = false
+				} else {
+					elem.Event(event, tab.context)
+				}
 			}
 		} else {
 			ok = false
 		}
 	}
 	if !ok {
-		elem := tab.newflow(event, tab, key, lowToHigh, tab.context, tab.flowID)
+		id := tab.flowID
+		if tab.Deterministic {
+			id = event.EventNr()
+		}
+		tab.newActiveTimeout, tab.newIdleTimeout = tab.timeouts(event)
+		if tab.ConnectionContext != nil {
+			if conn, ok := ConnectionOf(event, true); ok {
+				tab.ConnectionContext.add(tab.eventNr, when, conn)
+			}
+		}
+		elem := tab.newflow(event, tab, key, lowToHigh, tab.context, id)
+		elem.setOuter(elem)
 		tab.flowID++
 		tab.Stats.Flows++
 		var new int
@@ -145,7 +229,7 @@ func (tab *FlowTable) Event(event Event) {
 		elem.Event(event, tab.context)
 	}
 	if tab.SortOutput == SortTypeStartTime || tab.SortOutput == SortTypeStopTime {
-		tab.flushExports()
+		tab.flush()
 	}
 }
 
@@ -210,6 +294,78 @@ func (tab *FlowTable) flushAllExports() {
 	}
 }
 
+// flushWatermarkExports forwards all exported records, followed by a watermark, to the export pipelines.
+//
+// start/stop: exported records are popped from the back of the list until the first unexported record. Unexported
+// records older than SortMaxDelay are removed from the list and don't hold back the other records. The watermark is
+// the packetID of the oldest remaining unexported record of all the records sharing the same pipeline.
+//
+// expiry: all exported records are forwarded and the watermark is the current time.
+func (tab *FlowTable) flushWatermarkExports() {
+	now := tab.context.when
+	sent := false
+	watermarks := tab.watermarks
+	for i, exports := range tab.exports {
+		var head, tail *exportRecord
+		watermarks[i] = exportKey{packetID: tab.eventNr}
+		if tab.SortOutput == SortTypeExpiryTime {
+			watermarks[i] = exportKey{expiryTime: now}
+		}
+		elem := exports.prev
+		for elem != exports {
+			next := elem.prev
+			if elem.exported() {
+				elem.unlink()
+				if head == nil {
+					head = elem
+				} else {
+					tail.next = elem
+				}
+				tail = elem
+			} else if tab.SortOutput != SortTypeExpiryTime {
+				if tab.SortMaxDelay == 0 || now-elem.eventTime <= tab.SortMaxDelay {
+					watermarks[i].packetID = elem.packetID
+					break
+				}
+				// held back too long -> remove from list; record.Export puts it back
+				elem.unlink()
+			}
+			elem = next
+		}
+		if head != nil {
+			head.prev = tail
+			tail.next = nil
+			tab.records.list[i].export.export(head, int(tab.id))
+			sent = true
+		}
+	}
+	if !sent && now-tab.watermarkTime < watermarkInterval {
+		return
+	}
+	tab.watermarkTime = now
+	for i, record := range tab.records.list {
+		first := true
+		watermark := watermarks[i]
+		for j := range tab.records.list[:i] {
+			if tab.records.list[j].export == record.export {
+				first = false
+				break
+			}
+		}
+		if !first {
+			continue
+		}
+		for j := i + 1; j < len(tab.records.list); j++ {
+			if tab.records.list[j].export == record.export && watermarks[j].packetID < watermark.packetID {
+				watermark = watermarks[j]
+			}
+		}
+		e := &exportRecord{exportKey: watermark, watermark: true}
+		e.prev = e
+		record.export.export(e, int(tab.id))
+	}
+}
+
 func (tab *FlowTable) remove(entry Flow) {
 	if !tab.eof {
 		old := tab.flows[entry.Key()]
@@ -274,11 +430,97 @@ func (tab *FlowTable) expireWindow() {
 	tab.freelist = tab.freelist[:0]
 	tab.eof = false
 	tab.expiring = false
-	if tab.SortOutput == SortTypeExpiryTime {
-		tab.flushAllExports()
-	} else if tab.SortOutput != SortTypeNone {
-		tab.flushExports()
+	tab.flush()
+}
+
+// Checkpoint saves or restores the complete state of the table: active flows with timers and features, and records
+// waiting for export. Restoring is only possible into a newly created table with the same feature specification and
+// options. Must not be called concurrently with any other function of the table.
+func (tab *FlowTable) Checkpoint(c *Checkpoint) error {
+	tab.records.checkpoint(c)
+	c.Check("flow options", fmt.Sprintf("%+v", tab.FlowOptions))
+	c.Uint64(&tab.flowID)
+	c.Uint64(&tab.window)
+	c.Uint64(&tab.Stats.Packets)
+	c.Uint64(&tab.Stats.Flows)
+	c.Uint64(&tab.Stats.Maxflows)
+	c.Uint64(&tab.eventNr)
+	c.Time(&tab.watermarkTime)
+	c.Time(&tab.context.when)
+
+	c.exports = make(map[*exportRecord]int)
+	c.exportList = nil
+	if c.Loading() {
+		n := c.Len(0)
+		for i := 0; i < n && c.Err() == nil; i++ {
+			var name string
+			c.String(&name)
+			newFlow, ok := flowTypes[name]
+			if !ok {
+				c.Fail(fmt.Errorf("unknown flow type %s in checkpoint", name))
+				break
+			}
+			flow := newFlow()
+			flow.setTable(tab)
+			flow.setOuter(flow)
+			flow.Checkpoint(c)
+			if err := flow.checkTimers(); err != nil {
+				c.Fail(err)
+			}
+			tab.flows[flow.Key()] = len(tab.flowlist)
+			tab.flowlist = append(tab.flowlist, flow)
+		}
+	} else {
+		c.Len(len(tab.flows))
+		for _, flow := range tab.flowlist {
+			if flow == nil {
+				continue
+			}
+			name := flowTypeName(flow)
+			c.String(&name)
+			flow.Checkpoint(c)
+		}
+	}
+
+	for _, exports := range tab.exports {
+		n := 0
+		for elem := exports.next; elem != exports; elem = elem.next {
+			n++
+		}
+		n = c.Len(n)
+		elem := exports.next
+		for i := 0; i < n && c.Err() == nil; i++ {
+			// unexported records are saved with the flow and referenced by their position
+			ref := -1
+			if !c.Loading() {
+				if pos, ok := c.exports[elem]; ok {
+					ref = pos
+				}
+			}
+			c.Int(&ref)
+			if !c.Loading() {
+				if ref == -1 {
+					elem.checkpoint(c, tab)
+				}
+				elem = elem.next
+				continue
+			}
+			var e *exportRecord
+			if ref == -1 {
+				e = &exportRecord{}
+				e.checkpoint(c, tab)
+			} else if ref < len(c.exportList) {
+				e = c.exportList[ref]
+			} else {
+				c.Fail(fmt.Errorf("invalid export reference %d in checkpoint", ref))
+				break
+			}
+			e.insert(exports.prev)
+		}
 	}
+	c.exports = nil
+	c.exportList = nil
+	return c.Err()
 }
 
 // FiveTuple returns true if the key function is the fivetuple key
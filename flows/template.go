@@ -71,6 +71,23 @@ func (e *emptyTemplate) String() string {
 	return "<illegal>"
 }
 
+// findTemplate returns the leaf template with the given id or nil
+func findTemplate(t Template, id int) Template {
+	switch tt := t.(type) {
+	case *leafTemplate:
+		if tt.id == id {
+			return tt
+		}
+	case *multiTemplate:
+		for _, sub := range tt.templates {
+			if ret := findTemplate(sub, id); ret != nil {
+				return ret
+			}
+		}
+	}
+	return nil
+}
+
 func makeLeafTemplate(ies []ipfix.InformationElement, id *int) Template {
 	ret := &leafTemplate{id: *id, ies: ies}
 	*id++
@@ -0,0 +1,116 @@
+package flows
+
+import "fmt"
+
+// TCPState is the state of a tcp connection at the time a flow is created (used for matching timeout rules)
+type TCPState uint8
+
+const (
+	// TCPStateAny matches every event
+	TCPStateAny TCPState = iota
+	// TCPStateNew is a connection starting with a SYN
+	TCPStateNew
+	// TCPStateMidstream is a connection starting without a SYN (e.g. already established when the capture started)
+	TCPStateMidstream
+)
+
+// AtoTCPState converts a string to a tcp state for timeout rules
+func AtoTCPState(s string) (TCPState, error) {
+	switch s {
+	case "", "any":
+		return TCPStateAny, nil
+	case "new":
+		return TCPStateNew, nil
+	case "midstream":
+		return TCPStateMidstream, nil
+	}
+	return TCPStateAny, fmt.Errorf("unknown tcp state '%s'", s)
+}
+
+func (s TCPState) String() string {
+	switch s {
+	case TCPStateNew:
+		return "new"
+	case TCPStateMidstream:
+		return "midstream"
+	}
+	return "any"
+}
+
+// KeepTimeout in a TimeoutRule keeps the timeout from FlowOptions
+const KeepTimeout = ^DateTimeNanoseconds(0)
+
+// TimeoutRule overrides the flow timeouts for flows matching this rule. Rules are checked in order during flow
+// creation and the first matching rule is applied.
+type TimeoutRule struct {
+	// Protocol is the IP protocol number to match; -1 matches every protocol
+	Protocol int
+	// Port is the source or destination port to match; -1 matches every port
+	Port int
+	// State is the tcp state to match; TCPStateAny matches every packet, every other state only tcp packets
+	State TCPState
+	// ActiveTimeout is the active timeout in nanoseconds or KeepTimeout
+	ActiveTimeout DateTimeNanoseconds
+	// IdleTimeout is the idle timeout in nanoseconds or KeepTimeout
+	IdleTimeout DateTimeNanoseconds
+}
+
+// TimeoutEvent is an event that can be matched against timeout rules. Events not implementing this interface
+// only match rules without protocol, port, and state.
+type TimeoutEvent interface {
+	// TimeoutInfo returns the IP protocol, source port, destination port, and tcp state of the event
+	TimeoutInfo() (proto uint8, srcPort, dstPort uint16, state TCPState)
+}
+
+func (r TimeoutRule) matches(proto uint8, srcPort, dstPort uint16, state TCPState, ok bool) bool {
+	if !ok {
+		return r.Protocol == -1 && r.Port == -1 && r.State == TCPStateAny
+	}
+	if r.Protocol != -1 && r.Protocol != int(proto) {
+		return false
+	}
+	if r.Port != -1 && r.Port != int(srcPort) && r.Port != int(dstPort) {
+		return false
+	}
+	return r.State == TCPStateAny || r.State == state
+}
+
+// timeouts returns the active and idle timeout for a flow created by event according to the first matching rule
+func (opts *FlowOptions) timeouts(event Event) (active, idle DateTimeNanoseconds) {
+	active, idle = opts.ActiveTimeout, opts.IdleTimeout
+	if len(opts.TimeoutRules) == 0 {
+		return
+	}
+	var proto uint8
+	var srcPort, dstPort uint16
+	var state TCPState
+	te, ok := event.(TimeoutEvent)
+	if ok {
+		proto, srcPort, dstPort, state = te.TimeoutInfo()
+	}
+	for _, rule := range opts.TimeoutRules {
+		if rule.matches(proto, srcPort, dstPort, state, ok) {
+			if rule.ActiveTimeout != KeepTimeout {
+				active = rule.ActiveTimeout
+			}
+			if rule.IdleTimeout != KeepTimeout {
+				idle = rule.IdleTimeout
+			}
+			return
+		}
+	}
+	return
+}
+
+// TCPTimeouts holds idle timeouts for the different states of tcp connections. A timeout of 0 keeps the idle timeout
+// of the flow (see FlowOptions.IdleTimeout and TimeoutRules), except for TimeWait, where 0 ends the flow immediately.
+type TCPTimeouts struct {
+	// SynSent is used until the handshake is finished
+	SynSent DateTimeNanoseconds
+	// Established is used after the handshake, or for connections picked up in the middle
+	Established DateTimeNanoseconds
+	// FinWait is used after the first FIN
+	FinWait DateTimeNanoseconds
+	// TimeWait is the time a flow is kept after both FINs were acknowledged
+	TimeWait DateTimeNanoseconds
+}
@@ -0,0 +1,71 @@
+package flows_test
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestTimeoutRules(t *testing.T) {
+	second := flows.SecondsInNanoseconds
+	specs := []packet_test.EngineSpec{{
+		Features: []interface{}{"sourceTransportPort", "packetTotalCount"},
+		Options: flows.FlowOptions{ActiveTimeout: 1000 * second, IdleTimeout: 100 * second, TimeoutRules: []flows.TimeoutRule{
+			// the first matching rule wins -> port 53 must not get the timeouts of the second rule
+			{Protocol: 17, Port: 53, ActiveTimeout: flows.KeepTimeout, IdleTimeout: second},
+			{Protocol: 17, Port: -1, ActiveTimeout: 5 * second, IdleTimeout: flows.KeepTimeout},
+			{Protocol: -1, Port: -1, State: flows.TCPStateMidstream, ActiveTimeout: 10 * second, IdleTimeout: flows.KeepTimeout},
+		}},
+	}}
+	source := &packet_test.MemorySource{}
+	for i := 0; i < 20; i++ {
+		for _, transport := range []gopacket.SerializableLayer{
+			&layers.UDP{SrcPort: 53, DstPort: 1000},
+			&layers.UDP{SrcPort: 2000, DstPort: 53},
+			&layers.UDP{SrcPort: 54, DstPort: 1000},
+			&layers.TCP{SrcPort: 80, DstPort: 1000, ACK: true},
+			&layers.TCP{SrcPort: 81, DstPort: 1000, SYN: i == 0, ACK: i != 0},
+		} {
+			proto := layers.IPProtocolUDP
+			if _, ok := transport.(*layers.TCP); ok {
+				proto = layers.IPProtocolTCP
+			}
+			// one packet every 1.5s per flow key
+			err := source.AppendLayers(flows.DateTimeNanoseconds(i)*second*3/2+1,
+				&layers.IPv4{Version: 4, TTL: 64, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: proto},
+				transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	flowSizes := make(map[string][]string)
+	for _, line := range packet_test.RunEngine(t, source, specs, packet_test.EngineOptions{}) {
+		fields := strings.Split(line, ",")
+		flowSizes[fields[1]] = append(flowSizes[fields[1]], fields[2])
+	}
+	for port := range flowSizes {
+		sort.Strings(flowSizes[port])
+	}
+	ones := strings.Split(strings.Repeat("1", 20), "")
+	expected := map[string][]string{
+		// idle timeout of 1s from the first rule, which also matches the destination port
+		"53":   ones,
+		"2000": ones,
+		// active timeout of 5s from the second rule: packets at 0, 1.5, 3, 4.5s
+		"54": {"4", "4", "4", "4", "4"},
+		// active timeout of 10s for tcp flows without SYN: packets at 0, 1.5, ..., 9s
+		"80": {"6", "7", "7"},
+		// tcp flows starting with a SYN don't match any rule
+		"81": {"20"},
+	}
+	if !reflect.DeepEqual(flowSizes, expected) {
+		t.Errorf("flow sizes per port %v, expected %v", flowSizes, expected)
+	}
+}
@@ -20,6 +20,8 @@ var (
 	TimerIdle = RegisterTimer()
 	// TimerActive is the active timer of every flow
 	TimerActive = RegisterTimer()
+	// TimerInterim is the timer for interim exports of every flow
+	TimerInterim = RegisterTimer()
 )
 
 type funcEntry struct {
@@ -34,16 +36,18 @@ func makeFuncEntries() funcEntries {
 }
 
 func (fe *funcEntries) expire(when DateTimeNanoseconds) DateTimeNanoseconds {
-	var next DateTimeNanoseconds
 	fep := *fe
 	for i, v := range fep {
-		if v.expires != 0 {
-			if v.expires <= when {
-				fep[i].function(fep[i].expires, when)
-				fep[i].expires = 0
-			} else if next == 0 || v.expires <= next {
-				next = v.expires
-			}
+		if v.expires != 0 && v.expires <= when {
+			// clear the timer first, since the callback might add it again
+			fep[i].expires = 0
+			v.function(v.expires, when)
+		}
+	}
+	var next DateTimeNanoseconds
+	for _, v := range *fe {
+		if v.expires != 0 && (next == 0 || v.expires < next) {
+			next = v.expires
 		}
 	}
 	return next
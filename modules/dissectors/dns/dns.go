@@ -0,0 +1,44 @@
+// Package dns contains the dissector for DNS messages.
+package dns
+
+import (
+	"encoding/binary"
+
+	"github.com/CN-TU/go-flows/packet"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func dissectDNS(payload []byte, transport gopacket.LayerType) gopacket.ApplicationLayer {
+	if transport == layers.LayerTypeTCP {
+		// DNS over TCP has a two byte length prefix; only segments starting with a message are decoded
+		if len(payload) <= 2 || int(binary.BigEndian.Uint16(payload)) > len(payload)-2 {
+			return nil
+		}
+		payload = payload[2:]
+	}
+	if dns := Decode(payload); dns != nil {
+		return dns
+	}
+	return nil // not a typed nil
+}
+
+// Decode returns the decoded DNS message or nil, if data is not a valid message. The decoder of gopacket panics for
+// some truncated resource records, which is handled like any other decoding error.
+func Decode(data []byte) (ret *layers.DNS) {
+	defer func() {
+		if recover() != nil {
+			ret = nil
+		}
+	}()
+	dns := &layers.DNS{}
+	if err := dns.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		return nil
+	}
+	return dns
+}
+
+func init() {
+	packet.RegisterPortDissector("dns", "domain name system (including mDNS and LLMNR)", layers.LayerTypeUDP, []uint16{53, 5353, 5355}, dissectDNS)
+	packet.RegisterPortDissector("dns", "domain name system", layers.LayerTypeTCP, []uint16{53, 5355}, dissectDNS)
+}
@@ -0,0 +1,32 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestDissectMalformed(t *testing.T) {
+	dns := &layers.DNS{ID: 1, QR: true,
+		Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers:   []layers.DNSResourceRecord{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, IP: []byte{10, 0, 0, 3}}}}
+	buffer := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buffer, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	message := buffer.Bytes()
+	if dissectDNS(message, layers.LayerTypeUDP) == nil {
+		t.Fatal("Couldn't dissect DNS message")
+	}
+	if dissectDNS(append([]byte{0, byte(len(message))}, message...), layers.LayerTypeTCP) == nil {
+		t.Fatal("Couldn't dissect DNS message over TCP")
+	}
+	// truncated answers must not panic
+	for n := 0; n < len(message); n++ {
+		if dissectDNS(message[:n], layers.LayerTypeUDP) != nil {
+			t.Errorf("dissected DNS message truncated to %d bytes", n)
+		}
+		dissectDNS(append([]byte{0, byte(n)}, message[:n]...), layers.LayerTypeTCP)
+	}
+}
@@ -0,0 +1,183 @@
+package quic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// Initial packets are protected with keys derived from the destination connection ID of the first Initial packet of
+// the client and a version specific salt (RFC 9001 section 5.2, RFC 9369 section 3.3).
+
+var initialSalts = map[uint32][]byte{
+	Version1:       {0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a},
+	Version2:       {0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9},
+	VersionDraft29: {0xaf, 0xbf, 0xec, 0x28, 0x99, 0x93, 0xd2, 0x4c, 0x9e, 0x97, 0x86, 0xf1, 0x9c, 0x61, 0x11, 0xe0, 0x43, 0x90, 0xa8, 0x99},
+}
+
+func hkdfExtract(salt, secret []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label of TLS 1.3 with an empty context
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label))
+	info = append(info, byte(length>>8), byte(length), byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+
+	mac := hmac.New(sha256.New, secret)
+	var ret, t []byte
+	for i := byte(1); len(ret) < length; i++ {
+		mac.Reset()
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		ret = append(ret, t...)
+	}
+	return ret[:length]
+}
+
+// InitialKeys holds the packet and header protection of Initial packets of one direction
+type InitialKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+}
+
+// NewInitialKeys derives the keys for Initial packets sent by the client or the server from the destination
+// connection ID of the first Initial packet of the client
+func NewInitialKeys(version uint32, dcid []byte, client bool) (*InitialKeys, error) {
+	salt, ok := initialSalts[version]
+	if !ok {
+		return nil, errors.New("unknown QUIC version")
+	}
+	label := "server in"
+	if client {
+		label = "client in"
+	}
+	secret := hkdfExpandLabel(hkdfExtract(salt, dcid), label, 32)
+	prefix := "quic "
+	if version == Version2 {
+		prefix = "quicv2 "
+	}
+	block, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"key", 16))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"hp", 16))
+	if err != nil {
+		return nil, err
+	}
+	return &InitialKeys{
+		aead: aead,
+		iv:   hkdfExpandLabel(secret, prefix+"iv", 12),
+		hp:   hp,
+	}, nil
+}
+
+// Decrypt removes the header and packet protection of an Initial packet and returns the plaintext payload
+func (k *InitialKeys) Decrypt(p *Packet) ([]byte, error) {
+	if p.Type != PacketTypeInitial {
+		return nil, errors.New("not a QUIC Initial packet")
+	}
+	// the sample starts 4 bytes after the start of the packet number
+	if len(p.Payload) < 4+aes.BlockSize {
+		return nil, errTruncated
+	}
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, p.Payload[4:4+aes.BlockSize])
+
+	first := p.Header[0] ^ mask[0]&0x0f
+	pnLength := int(first&0x03) + 1
+	header := make([]byte, len(p.Header)+pnLength)
+	copy(header, p.Header)
+	header[0] = first
+	nonce := make([]byte, len(k.iv))
+	copy(nonce, k.iv)
+	for i := 0; i < pnLength; i++ {
+		b := p.Payload[i] ^ mask[1+i]
+		header[len(p.Header)+i] = b
+		nonce[len(nonce)-pnLength+i] ^= b
+	}
+	return k.aead.Open(nil, nonce, p.Payload[pnLength:], header)
+}
+
+// CryptoFrame is the data of a CRYPTO frame
+type CryptoFrame struct {
+	Offset uint64
+	Data   []byte
+}
+
+// skipVarints skips n variable-length integers and returns the remaining data, or nil on error
+func skipVarints(data []byte, n int) []byte {
+	for i := 0; i < n; i++ {
+		_, l := readVarint(data)
+		if l == 0 {
+			return nil
+		}
+		data = data[l:]
+	}
+	return data
+}
+
+// CryptoFrames returns the CRYPTO frames of a decrypted Initial or Handshake packet. Parsing stops at the first frame,
+// which is not allowed in these packets.
+func CryptoFrames(payload []byte) []CryptoFrame {
+	var ret []CryptoFrame
+	for len(payload) > 0 {
+		frame, n := readVarint(payload)
+		if n == 0 {
+			return ret
+		}
+		payload = payload[n:]
+		switch frame {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK
+			// largest acknowledged, delay, range count, first range, ranges, and optional ECN counts
+			count, n := readVarint(skipVarints(payload, 2))
+			if n == 0 || count > uint64(len(payload)) {
+				return ret
+			}
+			payload = skipVarints(payload, 4+2*int(count))
+			if frame == 0x03 && payload != nil {
+				payload = skipVarints(payload, 3)
+			}
+			if payload == nil {
+				return ret
+			}
+		case 0x06: // CRYPTO
+			offset, n := readVarint(payload)
+			if n == 0 {
+				return ret
+			}
+			payload = payload[n:]
+			length, n := readVarint(payload)
+			if n == 0 || uint64(len(payload)-n) < length {
+				return ret
+			}
+			ret = append(ret, CryptoFrame{offset, payload[n : n+int(length)]})
+			payload = payload[n+int(length):]
+		case 0x1c: // CONNECTION_CLOSE
+			payload = skipVarints(payload, 2)
+			length, n := readVarint(payload)
+			if n == 0 || uint64(len(payload)-n) < length {
+				return ret
+			}
+			payload = payload[n+int(length):]
+		default:
+			return ret
+		}
+	}
+	return ret
+}
@@ -0,0 +1,215 @@
+// Package quic contains the dissector for QUIC packets and the decryption of QUIC Initial packets.
+package quic
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/CN-TU/go-flows/packet"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// LayerTypeQUIC is the layer type of QUIC datagrams
+var LayerTypeQUIC = gopacket.RegisterLayerType(1001, gopacket.LayerTypeMetadata{Name: "QUIC"})
+
+// PacketType is the type of a QUIC packet
+type PacketType uint8
+
+// QUIC packet types
+const (
+	PacketTypeInitial PacketType = iota
+	PacketType0RTT
+	PacketTypeHandshake
+	PacketTypeRetry
+	PacketTypeVersionNegotiation
+	PacketType1RTT
+)
+
+func (t PacketType) String() string {
+	switch t {
+	case PacketTypeInitial:
+		return "Initial"
+	case PacketType0RTT:
+		return "0-RTT"
+	case PacketTypeHandshake:
+		return "Handshake"
+	case PacketTypeRetry:
+		return "Retry"
+	case PacketTypeVersionNegotiation:
+		return "VersionNegotiation"
+	case PacketType1RTT:
+		return "1-RTT"
+	}
+	return "Unknown"
+}
+
+// Known QUIC versions
+const (
+	Version1       = 0x00000001
+	Version2       = 0x6b3343cf
+	VersionDraft29 = 0xff00001d
+)
+
+// Packet is a single QUIC packet of a datagram. All slices reference the packet data.
+type Packet struct {
+	Long    bool
+	Type    PacketType
+	Version uint32 // 0 for short header packets
+	DCID    []byte // nil for short header packets, since the length is unknown
+	SCID    []byte
+	Token   []byte
+	// Header contains the header up to the (protected) packet number
+	Header []byte
+	// Payload contains the protected packet number and payload; for retry packets the retry token and integrity tag
+	Payload []byte
+}
+
+// QUIC is a QUIC datagram consisting of one or more coalesced packets
+type QUIC struct {
+	layers.BaseLayer
+	Packets []Packet
+}
+
+// LayerType returns LayerTypeQUIC
+func (q *QUIC) LayerType() gopacket.LayerType { return LayerTypeQUIC }
+
+// Payload returns the datagram
+func (q *QUIC) Payload() []byte { return q.Contents }
+
+var errTruncated = errors.New("QUIC packet too short")
+
+// readVarint reads a QUIC variable-length integer and returns the value and the number of bytes read (0 on error)
+func readVarint(data []byte) (uint64, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	n := 1 << (data[0] >> 6)
+	if len(data) < n {
+		return 0, 0
+	}
+	ret := uint64(data[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		ret = ret<<8 | uint64(data[i])
+	}
+	return ret, n
+}
+
+// longPacketType converts the type bits of a long header to PacketType
+func longPacketType(version uint32, bits uint8) PacketType {
+	if version == Version2 {
+		// QUIC version 2 (RFC 9369) rotates the packet types
+		return PacketType((bits + 3) & 3)
+	}
+	return PacketType(bits)
+}
+
+// decodePacket decodes the QUIC packet at the start of data and returns the number of bytes used
+func decodePacket(data []byte, p *Packet) (int, error) {
+	if len(data) == 0 {
+		return 0, errTruncated
+	}
+	if data[0]&0x80 == 0 {
+		// short header; fixed bit must be set
+		if data[0]&0x40 == 0 {
+			return 0, errors.New("QUIC fixed bit not set")
+		}
+		*p = Packet{Type: PacketType1RTT, Header: data[:1], Payload: data[1:]}
+		return len(data), nil
+	}
+	if len(data) < 7 {
+		return 0, errTruncated
+	}
+	*p = Packet{Long: true, Version: binary.BigEndian.Uint32(data[1:5])}
+	pos := 5
+	for _, cid := range []*[]byte{&p.DCID, &p.SCID} {
+		if pos >= len(data) {
+			return 0, errTruncated
+		}
+		l := int(data[pos])
+		pos++
+		if l > 20 && p.Version != 0 || pos+l > len(data) {
+			return 0, errors.New("invalid QUIC connection ID")
+		}
+		*cid = data[pos : pos+l]
+		pos += l
+	}
+	if p.Version == 0 {
+		p.Type = PacketTypeVersionNegotiation
+		p.Header = data[:pos]
+		p.Payload = data[pos:]
+		return len(data), nil
+	}
+	if data[0]&0x40 == 0 {
+		return 0, errors.New("QUIC fixed bit not set")
+	}
+	p.Type = longPacketType(p.Version, (data[0]>>4)&3)
+	if p.Type == PacketTypeRetry {
+		p.Header = data[:pos]
+		p.Payload = data[pos:]
+		return len(data), nil
+	}
+	if p.Type == PacketTypeInitial {
+		l, n := readVarint(data[pos:])
+		if n == 0 || uint64(len(data)-pos-n) < l {
+			return 0, errTruncated
+		}
+		p.Token = data[pos+n : pos+n+int(l)]
+		pos += n + int(l)
+	}
+	l, n := readVarint(data[pos:])
+	if n == 0 || uint64(len(data)-pos-n) < l {
+		return 0, errTruncated
+	}
+	pos += n
+	p.Header = data[:pos]
+	p.Payload = data[pos : pos+int(l)]
+	return pos + int(l), nil
+}
+
+// DecodeFromBytes decodes the coalesced packets of a QUIC datagram. Trailing data after the first packet, which
+// can't be decoded, is ignored.
+func (q *QUIC) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	q.Packets = q.Packets[:0]
+	q.BaseLayer = layers.BaseLayer{Contents: data}
+	for len(data) > 0 {
+		var p Packet
+		n, err := decodePacket(data, &p)
+		if err != nil {
+			if len(q.Packets) == 0 {
+				return err
+			}
+			break
+		}
+		q.Packets = append(q.Packets, p)
+		data = data[n:]
+	}
+	if len(q.Packets) == 0 {
+		return errTruncated
+	}
+	return nil
+}
+
+// knownVersion returns true for QUIC versions with known Initial salt
+func knownVersion(version uint32) bool {
+	_, ok := initialSalts[version]
+	return ok
+}
+
+// looksLikeQUIC returns true for long header packets of known versions
+func looksLikeQUIC(payload []byte, transport gopacket.LayerType) bool {
+	return len(payload) >= 7 && payload[0]&0xc0 == 0xc0 && knownVersion(binary.BigEndian.Uint32(payload[1:5]))
+}
+
+func dissectQUIC(payload []byte, transport gopacket.LayerType) gopacket.ApplicationLayer {
+	q := &QUIC{}
+	if err := q.DecodeFromBytes(payload, gopacket.NilDecodeFeedback); err != nil {
+		return nil
+	}
+	return q
+}
+
+func init() {
+	packet.RegisterPortDissector("quic", "QUIC (long and short header packets)", layers.LayerTypeUDP, []uint16{443}, dissectQUIC)
+	packet.RegisterHeuristicDissector("quic", "QUIC (long header packets of known versions)", layers.LayerTypeUDP, looksLikeQUIC, dissectQUIC)
+}
@@ -29,195 +29,127 @@ type csvExporter struct {
 	outfile string
 	f       io.WriteCloser
 	writer  *bufio.Writer
+	buf     []byte
 	flush   bool
 }
 
-func (pe *csvExporter) writeString(field string) {
+func appendString(buf []byte, field string) []byte {
 	if field == "" {
-		return
+		return buf
 	}
 	if !strings.ContainsAny(field, "\"\r\n,") {
 		r1, _ := utf8.DecodeRuneInString(field)
 		if unicode.IsSpace(r1) {
-			err := pe.writer.WriteByte('"')
-			if err != nil {
-				panic(err)
-			}
-			_, err = pe.writer.WriteString(field)
-			if err != nil {
-				panic(err)
-			}
-			err = pe.writer.WriteByte('"')
-			if err != nil {
-				panic(err)
-			}
-			return
+			buf = append(buf, '"')
+			buf = append(buf, field...)
+			return append(buf, '"')
 		}
-		_, err := pe.writer.WriteString(field)
-		if err != nil {
-			panic(err)
-		}
-		return
-	}
-	err := pe.writer.WriteByte('"')
-	if err != nil {
-		panic(err)
+		return append(buf, field...)
 	}
+	buf = append(buf, '"')
 	for len(field) > 0 {
 		special := strings.IndexAny(field, "\"")
 		if special == -1 {
-			_, err := pe.writer.WriteString(field)
-			if err != nil {
-				panic(err)
-			}
+			buf = append(buf, field...)
 			break
 		}
-		_, err := pe.writer.WriteString(field[:special])
-		if err != nil {
-			panic(err)
-		}
-		_, err = pe.writer.WriteString("\"\"")
-		if err != nil {
-			panic(err)
-		}
+		buf = append(buf, field[:special]...)
+		buf = append(buf, "\"\""...)
 		field = field[special+1:]
 	}
-	err = pe.writer.WriteByte('"')
-	if err != nil {
-		panic(err)
-	}
+	return append(buf, '"')
 }
 
 func (pe *csvExporter) Fields(fields []string) {
+	buf := pe.buf[:0]
 	for i, field := range fields {
 		if i > 0 {
-			err := pe.writer.WriteByte(',')
-			if err != nil {
-				panic(err)
-			}
+			buf = append(buf, ',')
 		}
-		pe.writeString(field)
+		buf = appendString(buf, field)
 	}
-	err := pe.writer.WriteByte('\n')
-	if err != nil {
-		panic(err)
-	}
-	if pe.flush {
-		err = pe.writer.Flush()
-		if err != nil {
-			panic(err)
-		}
+	buf = append(buf, '\n')
+	pe.Write(buf)
+	pe.buf = buf
+}
+
+func appendTime(buf []byte, val uint64, typ ipfix.Type, scale uint64) []byte {
+	// scale is the number of nanoseconds per unit of val
+	switch typ {
+	case ipfix.DateTimeNanosecondsType:
+		return strconv.AppendUint(buf, val*scale, 10)
+	case ipfix.DateTimeMicrosecondsType:
+		return strconv.AppendUint(buf, val*scale/1e3, 10)
+	case ipfix.DateTimeMillisecondsType:
+		return strconv.AppendUint(buf, val*scale/1e6, 10)
+	case ipfix.DateTimeSecondsType:
+		return strconv.AppendUint(buf, val*scale/1e9, 10)
 	}
+	return strconv.AppendUint(buf, val, 10)
 }
 
-//Export export given features
-func (pe *csvExporter) Export(template flows.Template, features []interface{}, when flows.DateTimeNanoseconds) {
+// Encode appends the given features as csv line to buf
+func (pe *csvExporter) Encode(buf []byte, template flows.Template, features []interface{}, when flows.DateTimeNanoseconds) []byte {
 	ies := template.InformationElements()[:len(features)]
 	for i, elem := range features {
-		var err error
 		if i > 0 {
-			err = pe.writer.WriteByte(',')
-			if err != nil {
-				panic(err)
-			}
+			buf = append(buf, ',')
 		}
 		switch val := elem.(type) {
 		case int:
-			_, err = pe.writer.WriteString(strconv.FormatInt(int64(val), 10))
+			buf = strconv.AppendInt(buf, int64(val), 10)
 		case int8:
-			_, err = pe.writer.WriteString(strconv.FormatInt(int64(val), 10))
+			buf = strconv.AppendInt(buf, int64(val), 10)
 		case int16:
-			_, err = pe.writer.WriteString(strconv.FormatInt(int64(val), 10))
+			buf = strconv.AppendInt(buf, int64(val), 10)
 		case int32:
-			_, err = pe.writer.WriteString(strconv.FormatInt(int64(val), 10))
+			buf = strconv.AppendInt(buf, int64(val), 10)
 		case int64:
-			_, err = pe.writer.WriteString(strconv.FormatInt(val, 10))
+			buf = strconv.AppendInt(buf, val, 10)
 		case uint:
-			_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
+			buf = strconv.AppendUint(buf, uint64(val), 10)
 		case uint8:
-			_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
+			buf = strconv.AppendUint(buf, uint64(val), 10)
 		case uint16:
-			_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
+			buf = strconv.AppendUint(buf, uint64(val), 10)
 		case uint32:
-			_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
+			buf = strconv.AppendUint(buf, uint64(val), 10)
 		case uint64:
-			_, err = pe.writer.WriteString(strconv.FormatUint(val, 10))
+			buf = strconv.AppendUint(buf, val, 10)
 		case float32:
-			_, err = pe.writer.WriteString(strconv.FormatFloat(float64(val), 'g', -1, 32))
+			buf = strconv.AppendFloat(buf, float64(val), 'g', -1, 32)
 		case float64:
-			_, err = pe.writer.WriteString(strconv.FormatFloat(val, 'g', -1, 64))
+			buf = strconv.AppendFloat(buf, val, 'g', -1, 64)
 		case net.IP:
-			_, err = pe.writer.WriteString(val.String())
+			buf = append(buf, val.String()...)
 		case nil:
 			continue
 		case flows.DateTimeNanoseconds:
-			switch ies[i].Type {
-			case ipfix.DateTimeNanosecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
-			case ipfix.DateTimeMicrosecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val/1e3), 10))
-			case ipfix.DateTimeMillisecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val/1e6), 10))
-			case ipfix.DateTimeSecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val/1e9), 10))
-			default:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
-			}
+			buf = appendTime(buf, uint64(val), ies[i].Type, 1)
 		case flows.DateTimeMicroseconds:
-			switch ies[i].Type {
-			case ipfix.DateTimeNanosecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val*1e3), 10))
-			case ipfix.DateTimeMicrosecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
-			case ipfix.DateTimeMillisecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val/1e3), 10))
-			case ipfix.DateTimeSecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val/1e6), 10))
-			default:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
-			}
+			buf = appendTime(buf, uint64(val), ies[i].Type, 1e3)
 		case flows.DateTimeMilliseconds:
-			switch ies[i].Type {
-			case ipfix.DateTimeNanosecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val*1e6), 10))
-			case ipfix.DateTimeMicrosecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val*1e3), 10))
-			case ipfix.DateTimeMillisecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
-			case ipfix.DateTimeSecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val/1e3), 10))
-			default:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
-			}
+			buf = appendTime(buf, uint64(val), ies[i].Type, 1e6)
 		case flows.DateTimeSeconds:
-			switch ies[i].Type {
-			case ipfix.DateTimeNanosecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val*1e9), 10))
-			case ipfix.DateTimeMicrosecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val*1e6), 10))
-			case ipfix.DateTimeMillisecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val*1e3), 10))
-			case ipfix.DateTimeSecondsType:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
-			default:
-				_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
-			}
+			buf = appendTime(buf, uint64(val), ies[i].Type, 1e9)
 		case flows.FlowEndReason:
-			_, err = pe.writer.WriteString(strconv.FormatUint(uint64(val), 10))
+			buf = strconv.AppendUint(buf, uint64(val), 10)
 		case []byte:
-			pe.writeString(string(val))
+			buf = appendString(buf, string(val))
 		case string:
-			pe.writeString(val)
+			buf = appendString(buf, val)
 		case net.HardwareAddr:
-			_, err = pe.writer.WriteString(val.String())
+			buf = append(buf, val.String()...)
 		default:
-			pe.writeString(fmt.Sprint(val))
-		}
-		if err != nil {
-			panic(err)
+			buf = appendString(buf, fmt.Sprint(val))
 		}
 	}
-	err := pe.writer.WriteByte('\n')
+	return append(buf, '\n')
+}
+
+// Write writes already encoded lines to the output
+func (pe *csvExporter) Write(buf []byte) {
+	_, err := pe.writer.Write(buf)
 	if err != nil {
 		panic(err)
 	}
@@ -229,6 +161,12 @@ func (pe *csvExporter) Export(template flows.Template, features []interface{}, w
 	}
 }
 
+//Export export given features
+func (pe *csvExporter) Export(template flows.Template, features []interface{}, when flows.DateTimeNanoseconds) {
+	pe.buf = pe.Encode(pe.buf[:0], template, features, when)
+	pe.Write(pe.buf)
+}
+
 //Finish Write outstanding data and wait for completion
 func (pe *csvExporter) Finish() {
 	pe.writer.Flush()
@@ -286,7 +224,8 @@ Usage:
 
 Flags:
 -flush
-	  Flush after each line (default off).
+	  Flush after each line (default off). If multiple encoders are used,
+	  the output is flushed after every batch of lines.
 `, name, name)
 }
 
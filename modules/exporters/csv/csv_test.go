@@ -0,0 +1,63 @@
+package csv
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet"
+	"github.com/google/gopacket/layers"
+
+	_ "github.com/CN-TU/go-flows/modules/features/iana"
+	_ "github.com/CN-TU/go-flows/modules/features/operations"
+)
+
+func runEncoders(t *testing.T, encoders uint, sortOrder flows.SortType) []byte {
+	outfile := filepath.Join(t.TempDir(), "out.csv")
+	_, exporter, err := flows.MakeExporter("csv", []string{outfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter.Init()
+	pipe, _ := flows.MakeExportPipeline([]flows.Exporter{exporter}, sortOrder, 1, encoders, false)
+	var f flows.RecordListMaker
+	features := []interface{}{"sourceTransportPort", "flowStartNanoseconds", []interface{}{"mean", "ipTotalLength"}, "packetTotalCount"}
+	if err := f.AppendRecord(features, nil, nil, pipe, false); err != nil {
+		t.Fatal(err)
+	}
+	f.Init()
+	table := flows.NewFlowTable(f, packet.NewFlow, flows.FlowOptions{ActiveTimeout: 1e12, IdleTimeout: 1e12, SortOutput: sortOrder}, true, 0)
+	selector := packet.MakeDynamicKeySelector([]string{"sourceTransportPort"}, true, true)
+	for i := 0; i < 50000; i++ {
+		data := packet.BufferFromLayers(flows.DateTimeNanoseconds(i),
+			&layers.IPv4{SrcIP: []byte{1, 2, 3, 4}, DstIP: []byte{1, 2, 3, 5}, Length: uint16(40 + i%1000), Protocol: layers.IPProtocolUDP},
+			&layers.UDP{SrcPort: layers.UDPPort(i % 5000), DstPort: 80})
+		key, fw, _ := selector.Key(data)
+		data.SetInfo(key, fw)
+		table.Event(data)
+	}
+	table.EOF(100000)
+	pipe.Flush()
+	exporter.Finish()
+	ret, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestParallelEncoders(t *testing.T) {
+	for _, sortOrder := range []flows.SortType{flows.SortTypeNone, flows.SortTypeStartTime} {
+		serial := runEncoders(t, 1, sortOrder)
+		if lines := bytes.Count(serial, []byte{'\n'}); lines != 5001 {
+			t.Fatalf("expected 5001 lines, got %d", lines)
+		}
+		for _, encoders := range []uint{2, 8} {
+			if parallel := runEncoders(t, encoders, sortOrder); !bytes.Equal(serial, parallel) {
+				t.Errorf("output of %d encoders differs from serial output (sort order %d)", encoders, sortOrder)
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package control
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/modules/features/custom"
+	"github.com/CN-TU/go-flows/packet"
+	"github.com/google/gopacket/layers"
+)
+
+// positive converts a parameter of a control feature to a positive integer
+func positive(value interface{}) (uint64, bool) {
+	ret, err := strconv.ParseUint(fmt.Sprint(value), 10, 64)
+	return ret, err == nil && ret != 0
+}
+
+// setting returns the positive integer parameter of the named control feature from the flow specification
+func setting(settings map[string]interface{}, name string) (uint64, error) {
+	val, ok := settings[name]
+	if !ok {
+		return 0, fmt.Errorf("control feature %s needs the parameter \"%s\" in the flow specification", name, name)
+	}
+	ret, ok := positive(val)
+	if !ok {
+		return 0, fmt.Errorf("parameter \"%s\" of control feature %s must be a positive integer (is %v)", name, name, val)
+	}
+	return ret, nil
+}
+
+// argument returns the positive integer parameter of a control feature given as constant argument
+func argument(arguments []int, features []flows.Feature) (uint64, error) {
+	val := features[arguments[0]].Value()
+	ret, ok := positive(val)
+	if !ok {
+		return 0, fmt.Errorf("argument must be a positive integer (is %v)", val)
+	}
+	return ret, nil
+}
+
+// parameter holds the parameter of a control feature, which is either given as constant argument or in the flow
+// specification. Both are verified when the specification is parsed.
+type parameter struct {
+	name     string
+	n        uint64
+	argument bool
+}
+
+func (p *parameter) SetArguments(arguments []int, features []flows.Feature) {
+	p.n, _ = argument(arguments, features)
+	p.argument = true
+}
+
+func (p *parameter) CheckArguments(arguments []int, features []flows.Feature) error {
+	_, err := argument(arguments, features)
+	return err
+}
+
+func (p *parameter) CheckSettings(settings map[string]interface{}) error {
+	if p.argument {
+		return nil
+	}
+	_, err := setting(settings, p.name)
+	return err
+}
+
+// value returns the parameter. It is read from the flow specification on first use, unless it was given as argument.
+// This also covers features restored from a checkpoint, which aren't started.
+func (p *parameter) value(context *flows.EventContext) uint64 {
+	if p.n == 0 {
+		p.n, _ = setting(context.Flow().Table().CustomSettings, p.name)
+	}
+	return p.n
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type exportEveryPackets struct {
+	flows.NoopFeature
+	parameter
+	count uint64
+}
+
+func (f *exportEveryPackets) Start(context *flows.EventContext) {
+	f.count = 0
+}
+
+func (f *exportEveryPackets) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.count++
+	if f.count == f.value(context) {
+		context.Export(false, flows.FlowEndReasonActive)
+		context.Restart(false)
+	}
+}
+
+func (f *exportEveryPackets) Checkpoint(c *flows.Checkpoint) {
+	c.Uint64(&f.count)
+}
+
+func init() {
+	flows.RegisterControlFeature("_exportEveryPackets", "exports the record with flowEndReason active after every n packets (n = argument or _exportEveryPackets) and continues with a new record.", func() flows.Feature { return &exportEveryPackets{parameter: parameter{name: "_exportEveryPackets"}} })
+	flows.RegisterFunction("_exportEveryPackets", "exports the record with flowEndReason active after every n packets (n = argument or _exportEveryPackets) and continues with a new record.", flows.ControlFeature, func() flows.Feature { return &exportEveryPackets{parameter: parameter{name: "_exportEveryPackets"}} }, flows.Const, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type restartOnSYN struct {
+	flows.NoopFeature
+	started bool
+}
+
+func (f *restartOnSYN) Start(context *flows.EventContext) {
+	f.started = false
+}
+
+func (f *restartOnSYN) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.started {
+		if tcp, ok := new.(packet.Buffer).TransportLayer().(*layers.TCP); ok && tcp.SYN && !tcp.ACK {
+			// the SYN is handled again by the new record
+			context.Export(true, flows.FlowEndReasonEnd)
+			return
+		}
+	}
+	f.started = true
+}
+
+func (f *restartOnSYN) Checkpoint(c *flows.Checkpoint) {
+	c.Bool(&f.started)
+}
+
+func init() {
+	flows.RegisterControlFeature("_restartOnSYN", "exports the record with flowEndReason end before a tcp SYN (without ACK) and starts a new record with the SYN.", func() flows.Feature { return &restartOnSYN{} })
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type endOnDNSResponse struct {
+	flows.NoopFeature
+}
+
+func (f *endOnDNSResponse) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	var port uint16
+	switch tl := new.(packet.Buffer).TransportLayer().(type) {
+	case *layers.UDP:
+		port = uint16(tl.SrcPort)
+	case *layers.TCP:
+		port = uint16(tl.SrcPort)
+	default:
+		return
+	}
+	if port != 53 {
+		return
+	}
+	if dns := custom.GetDNS(new); dns != nil && dns.QR {
+		context.Export(false, flows.FlowEndReasonEnd)
+	}
+}
+
+func init() {
+	flows.RegisterControlFeature("_endOnDNSResponse", "exports the record with flowEndReason end after a DNS response (source port 53).", func() flows.Feature { return &endOnDNSResponse{} })
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type minPackets struct {
+	flows.NoopFeature
+	parameter
+	count uint64
+}
+
+func (f *minPackets) Start(context *flows.EventContext) {
+	f.count = 0
+}
+
+func (f *minPackets) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.count++
+}
+
+func (f *minPackets) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if f.count < f.value(context) {
+		context.Stop()
+	}
+}
+
+func (f *minPackets) Checkpoint(c *flows.Checkpoint) {
+	c.Uint64(&f.count)
+}
+
+func init() {
+	flows.RegisterControlFeature("_minPackets", "discards records with less than n packets (n = argument or _minPackets).", func() flows.Feature { return &minPackets{parameter: parameter{name: "_minPackets"}} })
+	flows.RegisterFunction("_minPackets", "discards records with less than n packets (n = argument or _minPackets).", flows.ControlFeature, func() flows.Feature { return &minPackets{parameter: parameter{name: "_minPackets"}} }, flows.Const, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type octetLimit struct {
+	flows.NoopFeature
+	parameter
+	total uint64
+}
+
+func (f *octetLimit) Start(context *flows.EventContext) {
+	f.total = 0
+}
+
+func (f *octetLimit) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.total += uint64(new.(packet.Buffer).NetworkLayerLength())
+	if f.total >= f.value(context) {
+		context.Export(false, flows.FlowEndReasonEnd)
+	}
+}
+
+func (f *octetLimit) Checkpoint(c *flows.Checkpoint) {
+	c.Uint64(&f.total)
+}
+
+func init() {
+	flows.RegisterControlFeature("_octetLimit", "exports the record with flowEndReason end after the packet reaching n octets (n = argument or _octetLimit; like octetTotalCount).", func() flows.Feature { return &octetLimit{parameter: parameter{name: "_octetLimit"}} })
+	flows.RegisterFunction("_octetLimit", "exports the record with flowEndReason end after the packet reaching n octets (n = argument or _octetLimit; like octetTotalCount).", flows.ControlFeature, func() flows.Feature { return &octetLimit{parameter: parameter{name: "_octetLimit"}} }, flows.Const, flows.RawPacket)
+}
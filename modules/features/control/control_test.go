@@ -0,0 +1,198 @@
+package control
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	_ "github.com/CN-TU/go-flows/modules/features/iana"
+	_ "github.com/CN-TU/go-flows/modules/features/operations"
+)
+
+func makeTest(t *testing.T, control interface{}, settings map[string]interface{}) packet_test.TestTable {
+	return packet_test.MakeControlFeatureTest(t, []string{"packetTotalCount", "flowEndReason"}, []interface{}{control}, nil, flows.FlowOptions{CustomSettings: settings})
+}
+
+func result(when flows.DateTimeNanoseconds, packets uint64, reason flows.FlowEndReason) packet_test.FeatureLine {
+	return packet_test.FeatureLine{When: when, Features: []packet_test.FeatureResult{
+		{Name: "packetTotalCount", Value: packets},
+		{Name: "flowEndReason", Value: uint16(reason)},
+	}}
+}
+
+func TestExportEveryPackets(t *testing.T) {
+	table := makeTest(t, "_exportEveryPackets", map[string]interface{}{"_exportEveryPackets": json.Number("2")})
+	for i := 1; i <= 5; i++ {
+		table.EventLayers(flows.DateTimeNanoseconds(i), &layers.UDP{SrcPort: 1, DstPort: 2})
+	}
+	table.Finish(10)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		result(2, 2, flows.FlowEndReasonActive),
+		result(4, 2, flows.FlowEndReasonActive),
+		result(10, 1, flows.FlowEndReasonForcedEnd),
+	})
+}
+
+func TestExportEveryPacketsArgument(t *testing.T) {
+	table := makeTest(t, []interface{}{"_exportEveryPackets", int64(2)}, nil)
+	for i := 1; i <= 5; i++ {
+		table.EventLayers(flows.DateTimeNanoseconds(i), &layers.UDP{SrcPort: 1, DstPort: 2})
+	}
+	table.Finish(10)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		result(2, 2, flows.FlowEndReasonActive),
+		result(4, 2, flows.FlowEndReasonActive),
+		result(10, 1, flows.FlowEndReasonForcedEnd),
+	})
+}
+
+func TestFilterExpression(t *testing.T) {
+	table := packet_test.MakeControlFeatureTest(t, []string{"packetTotalCount", "flowEndReason"}, nil,
+		[]interface{}{[]interface{}{"greater", "ipTotalLength", int64(150)}}, flows.FlowOptions{})
+	for i, length := range []uint16{100, 200, 300, 100} {
+		table.EventLayers(flows.DateTimeNanoseconds(i+1), &layers.IPv4{SrcIP: []byte{1, 2, 3, 4}, DstIP: []byte{1, 2, 3, 5}, Length: length, Protocol: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 1, DstPort: 2})
+	}
+	table.Finish(10)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		result(10, 2, flows.FlowEndReasonForcedEnd),
+	})
+}
+
+func TestRestartOnSYN(t *testing.T) {
+	table := makeTest(t, "_restartOnSYN", nil)
+	events := []*layers.TCP{
+		{SrcPort: 1, DstPort: 2, SYN: true},
+		{SrcPort: 2, DstPort: 1, SYN: true, ACK: true},
+		{SrcPort: 1, DstPort: 2, ACK: true},
+		{SrcPort: 1, DstPort: 2, SYN: true},
+		{SrcPort: 2, DstPort: 1, SYN: true, ACK: true},
+	}
+	for i, event := range events {
+		if event.SrcPort == 1 {
+			table.EventLayers(flows.DateTimeNanoseconds(i+1), ip(1, 2), event)
+		} else {
+			table.EventLayers(flows.DateTimeNanoseconds(i+1), ip(2, 1), event)
+		}
+	}
+	table.Finish(10)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		result(4, 3, flows.FlowEndReasonEnd),
+		result(10, 2, flows.FlowEndReasonForcedEnd),
+	})
+}
+
+func ip(src, dst byte) *layers.IPv4 {
+	return &layers.IPv4{SrcIP: []byte{10, 0, 0, src}, DstIP: []byte{10, 0, 0, dst}}
+}
+
+func dnsPayload(t *testing.T, response bool) []byte {
+	dns := &layers.DNS{
+		ID:        1,
+		QR:        response,
+		Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEndOnDNSResponse(t *testing.T) {
+	table := makeTest(t, "_endOnDNSResponse", nil)
+	query := &layers.UDP{SrcPort: 1000, DstPort: 53}
+	query.Payload = dnsPayload(t, false)
+	response := &layers.UDP{SrcPort: 53, DstPort: 1000}
+	response.Payload = dnsPayload(t, true)
+	table.EventLayers(1, ip(1, 2), query)
+	table.EventLayers(2, ip(2, 1), response)
+	table.EventLayers(3, ip(1, 2), query)
+	table.Finish(10)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		result(2, 2, flows.FlowEndReasonEnd),
+		result(10, 1, flows.FlowEndReasonForcedEnd),
+	})
+}
+
+func TestMinPackets(t *testing.T) {
+	table := makeTest(t, "_minPackets", map[string]interface{}{"_minPackets": json.Number("2")})
+	events := []*layers.UDP{
+		{SrcPort: 1, DstPort: 2},
+		{SrcPort: 3, DstPort: 4},
+		{SrcPort: 3, DstPort: 4},
+	}
+	for i, event := range events {
+		table.EventLayers(flows.DateTimeNanoseconds(i+1), event)
+	}
+	table.Finish(10)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		result(10, 2, flows.FlowEndReasonForcedEnd),
+	})
+}
+
+func TestOctetLimit(t *testing.T) {
+	table := makeTest(t, "_octetLimit", map[string]interface{}{"_octetLimit": json.Number("250")})
+	for i := 1; i <= 4; i++ {
+		table.EventLayers(flows.DateTimeNanoseconds(i), &layers.IPv4{SrcIP: []byte{1, 2, 3, 4}, DstIP: []byte{1, 2, 3, 5}, Length: 100, Protocol: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 1, DstPort: 2})
+	}
+	table.Finish(10)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		result(3, 3, flows.FlowEndReasonEnd),
+		result(10, 1, flows.FlowEndReasonForcedEnd),
+	})
+}
+
+// appendRecord parses a record with the given control feature
+func appendRecord(control interface{}) (flows.RecordListMaker, error) {
+	var records flows.RecordListMaker
+	pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1, 1, false)
+	err := records.AppendRecord([]interface{}{"packetTotalCount"}, []interface{}{control}, nil, pipe, false)
+	return records, err
+}
+
+func TestSettingErrors(t *testing.T) {
+	for _, name := range []string{"_exportEveryPackets", "_minPackets", "_octetLimit"} {
+		records, err := appendRecord(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := records.CheckSettings(map[string]interface{}{name: json.Number("5")}); err != nil {
+			t.Errorf("%s: valid parameter rejected: %s", name, err)
+		}
+		for _, settings := range []map[string]interface{}{
+			nil,
+			{name: json.Number("0")},
+			{name: json.Number("-1")},
+			{name: json.Number("1.5")},
+			{name: "abc"},
+		} {
+			err := records.CheckSettings(settings)
+			if _, ok := err.(flows.FeatureError); !ok {
+				t.Errorf("%s: expected a feature error for the settings %v, got %v", name, settings, err)
+			}
+		}
+		// parameters given as argument don't need a setting
+		records, err = appendRecord([]interface{}{name, int64(5)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := records.CheckSettings(nil); err != nil {
+			t.Errorf("%s: argument not used as parameter: %s", name, err)
+		}
+	}
+}
+
+func TestArgumentErrors(t *testing.T) {
+	for _, name := range []string{"_exportEveryPackets", "_minPackets", "_octetLimit"} {
+		for _, arg := range []interface{}{int64(0), int64(-1), 1.5, "abc"} {
+			_, err := appendRecord([]interface{}{name, arg})
+			if _, ok := err.(flows.FeatureError); !ok {
+				t.Errorf("%s: expected a feature error for the argument %v, got %v", name, arg, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,12 @@
+// Package control contains control features, which can be used in _control_features of a flow specification.
+//
+// Parameters of control features can be given as constant argument, e.g.:
+//
+//	"_control_features": [{"_exportEveryPackets": [100]}, {"_minPackets": [2]}]
+//
+// or are read from the key with the name of the feature in the flow specification, e.g.:
+//
+//	"_control_features": ["_exportEveryPackets", "_minPackets"],
+//	"_exportEveryPackets": 100,
+//	"_minPackets": 2
+package control
@@ -1,12 +1,10 @@
 package custom
 
 import (
-	"strings"
-
 	"github.com/CN-TU/go-flows/flows"
+	_ "github.com/CN-TU/go-flows/modules/dissectors/dns" // dissector for GetDNS
 	"github.com/CN-TU/go-flows/packet"
 	ipfix "github.com/CN-TU/go-ipfix"
-	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 )
 
@@ -32,129 +30,10 @@ func init() {
 
 ////////////////////////////////////////////////////////////////////////////////
 
-type _HTTPLines struct {
-	flows.BaseFeature
-	buffer     []byte
-	status     uint8
-	statusNext uint8
-	current    string
-	src        string
-}
-
-const _None uint8 = 0
-const _Request uint8 = 1
-const _Response uint8 = 2
-const _Header uint8 = 3
-const _Body uint8 = 4
-const _Error string = "-1"
-
-func (f *_HTTPLines) Start(context *flows.EventContext) {
-	f.BaseFeature.Start(context)
-	f.status = _Request
-	f.statusNext = _None
-}
-
-func (f *_HTTPLines) extractLine(ignore bool) (ret string) {
-	data := string(f.buffer) // TODO maybe it's better to keep it as bytes and not use strings?
-	if ignore {
-		data = strings.TrimLeft(data, " \t\n\r")
-	}
-	dataSplit := strings.SplitN(data, "\n", 2)
-	if len(dataSplit) != 2 {
-		return _Error
-	}
-	f.buffer = []byte(dataSplit[1]) // TODO here maybe there's unnecessary allocations
-	return strings.TrimSpace(dataSplit[0])
-}
-
-func (f *_HTTPLines) extractRequest(line string) (ret string) {
-	// TODO implement proper request parsing
-	lineSplit := strings.Split(line, " ")
-	return lineSplit[0]
-}
-
-func (f *_HTTPLines) parsePart(context *flows.EventContext, src interface{}) (ret bool) {
-	// TODO nothing is implemented except for extracting headers in simple http sessions
-	switch f.status {
-	case _Request:
-		line := f.extractLine(true)
-		if line == _Error {
-			return false
-		}
-		f.SetValue(line, context, src)
-		f.statusNext = _Response
-		f.status = _Header
-		f.current = f.extractRequest(line)
-		if f.current == "" {
-			f.status = _Request
-		}
-	case _Header:
-		line := f.extractLine(false)
-		if line == _Error {
-			return false
-		}
-		if line == "" { // end of header
-			return false // FIXME this should continue, and do more stuff
-		}
-		f.SetValue(line, context, src)
-	default:
-	}
-	return true
-}
-
-func (f *_HTTPLines) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	f.buffer = append(f.buffer, []byte(new.(string))...)
-	for f.parsePart(context, src) == true {
-		continue
-	}
-}
-
-func (f *_HTTPLines) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
-}
-
-func init() {
-	flows.RegisterTemporaryFeature("httpLines", "returns headers from a http session", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_HTTPLines{} }, flows.PacketFeature)
-	flows.RegisterTemporaryCompositeFeature("_HTTPLines", "returns headers from a http session", ipfix.StringType, 0, "httpLines", "_tcpReorderPayload")
-}
-
-type httpRequestHost struct {
-	flows.BaseFeature
-}
-
-func (f *httpRequestHost) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	header := strings.SplitN(new.(string), ":", 2)
-	if header[0] == "Host" {
-		f.SetValue(strings.TrimSpace(header[1]), context, src)
-	}
-}
-
-func init() {
-	flows.RegisterTemporaryFeature("__httpRequestHost", "extract the host header from lines of text", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &httpRequestHost{} }, flows.PacketFeature)
-	flows.RegisterStandardCompositeFeature("httpRequestHost", "__httpRequestHost", "_HTTPLines")
-}
-
-////////////////////////////////////////////////////////////////////////////////
-
-// GetDNS returns the DNS layer of the packet or nil
+// GetDNS returns the DNS layer of the packet (see modules/dissectors/dns) or nil
 func GetDNS(new interface{}) *layers.DNS {
-	tl := new.(packet.Buffer).TransportLayer()
-	if tl != nil {
-		payload := tl.LayerPayload()
-		if tl.LayerType() == layers.LayerTypeTCP {
-			// when TCP, omit DNS length field (otherwise DNS parser fails)
-			if len(payload) > 2 {
-				payload = payload[2:]
-			} else {
-				return nil
-			}
-		}
-		dns := &layers.DNS{}
-		err := dns.DecodeFromBytes(payload, gopacket.NilDecodeFeedback)
-		if err == nil {
-			return dns
-		}
-	}
-	return nil
+	dns, _ := new.(packet.Buffer).ApplicationLayer().(*layers.DNS)
+	return dns
 }
 
 type _dnsDomain struct {
@@ -162,16 +41,15 @@ type _dnsDomain struct {
 }
 
 func (f *_dnsDomain) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		for _, dnsQuestion := range dns.Questions {
-			f.SetValue(string(dnsQuestion.Name), context, src)
-		}
+	dns := new.(*dnsMessage).dns
+	for _, dnsQuestion := range dns.Questions {
+		f.SetValue(string(dnsQuestion.Name), context, src)
 	}
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsDomain", "returns domains from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsDomain{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsDomain", "returns domains from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsDomain{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsDomain", "returns domains from DNS packets.", ipfix.StringType, 0, "__dnsDomain", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -181,14 +59,13 @@ type _dnsID struct {
 }
 
 func (f *_dnsID) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		f.SetValue(dns.ID, context, src)
-	}
+	dns := new.(*dnsMessage).dns
+	f.SetValue(dns.ID, context, src)
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsID", "returns ID of DNS query.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsID{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsID", "returns ID of DNS query.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsID{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsID", "returns ID of DNS query.", ipfix.Unsigned16Type, 0, "__dnsID", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -198,14 +75,13 @@ type _dnsQR struct {
 }
 
 func (f *_dnsQR) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		f.SetValue(dns.QR, context, src)
-	}
+	dns := new.(*dnsMessage).dns
+	f.SetValue(dns.QR, context, src)
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsQR", "returns QR of DNS query.", ipfix.BooleanType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsQR{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsQR", "returns QR of DNS query.", ipfix.BooleanType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsQR{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsQR", "returns QR of DNS query.", ipfix.BooleanType, 0, "__dnsQR", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -215,14 +91,13 @@ type _dnsResponseCode struct {
 }
 
 func (f *_dnsResponseCode) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		f.SetValue(dns.ResponseCode.String(), context, src)
-	}
+	dns := new.(*dnsMessage).dns
+	f.SetValue(dns.ResponseCode.String(), context, src)
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsResponseCode", "returns response code from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsResponseCode{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsResponseCode", "returns response code from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsResponseCode{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsResponseCode", "returns response code from DNS packets.", ipfix.StringType, 0, "__dnsResponseCode", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -232,14 +107,13 @@ type _dnsQDCount struct {
 }
 
 func (f *_dnsQDCount) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		f.SetValue(dns.QDCount, context, src)
-	}
+	dns := new.(*dnsMessage).dns
+	f.SetValue(dns.QDCount, context, src)
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsQDCount", "returns number of questions in DNS packets.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsQDCount{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsQDCount", "returns number of questions in DNS packets.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsQDCount{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsQDCount", "returns number of questions in DNS packets.", ipfix.Unsigned16Type, 0, "__dnsQDCount", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -249,14 +123,13 @@ type _dnsANCount struct {
 }
 
 func (f *_dnsANCount) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		f.SetValue(dns.ANCount, context, src)
-	}
+	dns := new.(*dnsMessage).dns
+	f.SetValue(dns.ANCount, context, src)
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsANCount", "returns number of answers in DNS packets.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsANCount{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsANCount", "returns number of answers in DNS packets.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsANCount{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsANCount", "returns number of answers in DNS packets.", ipfix.Unsigned16Type, 0, "__dnsANCount", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -266,14 +139,13 @@ type _dnsNSCount struct {
 }
 
 func (f *_dnsNSCount) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		f.SetValue(dns.NSCount, context, src)
-	}
+	dns := new.(*dnsMessage).dns
+	f.SetValue(dns.NSCount, context, src)
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsNSCount", "returns number of authorities in DNS packets.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsNSCount{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsNSCount", "returns number of authorities in DNS packets.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsNSCount{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsNSCount", "returns number of authorities in DNS packets.", ipfix.Unsigned16Type, 0, "__dnsNSCount", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -283,14 +155,13 @@ type _dnsARCount struct {
 }
 
 func (f *_dnsARCount) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		f.SetValue(dns.ARCount, context, src)
-	}
+	dns := new.(*dnsMessage).dns
+	f.SetValue(dns.ARCount, context, src)
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsARCount", "returns number of additional records in DNS packets.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsARCount{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsARCount", "returns number of additional records in DNS packets.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsARCount{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsARCount", "returns number of additional records in DNS packets.", ipfix.Unsigned16Type, 0, "__dnsARCount", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -300,16 +171,15 @@ type _dnsType struct {
 }
 
 func (f *_dnsType) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		for _, dnsQuestion := range dns.Questions {
-			f.SetValue(dnsQuestion.Type.String(), context, src)
-		}
+	dns := new.(*dnsMessage).dns
+	for _, dnsQuestion := range dns.Questions {
+		f.SetValue(dnsQuestion.Type.String(), context, src)
 	}
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsType", "returns request types from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsType{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsType", "returns request types from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsType{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsType", "returns request types from DNS packets.", ipfix.StringType, 0, "__dnsType", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -319,16 +189,15 @@ type _dnsClass struct {
 }
 
 func (f *_dnsClass) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		for _, dnsQuestion := range dns.Questions {
-			f.SetValue(dnsQuestion.Class.String(), context, src)
-		}
+	dns := new.(*dnsMessage).dns
+	for _, dnsQuestion := range dns.Questions {
+		f.SetValue(dnsQuestion.Class.String(), context, src)
 	}
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsClass", "returns request classes from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsClass{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsClass", "returns request classes from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsClass{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsClass", "returns request classes from DNS packets.", ipfix.StringType, 0, "__dnsClass", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -338,16 +207,15 @@ type _dnsAnsName struct {
 }
 
 func (f *_dnsAnsName) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		for _, dnsAnswer := range dns.Answers {
-			f.SetValue(string(dnsAnswer.Name), context, src)
-		}
+	dns := new.(*dnsMessage).dns
+	for _, dnsAnswer := range dns.Answers {
+		f.SetValue(string(dnsAnswer.Name), context, src)
 	}
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsAnsName", "returns answer domain names from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsAnsName{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsAnsName", "returns answer domain names from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsAnsName{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsAnsName", "returns answer domain names from DNS packets.", ipfix.StringType, 0, "__dnsAnsName", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -357,16 +225,15 @@ type _dnsAnsType struct {
 }
 
 func (f *_dnsAnsType) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		for _, dnsAnswer := range dns.Answers {
-			f.SetValue(dnsAnswer.Type.String(), context, src)
-		}
+	dns := new.(*dnsMessage).dns
+	for _, dnsAnswer := range dns.Answers {
+		f.SetValue(dnsAnswer.Type.String(), context, src)
 	}
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsAnsType", "returns answer DNS type from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsAnsType{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsAnsType", "returns answer DNS type from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsAnsType{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsAnsType", "returns answer DNS type from DNS packets.", ipfix.StringType, 0, "__dnsAnsType", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -376,16 +243,15 @@ type _dnsAnsClass struct {
 }
 
 func (f *_dnsAnsClass) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		for _, dnsAnswer := range dns.Answers {
-			f.SetValue(dnsAnswer.Class.String(), context, src)
-		}
+	dns := new.(*dnsMessage).dns
+	for _, dnsAnswer := range dns.Answers {
+		f.SetValue(dnsAnswer.Class.String(), context, src)
 	}
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsAnsClass", "returns answer DNS class from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsAnsClass{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsAnsClass", "returns answer DNS class from DNS packets.", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_dnsAnsClass{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsAnsClass", "returns answer DNS class from DNS packets.", ipfix.StringType, 0, "__dnsAnsClass", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -395,16 +261,15 @@ type _dnsAnsTTL struct {
 }
 
 func (f *_dnsAnsTTL) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		for _, dnsAnswer := range dns.Answers {
-			f.SetValue(dnsAnswer.TTL, context, src)
-		}
+	dns := new.(*dnsMessage).dns
+	for _, dnsAnswer := range dns.Answers {
+		f.SetValue(dnsAnswer.TTL, context, src)
 	}
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsAnsTTL", "returns answer TTL from DNS packets.", ipfix.Unsigned32Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsAnsTTL{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsAnsTTL", "returns answer TTL from DNS packets.", ipfix.Unsigned32Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsAnsTTL{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsAnsTTL", "returns answer TTL from DNS packets.", ipfix.Unsigned32Type, 0, "__dnsAnsTTL", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -414,16 +279,15 @@ type _dnsAnsDataLength struct {
 }
 
 func (f *_dnsAnsDataLength) Event(new interface{}, context *flows.EventContext, src interface{}) {
-	dns := GetDNS(new)
-	if dns != nil {
-		for _, dnsAnswer := range dns.Answers {
-			f.SetValue(dnsAnswer.DataLength, context, src)
-		}
+	dns := new.(*dnsMessage).dns
+	for _, dnsAnswer := range dns.Answers {
+		f.SetValue(dnsAnswer.DataLength, context, src)
 	}
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("_dnsAnsDataLength", "returns length of data in answers from DNS packets.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsAnsDataLength{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__dnsAnsDataLength", "returns length of data in answers from DNS packets.", ipfix.Unsigned16Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsAnsDataLength{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsAnsDataLength", "returns length of data in answers from DNS packets.", ipfix.Unsigned16Type, 0, "__dnsAnsDataLength", "__dnsMessages")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,405 @@
+package custom
+
+import (
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/modules/features"
+	"github.com/CN-TU/go-flows/packet"
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/google/gopacket/layers"
+)
+
+// The features in this file mimic the conn_state, history, and missed_bytes fields of the zeek conn.log.
+// The originator is the sender of the first packet, unless the first packet is a SYN+ACK (history starts with ^).
+
+// tcpEndpointState is the state of one side of the connection like in zeek
+type tcpEndpointState uint8
+
+const (
+	tcpEndpointInactive tcpEndpointState = iota
+	tcpEndpointSynSent
+	tcpEndpointSynAckSent
+	tcpEndpointPartial
+	tcpEndpointEstablished
+	tcpEndpointClosed
+	tcpEndpointReset
+)
+
+func (s tcpEndpointState) inactive() bool {
+	return s == tcpEndpointInactive || s == tcpEndpointPartial
+}
+
+// maxTCPHoles is the maximum number of sequence gaps remembered per direction for detecting filled gaps
+const maxTCPHoles = 64
+
+type tcpHole struct {
+	start, end features.Sequence
+}
+
+// tcpGaps keeps track of the sequence numbers of one direction to detect gaps and retransmissions
+type tcpGaps struct {
+	next  features.Sequence
+	holes []tcpHole
+}
+
+// fill removes the range [start, end) from the holes and returns the number of removed bytes
+func (g *tcpGaps) fill(start, end features.Sequence) (filled int) {
+	holes := g.holes[:0]
+	for _, h := range g.holes {
+		if h.end.Difference(start) >= 0 || end.Difference(h.start) >= 0 {
+			// no overlap
+			holes = append(holes, h)
+			continue
+		}
+		lo, hi := h.start, h.end
+		if h.start.Difference(start) > 0 {
+			lo = start
+		}
+		if end.Difference(h.end) > 0 {
+			hi = end
+		}
+		filled += lo.Difference(hi)
+		if lo != h.start {
+			holes = append(holes, tcpHole{h.start, lo})
+		}
+		if hi != h.end {
+			holes = append(holes, tcpHole{hi, h.end})
+		}
+	}
+	g.holes = holes
+	return
+}
+
+// segment processes a segment and returns the number of missed bytes (negative if a gap was filled), and if this
+// segment is a retransmission
+func (g *tcpGaps) segment(seq features.Sequence, plen int, tcp *layers.TCP) (missed int, retransmission bool) {
+	if tcp.SYN {
+		seq = seq.Add(1)
+	}
+	end := seq.Add(plen)
+	if tcp.FIN {
+		end = end.Add(1)
+	}
+	if g.next == features.InvalidSequence || tcp.RST {
+		if g.next == features.InvalidSequence {
+			g.next = end
+		}
+		return
+	}
+	diff := g.next.Difference(seq)
+	switch {
+	case diff > 0:
+		if len(g.holes) == maxTCPHoles {
+			g.holes = g.holes[1:]
+		}
+		g.holes = append(g.holes, tcpHole{g.next, seq})
+		g.next = end
+		return diff, false
+	case diff < 0 && plen > 0:
+		filled := g.fill(seq, end)
+		if g.next.Difference(end) > 0 {
+			g.next = end
+		}
+		return -filled, filled == 0
+	}
+	if g.next.Difference(end) > 0 {
+		g.next = end
+	}
+	return
+}
+
+func (g *tcpGaps) checkpoint(c *flows.Checkpoint) {
+	g.next.Checkpoint(c)
+	n := c.Len(len(g.holes))
+	if c.Loading() {
+		g.holes = make([]tcpHole, n)
+	}
+	for i := range g.holes {
+		g.holes[i].start.Checkpoint(c)
+		g.holes[i].end.Checkpoint(c)
+	}
+}
+
+// zeekConn tracks a connection like the zeek tcp and udp analyzers
+type zeekConn struct {
+	started    bool
+	tcp        bool
+	flipped    bool
+	synReset   bool
+	orig, resp tcpEndpointState
+	origBytes  uint64
+	respBytes  uint64
+	missed     uint64
+	origSeq    tcpGaps
+	respSeq    tcpGaps
+	origSeen   uint32
+	respSeen   uint32
+	history    []byte
+}
+
+func (z *zeekConn) reset() {
+	*z = zeekConn{
+		origSeq: tcpGaps{next: features.InvalidSequence},
+		respSeq: tcpGaps{next: features.InvalidSequence},
+	}
+}
+
+// addHistory adds the given letter (lower case) to the history, if it wasn't seen in this direction before
+func (z *zeekConn) addHistory(letter byte, orig bool) {
+	bit := uint32(1) << (letter - 'a')
+	seen := &z.respSeen
+	if orig {
+		seen = &z.origSeen
+		letter -= 'a' - 'A'
+	}
+	if *seen&bit != 0 {
+		return
+	}
+	*seen |= bit
+	z.history = append(z.history, letter)
+}
+
+func (z *zeekConn) event(buffer packet.Buffer, forward bool) {
+	tcp := features.GetTCP(buffer)
+	if !z.started {
+		z.started = true
+		z.tcp = tcp != nil
+		if z.tcp && tcp.SYN && tcp.ACK {
+			z.flipped = true
+			z.history = append(z.history, '^')
+		}
+	}
+	orig := forward != z.flipped
+	plen := buffer.PayloadLength()
+	if orig {
+		z.origBytes += uint64(plen)
+	} else {
+		z.respBytes += uint64(plen)
+	}
+	if !z.tcp || tcp == nil {
+		if orig {
+			z.orig = tcpEndpointEstablished
+		} else {
+			z.resp = tcpEndpointEstablished
+		}
+		if plen > 0 {
+			z.addHistory('d', orig)
+		}
+		return
+	}
+
+	self, peer, seq := &z.orig, &z.resp, &z.origSeq
+	if !orig {
+		self, peer, seq = &z.resp, &z.orig, &z.respSeq
+	}
+
+	if tcp.SYN {
+		if tcp.ACK {
+			z.addHistory('h', orig)
+		} else {
+			z.addHistory('s', orig)
+		}
+		if tcp.FIN || tcp.RST {
+			z.addHistory('q', orig)
+		}
+	}
+	if tcp.FIN {
+		z.addHistory('f', orig)
+	}
+	if tcp.RST {
+		z.addHistory('r', orig)
+	}
+	if plen > 0 {
+		z.addHistory('d', orig)
+	} else if tcp.ACK && !tcp.SYN && !tcp.FIN && !tcp.RST {
+		z.addHistory('a', orig)
+	}
+	if tcp.Window == 0 && !tcp.RST {
+		z.addHistory('w', orig)
+	}
+	missed, retransmission := seq.segment(features.Sequence(tcp.Seq), plen, tcp)
+	if missed > 0 {
+		z.addHistory('g', orig)
+	}
+	z.missed = uint64(int64(z.missed) + int64(missed))
+	if retransmission {
+		z.addHistory('t', orig)
+	}
+
+	switch {
+	case tcp.RST:
+		if orig && z.orig == tcpEndpointSynSent {
+			z.synReset = true
+		}
+		*self = tcpEndpointReset
+	case tcp.FIN:
+		if *self != tcpEndpointReset {
+			*self = tcpEndpointClosed
+		}
+	case tcp.SYN:
+		if *self == tcpEndpointInactive {
+			if tcp.ACK {
+				*self = tcpEndpointSynAckSent
+			} else {
+				*self = tcpEndpointSynSent
+			}
+		}
+	default:
+		switch *self {
+		case tcpEndpointInactive:
+			*self = tcpEndpointPartial
+		case tcpEndpointSynSent, tcpEndpointSynAckSent:
+			if tcp.ACK {
+				*self = tcpEndpointEstablished
+			}
+		}
+		if tcp.ACK && *peer == tcpEndpointSynAckSent {
+			*peer = tcpEndpointEstablished
+		}
+	}
+}
+
+// state returns the zeek conn_state
+func (z *zeekConn) state() string {
+	os, rs := z.orig, z.resp
+	if !z.tcp {
+		if os != tcpEndpointInactive {
+			if rs != tcpEndpointInactive {
+				return "SF"
+			}
+			return "S0"
+		}
+		if rs != tcpEndpointInactive {
+			return "SHR"
+		}
+		return "OTH"
+	}
+	switch {
+	case rs == tcpEndpointReset:
+		if os == tcpEndpointSynSent || os == tcpEndpointSynAckSent ||
+			(os == tcpEndpointReset && z.origBytes == 0 && z.respBytes == 0) {
+			return "REJ"
+		}
+		if os.inactive() {
+			return "RSTRH"
+		}
+		return "RSTR"
+	case os == tcpEndpointReset:
+		if rs.inactive() {
+			if z.synReset {
+				return "RSTOS0"
+			}
+			return "OTH"
+		}
+		return "RSTO"
+	case rs == tcpEndpointClosed && os == tcpEndpointClosed:
+		return "SF"
+	case os == tcpEndpointClosed:
+		if rs.inactive() {
+			return "SH"
+		}
+		return "S2"
+	case rs == tcpEndpointClosed:
+		if os.inactive() {
+			return "SHR"
+		}
+		return "S3"
+	case os == tcpEndpointSynSent && rs == tcpEndpointInactive:
+		return "S0"
+	case os == tcpEndpointEstablished && rs == tcpEndpointEstablished:
+		return "S1"
+	}
+	return "OTH"
+}
+
+func (z *zeekConn) checkpoint(c *flows.Checkpoint) {
+	c.Bool(&z.started)
+	c.Bool(&z.tcp)
+	c.Bool(&z.flipped)
+	c.Bool(&z.synReset)
+	c.Uint8((*uint8)(&z.orig))
+	c.Uint8((*uint8)(&z.resp))
+	c.Uint64(&z.origBytes)
+	c.Uint64(&z.respBytes)
+	c.Uint64(&z.missed)
+	z.origSeq.checkpoint(c)
+	z.respSeq.checkpoint(c)
+	c.Uint32(&z.origSeen)
+	c.Uint32(&z.respSeen)
+	c.Bytes(&z.history)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// _zeekConn tracks the connection once for _connState, _connHistory, and _missedBytes, and hands the connection to
+// them at the end of the flow
+type _zeekConn struct {
+	flows.BaseFeature
+	conn zeekConn
+}
+
+func (f *_zeekConn) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.conn.reset()
+}
+
+func (f *_zeekConn) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.conn.event(new.(packet.Buffer), context.Forward())
+}
+
+func (f *_zeekConn) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.SetValue(&f.conn, context, f)
+}
+
+func (f *_zeekConn) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.conn.checkpoint(c)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__zeekConn", "returns the connection tracked like the zeek tcp and udp analyzers", ipfix.OctetArrayType, 0, flows.FlowFeature, func() flows.Feature { return &_zeekConn{} }, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _connState struct {
+	flows.BaseFeature
+}
+
+func (f *_connState) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.SetValue(new.(*zeekConn).state(), context, f)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__connState", "returns the connection state of a zeek connection", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &_connState{} }, flows.FlowFeature)
+	flows.RegisterTemporaryCompositeFeature("_connState", "returns the connection state like zeek conn_state (S0, S1, SF, REJ, S2, S3, RSTO, RSTR, RSTOS0, RSTRH, SH, SHR, OTH)", ipfix.StringType, 0, "__connState", "__zeekConn")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _connHistory struct {
+	flows.BaseFeature
+}
+
+func (f *_connHistory) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.SetValue(string(new.(*zeekConn).history), context, f)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__connHistory", "returns the history of a zeek connection", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &_connHistory{} }, flows.FlowFeature)
+	flows.RegisterTemporaryCompositeFeature("_connHistory", "returns the connection history like zeek history (upper case: originator, lower case: responder; s: SYN, h: SYN+ACK, a: pure ACK, d: data, f: FIN, r: RST, q: SYN with FIN or RST, w: zero window, g: gap, t: retransmission, ^: direction flipped); every letter is only recorded once per direction", ipfix.StringType, 0, "__connHistory", "__zeekConn")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _missedBytes struct {
+	flows.BaseFeature
+}
+
+func (f *_missedBytes) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.SetValue(new.(*zeekConn).missed, context, f)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__missedBytes", "returns the missed bytes of a zeek connection", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_missedBytes{} }, flows.FlowFeature)
+	flows.RegisterTemporaryCompositeFeature("_missedBytes", "returns the number of bytes missed in tcp sequence gaps like zeek missed_bytes", ipfix.Unsigned64Type, 0, "__missedBytes", "__zeekConn")
+}
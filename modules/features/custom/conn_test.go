@@ -0,0 +1,120 @@
+package custom_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+)
+
+// the expected values are the conn_state, history, and missed_bytes fields zeek writes to conn.log for these connections
+var zeekConnections = []struct {
+	state, history string
+	missed         uint64
+	segments       []tcpSegment
+}{
+	{"SF", "ShADadFf", 0, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 100, Window: 100}, nil},
+		{1, true, 0, layers.TCP{SYN: true, ACK: true, Seq: 500, Ack: 101, Window: 100}, nil},
+		{2, false, 0, layers.TCP{ACK: true, Seq: 101, Ack: 501, Window: 100}, nil},
+		{3, false, 0, layers.TCP{ACK: true, PSH: true, Seq: 101, Ack: 501, Window: 100}, []byte("GET / HTTP/1.0\r\n\r\n")},
+		{4, true, 0, layers.TCP{ACK: true, Seq: 501, Ack: 119, Window: 100}, nil},
+		{5, true, 0, layers.TCP{ACK: true, PSH: true, Seq: 501, Ack: 119, Window: 100}, []byte("HTTP/1.0 200 OK\r\n\r\n")},
+		{6, false, 0, layers.TCP{FIN: true, ACK: true, Seq: 119, Ack: 520, Window: 100}, nil},
+		{7, true, 0, layers.TCP{FIN: true, ACK: true, Seq: 520, Ack: 120, Window: 100}, nil},
+		{8, false, 0, layers.TCP{ACK: true, Seq: 120, Ack: 521, Window: 100}, nil},
+	}},
+	{"S0", "S", 0, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 1, Window: 100}, nil},
+	}},
+	{"REJ", "Sr", 0, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 1, Window: 100}, nil},
+		{1, true, 0, layers.TCP{RST: true, ACK: true, Ack: 2}, nil},
+	}},
+	{"S1", "ShA", 0, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 1, Window: 100}, nil},
+		{1, true, 0, layers.TCP{SYN: true, ACK: true, Seq: 1, Ack: 2, Window: 100}, nil},
+		{2, false, 0, layers.TCP{ACK: true, Seq: 2, Ack: 2, Window: 100}, nil},
+	}},
+	{"RSTO", "ShAR", 0, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 1, Window: 100}, nil},
+		{1, true, 0, layers.TCP{SYN: true, ACK: true, Seq: 1, Ack: 2, Window: 100}, nil},
+		{2, false, 0, layers.TCP{ACK: true, Seq: 2, Ack: 2, Window: 100}, nil},
+		{3, false, 0, layers.TCP{RST: true, Seq: 2}, nil},
+	}},
+	{"RSTR", "ShADr", 0, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 1, Window: 100}, nil},
+		{1, true, 0, layers.TCP{SYN: true, ACK: true, Seq: 1, Ack: 2, Window: 100}, nil},
+		{2, false, 0, layers.TCP{ACK: true, Seq: 2, Ack: 2, Window: 100}, nil},
+		{3, false, 0, layers.TCP{ACK: true, Seq: 2, Ack: 2, Window: 100}, []byte("data")},
+		{4, true, 0, layers.TCP{RST: true, Seq: 2}, nil},
+	}},
+	{"RSTOS0", "SR", 0, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 1, Window: 100}, nil},
+		{1, false, 0, layers.TCP{RST: true, Seq: 2}, nil},
+	}},
+	{"SH", "SF", 0, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 1, Window: 100}, nil},
+		{1, false, 0, layers.TCP{FIN: true, Seq: 2, Window: 100}, nil},
+	}},
+	{"S2", "ShAF", 0, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 1, Window: 100}, nil},
+		{1, true, 0, layers.TCP{SYN: true, ACK: true, Seq: 1, Ack: 2, Window: 100}, nil},
+		{2, false, 0, layers.TCP{ACK: true, Seq: 2, Ack: 2, Window: 100}, nil},
+		{3, false, 0, layers.TCP{FIN: true, ACK: true, Seq: 2, Ack: 2, Window: 100}, nil},
+	}},
+	{"S3", "ShAf", 0, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 1, Window: 100}, nil},
+		{1, true, 0, layers.TCP{SYN: true, ACK: true, Seq: 1, Ack: 2, Window: 100}, nil},
+		{2, false, 0, layers.TCP{ACK: true, Seq: 2, Ack: 2, Window: 100}, nil},
+		{3, true, 0, layers.TCP{FIN: true, ACK: true, Seq: 2, Ack: 2, Window: 100}, nil},
+	}},
+	// SYN+ACK as first packet -> the sender becomes the responder
+	{"OTH", "^h", 0, []tcpSegment{
+		{0, true, 0, layers.TCP{SYN: true, ACK: true, Seq: 1, Ack: 2, Window: 100}, nil},
+	}},
+	// content gap of 20 bytes, where 10 bytes are retransmitted later
+	{"SF", "ShDGTaFfA", 10, []tcpSegment{
+		{0, false, 0, layers.TCP{SYN: true, Seq: 100, Window: 100}, nil},
+		{1, true, 0, layers.TCP{SYN: true, ACK: true, Seq: 500, Ack: 101, Window: 100}, nil},
+		{2, false, 0, layers.TCP{ACK: true, Seq: 101, Ack: 501, Window: 100}, make([]byte, 10)},
+		{3, false, 0, layers.TCP{ACK: true, Seq: 131, Ack: 501, Window: 100}, make([]byte, 10)},
+		{4, false, 0, layers.TCP{ACK: true, Seq: 111, Ack: 501, Window: 100}, make([]byte, 10)},
+		{5, false, 0, layers.TCP{ACK: true, Seq: 111, Ack: 501, Window: 100}, make([]byte, 10)},
+		{6, true, 0, layers.TCP{ACK: true, Seq: 501, Ack: 141, Window: 100}, nil},
+		{7, false, 0, layers.TCP{FIN: true, ACK: true, Seq: 141, Ack: 501, Window: 100}, nil},
+		{8, true, 0, layers.TCP{FIN: true, ACK: true, Seq: 501, Ack: 142, Window: 100}, nil},
+		{9, false, 0, layers.TCP{ACK: true, Seq: 142, Ack: 502, Window: 100}, nil},
+	}},
+}
+
+func TestZeekConnection(t *testing.T) {
+	var segments []tcpSegment
+	for i, conn := range zeekConnections {
+		for _, segment := range conn.segments {
+			// every connection gets its own port and starts one second after the previous one
+			segment.ms += i * 1000
+			segment.port = layers.TCPPort(1000 + i)
+			segments = append(segments, segment)
+		}
+	}
+	specs := []packet_test.EngineSpec{{
+		Features: []interface{}{"flowStartMilliseconds", "_connState", "_connHistory", "_missedBytes"},
+		Options:  flows.FlowOptions{ActiveTimeout: 1000 * flows.SecondsInNanoseconds, IdleTimeout: 100 * flows.SecondsInNanoseconds},
+	}}
+	lines := packet_test.RunEngine(t, tcpSource(t, segments), specs, packet_test.EngineOptions{Sort: flows.SortTypeStartTime})
+	if len(lines) != len(zeekConnections) {
+		t.Fatalf("expected %d connections, got %d: %q", len(zeekConnections), len(lines), lines)
+	}
+	for i, line := range lines {
+		conn := zeekConnections[i]
+		fields := strings.Split(line, ",")
+		if got, expected := fields[2:], []string{conn.state, conn.history, fmt.Sprint(conn.missed)}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("connection %d: got state, history, missed bytes %q, expected %q", i, got, expected)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package custom
+
+import (
+	"errors"
+
+	"github.com/CN-TU/go-flows/flows"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// The features in this file mimic the host and service based traffic features of the KDD Cup 99 dataset. They need
+// a connection context (_context in the flow specification), which is evaluated at the start or the end of the flow.
+
+type _contextFeature struct {
+	flows.BaseFeature
+	conn  flows.Connection
+	valid bool
+	value func(*flows.ConnectionCounts) interface{}
+}
+
+func (f *_contextFeature) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.valid = false
+}
+
+// CheckSettings reports a missing _context when the flow specification is parsed
+func (f *_contextFeature) CheckSettings(settings map[string]interface{}) error {
+	if _, ok := settings["_context"]; !ok {
+		return errors.New("context features need _context in the flow specification")
+	}
+	return nil
+}
+
+func (f *_contextFeature) query(context *flows.EventContext) {
+	counts := context.Flow().Table().ConnectionCounts(context.When(), f.conn)
+	f.SetValue(f.value(&counts), context, f)
+}
+
+func (f *_contextFeature) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.valid {
+		return
+	}
+	f.conn, f.valid = flows.ConnectionOf(new, context.Forward())
+	if f.valid && !context.Flow().Table().ConnectionContext.AtEnd {
+		f.query(context)
+	}
+}
+
+func (f *_contextFeature) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if f.valid && context.Flow().Table().ConnectionContext.AtEnd {
+		f.query(context)
+	}
+}
+
+func (f *_contextFeature) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.String(&f.conn.Src)
+	c.String(&f.conn.Dst)
+	c.Uint32(&f.conn.Service)
+	c.Bool(&f.valid)
+}
+
+func rate(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+func registerContextCount(name, description string, value func(*flows.ConnectionCounts) uint64) {
+	flows.RegisterTemporaryFeature(name, description, ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature {
+		return &_contextFeature{value: func(c *flows.ConnectionCounts) interface{} { return value(c) }}
+	}, flows.RawPacket)
+}
+
+func registerContextRate(name, description string, value func(*flows.ConnectionCounts) float64) {
+	flows.RegisterTemporaryFeature(name, description, ipfix.Float64Type, 0, flows.FlowFeature, func() flows.Feature {
+		return &_contextFeature{value: func(c *flows.ConnectionCounts) interface{} { return value(c) }}
+	}, flows.RawPacket)
+}
+
+func init() {
+	registerContextCount("_srcHostConnCount", "number of connections from the same source host within the context window", func(c *flows.ConnectionCounts) uint64 { return c.SrcHostConn })
+	registerContextCount("_srcHostDstCount", "number of distinct destination hosts contacted by the same source host within the context window", func(c *flows.ConnectionCounts) uint64 { return c.SrcHostDst })
+	registerContextCount("_dstHostConnCount", "number of connections to the same destination host within the context window", func(c *flows.ConnectionCounts) uint64 { return c.DstHostConn })
+	registerContextCount("_srvConnCount", "number of connections to the same service (protocol and destination port) within the context window", func(c *flows.ConnectionCounts) uint64 { return c.SrvConn })
+	registerContextRate("_sameSrvRate", "fraction of the connections to the same destination host within the context window, which use the same service", func(c *flows.ConnectionCounts) float64 { return rate(c.DstHostSameSrv, c.DstHostConn) })
+	registerContextCount("_dstHostCount", "number of connections to the same destination host among the last context connections", func(c *flows.ConnectionCounts) uint64 { return c.DstHost })
+	registerContextCount("_dstHostSrvCount", "number of connections to the same destination host and service among the last context connections", func(c *flows.ConnectionCounts) uint64 { return c.DstHostSrv })
+	registerContextRate("_dstHostSameSrvRate", "fraction of the connections to the same destination host among the last context connections, which use the same service", func(c *flows.ConnectionCounts) float64 { return rate(c.DstHostSrv, c.DstHost) })
+}
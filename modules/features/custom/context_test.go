@@ -0,0 +1,108 @@
+package custom_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// contextPacket is an udp packet from 10.0.0.src to 10.0.0.dst:port
+type contextPacket struct {
+	ms       int
+	src, dst byte
+	port     layers.UDPPort
+}
+
+var contextFeatures = []interface{}{"sourceIPAddress", "destinationIPAddress", "destinationTransportPort",
+	"_srcHostConnCount", "_srcHostDstCount", "_dstHostConnCount", "_srvConnCount", "_sameSrvRate",
+	"_dstHostCount", "_dstHostSrvCount", "_dstHostSameSrvRate"}
+
+// contextFlows returns the context features of the flows of packets as sorted lines without the export time
+func contextFlows(t *testing.T, context map[string]interface{}, options flows.ContextOptions, tables int, packets []contextPacket) []string {
+	source := &packet_test.MemorySource{}
+	for _, packet := range packets {
+		err := source.AppendLayers(flows.DateTimeNanoseconds(packet.ms)*flows.MillisecondsInNanoseconds+1,
+			&layers.IPv4{Version: 4, TTL: 64, SrcIP: []byte{10, 0, 0, packet.src}, DstIP: []byte{10, 0, 0, packet.dst}, Protocol: layers.IPProtocolUDP},
+			&layers.UDP{SrcPort: 1000, DstPort: packet.port},
+			gopacket.Payload("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := packet_test.EngineSpec{Features: contextFeatures, Options: flows.FlowOptions{
+		Context:        options,
+		CustomSettings: map[string]interface{}{"_context": context},
+	}}
+	lines := packet_test.RunEngine(t, source, []packet_test.EngineSpec{spec}, packet_test.EngineOptions{Tables: tables})
+	for i, line := range lines {
+		lines[i] = line[strings.Index(line, ",")+1:]
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestContext(t *testing.T) {
+	// the flow 10.0.0.1 -> 10.0.0.9:80 lasts until 1500ms; the time window is 2s and the count window 3 connections
+	packets := []contextPacket{
+		{0, 1, 9, 80},
+		{500, 2, 9, 80},
+		{1000, 1, 8, 22},
+		{1500, 1, 9, 80},
+		{2600, 3, 9, 80},
+		{2700, 1, 9, 22},
+	}
+	context := map[string]interface{}{"window": json.Number("2"), "connections": json.Number("3")}
+	options := flows.ContextOptions{Window: 2 * flows.SecondsInNanoseconds, Connections: 3}
+	// at the start of a flow, the windows end with the connection of the flow; the connections from 0 and 500ms
+	// left the time window at 2600ms
+	start := []string{
+		"10.0.0.1,10.0.0.8,22,2,2,1,1,1,1,1,1",
+		"10.0.0.1,10.0.0.9,22,2,2,2,2,0.5,2,1,0.5",
+		"10.0.0.1,10.0.0.9,80,1,1,1,1,1,1,1,1",
+		"10.0.0.2,10.0.0.9,80,1,1,2,2,1,2,2,1",
+		"10.0.0.3,10.0.0.9,80,1,1,1,1,1,2,2,1",
+	}
+	// at the end of the file (2700ms), every flow sees the connections from 1000, 2600, and 2700ms
+	end := []string{
+		"10.0.0.1,10.0.0.8,22,2,2,1,2,1,1,1,1",
+		"10.0.0.1,10.0.0.9,22,2,2,2,2,0.5,2,1,0.5",
+		"10.0.0.1,10.0.0.9,80,2,2,2,1,0.5,2,1,0.5",
+		"10.0.0.2,10.0.0.9,80,0,0,2,1,0.5,2,1,0.5",
+		"10.0.0.3,10.0.0.9,80,1,1,2,1,0.5,2,1,0.5",
+	}
+	atEnd := options
+	atEnd.AtEnd = true
+	endContext := map[string]interface{}{"window": json.Number("2"), "connections": json.Number("3"), "evaluate": "end"}
+	// parallel tables share the context and must yield the same counts
+	for _, tables := range []int{1, 2, 3} {
+		if lines := contextFlows(t, context, options, tables, packets); !reflect.DeepEqual(lines, start) {
+			t.Errorf("%d tables, evaluated at start: got records\n%s\nexpected\n%s", tables, strings.Join(lines, "\n"), strings.Join(start, "\n"))
+		}
+		if lines := contextFlows(t, endContext, atEnd, tables, packets); !reflect.DeepEqual(lines, end) {
+			t.Errorf("%d tables, evaluated at end: got records\n%s\nexpected\n%s", tables, strings.Join(lines, "\n"), strings.Join(end, "\n"))
+		}
+	}
+}
+
+func TestContextSetting(t *testing.T) {
+	for _, name := range contextFeatures[3:] {
+		var records flows.RecordListMaker
+		pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1, 1, false)
+		if err := records.AppendRecord([]interface{}{name}, nil, nil, pipe, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := records.CheckSettings(map[string]interface{}{"features": []interface{}{name}}).(flows.FeatureError); !ok {
+			t.Errorf("%s: missing _context not reported", name)
+		}
+		if err := records.CheckSettings(map[string]interface{}{"_context": map[string]interface{}{}}); err != nil {
+			t.Errorf("%s: _context rejected: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package custom
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/modules/features"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// DNP3 parser working on the reassembled TCP streams (see __tcpStream). Link layer frames are found by their start
+// bytes; the CRCs are not checked. The application layer is only parsed from the first transport segment of a
+// fragment.
+
+const (
+	dnp3LinkHeader = 10
+	dnp3Block      = 16
+	dnp3CRC        = 2
+	dnp3FIR        = 0x40
+)
+
+var dnp3Start = []byte{0x05, 0x64}
+
+// dnp3Frame is emitted for every link layer frame. application is only set for the first segment of a fragment.
+type dnp3Frame struct {
+	control             uint8
+	destination         uint16
+	source              uint16
+	application         bool
+	function            uint8
+	response            bool
+	internalIndications uint16
+	groups              []uint8
+}
+
+// dnp3Range returns the size of the range field and the number of objects for a qualifier
+func dnp3Range(qualifier uint8, data []byte) (int, int, bool) {
+	var size int
+	switch qualifier & 0x0f {
+	case 0, 3:
+		size = 1
+	case 1, 4:
+		size = 2
+	case 2, 5:
+		size = 4
+	case 6:
+		return 0, 0, true
+	case 7:
+		if len(data) < 1 {
+			return 0, 0, false
+		}
+		return 1, int(data[0]), true
+	case 8:
+		if len(data) < 2 {
+			return 0, 0, false
+		}
+		return 2, int(binary.LittleEndian.Uint16(data)), true
+	default:
+		return 0, 0, false
+	}
+	// start and stop index
+	if len(data) < 2*size {
+		return 0, 0, false
+	}
+	var start, stop uint32
+	for i := size - 1; i >= 0; i-- {
+		start = start<<8 | uint32(data[i])
+		stop = stop<<8 | uint32(data[size+i])
+	}
+	if stop < start {
+		return 0, 0, false
+	}
+	return 2 * size, int(stop-start) + 1, true
+}
+
+// parseObjects collects the object groups of the object headers. Since object sizes are not known, only requests
+// without object data (read, enable or disable unsolicited, assign class) are parsed beyond the first header.
+func (f *dnp3Frame) parseObjects(data []byte) {
+	walk := !f.response && (f.function == 1 || f.function == 20 || f.function == 21 || f.function == 22)
+	for len(data) >= 3 {
+		f.groups = append(f.groups, data[0])
+		if !walk {
+			return
+		}
+		qualifier := data[2]
+		data = data[3:]
+		size, count, ok := dnp3Range(qualifier, data)
+		if !ok {
+			return
+		}
+		data = data[size:]
+		// objects can have an index prefix
+		prefix := [8]int{0, 1, 2, 4}[qualifier>>4&7]
+		if count*prefix > len(data) {
+			return
+		}
+		data = data[count*prefix:]
+	}
+}
+
+// parseDNP3Frame parses a frame with CRCs
+func parseDNP3Frame(frame []byte) *dnp3Frame {
+	ret := &dnp3Frame{
+		control:     frame[3],
+		destination: binary.LittleEndian.Uint16(frame[4:]),
+		source:      binary.LittleEndian.Uint16(frame[6:]),
+	}
+	var user []byte
+	for data := frame[dnp3LinkHeader:]; len(data) > dnp3CRC; {
+		n := dnp3Block
+		if n > len(data)-dnp3CRC {
+			n = len(data) - dnp3CRC
+		}
+		user = append(user, data[:n]...)
+		data = data[n+dnp3CRC:]
+	}
+	// transport header, application control, function code
+	if len(user) < 3 || user[0]&dnp3FIR == 0 {
+		return ret
+	}
+	ret.application = true
+	ret.function = user[2]
+	objects := user[3:]
+	if ret.function >= 129 && ret.function <= 131 {
+		// response, unsolicited response, authentication response
+		ret.response = true
+		if len(objects) < 2 {
+			return ret
+		}
+		ret.internalIndications = binary.BigEndian.Uint16(objects)
+		objects = objects[2:]
+	}
+	ret.parseObjects(objects)
+	return ret
+}
+
+// dnp3FrameLength returns the length of a frame with CRCs from the length field
+func dnp3FrameLength(length uint8) int {
+	user := int(length) - 5
+	return dnp3LinkHeader + user + dnp3CRC*((user+dnp3Block-1)/dnp3Block)
+}
+
+// __dnp3 emits the DNP3 frames of the connection
+type _dnp3 struct {
+	flows.BaseFeature
+	buffers [2][]byte
+}
+
+func (f *_dnp3) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	for i := range f.buffers {
+		f.buffers[i] = f.buffers[i][:0]
+	}
+}
+
+func (f *_dnp3) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	chunk := new.(*features.StreamChunk)
+	i := 0
+	if !chunk.Forward {
+		i = 1
+	}
+	if chunk.Gap != 0 {
+		// frames are found again by their start bytes
+		f.buffers[i] = f.buffers[i][:0]
+	}
+	f.buffers[i] = append(f.buffers[i], chunk.Data...)
+	data := f.buffers[i]
+	for len(data) >= dnp3LinkHeader {
+		if !bytes.HasPrefix(data, dnp3Start) || data[2] < 5 {
+			next := bytes.Index(data[1:], dnp3Start)
+			if next < 0 {
+				data = data[len(data)-1:]
+				break
+			}
+			data = data[1+next:]
+			continue
+		}
+		length := dnp3FrameLength(data[2])
+		if len(data) < length {
+			break
+		}
+		frame := parseDNP3Frame(data[:length])
+		data = data[length:]
+		f.Emit(frame, context, f)
+	}
+	f.buffers[i] = f.buffers[i][:copy(f.buffers[i], data)]
+}
+
+func (f *_dnp3) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	for i := range f.buffers {
+		c.Bytes(&f.buffers[i])
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// dnp3Field returns a field of a frame and if the field is present
+type dnp3Field func(*dnp3Frame) (interface{}, bool)
+
+type _dnp3FieldPacket struct {
+	flows.BaseFeature
+	field dnp3Field
+}
+
+func (f *_dnp3FieldPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if value, ok := f.field(new.(*dnp3Frame)); ok {
+		f.SetValue(value, context, f)
+	}
+}
+
+// Checkpoint saves or restores the value; field is set when the feature is made
+func (f *_dnp3FieldPacket) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+}
+
+type _dnp3FieldFlow struct {
+	_dnp3FieldPacket
+}
+
+func (f *_dnp3FieldFlow) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f._dnp3FieldPacket.Event(new, context, src)
+	}
+}
+
+// registerDNP3Field registers name as composite feature of the field from the first frame (FlowFeature) or every
+// frame (PacketFeature) having this field
+func registerDNP3Field(name, description string, t ipfix.Type, field dnp3Field) {
+	flows.RegisterTemporaryFeature("_"+name, description, t, 0, flows.FlowFeature, func() flows.Feature { return &_dnp3FieldFlow{_dnp3FieldPacket{field: field}} }, flows.PacketFeature)
+	flows.RegisterTemporaryFeature("_"+name, description, t, 0, flows.PacketFeature, func() flows.Feature { return &_dnp3FieldPacket{field: field} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature(name, description, t, 0, "_"+name, []interface{}{"__dnp3", "__tcpStream"})
+}
+
+type _dnp3ObjectGroup struct {
+	flows.BaseFeature
+}
+
+func (f *_dnp3ObjectGroup) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	for _, group := range new.(*dnp3Frame).groups {
+		f.SetValue(group, context, f)
+	}
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__dnp3", "returns the DNP3 frames of a TCP stream", ipfix.OctetArrayType, 0, flows.PacketFeature, func() flows.Feature { return &_dnp3{} }, flows.PacketFeature)
+
+	registerDNP3Field("_dnp3Source", "source address of DNP3 frames", ipfix.Unsigned16Type, func(f *dnp3Frame) (interface{}, bool) { return f.source, true })
+	registerDNP3Field("_dnp3Destination", "destination address of DNP3 frames", ipfix.Unsigned16Type, func(f *dnp3Frame) (interface{}, bool) { return f.destination, true })
+	registerDNP3Field("_dnp3LinkFunctionCode", "link layer function code of DNP3 frames", ipfix.Unsigned8Type, func(f *dnp3Frame) (interface{}, bool) { return f.control & 0x0f, true })
+	registerDNP3Field("_dnp3FunctionCode", "application layer function code of DNP3 fragments", ipfix.Unsigned8Type, func(f *dnp3Frame) (interface{}, bool) { return f.function, f.application })
+	registerDNP3Field("_dnp3InternalIndications", "internal indications of DNP3 responses", ipfix.Unsigned16Type, func(f *dnp3Frame) (interface{}, bool) { return f.internalIndications, f.response })
+
+	flows.RegisterTemporaryFeature("__dnp3ObjectGroup", "object groups of DNP3 fragments", ipfix.Unsigned8Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnp3ObjectGroup{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnp3ObjectGroup", "object groups of DNP3 fragments", ipfix.Unsigned8Type, 0, "__dnp3ObjectGroup", []interface{}{"__dnp3", "__tcpStream"})
+}
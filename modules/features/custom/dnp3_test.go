@@ -0,0 +1,120 @@
+package custom_test
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+// dnp3CRC returns the CRC of the DNP3 link layer (CRC-16/DNP) in the byte order of the frame
+func dnp3CRC(data []byte) []byte {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa6bc
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	ret := make([]byte, 2)
+	binary.LittleEndian.PutUint16(ret, ^crc)
+	return ret
+}
+
+// dnp3Frame returns a link layer frame with CRCs and the given user data
+func dnp3Frame(control byte, destination, source uint16, user ...byte) []byte {
+	header := []byte{0x05, 0x64, byte(5 + len(user)), control, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(header[4:], destination)
+	binary.LittleEndian.PutUint16(header[6:], source)
+	ret := append(header, dnp3CRC(header)...)
+	for len(user) > 0 {
+		n := 16
+		if n > len(user) {
+			n = len(user)
+		}
+		ret = append(ret, user[:n]...)
+		ret = append(ret, dnp3CRC(user[:n])...)
+		user = user[n:]
+	}
+	return ret
+}
+
+var dnp3Features = []interface{}{"_dnp3Source", "_dnp3Destination", []interface{}{"accumulate", "_dnp3LinkFunctionCode"},
+	[]interface{}{"accumulate", "_dnp3FunctionCode"}, "_dnp3InternalIndications", []interface{}{"accumulate", "_dnp3ObjectGroup"},
+	[]interface{}{"mode", "_dnp3ObjectGroup"}}
+
+var (
+	// class 0, 1, 2, 3 read and a read of binary inputs 0-5 from the master 1 to the outstation 10
+	dnp3Read = dnp3Frame(0xc4, 10, 1, 0xc0, 0xc1, 1, 60, 2, 6, 60, 3, 6, 60, 4, 6, 60, 1, 6, 30, 1, 0, 0, 5)
+	// response with device restart in the internal indications and two analog inputs
+	dnp3Response = dnp3Frame(0x44, 1, 10, 0xc0, 0xc1, 129, 0x80, 0, 30, 1, 0, 0, 1, 1, 0, 0, 0, 2, 0, 0, 0)
+	// second transport segment of a fragment
+	dnp3Segment = dnp3Frame(0x44, 1, 10, 0x01, 0xaa, 0xbb)
+	// link status request without user data
+	dnp3LinkStatus = dnp3Frame(0xc9, 10, 1)
+)
+
+func TestDNP3CRC(t *testing.T) {
+	// check value of CRC-16/DNP
+	if crc := binary.LittleEndian.Uint16(dnp3CRC([]byte("123456789"))); crc != 0xea82 {
+		t.Errorf("got CRC %#04x, expected 0xea82", crc)
+	}
+}
+
+func TestDNP3(t *testing.T) {
+	session := tcpSession(1000, concat(dnp3LinkStatus, dnp3Read), concat(dnp3Response, dnp3Segment))
+	// garbage before frames (also partial start bytes), and every byte in its own segment
+	var chunks []streamChunk
+	for _, b := range concat([]byte{0x05, 0x05, 0x00, 0x64, 0x05, 0x64, 0x04, 0x05}, dnp3Read) {
+		chunks = append(chunks, streamChunk{false, []byte{b}})
+	}
+	bytewise := streamSession(1001, chunks...)
+	// frames are found again after a gap
+	gap := streamSession(1002, streamChunk{false, dnp3Read}, streamChunk{true, dnp3Segment[:12]}, streamChunk{true, dnp3Response})
+	for i := range gap {
+		if gap[i].reverse && gap[i].ms >= 30 {
+			gap[i].tcp.Seq += 10
+		}
+	}
+	lines := sessionFlows(t, dnp3Features, session, bytewise, gap)
+	expected := []string{
+		"1000,1,10,[9 4 4 4],[1 129],32768,[60 60 60 60 30 30],60",
+		"1001,1,10,[4],[1],<nil>,[60 60 60 60 30],60",
+		"1002,1,10,[4 4],[1 129],32768,[60 60 60 60 30 30],60",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestDNP3Malformed(t *testing.T) {
+	// every truncation and every corrupted byte of requests and responses
+	var sessions [][]tcpSegment
+	port := layers.TCPPort(1000)
+	for _, frame := range [][]byte{dnp3Read, dnp3Response, dnp3LinkStatus} {
+		for n := 0; n < len(frame); n++ {
+			corrupt := append([]byte(nil), frame...)
+			corrupt[n] ^= 0xff
+			sessions = append(sessions,
+				tcpSession(port, frame[:n], frame[:n]),
+				tcpSession(port+1, corrupt, corrupt),
+			)
+			port += 2
+		}
+	}
+	// object headers with every qualifier and ranges, which are invalid or larger than the fragment
+	for qualifier := 0; qualifier < 256; qualifier++ {
+		sessions = append(sessions, tcpSession(port, dnp3Frame(0xc4, 10, 1, 0xc0, 0xc1, 1, 1, 2, byte(qualifier), 0xff, 0, 0x10, 0)))
+		port++
+	}
+	lines := sessionFlows(t, dnp3Features, sessions...)
+	if len(lines) != len(sessions) {
+		t.Errorf("got %d records, expected %d", len(lines), len(sessions))
+	}
+}
@@ -0,0 +1,291 @@
+package custom
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/CN-TU/go-flows/flows"
+	dnsdissector "github.com/CN-TU/go-flows/modules/dissectors/dns"
+	"github.com/CN-TU/go-flows/modules/features"
+	"github.com/CN-TU/go-flows/packet"
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/google/gopacket/layers"
+)
+
+// dnsMaxPending limits the number of unanswered queries, which are kept for matching responses
+const dnsMaxPending = 1024
+
+// dnsMessage is emitted by __dnsMessages for every DNS message. dns is only valid during the event.
+type dnsMessage struct {
+	dns    *layers.DNS
+	length int
+	// matched is true for responses to a previous query with the same ID; responseTime is the time since this query
+	matched      bool
+	responseTime int64
+}
+
+// __dnsMessages emits the DNS messages of UDP packets (see GetDNS) and of the reassembled TCP streams, where every
+// message has a two byte length prefix. A direction of a TCP stream is not parsed anymore after a gap or a message,
+// which is not DNS. Responses are matched to the first unanswered query with the same ID.
+type _dnsMessages struct {
+	flows.BaseFeature
+	stream  *features.TCPStream
+	buffers [2][]byte
+	lost    [2]bool
+	queries map[uint16]flows.DateTimeNanoseconds
+	context *flows.EventContext
+	emit    func(*features.StreamChunk)
+}
+
+func newDNSMessages() flows.Feature {
+	f := &_dnsMessages{stream: features.NewTCPStream(features.DefaultStreamOptions)}
+	f.emit = f.chunk
+	return f
+}
+
+func (f *_dnsMessages) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.stream.Reset()
+	for i := range f.buffers {
+		f.buffers[i] = f.buffers[i][:0]
+		f.lost[i] = false
+	}
+	f.queries = make(map[uint16]flows.DateTimeNanoseconds)
+}
+
+func (f *_dnsMessages) message(dns *layers.DNS, length int, context *flows.EventContext) {
+	message := &dnsMessage{dns: dns, length: length}
+	if dns.QR {
+		if when, ok := f.queries[dns.ID]; ok {
+			message.matched = true
+			message.responseTime = int64(context.When()) - int64(when)
+			delete(f.queries, dns.ID)
+		}
+	} else if _, ok := f.queries[dns.ID]; !ok && len(f.queries) < dnsMaxPending {
+		f.queries[dns.ID] = context.When()
+	}
+	f.Emit(message, context, f)
+}
+
+func (f *_dnsMessages) chunk(chunk *features.StreamChunk) {
+	i := 0
+	if !chunk.Forward {
+		i = 1
+	}
+	if chunk.Gap != 0 {
+		// the message boundaries are lost
+		f.lost[i] = true
+		f.buffers[i] = f.buffers[i][:0]
+	}
+	if f.lost[i] {
+		return
+	}
+	f.buffers[i] = append(f.buffers[i], chunk.Data...)
+	buffer := f.buffers[i]
+	for len(buffer) >= 2 {
+		length := int(binary.BigEndian.Uint16(buffer))
+		if len(buffer) < 2+length {
+			break
+		}
+		dns := dnsdissector.Decode(buffer[2 : 2+length])
+		if dns == nil {
+			// not DNS or out of sync
+			f.lost[i] = true
+			f.buffers[i] = f.buffers[i][:0]
+			return
+		}
+		f.message(dns, length, f.context)
+		buffer = buffer[2+length:]
+	}
+	f.buffers[i] = f.buffers[i][:copy(f.buffers[i], buffer)]
+}
+
+func (f *_dnsMessages) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if tcp := features.GetTCP(new); tcp != nil {
+		f.context = context
+		f.stream.Packet(tcp, context.Forward(), f.emit)
+		return
+	}
+	if dns := GetDNS(new); dns != nil {
+		f.message(dns, len(new.(packet.Buffer).TransportLayer().LayerPayload()), context)
+	}
+}
+
+func (f *_dnsMessages) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.stream.Checkpoint(c)
+	for i := range f.buffers {
+		c.Bytes(&f.buffers[i])
+		c.Bool(&f.lost[i])
+	}
+	n := c.Len(len(f.queries))
+	if c.Loading() {
+		f.queries = make(map[uint16]flows.DateTimeNanoseconds, n)
+		for i := 0; i < n; i++ {
+			var id uint16
+			var when flows.DateTimeNanoseconds
+			c.Uint16(&id)
+			c.Time(&when)
+			f.queries[id] = when
+		}
+		return
+	}
+	ids := make([]uint16, 0, n)
+	for id := range f.queries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		when := f.queries[id]
+		c.Uint16(&id)
+		c.Time(&when)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _dnsCount struct {
+	flows.BaseFeature
+	count    uint64
+	response bool
+	octets   bool
+}
+
+func (f *_dnsCount) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.count = 0
+}
+
+func (f *_dnsCount) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	message := new.(*dnsMessage)
+	if message.dns.QR != f.response {
+		return
+	}
+	if f.octets {
+		f.count += uint64(message.length)
+	} else {
+		f.count++
+	}
+}
+
+func (f *_dnsCount) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.SetValue(f.count, context, f)
+}
+
+func (f *_dnsCount) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _dnsUnansweredQueryCount struct {
+	flows.BaseFeature
+	queries  uint64
+	answered uint64
+}
+
+func (f *_dnsUnansweredQueryCount) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.queries = 0
+	f.answered = 0
+}
+
+func (f *_dnsUnansweredQueryCount) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	message := new.(*dnsMessage)
+	if !message.dns.QR {
+		f.queries++
+	} else if message.matched {
+		f.answered++
+	}
+}
+
+func (f *_dnsUnansweredQueryCount) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.SetValue(f.queries-f.answered, context, f)
+}
+
+func (f *_dnsUnansweredQueryCount) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.queries)
+	c.Uint64(&f.answered)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _dnsNXDomainRatio struct {
+	flows.BaseFeature
+	responses uint64
+	nxdomain  uint64
+}
+
+func (f *_dnsNXDomainRatio) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.responses = 0
+	f.nxdomain = 0
+}
+
+func (f *_dnsNXDomainRatio) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	dns := new.(*dnsMessage).dns
+	if !dns.QR {
+		return
+	}
+	f.responses++
+	if dns.ResponseCode == layers.DNSResponseCodeNXDomain {
+		f.nxdomain++
+	}
+}
+
+func (f *_dnsNXDomainRatio) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if f.responses != 0 {
+		f.SetValue(float64(f.nxdomain)/float64(f.responses), context, f)
+	}
+}
+
+func (f *_dnsNXDomainRatio) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.responses)
+	c.Uint64(&f.nxdomain)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _dnsResponseTimeNanoseconds struct {
+	flows.BaseFeature
+}
+
+func (f *_dnsResponseTimeNanoseconds) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if message := new.(*dnsMessage); message.matched {
+		f.SetValue(message.responseTime, context, f)
+	}
+}
+
+type _dnsResponseLength struct {
+	flows.BaseFeature
+}
+
+func (f *_dnsResponseLength) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if message := new.(*dnsMessage); message.dns.QR {
+		f.SetValue(uint64(message.length), context, f)
+	}
+}
+
+func registerDNSFlowFeature(name, description string, t ipfix.Type, make flows.MakeFeature) {
+	flows.RegisterTemporaryFeature("_"+name, description, t, 0, flows.FlowFeature, make, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature(name, description, t, 0, "_"+name, "__dnsMessages")
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__dnsMessages", "returns the DNS messages of UDP packets and TCP streams", ipfix.OctetArrayType, 0, flows.PacketFeature, newDNSMessages, flows.RawPacket)
+
+	registerDNSFlowFeature("_dnsQueryCount", "number of DNS queries", ipfix.Unsigned64Type, func() flows.Feature { return &_dnsCount{} })
+	registerDNSFlowFeature("_dnsResponseCount", "number of DNS responses", ipfix.Unsigned64Type, func() flows.Feature { return &_dnsCount{response: true} })
+	registerDNSFlowFeature("_dnsResponseOctetCount", "sum of the lengths of the DNS responses", ipfix.Unsigned64Type, func() flows.Feature { return &_dnsCount{response: true, octets: true} })
+	registerDNSFlowFeature("_dnsUnansweredQueryCount", "number of DNS queries without a response with the same ID", ipfix.Unsigned64Type, func() flows.Feature { return &_dnsUnansweredQueryCount{} })
+	registerDNSFlowFeature("_dnsNXDomainRatio", "ratio of DNS responses with response code NXDomain", ipfix.Float64Type, func() flows.Feature { return &_dnsNXDomainRatio{} })
+
+	flows.RegisterTemporaryFeature("__dnsResponseTimeNanoseconds", "time between a DNS query and its response", ipfix.Signed64Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsResponseTimeNanoseconds{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsResponseTimeNanoseconds", "time between a DNS query and its response", ipfix.Signed64Type, 0, "__dnsResponseTimeNanoseconds", "__dnsMessages")
+	flows.RegisterTemporaryCompositeFeature("_dnsResponseTimeMilliseconds", "time between a DNS query and its response", ipfix.Signed64Type, 0, "divide", "_dnsResponseTimeNanoseconds", 1000000)
+	flows.RegisterTemporaryFeature("__dnsResponseLength", "length of a DNS response", ipfix.Unsigned64Type, 0, flows.PacketFeature, func() flows.Feature { return &_dnsResponseLength{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_dnsResponseLength", "length of a DNS response", ipfix.Unsigned64Type, 0, "__dnsResponseLength", "__dnsMessages")
+}
@@ -0,0 +1,100 @@
+package custom_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// dnsMessage returns a DNS query (no answers) or response with the given question
+func dnsMessage(t *testing.T, id uint16, name string, response bool, code layers.DNSResponseCode, answers ...net.IP) []byte {
+	dns := &layers.DNS{ID: id, QR: response, RD: true, RA: response, ResponseCode: code,
+		Questions: []layers.DNSQuestion{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN}}}
+	for _, answer := range answers {
+		dns.Answers = append(dns.Answers, layers.DNSResourceRecord{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: answer})
+	}
+	buffer := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buffer, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// dnsTCP returns the messages with the length prefix of DNS over TCP
+func dnsTCP(messages ...[]byte) []byte {
+	var ret []byte
+	for _, message := range messages {
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(message)))
+		ret = append(ret, length...)
+		ret = append(ret, message...)
+	}
+	return ret
+}
+
+var dnsFeatures = []interface{}{"_dnsQueryCount", "_dnsResponseCount", "_dnsResponseOctetCount", "_dnsUnansweredQueryCount",
+	"_dnsNXDomainRatio", []interface{}{"accumulate", "_dnsResponseTimeMilliseconds"}, []interface{}{"accumulate", "_dnsDomain"}}
+
+func TestDNSOverTCP(t *testing.T) {
+	q1 := dnsMessage(t, 1, "example.com", false, layers.DNSResponseCodeNoErr)
+	q2 := dnsMessage(t, 2, "nonexistent.example", false, layers.DNSResponseCodeNoErr)
+	q3 := dnsMessage(t, 3, "unanswered.example", false, layers.DNSResponseCodeNoErr)
+	r1 := dnsMessage(t, 1, "example.com", true, layers.DNSResponseCodeNoErr, net.IP{10, 0, 0, 3})
+	r2 := dnsMessage(t, 2, "nonexistent.example", true, layers.DNSResponseCodeNXDomain)
+	client, server := dnsTCP(q1, q2, q3), dnsTCP(r1, r2)
+	// messages and length prefixes split across segments, several messages per segment
+	split := streamSession(1000,
+		streamChunk{false, client[:len(q1)+3]},
+		streamChunk{false, client[len(q1)+3 : len(q1)+10]},
+		streamChunk{false, client[len(q1)+10:]},
+		streamChunk{true, server[:7]},
+		streamChunk{true, server[7:]},
+	)
+	// every message in its own segment
+	whole := streamSession(1001, streamChunk{false, dnsTCP(q1)}, streamChunk{true, dnsTCP(r1)})
+	// a direction is not parsed anymore after a message, which is not DNS, or a gap
+	garbage := streamSession(1002, streamChunk{false, append([]byte{0, 4, 1, 2, 3, 4}, dnsTCP(q1)...)}, streamChunk{true, dnsTCP(r1)})
+	gap := streamSession(1003, streamChunk{false, dnsTCP(q1)}, streamChunk{true, dnsTCP(r1)})
+	for i := range gap {
+		if gap[i].reverse && len(gap[i].payload) > 0 {
+			gap[i].tcp.Seq += 10
+		}
+	}
+	lines := sessionFlows(t, dnsFeatures, split, whole, garbage, gap)
+	expected := []string{
+		fmt.Sprintf("1000,3,2,%d,1,0.5,[40 20],[example.com nonexistent.example unanswered.example example.com nonexistent.example]", len(r1)+len(r2)),
+		fmt.Sprintf("1001,1,1,%d,0,0,[10],[example.com example.com]", len(r1)),
+		fmt.Sprintf("1002,0,1,%d,0,0,<nil>,[example.com]", len(r1)),
+		"1003,1,0,0,1,<nil>,<nil>,[example.com]",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestDNSMalformed(t *testing.T) {
+	// every truncation and every corrupted byte of a response with a matching length prefix, and truncated streams
+	r1 := dnsMessage(t, 1, "example.com", true, layers.DNSResponseCodeNoErr, net.IP{10, 0, 0, 3})
+	var sessions [][]tcpSegment
+	port := layers.TCPPort(1000)
+	for n := 0; n < len(r1); n++ {
+		corrupt := append([]byte(nil), r1...)
+		corrupt[n] ^= 0xff
+		sessions = append(sessions,
+			streamSession(port, streamChunk{true, dnsTCP(r1[:n])}),
+			streamSession(port+1, streamChunk{true, dnsTCP(corrupt)}),
+			streamSession(port+2, streamChunk{true, dnsTCP(r1)[:n]}),
+		)
+		port += 3
+	}
+	lines := sessionFlows(t, dnsFeatures, sessions...)
+	if len(lines) != len(sessions) {
+		t.Errorf("got %d records, expected %d", len(lines), len(sessions))
+	}
+}
@@ -0,0 +1,663 @@
+package custom
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/modules/features"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// HTTP/1.0 and HTTP/1.1 parser working on the reassembled TCP streams (see __tcpStream). Both directions are parsed
+// independently; whether a direction contains requests or responses is decided by the start line. Bodies are
+// delimited by Transfer-Encoding: chunked, Content-Length, or the end of the stream (responses only). Pipelined
+// requests are matched with the responses for the cases, where the request decides if a response has a body
+// (HEAD, CONNECT).
+
+const (
+	// httpMaxLine is the maximum length of a start, header, or chunk line
+	httpMaxLine = 16 * 1024
+	// httpMaxPending is the maximum number of requests waiting for a response
+	httpMaxPending = 64
+)
+
+const (
+	httpStateStart uint8 = iota
+	httpStateHeaders
+	httpStateBody
+	httpStateChunkSize
+	httpStateChunkData
+	httpStateChunkEnd
+	httpStateTrailer
+	httpStateBodyClose
+	httpStateLost
+	httpStateTunnel
+)
+
+// httpMessage is a parsed HTTP request or response
+type httpMessage struct {
+	request     bool
+	line        string
+	method      string
+	target      string
+	version     string
+	status      uint16
+	reason      string
+	headers     []string
+	host        string
+	userAgent   string
+	contentType string
+	bodyLength  uint64
+	complete    bool
+
+	contentLength int64
+	chunked       bool
+}
+
+func (m *httpMessage) header(line string) {
+	if (line[0] == ' ' || line[0] == '\t') && len(m.headers) > 0 {
+		// obsolete line folding
+		m.headers[len(m.headers)-1] += " " + strings.TrimSpace(line)
+		return
+	}
+	m.headers = append(m.headers, line)
+	kv := strings.SplitN(line, ":", 2)
+	if len(kv) != 2 {
+		return
+	}
+	value := strings.TrimSpace(kv[1])
+	switch strings.ToLower(strings.TrimSpace(kv[0])) {
+	case "host":
+		m.host = value
+	case "user-agent":
+		m.userAgent = value
+	case "content-type":
+		m.contentType = value
+	case "content-length":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+			m.contentLength = n
+		}
+	case "transfer-encoding":
+		encodings := strings.Split(value, ",")
+		m.chunked = strings.EqualFold(strings.TrimSpace(encodings[len(encodings)-1]), "chunked")
+	}
+}
+
+func (m *httpMessage) checkpoint(c *flows.Checkpoint) {
+	c.Bool(&m.request)
+	c.String(&m.line)
+	c.String(&m.method)
+	c.String(&m.target)
+	c.String(&m.version)
+	c.Uint16(&m.status)
+	c.String(&m.reason)
+	n := c.Len(len(m.headers))
+	if c.Loading() {
+		m.headers = make([]string, n)
+	}
+	for i := range m.headers {
+		c.String(&m.headers[i])
+	}
+	c.String(&m.host)
+	c.String(&m.userAgent)
+	c.String(&m.contentType)
+	c.Uint64(&m.bodyLength)
+	c.Bool(&m.complete)
+	c.Int64(&m.contentLength)
+	c.Bool(&m.chunked)
+}
+
+var httpMethods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "CONNECT", "TRACE", "PATCH"}
+
+// httpStartLine returns true if data looks like the start of a request or response
+func httpStartLine(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("HTTP/1.")) {
+		return true
+	}
+	for _, method := range httpMethods {
+		if len(data) > len(method) && data[len(method)] == ' ' && string(data[:len(method)]) == method {
+			return true
+		}
+	}
+	return false
+}
+
+func httpToken(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("()<>@,;:\\\"/[]?={}", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// httpHalf parses one direction of a connection
+type httpHalf struct {
+	state     uint8
+	line      []byte
+	message   *httpMessage
+	remaining uint64
+}
+
+// httpParser parses both directions of a connection and calls emit for every message
+type httpParser struct {
+	halfs   [2]httpHalf
+	pending []string // methods of the requests without response
+	emit    func(*httpMessage)
+}
+
+func (p *httpParser) reset() {
+	p.halfs = [2]httpHalf{}
+	p.pending = p.pending[:0]
+}
+
+func (p *httpParser) half(forward bool) *httpHalf {
+	if forward {
+		return &p.halfs[0]
+	}
+	return &p.halfs[1]
+}
+
+func (p *httpParser) chunk(chunk *features.StreamChunk) {
+	h := p.half(chunk.Forward)
+	if chunk.Gap != 0 {
+		p.gap(h, uint64(chunk.Gap))
+	}
+	if chunk.End {
+		p.end(h)
+		return
+	}
+	data := chunk.Data
+	if h.state == httpStateLost && httpStartLine(data) {
+		// chunks usually start at segment boundaries, where new messages are likely
+		h.state = httpStateStart
+	}
+	for len(data) > 0 {
+		switch h.state {
+		case httpStateBody, httpStateChunkData:
+			n := uint64(len(data))
+			if n > h.remaining {
+				n = h.remaining
+			}
+			h.message.bodyLength += n
+			h.remaining -= n
+			data = data[n:]
+			if h.remaining == 0 {
+				if h.state == httpStateBody {
+					p.finish(h)
+				} else {
+					h.state = httpStateChunkEnd
+				}
+			}
+		case httpStateBodyClose:
+			h.message.bodyLength += uint64(len(data))
+			data = nil
+		case httpStateLost, httpStateTunnel:
+			data = nil
+		default:
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				h.line = append(h.line, data...)
+				if len(h.line) > httpMaxLine {
+					p.lost(h)
+				}
+				return
+			}
+			line := data[:i]
+			if len(h.line) > 0 {
+				h.line = append(h.line, line...)
+				line = h.line
+			}
+			data = data[i+1:]
+			if len(line) > httpMaxLine {
+				p.lost(h)
+				continue
+			}
+			line = bytes.TrimSuffix(line, []byte{'\r'})
+			p.handleLine(h, string(line))
+			h.line = h.line[:0]
+		}
+	}
+}
+
+func (p *httpParser) handleLine(h *httpHalf, line string) {
+	switch h.state {
+	case httpStateStart:
+		if line == "" {
+			// robustness: ignore empty lines before a message
+			return
+		}
+		h.message = p.startLine(line)
+		if h.message == nil {
+			p.lost(h)
+			return
+		}
+		h.state = httpStateHeaders
+	case httpStateHeaders:
+		if line == "" {
+			p.body(h)
+			return
+		}
+		h.message.header(line)
+	case httpStateChunkSize:
+		size := line
+		if i := strings.IndexByte(size, ';'); i >= 0 {
+			size = size[:i]
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(size), 16, 63)
+		if err != nil {
+			p.lost(h)
+			return
+		}
+		if n == 0 {
+			h.state = httpStateTrailer
+			return
+		}
+		h.remaining = n
+		h.state = httpStateChunkData
+	case httpStateChunkEnd:
+		if line != "" {
+			p.lost(h)
+			return
+		}
+		h.state = httpStateChunkSize
+	case httpStateTrailer:
+		if line == "" {
+			p.finish(h)
+		}
+	}
+}
+
+func (p *httpParser) startLine(line string) *httpMessage {
+	if strings.HasPrefix(line, "HTTP/") {
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) < 2 || len(parts[1]) != 3 {
+			return nil
+		}
+		status, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			return nil
+		}
+		m := &httpMessage{line: line, version: parts[0], status: uint16(status), contentLength: -1}
+		if len(parts) == 3 {
+			m.reason = parts[2]
+		}
+		return m
+	}
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 || !httpToken(parts[0]) || parts[1] == "" || !strings.HasPrefix(parts[2], "HTTP/") {
+		return nil
+	}
+	return &httpMessage{request: true, line: line, method: parts[0], target: parts[1], version: parts[2], contentLength: -1}
+}
+
+// body decides how the body of the message is delimited after the headers
+func (p *httpParser) body(h *httpHalf) {
+	m := h.message
+	if m.request {
+		if len(p.pending) < httpMaxPending {
+			p.pending = append(p.pending, m.method)
+		}
+	} else {
+		if m.status < 200 {
+			if m.status == 101 {
+				// switching protocols; the rest of the connection is not HTTP anymore
+				p.tunnel(h)
+				return
+			}
+			// interim response; doesn't answer the request
+			p.finish(h)
+			return
+		}
+		var method string
+		if len(p.pending) > 0 {
+			method = p.pending[0]
+			p.pending = p.pending[1:]
+		}
+		if method == "CONNECT" && m.status < 300 {
+			p.tunnel(h)
+			return
+		}
+		if method == "HEAD" || m.status == 204 || m.status == 304 {
+			p.finish(h)
+			return
+		}
+	}
+	switch {
+	case m.chunked:
+		h.state = httpStateChunkSize
+	case m.contentLength > 0:
+		h.remaining = uint64(m.contentLength)
+		h.state = httpStateBody
+	case m.contentLength == 0 || m.request:
+		p.finish(h)
+	default:
+		h.state = httpStateBodyClose
+	}
+}
+
+func (p *httpParser) finish(h *httpHalf) {
+	h.message.complete = true
+	p.emit(h.message)
+	h.message = nil
+	h.state = httpStateStart
+}
+
+// abort emits a message that is cut off, if its headers are complete
+func (p *httpParser) abort(h *httpHalf) {
+	if h.message != nil && h.state != httpStateHeaders && h.state != httpStateStart {
+		p.emit(h.message)
+	}
+	h.message = nil
+	h.line = h.line[:0]
+}
+
+func (p *httpParser) lost(h *httpHalf) {
+	p.abort(h)
+	h.state = httpStateLost
+}
+
+func (p *httpParser) tunnel(h *httpHalf) {
+	h.message.complete = true
+	p.emit(h.message)
+	h.message = nil
+	for i := range p.halfs {
+		p.abort(&p.halfs[i])
+		p.halfs[i].state = httpStateTunnel
+	}
+}
+
+func (p *httpParser) gap(h *httpHalf, gap uint64) {
+	switch h.state {
+	case httpStateStart, httpStateLost, httpStateTunnel:
+		if len(h.line) == 0 {
+			return
+		}
+	case httpStateBody, httpStateChunkData:
+		if gap <= h.remaining {
+			// the missing bytes are part of the body
+			h.message.bodyLength += gap
+			h.remaining -= gap
+			if h.remaining == 0 {
+				if h.state == httpStateBody {
+					p.finish(h)
+				} else {
+					h.state = httpStateChunkEnd
+				}
+			}
+			return
+		}
+	case httpStateBodyClose:
+		h.message.bodyLength += gap
+		return
+	}
+	p.lost(h)
+}
+
+func (p *httpParser) end(h *httpHalf) {
+	if h.state == httpStateBodyClose {
+		p.finish(h)
+	} else {
+		p.abort(h)
+	}
+	h.state = httpStateLost
+}
+
+// flush emits the messages, which are cut off by the end of the flow
+func (p *httpParser) flush() {
+	for i := range p.halfs {
+		p.abort(&p.halfs[i])
+	}
+}
+
+func (p *httpParser) checkpoint(c *flows.Checkpoint) {
+	for i := range p.halfs {
+		h := &p.halfs[i]
+		c.Uint8(&h.state)
+		c.Bytes(&h.line)
+		c.Uint64(&h.remaining)
+		valid := h.message != nil
+		c.Bool(&valid)
+		if valid {
+			if c.Loading() {
+				h.message = &httpMessage{}
+			}
+			h.message.checkpoint(c)
+		}
+	}
+	n := c.Len(len(p.pending))
+	if c.Loading() {
+		p.pending = make([]string, n)
+	}
+	for i := range p.pending {
+		c.String(&p.pending[i])
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// __httpMessages emits every parsed HTTP message of the connection as *httpMessage
+type _httpMessages struct {
+	flows.BaseFeature
+	parser  httpParser
+	context *flows.EventContext
+}
+
+func newHTTPMessages() flows.Feature {
+	f := &_httpMessages{}
+	f.parser.emit = func(message *httpMessage) {
+		f.Emit(message, f.context, f)
+	}
+	return f
+}
+
+func (f *_httpMessages) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.parser.reset()
+}
+
+func (f *_httpMessages) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.context = context
+	f.parser.chunk(new.(*features.StreamChunk))
+}
+
+func (f *_httpMessages) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.context = context
+	f.parser.flush()
+}
+
+func (f *_httpMessages) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.parser.checkpoint(c)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__httpMessages", "returns the parsed HTTP messages of a TCP stream", ipfix.OctetArrayType, 0, flows.PacketFeature, newHTTPMessages, flows.PacketFeature)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _httpLines struct {
+	flows.BaseFeature
+}
+
+func (f *_httpLines) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	message := new.(*httpMessage)
+	f.SetValue(message.line, context, f)
+	for _, header := range message.headers {
+		f.SetValue(header, context, f)
+	}
+}
+
+// httpLines parses HTTP messages from a payload string (e.g. _tcpReorderPayload) instead of a TCP stream. Without
+// directions, both requests and responses are parsed as one stream.
+type httpLines struct {
+	flows.BaseFeature
+	parser  httpParser
+	context *flows.EventContext
+}
+
+func newHTTPLinesPayload() flows.Feature {
+	f := &httpLines{}
+	f.parser.emit = func(message *httpMessage) {
+		f.SetValue(message.line, f.context, f)
+		for _, header := range message.headers {
+			f.SetValue(header, f.context, f)
+		}
+	}
+	return f
+}
+
+func (f *httpLines) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.parser.reset()
+}
+
+func (f *httpLines) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.context = context
+	f.parser.chunk(&features.StreamChunk{Data: []byte(new.(string)), Forward: true})
+}
+
+func (f *httpLines) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.context = context
+	f.parser.flush()
+}
+
+func (f *httpLines) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.parser.checkpoint(c)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("httpLines", "returns the start line and the header lines of HTTP messages in a payload", ipfix.StringType, 0, flows.PacketFeature, newHTTPLinesPayload, flows.PacketFeature)
+	flows.RegisterTemporaryFeature("__httpLines", "returns the start line and the header lines of HTTP messages", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_httpLines{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_HTTPLines", "returns the start line and the header lines of every message of a http session", ipfix.StringType, 0, "__httpLines", []interface{}{"__httpMessages", "__tcpStream"})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// httpField returns a field of a message and if the field is present
+type httpField func(*httpMessage) (interface{}, bool)
+
+type _httpFieldPacket struct {
+	flows.BaseFeature
+	field httpField
+}
+
+func (f *_httpFieldPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if value, ok := f.field(new.(*httpMessage)); ok {
+		f.SetValue(value, context, f)
+	}
+}
+
+// Checkpoint saves or restores the value; field is set when the feature is made
+func (f *_httpFieldPacket) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+}
+
+type _httpFieldFlow struct {
+	_httpFieldPacket
+}
+
+func (f *_httpFieldFlow) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f._httpFieldPacket.Event(new, context, src)
+	}
+}
+
+// registerHTTPField registers name as composite feature of the field from the first message (FlowFeature) or every
+// message (PacketFeature) having this field
+func registerHTTPField(name, description string, field httpField) {
+	ie, err := ipfix.GetInformationElement(name)
+	if err != nil {
+		log.Panic(err)
+	}
+	flows.RegisterTemporaryFeature("__"+name, description, ie.Type, ie.Length, flows.FlowFeature, func() flows.Feature { return &_httpFieldFlow{_httpFieldPacket{field: field}} }, flows.PacketFeature)
+	flows.RegisterTemporaryFeature("__"+name, description, ie.Type, ie.Length, flows.PacketFeature, func() flows.Feature { return &_httpFieldPacket{field: field} }, flows.PacketFeature)
+	flows.RegisterStandardCompositeFeature(name, "__"+name, []interface{}{"__httpMessages", "__tcpStream"})
+}
+
+func httpRequestField(value func(*httpMessage) string) httpField {
+	return func(m *httpMessage) (interface{}, bool) {
+		if !m.request {
+			return nil, false
+		}
+		ret := value(m)
+		return ret, ret != ""
+	}
+}
+
+func httpResponseField(value func(*httpMessage) string) httpField {
+	return func(m *httpMessage) (interface{}, bool) {
+		if m.request {
+			return nil, false
+		}
+		ret := value(m)
+		return ret, ret != ""
+	}
+}
+
+func init() {
+	registerHTTPField("httpRequestMethod", "method of the first HTTP request", httpRequestField(func(m *httpMessage) string { return m.method }))
+	registerHTTPField("httpRequestTarget", "target of the first HTTP request", httpRequestField(func(m *httpMessage) string { return m.target }))
+	registerHTTPField("httpRequestHost", "host header of the first HTTP request", httpRequestField(func(m *httpMessage) string { return m.host }))
+	registerHTTPField("httpUserAgent", "user-agent header of the first HTTP request", httpRequestField(func(m *httpMessage) string { return m.userAgent }))
+	registerHTTPField("httpMessageVersion", "version of the first HTTP message", func(m *httpMessage) (interface{}, bool) { return m.version, true })
+	registerHTTPField("httpStatusCode", "status code of the first HTTP response", func(m *httpMessage) (interface{}, bool) { return m.status, !m.request })
+	registerHTTPField("httpReasonPhrase", "reason phrase of the first HTTP response", httpResponseField(func(m *httpMessage) string { return m.reason }))
+	registerHTTPField("httpContentType", "content-type header of the first HTTP response", httpResponseField(func(m *httpMessage) string { return m.contentType }))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _httpCount struct {
+	flows.BaseFeature
+	count   uint64
+	request bool
+	body    bool
+}
+
+func (f *_httpCount) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.count = 0
+}
+
+func (f *_httpCount) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	message := new.(*httpMessage)
+	if message.request != f.request {
+		return
+	}
+	if f.body {
+		f.count += message.bodyLength
+	} else {
+		f.count++
+	}
+}
+
+func (f *_httpCount) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.SetValue(f.count, context, f)
+}
+
+func (f *_httpCount) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
+func registerHTTPCount(name, description string, request, body bool) {
+	flows.RegisterTemporaryFeature("_"+name, description, ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_httpCount{request: request, body: body} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature(name, description, ipfix.Unsigned64Type, 0, "_"+name, []interface{}{"__httpMessages", "__tcpStream"})
+}
+
+func init() {
+	registerHTTPCount("_httpRequestCount", "number of HTTP requests", true, false)
+	registerHTTPCount("_httpResponseCount", "number of HTTP responses (including interim responses)", false, false)
+	registerHTTPCount("_httpRequestBodyOctetCount", "sum of the body lengths of the HTTP requests (without transfer encoding)", true, true)
+	registerHTTPCount("_httpResponseBodyOctetCount", "sum of the body lengths of the HTTP responses (without transfer encoding)", false, true)
+}
@@ -0,0 +1,71 @@
+package custom_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	_ "github.com/CN-TU/go-flows/modules/features/operations"
+	"github.com/CN-TU/go-flows/packet_test"
+)
+
+// httpSession returns the segments of a http connection from port 1000 with the given requests and responses
+func httpSession(messages ...string) []tcpSegment {
+	data := make([][]byte, len(messages))
+	for i, message := range messages {
+		data[i] = []byte(message)
+	}
+	return tcpSession(1000, data...)
+}
+
+var httpMessages = []string{
+	"GET /index.html HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\n\r\n",
+	"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 5\r\n\r\nhello",
+	"POST /form HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3\r\n\r\na=b",
+	"HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n",
+}
+
+func TestHTTPInterim(t *testing.T) {
+	source := tcpSource(t, httpSession(httpMessages...))
+	features := []interface{}{"httpRequestMethod", "httpRequestHost", "httpStatusCode", "_httpRequestCount",
+		"_httpResponseCount", "_httpRequestBodyOctetCount", "_httpResponseBodyOctetCount", "flowEndReason"}
+	options := flows.FlowOptions{ActiveTimeout: 1000 * flows.SecondsInNanoseconds, IdleTimeout: 100 * flows.SecondsInNanoseconds}
+	plain := packet_test.RunEngine(t, source, []packet_test.EngineSpec{{Features: features, Options: options}}, packet_test.EngineOptions{})
+	expected := []string{"GET,example.com,200,2,2,3,5,4"}
+	if len(plain) != 1 || plain[0][strings.Index(plain[0], ",")+1:] != expected[0] {
+		t.Fatalf("got records %q, expected %q", plain, expected)
+	}
+	options.InterimPackets = []uint64{4, 5, 7, 9, 11}
+	lines := packet_test.RunEngine(t, source, []packet_test.EngineSpec{{Features: features, Options: options}}, packet_test.EngineOptions{})
+	// the interim records see the messages completed so far; the final record is unchanged
+	expected = []string{
+		"10000001,<nil>,<nil>,<nil>,0,0,0,0,6",
+		"20000001,GET,example.com,<nil>,1,0,0,0,6",
+		"40000001,GET,example.com,200,1,1,0,5,6",
+		"60000001,GET,example.com,200,2,1,3,5,6",
+		"80000001,GET,example.com,200,2,2,3,5,6",
+		plain[0],
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records %q, expected %q", lines, expected)
+	}
+}
+
+func TestHTTPLines(t *testing.T) {
+	source := tcpSource(t, httpSession(httpMessages...))
+	features := []interface{}{[]interface{}{"accumulate", "_HTTPLines"}, []interface{}{"accumulate", []interface{}{"httpLines", "_tcpReorderPayload"}}}
+	lines := packet_test.RunEngine(t, source, []packet_test.EngineSpec{{Features: features}}, packet_test.EngineOptions{})
+	// start and header lines of every request and response; the payload version of httpLines sees the same messages
+	var headers []string
+	for _, message := range httpMessages {
+		headers = append(headers, strings.Split(message[:strings.Index(message, "\r\n\r\n")], "\r\n")...)
+	}
+	expected := "[" + strings.Join(headers, " ") + "]"
+	if len(lines) != 1 {
+		t.Fatalf("got records %q, expected one record", lines)
+	}
+	if fields := strings.SplitN(lines[0], ",", 2)[1]; fields != expected+","+expected {
+		t.Errorf("got lines %q, expected %q for both features", fields, expected)
+	}
+}
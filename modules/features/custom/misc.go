@@ -32,6 +32,10 @@ func (e *exportPackets) Event(new interface{}, context *flows.EventContext, src
 }
 
 func (e *exportPackets) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if reason == flows.FlowEndReasonInterim {
+		// the pcap is written once the flow ends
+		return
+	}
 	flow := context.Flow()
 	id := flow.ID()
 	tid := flow.Table().ID()
@@ -41,6 +45,16 @@ func (e *exportPackets) Stop(reason flows.FlowEndReason, context *flows.EventCon
 	ioutil.WriteFile(p, e.b.Bytes(), 0644)
 }
 
+func (e *exportPackets) Checkpoint(c *flows.Checkpoint) {
+	data := e.b.Bytes()
+	c.Bytes(&data)
+	if c.Loading() {
+		e.b.Reset()
+		e.b.Write(data)
+		e.w = pcapgo.NewWriter(&e.b)
+	}
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("__exportPackets", "Writes one pcap per flow containing the flow's packets", ipfix.Unsigned8Type, 0, flows.FlowFeature, func() flows.Feature { return &exportPackets{} }, flows.RawPacket)
 }
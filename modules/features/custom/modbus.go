@@ -0,0 +1,227 @@
+package custom
+
+import (
+	"encoding/binary"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/modules/features"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// Modbus/TCP parser working on the reassembled TCP streams (see __tcpStream). Requests are sent by the client (the
+// forward direction) and responses by the server. A direction is not parsed anymore after a gap or an invalid header.
+
+const (
+	modbusHeader    = 7
+	modbusMaxLength = 254
+	modbusException = 0x80
+)
+
+// modbusMessage is emitted for every Modbus/TCP message
+type modbusMessage struct {
+	request       bool
+	transactionID uint16
+	unitID        uint8
+	function      uint8 // without exception bit
+	exception     bool
+	exceptionCode uint8
+	// register or coil ranges accessed by requests
+	read          bool
+	readAddress   uint16
+	readQuantity  uint16
+	write         bool
+	writeAddress  uint16
+	writeQuantity uint16
+}
+
+// parsePDU fills the function code and the accessed ranges of a message
+func (m *modbusMessage) parsePDU(pdu []byte) {
+	m.function = pdu[0] &^ modbusException
+	data := pdu[1:]
+	if !m.request {
+		if pdu[0]&modbusException != 0 && len(data) > 0 {
+			m.exception = true
+			m.exceptionCode = data[0]
+		}
+		return
+	}
+	switch m.function {
+	case 1, 2, 3, 4: // read coils, discrete inputs, holding registers, input registers
+		if len(data) >= 4 {
+			m.read = true
+			m.readAddress = binary.BigEndian.Uint16(data)
+			m.readQuantity = binary.BigEndian.Uint16(data[2:])
+		}
+	case 5, 6: // write single coil or register
+		if len(data) >= 2 {
+			m.write = true
+			m.writeAddress = binary.BigEndian.Uint16(data)
+			m.writeQuantity = 1
+		}
+	case 15, 16: // write multiple coils or registers
+		if len(data) >= 4 {
+			m.write = true
+			m.writeAddress = binary.BigEndian.Uint16(data)
+			m.writeQuantity = binary.BigEndian.Uint16(data[2:])
+		}
+	case 23: // read/write multiple registers
+		if len(data) >= 8 {
+			m.read = true
+			m.readAddress = binary.BigEndian.Uint16(data)
+			m.readQuantity = binary.BigEndian.Uint16(data[2:])
+			m.write = true
+			m.writeAddress = binary.BigEndian.Uint16(data[4:])
+			m.writeQuantity = binary.BigEndian.Uint16(data[6:])
+		}
+	}
+}
+
+// __modbus emits the Modbus/TCP messages of the connection
+type _modbus struct {
+	flows.BaseFeature
+	buffers [2][]byte
+	lost    [2]bool
+}
+
+func (f *_modbus) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	for i := range f.buffers {
+		f.buffers[i] = f.buffers[i][:0]
+		f.lost[i] = false
+	}
+}
+
+func (f *_modbus) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	chunk := new.(*features.StreamChunk)
+	i := 0
+	if !chunk.Forward {
+		i = 1
+	}
+	if chunk.Gap != 0 {
+		f.lost[i] = true
+		f.buffers[i] = f.buffers[i][:0]
+	}
+	if f.lost[i] {
+		return
+	}
+	f.buffers[i] = append(f.buffers[i], chunk.Data...)
+	data := f.buffers[i]
+	for len(data) >= modbusHeader {
+		length := int(binary.BigEndian.Uint16(data[4:]))
+		if binary.BigEndian.Uint16(data[2:]) != 0 || length < 2 || length > modbusMaxLength {
+			// protocol identifier must be 0; unit identifier and function code are part of length
+			f.lost[i] = true
+			f.buffers[i] = f.buffers[i][:0]
+			return
+		}
+		if len(data) < modbusHeader-1+length {
+			break
+		}
+		message := &modbusMessage{
+			request:       chunk.Forward,
+			transactionID: binary.BigEndian.Uint16(data),
+			unitID:        data[6],
+		}
+		message.parsePDU(data[modbusHeader : modbusHeader-1+length])
+		data = data[modbusHeader-1+length:]
+		f.Emit(message, context, f)
+	}
+	f.buffers[i] = f.buffers[i][:copy(f.buffers[i], data)]
+}
+
+func (f *_modbus) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	for i := range f.buffers {
+		c.Bytes(&f.buffers[i])
+		c.Bool(&f.lost[i])
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// modbusField returns a field of a message and if the field is present
+type modbusField func(*modbusMessage) (interface{}, bool)
+
+type _modbusFieldPacket struct {
+	flows.BaseFeature
+	field modbusField
+}
+
+func (f *_modbusFieldPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if value, ok := f.field(new.(*modbusMessage)); ok {
+		f.SetValue(value, context, f)
+	}
+}
+
+// Checkpoint saves or restores the value; field is set when the feature is made
+func (f *_modbusFieldPacket) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+}
+
+type _modbusFieldFlow struct {
+	_modbusFieldPacket
+}
+
+func (f *_modbusFieldFlow) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f._modbusFieldPacket.Event(new, context, src)
+	}
+}
+
+// registerModbusField registers name as composite feature of the field from the first message (FlowFeature) or every
+// message (PacketFeature) having this field
+func registerModbusField(name, description string, t ipfix.Type, field modbusField) {
+	flows.RegisterTemporaryFeature("_"+name, description, t, 0, flows.FlowFeature, func() flows.Feature { return &_modbusFieldFlow{_modbusFieldPacket{field: field}} }, flows.PacketFeature)
+	flows.RegisterTemporaryFeature("_"+name, description, t, 0, flows.PacketFeature, func() flows.Feature { return &_modbusFieldPacket{field: field} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature(name, description, t, 0, "_"+name, []interface{}{"__modbus", "__tcpStream"})
+}
+
+type _modbusCount struct {
+	flows.BaseFeature
+	count     uint64
+	request   bool
+	exception bool
+}
+
+func (f *_modbusCount) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.count = 0
+}
+
+func (f *_modbusCount) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	message := new.(*modbusMessage)
+	if message.request == f.request && (!f.exception || message.exception) {
+		f.count++
+	}
+}
+
+func (f *_modbusCount) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.SetValue(f.count, context, f)
+}
+
+func (f *_modbusCount) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
+func registerModbusCount(name, description string, request, exception bool) {
+	flows.RegisterTemporaryFeature("_"+name, description, ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_modbusCount{request: request, exception: exception} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature(name, description, ipfix.Unsigned64Type, 0, "_"+name, []interface{}{"__modbus", "__tcpStream"})
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__modbus", "returns the Modbus/TCP messages of a TCP stream", ipfix.OctetArrayType, 0, flows.PacketFeature, func() flows.Feature { return &_modbus{} }, flows.PacketFeature)
+
+	registerModbusField("_modbusTransactionID", "transaction identifier of Modbus/TCP messages", ipfix.Unsigned16Type, func(m *modbusMessage) (interface{}, bool) { return m.transactionID, true })
+	registerModbusField("_modbusUnitID", "unit identifier of Modbus/TCP messages", ipfix.Unsigned8Type, func(m *modbusMessage) (interface{}, bool) { return m.unitID, true })
+	registerModbusField("_modbusFunctionCode", "function code of Modbus/TCP requests", ipfix.Unsigned8Type, func(m *modbusMessage) (interface{}, bool) { return m.function, m.request })
+	registerModbusField("_modbusExceptionCode", "exception code of Modbus/TCP exception responses", ipfix.Unsigned8Type, func(m *modbusMessage) (interface{}, bool) { return m.exceptionCode, m.exception })
+	registerModbusField("_modbusReadAddress", "start address of the registers or coils read by Modbus/TCP requests", ipfix.Unsigned16Type, func(m *modbusMessage) (interface{}, bool) { return m.readAddress, m.read })
+	registerModbusField("_modbusReadQuantity", "number of registers or coils read by Modbus/TCP requests", ipfix.Unsigned16Type, func(m *modbusMessage) (interface{}, bool) { return m.readQuantity, m.read })
+	registerModbusField("_modbusWriteAddress", "start address of the registers or coils written by Modbus/TCP requests", ipfix.Unsigned16Type, func(m *modbusMessage) (interface{}, bool) { return m.writeAddress, m.write })
+	registerModbusField("_modbusWriteQuantity", "number of registers or coils written by Modbus/TCP requests", ipfix.Unsigned16Type, func(m *modbusMessage) (interface{}, bool) { return m.writeQuantity, m.write })
+
+	registerModbusCount("_modbusRequestCount", "number of Modbus/TCP requests", true, false)
+	registerModbusCount("_modbusResponseCount", "number of Modbus/TCP responses", false, false)
+	registerModbusCount("_modbusExceptionCount", "number of Modbus/TCP exception responses", false, true)
+}
@@ -0,0 +1,103 @@
+package custom_test
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+// modbusADU returns a Modbus/TCP message with the given PDU
+func modbusADU(transaction uint16, unit byte, pdu ...byte) []byte {
+	ret := make([]byte, 7, 7+len(pdu))
+	binary.BigEndian.PutUint16(ret, transaction)
+	binary.BigEndian.PutUint16(ret[4:], uint16(1+len(pdu)))
+	ret[6] = unit
+	return append(ret, pdu...)
+}
+
+var modbusFeatures = []interface{}{"_modbusTransactionID", "_modbusUnitID", []interface{}{"distinct", "_modbusTransactionID"},
+	[]interface{}{"accumulate", "_modbusFunctionCode"}, "_modbusExceptionCode",
+	[]interface{}{"accumulate", "_modbusReadAddress"}, []interface{}{"accumulate", "_modbusReadQuantity"},
+	[]interface{}{"accumulate", "_modbusWriteAddress"}, []interface{}{"accumulate", "_modbusWriteQuantity"},
+	"_modbusRequestCount", "_modbusResponseCount", "_modbusExceptionCount"}
+
+var (
+	// read holding registers 107-109 (example of the Modbus specification)
+	modbusRead         = modbusADU(1, 17, 3, 0, 0x6b, 0, 3)
+	modbusReadResponse = modbusADU(1, 17, 3, 6, 0x02, 0x2b, 0, 0, 0, 0x64)
+	// write multiple registers 1-2
+	modbusWrite         = modbusADU(2, 17, 16, 0, 1, 0, 2, 4, 0, 0x0a, 1, 2)
+	modbusWriteResponse = modbusADU(2, 17, 16, 0, 1, 0, 2)
+	// read/write multiple registers (read 3-8, write 14-16) with an illegal data address exception
+	modbusReadWrite          = modbusADU(3, 17, 23, 0, 3, 0, 6, 0, 14, 0, 3, 6, 0, 0xff, 0, 0xff, 0, 0xff)
+	modbusReadWriteException = modbusADU(3, 17, 0x97, 2)
+	// write single coil 172 with a server device failure exception
+	modbusWriteCoil          = modbusADU(4, 17, 5, 0, 0xac, 0xff, 0)
+	modbusWriteCoilException = modbusADU(4, 17, 0x85, 4)
+)
+
+func TestModbus(t *testing.T) {
+	// two pipelined requests and responses in a message
+	session := tcpSession(1000,
+		concat(modbusRead, modbusWrite),
+		concat(modbusReadResponse, modbusWriteResponse),
+		modbusReadWrite,
+		modbusReadWriteException,
+		modbusWriteCoil,
+		modbusWriteCoilException,
+	)
+	// every byte in its own segment
+	var chunks []streamChunk
+	for _, b := range concat(modbusRead, modbusWrite) {
+		chunks = append(chunks, streamChunk{false, []byte{b}})
+	}
+	bytewise := streamSession(1001, chunks...)
+	// a direction is not parsed anymore after a gap or an invalid header
+	gap := streamSession(1002, streamChunk{false, modbusRead}, streamChunk{true, modbusReadResponse},
+		streamChunk{false, modbusWriteCoil}, streamChunk{true, modbusWriteCoilException})
+	for i := range gap {
+		if gap[i].reverse && gap[i].ms >= 40 {
+			gap[i].tcp.Seq += 10
+		}
+	}
+	invalid := tcpSession(1003, concat([]byte{0, 1, 0, 1, 0, 6, 17, 3, 0, 0, 0, 1}, modbusRead), modbusReadResponse)
+	lines := sessionFlows(t, modbusFeatures, session, bytewise, gap, invalid)
+	expected := []string{
+		"1000,1,17,4,[3 16 23 5],2,[107 3],[3 6],[1 14 172],[2 3 1],4,4,2",
+		"1001,1,17,2,[3 16],<nil>,[107],[3],[1],[2],2,0,0",
+		"1002,1,17,2,[3 5],<nil>,[107],[3],[172],[1],2,1,0",
+		"1003,1,17,1,<nil>,<nil>,<nil>,<nil>,<nil>,<nil>,0,1,0",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestModbusMalformed(t *testing.T) {
+	// every truncation and every corrupted byte of requests and responses
+	var sessions [][]tcpSegment
+	port := layers.TCPPort(1000)
+	for _, message := range [][]byte{modbusRead, modbusWrite, modbusReadWrite, modbusWriteCoil, modbusReadWriteException} {
+		for n := 0; n < len(message); n++ {
+			corrupt := append([]byte(nil), message...)
+			corrupt[n] ^= 0xff
+			sessions = append(sessions,
+				tcpSession(port, message[:n], message[:n]),
+				tcpSession(port+1, corrupt, corrupt),
+			)
+			port += 2
+		}
+	}
+	// PDUs too short for their function code
+	for function := byte(1); function < 24; function++ {
+		sessions = append(sessions, tcpSession(port, modbusADU(1, 1, function, 0), modbusADU(1, 1, function|0x80)))
+		port++
+	}
+	lines := sessionFlows(t, modbusFeatures, sessions...)
+	if len(lines) != len(sessions) {
+		t.Errorf("got %d records, expected %d", len(lines), len(sessions))
+	}
+}
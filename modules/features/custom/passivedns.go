@@ -0,0 +1,50 @@
+package custom
+
+import (
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// The features in this file annotate flows with the names from the passive DNS cache of the engine (-passiveDNS).
+
+type _domainName struct {
+	flows.BaseFeature
+	destination bool
+	done        bool
+}
+
+func (f *_domainName) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.done = false
+}
+
+// NeedsPassiveDNS reports a missing -passiveDNS at startup (see packet.CheckPassiveDNS)
+func (f *_domainName) NeedsPassiveDNS() {}
+
+func (f *_domainName) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.done {
+		return
+	}
+	f.done = true
+	buffer := new.(packet.Buffer)
+	cache := buffer.PassiveDNS()
+	network := buffer.NetworkLayer().NetworkFlow()
+	ip := network.Src().Raw()
+	if f.destination == context.Forward() {
+		ip = network.Dst().Raw()
+	}
+	if name, ok := cache.Lookup(ip, buffer.PacketNr(), buffer.Timestamp()); ok {
+		f.SetValue(name, context, f)
+	}
+}
+
+func (f *_domainName) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Bool(&f.done)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("_sourceDomainName", "most recent name resolved to the source address by a DNS answer before the flow started (needs -passiveDNS).", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &_domainName{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("_destinationDomainName", "most recent name resolved to the destination address by a DNS answer before the flow started (needs -passiveDNS).", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &_domainName{destination: true} }, flows.RawPacket)
+}
@@ -0,0 +1,115 @@
+package custom_test
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// domainPacket is an udp packet from src:srcPort to dst:dstPort at ms with payload
+type domainPacket struct {
+	ms               int
+	src, dst         string
+	srcPort, dstPort layers.UDPPort
+	payload          []byte
+}
+
+// domainSource returns a source with the given packets
+func domainSource(t *testing.T, packets []domainPacket) *packet_test.MemorySource {
+	source := &packet_test.MemorySource{}
+	for _, p := range packets {
+		var ip gopacket.SerializableLayer
+		src, dst := net.ParseIP(p.src), net.ParseIP(p.dst)
+		if src.To4() != nil {
+			ip = &layers.IPv4{Version: 4, TTL: 64, SrcIP: src.To4(), DstIP: dst.To4(), Protocol: layers.IPProtocolUDP}
+		} else {
+			ip = &layers.IPv6{Version: 6, HopLimit: 64, SrcIP: src, DstIP: dst, NextHeader: layers.IPProtocolUDP}
+		}
+		udp := &layers.UDP{SrcPort: p.srcPort, DstPort: p.dstPort}
+		udp.SetNetworkLayerForChecksum(ip.(gopacket.NetworkLayer))
+		if err := source.AppendLayers(flows.DateTimeNanoseconds(p.ms)*flows.MillisecondsInNanoseconds+1, ip, udp, gopacket.Payload(p.payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source
+}
+
+// dnsAnswer returns a DNS response with the given answers
+func dnsAnswer(t *testing.T, answers ...layers.DNSResourceRecord) []byte {
+	dns := &layers.DNS{ID: 1, QR: true, RA: true, Answers: answers}
+	buffer := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buffer, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func dnsRecord(name, ip string, ttl uint32) layers.DNSResourceRecord {
+	typ := layers.DNSTypeA
+	if net.ParseIP(ip).To4() == nil {
+		typ = layers.DNSTypeAAAA
+	}
+	return layers.DNSResourceRecord{Name: []byte(name), Type: typ, Class: layers.DNSClassIN, TTL: ttl, IP: net.ParseIP(ip)}
+}
+
+var domainFeatures = []interface{}{"sourceIPAddress", "destinationIPAddress", "destinationTransportPort", "_sourceDomainName", "_destinationDomainName"}
+
+func TestDomainName(t *testing.T) {
+	packets := []domainPacket{
+		// a flow to the address before it was resolved
+		{0, "10.0.0.1", "192.0.2.1", 1000, 443, nil},
+		{100, "10.0.0.53", "10.0.0.1", 53, 2000, dnsAnswer(t,
+			layers.DNSResourceRecord{Name: []byte("www.example.com"), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 60, CNAME: []byte("cdn.example.net")},
+			dnsRecord("cdn.example.net", "192.0.2.1", 10), dnsRecord("cdn.example.net", "2001:db8::1", 10))},
+		// the packet of the old flow after the answer doesn't change the name
+		{200, "10.0.0.1", "192.0.2.1", 1000, 443, nil},
+		{300, "10.0.0.1", "192.0.2.1", 1001, 443, nil},
+		{400, "192.0.2.1", "10.0.0.1", 443, 1002, nil},
+		{500, "2001:db8::2", "2001:db8::1", 1000, 443, nil},
+		{600, "10.0.0.1", "192.0.2.99", 1000, 443, nil},
+		// the TTL of the answer expired
+		{10200, "10.0.0.1", "192.0.2.1", 1003, 443, nil},
+	}
+	lines := packet_test.RunEngine(t, domainSource(t, packets), []packet_test.EngineSpec{{Features: domainFeatures,
+		Options: flows.FlowOptions{IdleTimeout: 100 * flows.SecondsInNanoseconds}}}, packet_test.EngineOptions{PassiveDNS: true})
+	for i, line := range lines {
+		lines[i] = line[strings.Index(line, ",")+1:]
+	}
+	sort.Strings(lines)
+	expected := []string{
+		"10.0.0.1,192.0.2.1,443,<nil>,<nil>",
+		"10.0.0.1,192.0.2.1,443,<nil>,<nil>",
+		"10.0.0.1,192.0.2.1,443,<nil>,www.example.com",
+		"10.0.0.1,192.0.2.99,443,<nil>,<nil>",
+		"10.0.0.53,10.0.0.1,2000,<nil>,<nil>",
+		"192.0.2.1,10.0.0.1,1002,www.example.com,<nil>",
+		"2001:db8::2,2001:db8::1,443,<nil>,www.example.com",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestDomainNameNeedsPassiveDNS(t *testing.T) {
+	for _, name := range []string{"_sourceDomainName", "_destinationDomainName"} {
+		var records flows.RecordListMaker
+		pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1, 1, false)
+		if err := records.AppendRecord([]interface{}{name}, nil, nil, pipe, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := packet.CheckPassiveDNS(records, false).(flows.FeatureError); !ok {
+			t.Errorf("%s: missing -passiveDNS not reported", name)
+		}
+		if err := packet.CheckPassiveDNS(records, true); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+}
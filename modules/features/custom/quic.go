@@ -0,0 +1,263 @@
+package custom
+
+import (
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/modules/dissectors/quic"
+	"github.com/CN-TU/go-flows/packet"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// GetQUIC returns the QUIC layer of the packet (see modules/dissectors/quic) or nil
+func GetQUIC(new interface{}) *quic.QUIC {
+	q, _ := new.(packet.Buffer).ApplicationLayer().(*quic.QUIC)
+	return q
+}
+
+// quicField returns a field of a long header packet and if the field is present
+type quicField func(*quic.Packet) (interface{}, bool)
+
+type _quicFieldPacket struct {
+	flows.BaseFeature
+	field quicField
+}
+
+func (f *_quicFieldPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if q := GetQUIC(new); q != nil {
+		for i := range q.Packets {
+			if value, ok := f.field(&q.Packets[i]); ok {
+				f.SetValue(value, context, f)
+			}
+		}
+	}
+}
+
+// Checkpoint saves or restores the value; field is set when the feature is made
+func (f *_quicFieldPacket) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+}
+
+type _quicFieldFlow struct {
+	_quicFieldPacket
+}
+
+func (f *_quicFieldFlow) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f._quicFieldPacket.Event(new, context, src)
+	}
+}
+
+// registerQUICField registers the field of the first packet (FlowFeature) or every packet (PacketFeature) having this
+// field
+func registerQUICField(name, description string, t ipfix.Type, field quicField) {
+	flows.RegisterTemporaryFeature(name, description, t, 0, flows.FlowFeature, func() flows.Feature { return &_quicFieldFlow{_quicFieldPacket{field: field}} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature(name, description, t, 0, flows.PacketFeature, func() flows.Feature { return &_quicFieldPacket{field: field} }, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _quicPacketCount struct {
+	flows.BaseFeature
+	count uint64
+	long  bool
+	quic  bool
+}
+
+func (f *_quicPacketCount) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.count = 0
+	f.quic = false
+}
+
+func (f *_quicPacketCount) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if q := GetQUIC(new); q != nil {
+		for _, p := range q.Packets {
+			if p.Long {
+				f.quic = true
+			}
+			if p.Long == f.long {
+				f.count++
+			}
+		}
+		return
+	}
+	if !f.long && f.quic {
+		// short header packets are only dissected on well known ports
+		if tl := new.(packet.Buffer).TransportLayer(); tl != nil {
+			if payload := tl.LayerPayload(); len(payload) > 0 && payload[0]&0xc0 == 0x40 {
+				f.count++
+			}
+		}
+	}
+}
+
+func (f *_quicPacketCount) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.SetValue(f.count, context, f)
+}
+
+func (f *_quicPacketCount) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+	c.Bool(&f.quic)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+const quicMaxCrypto = 1 << 16
+
+// quicCrypto reassembles the CRYPTO frames of the Initial packets of one direction
+type quicCrypto struct {
+	data    []byte
+	pending []quic.CryptoFrame
+	done    bool
+}
+
+func (c *quicCrypto) reset() {
+	c.data = c.data[:0]
+	c.pending = c.pending[:0]
+	c.done = false
+}
+
+// add adds a frame and returns the contiguous data
+func (c *quicCrypto) add(frame quic.CryptoFrame) []byte {
+	if frame.Offset+uint64(len(frame.Data)) > quicMaxCrypto {
+		c.done = true
+		return nil
+	}
+	c.pending = append(c.pending, quic.CryptoFrame{Offset: frame.Offset, Data: append([]byte(nil), frame.Data...)})
+	for progress := true; progress; {
+		progress = false
+		pending := c.pending[:0]
+		for _, p := range c.pending {
+			end := p.Offset + uint64(len(p.Data))
+			switch {
+			case end <= uint64(len(c.data)):
+			case p.Offset <= uint64(len(c.data)):
+				c.data = append(c.data, p.Data[uint64(len(c.data))-p.Offset:]...)
+				progress = true
+			default:
+				pending = append(pending, p)
+			}
+		}
+		c.pending = pending
+	}
+	return c.data
+}
+
+func (c *quicCrypto) checkpoint(cp *flows.Checkpoint) {
+	cp.Bytes(&c.data)
+	cp.Bool(&c.done)
+	n := cp.Len(len(c.pending))
+	if cp.Loading() {
+		c.pending = make([]quic.CryptoFrame, n)
+	}
+	for i := range c.pending {
+		cp.Uint64(&c.pending[i].Offset)
+		cp.Bytes(&c.pending[i].Data)
+	}
+}
+
+// __quicHello decrypts the Initial packets and emits the ClientHello and ServerHello (*tlsHello)
+type _quicHello struct {
+	flows.BaseFeature
+	version uint32
+	dcid    []byte
+	keys    [2]*quic.InitialKeys
+	crypto  [2]quicCrypto
+}
+
+func (f *_quicHello) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.version = 0
+	f.dcid = nil
+	f.keys = [2]*quic.InitialKeys{}
+	f.crypto[0].reset()
+	f.crypto[1].reset()
+}
+
+func (f *_quicHello) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	q := GetQUIC(new)
+	if q == nil {
+		return
+	}
+	forward := context.Forward()
+	dir := 0
+	if !forward {
+		dir = 1
+	}
+	for i := range q.Packets {
+		p := &q.Packets[i]
+		if p.Type == quic.PacketTypeRetry && !forward && f.dcid != nil {
+			// the client uses the connection ID chosen by the server for the keys of the following Initial packets
+			f.dcid = append(f.dcid[:0], p.SCID...)
+			f.keys = [2]*quic.InitialKeys{}
+			f.crypto[0].reset()
+			continue
+		}
+		if p.Type != quic.PacketTypeInitial || f.crypto[dir].done {
+			continue
+		}
+		if f.dcid == nil {
+			if !forward {
+				continue
+			}
+			f.version = p.Version
+			f.dcid = append([]byte{}, p.DCID...)
+		}
+		if f.keys[dir] == nil {
+			keys, err := quic.NewInitialKeys(f.version, f.dcid, forward)
+			if err != nil {
+				f.crypto[dir].done = true
+				continue
+			}
+			f.keys[dir] = keys
+		}
+		payload, err := f.keys[dir].Decrypt(p)
+		if err != nil {
+			continue
+		}
+		for _, frame := range quic.CryptoFrames(payload) {
+			if f.crypto[dir].done {
+				break
+			}
+			data := f.crypto[dir].add(frame)
+			if len(data) < tlsHandshakeHeader {
+				continue
+			}
+			length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+			if len(data) < tlsHandshakeHeader+length {
+				continue
+			}
+			f.crypto[dir].done = true
+			if (data[0] == tlsClientHello) != forward || (data[0] != tlsClientHello && data[0] != tlsServerHello) {
+				continue
+			}
+			if hello := parseTLSHello(data[tlsHandshakeHeader:tlsHandshakeHeader+length], forward, true); hello != nil {
+				f.Emit(hello, context, f)
+			}
+		}
+	}
+}
+
+func (f *_quicHello) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint32(&f.version)
+	c.Bytes(&f.dcid)
+	f.crypto[0].checkpoint(c)
+	f.crypto[1].checkpoint(c)
+}
+
+func init() {
+	registerQUICField("_quicVersion", "QUIC version of the first long header packet", ipfix.Unsigned32Type, func(p *quic.Packet) (interface{}, bool) { return p.Version, p.Long && p.Version != 0 })
+	registerQUICField("_quicDCIDLength", "destination connection ID length of the first long header packet", ipfix.Unsigned8Type, func(p *quic.Packet) (interface{}, bool) { return uint8(len(p.DCID)), p.Long })
+	registerQUICField("_quicSCIDLength", "source connection ID length of the first long header packet", ipfix.Unsigned8Type, func(p *quic.Packet) (interface{}, bool) { return uint8(len(p.SCID)), p.Long })
+	registerQUICField("_quicPacketType", "type of the first QUIC packet (Initial, 0-RTT, Handshake, Retry, VersionNegotiation, or 1-RTT)", ipfix.StringType, func(p *quic.Packet) (interface{}, bool) { return p.Type.String(), true })
+
+	flows.RegisterTemporaryFeature("_quicLongHeaderPacketCount", "number of QUIC long header packets (including coalesced packets)", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_quicPacketCount{long: true} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("_quicShortHeaderPacketCount", "number of QUIC short header (1-RTT) packets", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_quicPacketCount{long: false} }, flows.RawPacket)
+
+	flows.RegisterTemporaryFeature("__quicHello", "returns the ClientHello and ServerHello of the QUIC Initial packets", ipfix.OctetArrayType, 0, flows.PacketFeature, func() flows.Feature { return &_quicHello{} }, flows.RawPacket)
+	flows.RegisterTemporaryCompositeFeature("_quicServerName", "server name indication of the QUIC ClientHello", ipfix.StringType, 0, "__tlsServerName", "__quicHello")
+	flows.RegisterTemporaryCompositeFeature("_quicALPN", "application protocols offered in the QUIC ClientHello (comma separated)", ipfix.StringType, 0, "__tlsClientALPN", "__quicHello")
+	flows.RegisterTemporaryCompositeFeature("_quicCipherSuite", "cipher suite chosen in the QUIC ServerHello", ipfix.Unsigned16Type, 0, "__tlsCipherSuite", "__quicHello")
+	flows.RegisterTemporaryCompositeFeature("_quicJA4", "JA4 fingerprint of the QUIC ClientHello", ipfix.StringType, 0, "__tlsJA4", "__quicHello")
+}
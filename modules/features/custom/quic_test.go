@@ -0,0 +1,149 @@
+package custom_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// quicKeys are the Initial keys for the destination connection ID 8394c8f03e515708 (RFC 9001 appendix A.1, RFC 9369
+// appendix A.1)
+type quicKeys struct {
+	version     uint32
+	initial     byte // packet type bits of Initial packets
+	key, iv, hp string
+}
+
+var (
+	quicDCID     = []byte{0x83, 0x94, 0xc8, 0xf0, 0x3e, 0x51, 0x57, 0x08}
+	quicClientV1 = quicKeys{1, 0, "1f369613dd76d5467730efcbe3b1a22d", "fa044b2f42a3fd3b46fb255c", "9f50449e04a0e810283a1e9933adedd2"}
+	quicServerV1 = quicKeys{1, 0, "cf3a5331653c364c88f0f379b6067e37", "0ac1493ca1905853b0bba03e", "c206b8d9b9f0f37644430b490eeaa314"}
+	quicClientV2 = quicKeys{0x6b3343cf, 1, "8b1a0bc121284290a29e0971b5cd045d", "91f73e2351d8fa91660e909f", "45b95e15235d6f45a6b19cbcb0294ba9"}
+)
+
+// quicCryptoFrame returns a CRYPTO frame with data at the given offset
+func quicCryptoFrame(offset int, data []byte) []byte {
+	ret := []byte{0x06, 0x80, 0, 0, 0, 0x40, 0}
+	binary.BigEndian.PutUint32(ret[1:], uint32(offset)|0x80000000)
+	binary.BigEndian.PutUint16(ret[5:], uint16(len(data))|0x4000)
+	return append(ret, data...)
+}
+
+// quicInitial returns a protected Initial packet with a 2 byte packet number, whose frames are padded to 1000 bytes
+func quicInitial(t *testing.T, keys quicKeys, dcid, scid []byte, pn uint16, frames ...[]byte) []byte {
+	payload := concat(frames...)
+	payload = append(payload, make([]byte, 1000-len(payload))...)
+	header := []byte{0xc1 | keys.initial<<4, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[1:], keys.version)
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, byte(len(scid)))
+	header = append(header, scid...)
+	header = append(header, 0, 0x40, 0, byte(pn>>8), byte(pn)) // token, length, packet number
+	binary.BigEndian.PutUint16(header[len(header)-4:], uint16(2+len(payload)+16)|0x4000)
+
+	key, _ := hex.DecodeString(keys.key)
+	nonce, _ := hex.DecodeString(keys.iv)
+	hp, _ := hex.DecodeString(keys.hp)
+	nonce[10] ^= byte(pn >> 8)
+	nonce[11] ^= byte(pn)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := aead.Seal(nil, nonce, payload, header)
+	block, err = aes.NewCipher(hp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, ciphertext[2:2+aes.BlockSize])
+	header[0] ^= mask[0] & 0x0f
+	header[len(header)-2] ^= mask[1]
+	header[len(header)-1] ^= mask[2]
+	return append(header, ciphertext...)
+}
+
+// quicDatagram is a UDP datagram between the client 10.0.0.1:port and the server 10.0.0.2:443
+type quicDatagram struct {
+	port    layers.UDPPort
+	reverse bool
+	payload []byte
+}
+
+// quicFlows returns the given features of the flows of the datagrams as sorted lines with the client port instead of
+// the export time
+func quicFlows(t *testing.T, features []interface{}, datagrams []quicDatagram) []string {
+	source := &packet_test.MemorySource{}
+	client, server := []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}
+	for i, datagram := range datagrams {
+		ip := &layers.IPv4{Version: 4, TTL: 64, SrcIP: client, DstIP: server, Protocol: layers.IPProtocolUDP}
+		udp := &layers.UDP{SrcPort: datagram.port, DstPort: 443}
+		if datagram.reverse {
+			ip.SrcIP, ip.DstIP = server, client
+			udp.SrcPort, udp.DstPort = 443, datagram.port
+		}
+		if err := source.AppendLayers(flows.DateTimeNanoseconds(i+1)*flows.MillisecondsInNanoseconds, ip, udp, gopacket.Payload(datagram.payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	features = append([]interface{}{"sourceTransportPort"}, features...)
+	lines := packet_test.RunEngine(t, source, []packet_test.EngineSpec{{Features: features}}, packet_test.EngineOptions{})
+	for i, line := range lines {
+		lines[i] = line[strings.Index(line, ",")+1:]
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+var quicFeatures = []interface{}{"_quicVersion", "_quicPacketType", "_quicDCIDLength", "_quicSCIDLength", "_quicLongHeaderPacketCount",
+	"_quicShortHeaderPacketCount", "_quicServerName", "_quicALPN", "_quicCipherSuite", "_quicJA4"}
+
+func TestQUIC(t *testing.T) {
+	clientHello := tlsHandshakeMessage(1, chromeHello)
+	serverHello := tlsHandshakeMessage(2, tlsHelloBody(false, 0x0303, []uint16{0x1301}, tlsExtension(0x002b, tlsUint16s(0x0304))))
+	scid := []byte{1, 2, 3, 4}
+	ack := []byte{0x02, 0x00, 0x00, 0x00, 0x00}
+	datagrams := []quicDatagram{
+		// ClientHello in two Initial packets in reverse order; ServerHello after an ACK frame, coalesced with a
+		// Handshake packet
+		{1000, false, quicInitial(t, quicClientV1, quicDCID, scid, 1, quicCryptoFrame(100, clientHello[100:]))},
+		{1000, false, quicInitial(t, quicClientV1, quicDCID, scid, 2, quicCryptoFrame(0, clientHello[:100]))},
+		{1000, true, append(quicInitial(t, quicServerV1, scid, []byte{5, 6}, 0, ack, quicCryptoFrame(0, serverHello)),
+			0xe0, 0, 0, 0, 1, 4, 1, 2, 3, 4, 2, 5, 6, 0x40, 3, 0, 0, 0)},
+		{1000, false, []byte{0x40, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{1000, true, []byte{0x41, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		// QUIC version 2
+		{1001, false, quicInitial(t, quicClientV2, quicDCID, nil, 0, quicCryptoFrame(0, clientHello))},
+		// wrong keys (not decrypted), truncated packet, and garbage (not dissected)
+		{1002, false, quicInitial(t, quicServerV1, quicDCID, nil, 0, quicCryptoFrame(0, clientHello))},
+		{1003, false, quicInitial(t, quicClientV1, quicDCID, nil, 0, quicCryptoFrame(0, clientHello))[:500]},
+		{1004, false, []byte{0xc0, 0, 0, 0, 1, 0xff, 0xff}},
+	}
+	lines := quicFlows(t, quicFeatures, datagrams)
+	ja4 := "q13d1516h2_8daaf6152771_e5627efa2ab1"
+	expected := []string{
+		"1000,1,Initial,8,4,4,2,example.com,h2,http/1.1,4865," + ja4,
+		"1001,1798521807,Initial,8,0,1,0,example.com,h2,http/1.1,<nil>," + ja4,
+		"1002,1,Initial,8,0,1,0,<nil>,<nil>,<nil>,<nil>",
+		"1003,<nil>,<nil>,<nil>,<nil>,0,0,<nil>,<nil>,<nil>,<nil>",
+		"1004,<nil>,<nil>,<nil>,<nil>,0,0,<nil>,<nil>,<nil>,<nil>",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
@@ -0,0 +1,390 @@
+package custom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/modules/features"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// SSH parser working on the reassembled TCP streams (see __tcpStream). It reads the protocol version exchange and the
+// unencrypted binary packets of the key exchange until NEWKEYS. Everything afterwards is only counted.
+
+const (
+	sshMaxBanner     = 8192
+	sshMaxPacket     = 35000
+	sshMsgKexInit    = 20
+	sshMsgNewKeys    = 21
+	sshKexInitCookie = 16
+	sshNameLists     = 10
+)
+
+// indices of the name-lists of KEXINIT
+const (
+	sshKexAlgorithms = iota
+	sshHostKeyAlgorithms
+	sshEncryptionClientToServer
+	sshEncryptionServerToClient
+	sshMACClientToServer
+	sshMACServerToClient
+	sshCompressionClientToServer
+	sshCompressionServerToClient
+)
+
+const (
+	sshStateBanner = iota
+	sshStatePackets
+	sshStateEncrypted
+	sshStateLost
+)
+
+// sshBanner is emitted for the protocol version line
+type sshBanner struct {
+	forward bool
+	banner  string
+}
+
+// sshKexInit is emitted for every unencrypted KEXINIT message
+type sshKexInit struct {
+	forward bool
+	lists   [sshNameLists]string
+}
+
+// sshEncrypted is emitted for the data of a direction after NEWKEYS
+type sshEncrypted struct {
+	forward bool
+	length  int
+}
+
+type sshHalf struct {
+	state  int
+	buffer []byte
+}
+
+// sshParser parses both directions of a connection and calls emit for every *sshBanner, *sshKexInit, and
+// *sshEncrypted
+type sshParser struct {
+	halfs [2]sshHalf
+	emit  func(interface{})
+}
+
+func (p *sshParser) reset() {
+	for i := range p.halfs {
+		p.halfs[i].state = sshStateBanner
+		p.halfs[i].buffer = p.halfs[i].buffer[:0]
+	}
+}
+
+func (p *sshParser) chunk(chunk *features.StreamChunk) {
+	h := &p.halfs[0]
+	if !chunk.Forward {
+		h = &p.halfs[1]
+	}
+	if chunk.Gap != 0 && h.state != sshStateEncrypted {
+		h.state = sshStateLost
+		h.buffer = h.buffer[:0]
+	}
+	switch h.state {
+	case sshStateLost:
+		return
+	case sshStateEncrypted:
+		if len(chunk.Data) > 0 {
+			p.emit(&sshEncrypted{forward: chunk.Forward, length: len(chunk.Data)})
+		}
+		return
+	}
+	h.buffer = append(h.buffer, chunk.Data...)
+	data := h.buffer
+	for h.state == sshStateBanner {
+		line := bytes.IndexByte(data, '\n')
+		if line < 0 {
+			if len(h.buffer) > sshMaxBanner {
+				h.state = sshStateLost
+			}
+			break
+		}
+		text := strings.TrimRight(string(data[:line]), "\r")
+		data = data[line+1:]
+		if strings.HasPrefix(text, "SSH-") {
+			p.emit(&sshBanner{forward: chunk.Forward, banner: text})
+			if strings.HasPrefix(text, "SSH-2.0-") || strings.HasPrefix(text, "SSH-1.99-") {
+				h.state = sshStatePackets
+			} else {
+				// SSH 1 uses a different packet format
+				h.state = sshStateLost
+			}
+		}
+	}
+	for h.state == sshStatePackets && len(data) >= 5 {
+		length := int(binary.BigEndian.Uint32(data))
+		padding := int(data[4])
+		if length > sshMaxPacket || padding+1 > length {
+			h.state = sshStateLost
+			break
+		}
+		if len(data) < 4+length {
+			break
+		}
+		payload := data[5 : 4+length-padding]
+		data = data[4+length:]
+		if len(payload) == 0 {
+			continue
+		}
+		switch payload[0] {
+		case sshMsgKexInit:
+			if kex := parseSSHKexInit(payload, chunk.Forward); kex != nil {
+				p.emit(kex)
+			}
+		case sshMsgNewKeys:
+			h.state = sshStateEncrypted
+			if len(data) > 0 {
+				p.emit(&sshEncrypted{forward: chunk.Forward, length: len(data)})
+			}
+			data = nil
+		}
+	}
+	if h.state == sshStateLost || h.state == sshStateEncrypted {
+		h.buffer = h.buffer[:0]
+		return
+	}
+	h.buffer = h.buffer[:copy(h.buffer, data)]
+}
+
+func parseSSHKexInit(payload []byte, forward bool) *sshKexInit {
+	ret := &sshKexInit{forward: forward}
+	data := payload[1:]
+	if len(data) < sshKexInitCookie {
+		return nil
+	}
+	data = data[sshKexInitCookie:]
+	for i := range ret.lists {
+		if len(data) < 4 {
+			return nil
+		}
+		n := binary.BigEndian.Uint32(data)
+		if uint64(len(data)-4) < uint64(n) {
+			return nil
+		}
+		ret.lists[i] = string(data[4 : 4+n])
+		data = data[4+n:]
+	}
+	return ret
+}
+
+func (p *sshParser) checkpoint(c *flows.Checkpoint) {
+	for i := range p.halfs {
+		c.Int(&p.halfs[i].state)
+		c.Bytes(&p.halfs[i].buffer)
+	}
+}
+
+// hassh returns the HASSH (client) or HASSHServer string of a KEXINIT
+func (k *sshKexInit) hassh() string {
+	if k.forward {
+		return strings.Join([]string{k.lists[sshKexAlgorithms], k.lists[sshEncryptionClientToServer], k.lists[sshMACClientToServer], k.lists[sshCompressionClientToServer]}, ";")
+	}
+	return strings.Join([]string{k.lists[sshKexAlgorithms], k.lists[sshEncryptionServerToClient], k.lists[sshMACServerToClient], k.lists[sshCompressionServerToClient]}, ";")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// __ssh emits the banners, KEXINIT messages, and encrypted data of the connection
+type _ssh struct {
+	flows.BaseFeature
+	parser  sshParser
+	context *flows.EventContext
+}
+
+func newSSH() flows.Feature {
+	f := &_ssh{}
+	f.parser.emit = func(event interface{}) {
+		f.Emit(event, f.context, f)
+	}
+	return f
+}
+
+func (f *_ssh) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.parser.reset()
+}
+
+func (f *_ssh) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.context = context
+	f.parser.chunk(new.(*features.StreamChunk))
+}
+
+func (f *_ssh) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.parser.checkpoint(c)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// sshField returns a field of a banner or KEXINIT event and if the field is present
+type sshField func(event interface{}) (string, bool)
+
+type _sshField struct {
+	flows.BaseFeature
+	field sshField
+}
+
+func (f *_sshField) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() != nil {
+		return
+	}
+	if value, ok := f.field(new); ok {
+		f.SetValue(value, context, f)
+	}
+}
+
+// Checkpoint saves or restores the value; field is set when the feature is made
+func (f *_sshField) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+}
+
+// registerSSHField registers name as composite feature of the field from the first event having this field
+func registerSSHField(name, description string, field sshField) {
+	flows.RegisterTemporaryFeature("_"+name, description, ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &_sshField{field: field} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature(name, description, ipfix.StringType, 0, "_"+name, []interface{}{"__ssh", "__tcpStream"})
+}
+
+func sshBannerField(forward bool) sshField {
+	return func(event interface{}) (string, bool) {
+		if banner, ok := event.(*sshBanner); ok && banner.forward == forward {
+			return banner.banner, true
+		}
+		return "", false
+	}
+}
+
+func sshKexInitField(forward bool, value func(*sshKexInit) string) sshField {
+	return func(event interface{}) (string, bool) {
+		if kex, ok := event.(*sshKexInit); ok && kex.forward == forward {
+			return value(kex), true
+		}
+		return "", false
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// _sshAuthAttempts estimates the number of authentication attempts from the sizes of the encrypted data. After the key
+// exchange, every request of the client (a burst of client data) is answered by the server (a burst of server data).
+// The first exchange is the service request. The following exchanges are counted as authentication attempts as long
+// as the answers of the server have the same size as the first one (the USERAUTH_FAILURE messages of a server have
+// the same size). The first answer of a different size ends the authentication and is counted as last attempt.
+type _sshAuthAttempts struct {
+	flows.BaseFeature
+	exchanges   uint64
+	attempts    uint64
+	failureSize int
+	request     int
+	response    int
+	done        bool
+}
+
+func (f *_sshAuthAttempts) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.exchanges = 0
+	f.attempts = 0
+	f.failureSize = 0
+	f.request = 0
+	f.response = 0
+	f.done = false
+}
+
+// exchange returns the attempts and if authentication is done, after the given exchange is completed
+func (f *_sshAuthAttempts) exchange(response int) (uint64, bool) {
+	switch {
+	case f.exchanges == 0:
+		return 0, false
+	case f.exchanges == 1 || response == f.failureSize:
+		return f.attempts + 1, false
+	}
+	return f.attempts + 1, true
+}
+
+func (f *_sshAuthAttempts) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	encrypted, ok := new.(*sshEncrypted)
+	if !ok || f.done {
+		return
+	}
+	if !encrypted.forward {
+		if f.request != 0 {
+			f.response += encrypted.length
+		}
+		return
+	}
+	if f.response != 0 {
+		// a new request completes the exchange
+		f.attempts, f.done = f.exchange(f.response)
+		if f.exchanges == 1 {
+			f.failureSize = f.response
+		}
+		f.exchanges++
+		f.request = 0
+		f.response = 0
+	}
+	f.request += encrypted.length
+}
+
+func (f *_sshAuthAttempts) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	attempts := f.attempts
+	if !f.done && f.response != 0 {
+		attempts, _ = f.exchange(f.response)
+	}
+	f.SetValue(attempts, context, f)
+}
+
+func (f *_sshAuthAttempts) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.exchanges)
+	c.Uint64(&f.attempts)
+	c.Int(&f.failureSize)
+	c.Int(&f.request)
+	c.Int(&f.response)
+	c.Bool(&f.done)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__ssh", "returns the banners, KEXINIT messages, and encrypted data of an SSH connection", ipfix.OctetArrayType, 0, flows.PacketFeature, newSSH, flows.PacketFeature)
+
+	registerSSHField("_sshClientBanner", "protocol version line of the SSH client", sshBannerField(true))
+	registerSSHField("_sshServerBanner", "protocol version line of the SSH server", sshBannerField(false))
+	for _, direction := range []struct {
+		name    string
+		sender  string
+		forward bool
+		enc     int
+		mac     int
+		comp    int
+	}{
+		{"Client", "client (client to server algorithms)", true, sshEncryptionClientToServer, sshMACClientToServer, sshCompressionClientToServer},
+		{"Server", "server (server to client algorithms)", false, sshEncryptionServerToClient, sshMACServerToClient, sshCompressionServerToClient},
+	} {
+		for _, list := range []struct {
+			name        string
+			description string
+			index       int
+		}{
+			{"KexAlgorithms", "key exchange algorithms", sshKexAlgorithms},
+			{"HostKeyAlgorithms", "host key algorithms", sshHostKeyAlgorithms},
+			{"EncryptionAlgorithms", "encryption algorithms", direction.enc},
+			{"MACAlgorithms", "MAC algorithms", direction.mac},
+			{"CompressionAlgorithms", "compression algorithms", direction.comp},
+		} {
+			index := list.index
+			registerSSHField("_ssh"+direction.name+list.name, list.description+" of the first KEXINIT of the "+direction.sender, sshKexInitField(direction.forward, func(k *sshKexInit) string { return k.lists[index] }))
+		}
+	}
+	registerSSHField("_sshHASSHString", "HASSH string of the first KEXINIT of the client", sshKexInitField(true, func(k *sshKexInit) string { return k.hassh() }))
+	registerSSHField("_sshHASSH", "HASSH fingerprint (md5 of the HASSH string) of the first KEXINIT of the client", sshKexInitField(true, func(k *sshKexInit) string { return md5Hex(k.hassh()) }))
+	registerSSHField("_sshHASSHServerString", "HASSHServer string of the first KEXINIT of the server", sshKexInitField(false, func(k *sshKexInit) string { return k.hassh() }))
+	registerSSHField("_sshHASSHServer", "HASSHServer fingerprint (md5 of the HASSHServer string) of the first KEXINIT of the server", sshKexInitField(false, func(k *sshKexInit) string { return md5Hex(k.hassh()) }))
+
+	flows.RegisterTemporaryFeature("__sshAuthAttempts", "estimated number of SSH authentication attempts", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_sshAuthAttempts{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_sshAuthAttempts", "estimated number of SSH authentication attempts", ipfix.Unsigned64Type, 0, "__sshAuthAttempts", []interface{}{"__ssh", "__tcpStream"})
+}
@@ -0,0 +1,133 @@
+package custom_test
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+// sshPacket returns payload as unencrypted binary packet with padding to a multiple of 8 bytes
+func sshPacket(payload []byte) []byte {
+	padding := 8 - (5+len(payload))%8
+	if padding < 4 {
+		padding += 8
+	}
+	ret := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(ret, uint32(1+len(payload)+padding))
+	ret[4] = byte(padding)
+	ret = append(ret, payload...)
+	return append(ret, make([]byte, padding)...)
+}
+
+// sshKexInit returns a KEXINIT packet with the given name-lists (empty languages)
+func sshKexInit(lists ...string) []byte {
+	payload := append([]byte{20}, make([]byte, 16)...) // cookie
+	for _, list := range append(lists, "", "") {
+		payload = append(payload, tlsVector(4, []byte(list))...)
+	}
+	payload = append(payload, 0, 0, 0, 0, 0) // first_kex_packet_follows, reserved
+	return sshPacket(payload)
+}
+
+var (
+	sshClientKexInit = sshKexInit(
+		"curve25519-sha256,curve25519-sha256@libssh.org,ecdh-sha2-nistp256,diffie-hellman-group14-sha256",
+		"ssh-ed25519,rsa-sha2-512",
+		"chacha20-poly1305@openssh.com,aes128-ctr,aes256-gcm@openssh.com", "aes128-ctr",
+		"umac-64-etm@openssh.com,hmac-sha2-256", "hmac-sha1",
+		"none,zlib@openssh.com", "none",
+	)
+	sshServerKexInit = sshKexInit(
+		"curve25519-sha256,ecdh-sha2-nistp256",
+		"ssh-ed25519",
+		"aes128-gcm@openssh.com", "aes256-ctr,aes128-ctr",
+		"hmac-sha1", "hmac-sha2-512,hmac-sha2-256",
+		"zlib", "none",
+	)
+	sshNewKeys = sshPacket([]byte{21})
+)
+
+var sshFeatures = []interface{}{"_sshClientBanner", "_sshServerBanner", "_sshClientHostKeyAlgorithms", "_sshServerEncryptionAlgorithms",
+	"_sshHASSHString", "_sshHASSH", "_sshHASSHServerString", "_sshHASSHServer", "_sshAuthAttempts"}
+
+func TestSSH(t *testing.T) {
+	clientBanner := []byte("SSH-2.0-OpenSSH_8.9p1 Ubuntu-3\r\n")
+	serverBanner := []byte("SSH-2.0-OpenSSH_7.4\r\n")
+	// banner and KEXINIT in one message, NEWKEYS followed by the encrypted service request; three failed
+	// authentication attempts (same answer size as the service accept) and a successful one
+	login := tcpSession(1000,
+		concat(clientBanner, sshClientKexInit),
+		concat([]byte("a line before the banner\r\n"), serverBanner, sshServerKexInit),
+		sshPacket(append([]byte{30}, make([]byte, 36)...)),
+		concat(sshPacket(append([]byte{31}, make([]byte, 200)...)), sshNewKeys),
+		concat(sshNewKeys, make([]byte, 52)),
+		make([]byte, 52),
+		make([]byte, 100),
+		make([]byte, 60),
+		make([]byte, 100),
+		make([]byte, 60),
+		make([]byte, 100),
+		make([]byte, 28),
+		make([]byte, 80),
+	)
+	// every byte in its own segment
+	var chunks []streamChunk
+	for _, b := range concat(clientBanner, sshClientKexInit) {
+		chunks = append(chunks, streamChunk{false, []byte{b}})
+	}
+	bytewise := streamSession(1001, chunks...)
+	// SSH 1 and packets after a gap are not parsed (the gap is in the KEXINIT of the server)
+	ssh1 := tcpSession(1002, concat([]byte("SSH-1.5-client\n"), sshClientKexInit), concat(serverBanner, sshServerKexInit))
+	gap := tcpSession(1003, concat(clientBanner, sshClientKexInit), serverBanner, sshClientKexInit, sshServerKexInit)
+	for i := range gap {
+		if gap[i].reverse && len(gap[i].payload) > 0 && gap[i].ms >= 70 {
+			gap[i].tcp.Seq += 10
+		}
+	}
+	lines := sessionFlows(t, sshFeatures, login, bytewise, ssh1, gap)
+	hassh := "curve25519-sha256,curve25519-sha256@libssh.org,ecdh-sha2-nistp256,diffie-hellman-group14-sha256;" +
+		"chacha20-poly1305@openssh.com,aes128-ctr,aes256-gcm@openssh.com;umac-64-etm@openssh.com,hmac-sha2-256;none,zlib@openssh.com"
+	hasshServer := "curve25519-sha256,ecdh-sha2-nistp256;aes256-ctr,aes128-ctr;hmac-sha2-512,hmac-sha2-256;none"
+	client := "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3"
+	expected := []string{
+		"1000," + client + ",SSH-2.0-OpenSSH_7.4,ssh-ed25519,rsa-sha2-512,aes256-ctr,aes128-ctr," + hassh + ",8782a7d3fe5559a8628a2cf1aeb6ebce," +
+			hasshServer + ",f8f493a18d7c66817c9433150dab62f4,3",
+		"1001," + client + ",<nil>,ssh-ed25519,rsa-sha2-512,<nil>," + hassh + ",8782a7d3fe5559a8628a2cf1aeb6ebce,<nil>,<nil>,0",
+		"1002,SSH-1.5-client,SSH-2.0-OpenSSH_7.4,<nil>,aes256-ctr,aes128-ctr,<nil>,<nil>," + hasshServer + ",f8f493a18d7c66817c9433150dab62f4,0",
+		"1003," + client + ",SSH-2.0-OpenSSH_7.4,ssh-ed25519,rsa-sha2-512,<nil>," + hassh + ",8782a7d3fe5559a8628a2cf1aeb6ebce,<nil>,<nil>,0",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestSSHMalformed(t *testing.T) {
+	// every truncation and every corrupted byte of a KEXINIT packet after a banner
+	banner := []byte("SSH-2.0-client\r\n")
+	var sessions [][]tcpSegment
+	port := layers.TCPPort(1000)
+	for n := 0; n < len(sshClientKexInit); n++ {
+		corrupt := append([]byte(nil), sshClientKexInit...)
+		corrupt[n] ^= 0xff
+		sessions = append(sessions,
+			streamSession(port, streamChunk{false, concat(banner, sshClientKexInit[:n])}),
+			streamSession(port+1, streamChunk{false, concat(banner, corrupt, sshNewKeys)}, streamChunk{false, make([]byte, 10)}),
+		)
+		port += 2
+	}
+	// a banner without line end, and packets with invalid lengths and padding
+	sessions = append(sessions,
+		streamSession(port, streamChunk{false, []byte("SSH-2.0-")}, streamChunk{false, make([]byte, 9000)}),
+		streamSession(port+1, streamChunk{false, concat(banner, []byte{0xff, 0xff, 0xff, 0xff, 4})}),
+		streamSession(port+2, streamChunk{false, concat(banner, []byte{0, 0, 0, 4, 4, 20, 0, 0})}),
+		streamSession(port+3, streamChunk{false, concat(banner, []byte{0, 0, 0, 0, 0})}),
+		streamSession(port+4, streamChunk{false, concat(banner, sshPacket([]byte{20}))}),
+	)
+	lines := sessionFlows(t, sshFeatures, sessions...)
+	if len(lines) != len(sessions) {
+		t.Errorf("got %d records, expected %d", len(lines), len(sessions))
+	}
+}
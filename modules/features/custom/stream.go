@@ -0,0 +1,110 @@
+package custom
+
+import (
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/modules/features"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// __tcpStream reassembles the TCP connection and emits the ordered byte streams of both directions as
+// *features.StreamChunk. Application layer features use it as argument instead of looking at single packets.
+type _tcpStream struct {
+	flows.BaseFeature
+	stream  *features.TCPStream
+	context *flows.EventContext
+	emit    func(*features.StreamChunk)
+}
+
+func newTCPStream() flows.Feature {
+	f := &_tcpStream{stream: features.NewTCPStream(features.DefaultStreamOptions)}
+	f.emit = func(chunk *features.StreamChunk) {
+		// chunks are not kept as value, since they are only valid during the event
+		f.Emit(chunk, f.context, f)
+	}
+	return f
+}
+
+func (f *_tcpStream) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.stream.Reset()
+}
+
+func (f *_tcpStream) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	tcp := features.GetTCP(new)
+	if tcp == nil {
+		return
+	}
+	f.context = context
+	f.stream.Packet(tcp, context.Forward(), f.emit)
+}
+
+func (f *_tcpStream) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.context = context
+	f.stream.Flush(f.emit)
+}
+
+func (f *_tcpStream) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.stream.Checkpoint(c)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__tcpStream", "returns the reassembled byte streams of both directions of a TCP connection", ipfix.OctetArrayType, 0, flows.PacketFeature, newTCPStream, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _tcpStreamPayload struct {
+	flows.BaseFeature
+}
+
+func (f *_tcpStreamPayload) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	chunk := new.(*features.StreamChunk)
+	if len(chunk.Data) == 0 {
+		return
+	}
+	f.SetValue(string(chunk.Data), context, f)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__tcpStreamPayload", "returns the data of stream chunks", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_tcpStreamPayload{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_tcpReorderPayload", "reassembled payload of a TCP connection in order of arrival of the bytes", ipfix.StringType, 0, "__tcpStreamPayload", "__tcpStream")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _tcpStreamOctets struct {
+	flows.BaseFeature
+	count uint64
+	gaps  bool
+}
+
+func (f *_tcpStreamOctets) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.count = 0
+}
+
+func (f *_tcpStreamOctets) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	chunk := new.(*features.StreamChunk)
+	if f.gaps {
+		f.count += uint64(chunk.Gap)
+	} else {
+		f.count += uint64(len(chunk.Data))
+	}
+}
+
+func (f *_tcpStreamOctets) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.SetValue(f.count, context, f)
+}
+
+func (f *_tcpStreamOctets) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__tcpStreamOctetCount", "number of bytes in the stream chunks", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_tcpStreamOctets{} }, flows.PacketFeature)
+	flows.RegisterTemporaryFeature("__tcpStreamGapOctetCount", "number of missing bytes in the stream chunks", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_tcpStreamOctets{gaps: true} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_tcpStreamOctetCount", "number of reassembled bytes of a TCP connection (both directions)", ipfix.Unsigned64Type, 0, "__tcpStreamOctetCount", "__tcpStream")
+	flows.RegisterTemporaryCompositeFeature("_tcpStreamGapOctetCount", "number of bytes missing in the reassembled streams of a TCP connection (both directions)", ipfix.Unsigned64Type, 0, "__tcpStreamGapOctetCount", "__tcpStream")
+}
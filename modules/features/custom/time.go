@@ -22,6 +22,11 @@ func (f *_interPacketTimeNanoseconds) Event(new interface{}, context *flows.Even
 	f.time = context.When()
 }
 
+func (f *_interPacketTimeNanoseconds) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Time(&f.time)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_interPacketTimeNanoseconds", "time difference between consecutive packets", ipfix.Signed64Type, 0, flows.PacketFeature, func() flows.Feature { return &_interPacketTimeNanoseconds{} }, flows.RawPacket)
 	flows.RegisterTemporaryCompositeFeature("_interPacketTimeMicroseconds", "time difference between consecutive packets", ipfix.Signed64Type, 0, "divide", "_interPacketTimeNanoseconds", 1000)
@@ -0,0 +1,218 @@
+package custom
+
+import (
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/modules/features"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// TLS record and handshake parser working on the reassembled TCP streams (see __tcpStream). Handshake messages are
+// reassembled across records and segments, until the handshake of a direction is encrypted (ChangeCipherSpec or TLS
+// 1.3). A direction is not parsed anymore after a gap or an invalid record header.
+
+const (
+	tlsRecordHeader     = 5
+	tlsMaxRecord        = 1<<14 + 2048
+	tlsMaxHandshake     = 1 << 18
+	tlsHandshakeHeader  = 4
+	tlsChangeCipherSpec = 20
+	tlsAlert            = 21
+	tlsHandshake        = 22
+	tlsApplicationData  = 23
+	tlsHeartbeat        = 24
+)
+
+// tlsRecord is emitted for the header of every TLS record
+type tlsRecord struct {
+	forward     bool
+	contentType uint8
+	version     uint16
+	length      int
+}
+
+// tlsHandshakeMessage is emitted for every unencrypted handshake message. body is only valid during the event.
+type tlsHandshakeMessage struct {
+	forward bool
+	msgType uint8
+	body    []byte
+}
+
+// tlsHalf parses one direction of a connection
+type tlsHalf struct {
+	header    []byte
+	record    tlsRecord
+	remaining int
+	handshake []byte
+	encrypted bool
+	lost      bool
+}
+
+// tlsParser parses both directions of a connection and calls emit for every *tlsRecord, *tlsHandshakeMessage, and
+// *tlsHello
+type tlsParser struct {
+	halfs [2]tlsHalf
+	emit  func(interface{})
+}
+
+func (p *tlsParser) reset() {
+	for i := range p.halfs {
+		h := &p.halfs[i]
+		h.header = h.header[:0]
+		h.remaining = 0
+		h.handshake = h.handshake[:0]
+		h.encrypted = false
+		h.lost = false
+	}
+}
+
+func (p *tlsParser) chunk(chunk *features.StreamChunk) {
+	h := &p.halfs[0]
+	if !chunk.Forward {
+		h = &p.halfs[1]
+	}
+	if chunk.Gap != 0 || chunk.End {
+		h.lost = true
+	}
+	if h.lost {
+		return
+	}
+	data := chunk.Data
+	for len(data) > 0 && !h.lost {
+		if h.remaining == 0 {
+			n := tlsRecordHeader - len(h.header)
+			if n > len(data) {
+				n = len(data)
+			}
+			h.header = append(h.header, data[:n]...)
+			data = data[n:]
+			if len(h.header) < tlsRecordHeader {
+				return
+			}
+			p.recordHeader(h, chunk.Forward)
+			continue
+		}
+		n := h.remaining
+		if n > len(data) {
+			n = len(data)
+		}
+		if h.record.contentType == tlsHandshake && !h.encrypted {
+			p.handshakeData(h, data[:n], chunk.Forward)
+		}
+		h.remaining -= n
+		data = data[n:]
+		if h.remaining == 0 {
+			p.recordEnd(h)
+		}
+	}
+}
+
+func (p *tlsParser) recordHeader(h *tlsHalf, forward bool) {
+	header := h.header
+	h.header = h.header[:0]
+	h.record = tlsRecord{
+		forward:     forward,
+		contentType: header[0],
+		version:     uint16(header[1])<<8 | uint16(header[2]),
+		length:      int(header[3])<<8 | int(header[4]),
+	}
+	if h.record.contentType < tlsChangeCipherSpec || h.record.contentType > tlsHeartbeat ||
+		header[1] != 3 || header[2] > 4 || h.record.length == 0 || h.record.length > tlsMaxRecord {
+		h.lost = true
+		return
+	}
+	record := h.record
+	p.emit(&record)
+	h.remaining = h.record.length
+}
+
+func (p *tlsParser) recordEnd(h *tlsHalf) {
+	switch h.record.contentType {
+	case tlsChangeCipherSpec, tlsApplicationData:
+		// everything afterwards is encrypted
+		h.encrypted = true
+		h.handshake = h.handshake[:0]
+	}
+}
+
+func (p *tlsParser) handshakeData(h *tlsHalf, data []byte, forward bool) {
+	if len(h.handshake)+len(data) > tlsMaxHandshake {
+		h.encrypted = true
+		h.handshake = h.handshake[:0]
+		return
+	}
+	h.handshake = append(h.handshake, data...)
+	messages := h.handshake
+	for len(messages) >= tlsHandshakeHeader {
+		length := int(messages[1])<<16 | int(messages[2])<<8 | int(messages[3])
+		if len(messages) < tlsHandshakeHeader+length {
+			break
+		}
+		message := &tlsHandshakeMessage{forward: forward, msgType: messages[0], body: messages[tlsHandshakeHeader : tlsHandshakeHeader+length]}
+		messages = messages[tlsHandshakeHeader+length:]
+		p.emit(message)
+		switch message.msgType {
+		case tlsClientHello, tlsServerHello:
+			if hello := parseTLSHello(message.body, message.msgType == tlsClientHello, false); hello != nil {
+				p.emit(hello)
+				if !hello.client && hello.negotiatedVersion() >= 0x0304 {
+					// the rest of the TLS 1.3 handshake is encrypted
+					h.encrypted = true
+					h.handshake = h.handshake[:0]
+					return
+				}
+			}
+		}
+	}
+	h.handshake = h.handshake[:copy(h.handshake, messages)]
+}
+
+func (p *tlsParser) checkpoint(c *flows.Checkpoint) {
+	for i := range p.halfs {
+		h := &p.halfs[i]
+		c.Bytes(&h.header)
+		c.Bool(&h.record.forward)
+		c.Uint8(&h.record.contentType)
+		c.Uint16(&h.record.version)
+		c.Int(&h.record.length)
+		c.Int(&h.remaining)
+		c.Bytes(&h.handshake)
+		c.Bool(&h.encrypted)
+		c.Bool(&h.lost)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// __tls emits the TLS records, handshake messages, and hello messages of the connection
+type _tls struct {
+	flows.BaseFeature
+	parser  tlsParser
+	context *flows.EventContext
+}
+
+func newTLS() flows.Feature {
+	f := &_tls{}
+	f.parser.emit = func(event interface{}) {
+		f.Emit(event, f.context, f)
+	}
+	return f
+}
+
+func (f *_tls) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.parser.reset()
+}
+
+func (f *_tls) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.context = context
+	f.parser.chunk(new.(*features.StreamChunk))
+}
+
+func (f *_tls) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.parser.checkpoint(c)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__tls", "returns the TLS records and handshake messages of a TCP stream", ipfix.OctetArrayType, 0, flows.PacketFeature, newTLS, flows.PacketFeature)
+}
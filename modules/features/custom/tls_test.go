@@ -0,0 +1,159 @@
+package custom_test
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func tlsUint16s(values ...uint16) []byte {
+	ret := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(ret[2*i:], v)
+	}
+	return ret
+}
+
+// tlsVector returns data with a length field of the given size in bytes
+func tlsVector(size int, data ...[]byte) []byte {
+	var ret []byte
+	for _, d := range data {
+		ret = append(ret, d...)
+	}
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(ret)))
+	return append(length[4-size:], ret...)
+}
+
+func tlsExtension(typ uint16, data ...[]byte) []byte {
+	return append(tlsUint16s(typ), tlsVector(2, data...)...)
+}
+
+// tlsHelloBody returns the body of a ClientHello (several cipher suites) or ServerHello (one cipher suite)
+func tlsHelloBody(client bool, version uint16, ciphers []uint16, extensions ...[]byte) []byte {
+	ret := append(tlsUint16s(version), make([]byte, 32)...) // random
+	ret = append(ret, tlsVector(1, []byte{1, 2, 3, 4})...)  // session id
+	if client {
+		ret = append(ret, tlsVector(2, tlsUint16s(ciphers...))...)
+		ret = append(ret, tlsVector(1, []byte{0})...) // compression methods
+	} else {
+		ret = append(ret, tlsUint16s(ciphers[0])...)
+		ret = append(ret, 0) // compression method
+	}
+	return append(ret, tlsVector(2, extensions...)...)
+}
+
+func tlsHandshakeMessage(typ byte, body []byte) []byte {
+	return append([]byte{typ}, tlsVector(3, body)...)
+}
+
+// tlsRecords returns data as TLS records of the given content type, which hold at most size bytes
+func tlsRecords(contentType byte, size int, data []byte) []byte {
+	var ret []byte
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		ret = append(ret, contentType, 3, 1)
+		ret = append(ret, tlsVector(2, data[:n])...)
+		data = data[n:]
+	}
+	return ret
+}
+
+// chromeHello is a ClientHello of a chrome browser with GREASE values (see the JA4 documentation)
+var chromeHello = tlsHelloBody(true, 0x0303,
+	[]uint16{0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+	tlsExtension(0x1a1a),
+	tlsExtension(0x0000, tlsVector(2, []byte{0}, tlsVector(2, []byte("example.com")))),
+	tlsExtension(0x0017),
+	tlsExtension(0xff01, []byte{0}),
+	tlsExtension(0x000a, tlsVector(2, tlsUint16s(0x0a0a, 0x001d, 0x0017, 0x0018))),
+	tlsExtension(0x000b, tlsVector(1, []byte{0})),
+	tlsExtension(0x0023),
+	tlsExtension(0x0010, tlsVector(2, tlsVector(1, []byte("h2")), tlsVector(1, []byte("http/1.1")))),
+	tlsExtension(0x0005, []byte{1, 0, 0, 0, 0}),
+	tlsExtension(0x000d, tlsVector(2, tlsUint16s(0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601))),
+	tlsExtension(0x0012),
+	tlsExtension(0x0033, tlsVector(2, tlsUint16s(0x0a0a, 1), []byte{0})),
+	tlsExtension(0x002d, tlsVector(1, []byte{1})),
+	tlsExtension(0x002b, tlsVector(1, tlsUint16s(0x0a0a, 0x0304, 0x0303))),
+	tlsExtension(0x001b, tlsVector(1, tlsUint16s(2))),
+	tlsExtension(0x4469, tlsVector(2, tlsVector(1, []byte("h2")))),
+	tlsExtension(0x2a2a, []byte{0}),
+	tlsExtension(0x0015, make([]byte, 10)),
+)
+
+// ja3Hello is the ClientHello of the example in the JA3 documentation
+var ja3Hello = tlsHelloBody(true, 0x0301,
+	[]uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+	tlsExtension(0x0000, tlsVector(2, []byte{0}, tlsVector(2, []byte("example.com")))),
+	tlsExtension(0x000a, tlsVector(2, tlsUint16s(23, 24, 25))),
+	tlsExtension(0x000b, tlsVector(1, []byte{0})),
+)
+
+var ja3ServerHello = tlsHelloBody(false, 0x0301, []uint16{47},
+	tlsExtension(0xff01, []byte{0}),
+	tlsExtension(0x0000),
+	tlsExtension(0x000b, tlsVector(1, []byte{0})),
+	tlsExtension(0x0023),
+	tlsExtension(0x0005),
+	tlsExtension(0x0010, tlsVector(2, tlsVector(1, []byte("h2")))),
+)
+
+var tlsHelloFeatures = []interface{}{"_tlsServerName", "_tlsClientALPN", "_tlsALPN", "_tlsClientVersion", "_tlsCipherSuite",
+	"_tlsJA3String", "_tlsJA3", "_tlsJA3SString", "_tlsJA3S", "_tlsJA4"}
+
+func TestTLSFingerprints(t *testing.T) {
+	lines := sessionFlows(t, tlsHelloFeatures,
+		tcpSession(1000, tlsRecords(22, 1<<14, tlsHandshakeMessage(1, chromeHello))),
+		tcpSession(1001, tlsRecords(22, 1<<14, tlsHandshakeMessage(1, ja3Hello)), tlsRecords(22, 1<<14, tlsHandshakeMessage(2, ja3ServerHello))),
+		// hellos fragmented into several records
+		tcpSession(1002, tlsRecords(22, 20, tlsHandshakeMessage(1, ja3Hello)), tlsRecords(22, 7, tlsHandshakeMessage(2, ja3ServerHello))),
+	)
+	ja3 := "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0"
+	ja3Line := "example.com,<nil>,h2,769,47," + ja3 + ",ada70206e40642a3e4461f35503241d5,769,47,65281-0-11-35-5-16,836ce314215654b5b1f85f97c73e506f,t10d120300_d94e65cdb899_33a13ba74d1c"
+	expected := []string{
+		"1000,example.com,h2,http/1.1,<nil>,772,<nil>," +
+			"771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0," +
+			"cd08e31494f9531f560d64c695473da9,<nil>,<nil>,t13d1516h2_8daaf6152771_e5627efa2ab1",
+		"1001," + ja3Line,
+		"1002," + ja3Line,
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestTLSMalformedHello(t *testing.T) {
+	// every truncation and every corrupted byte of a hello within valid records and handshake messages
+	var sessions [][]tcpSegment
+	port := layers.TCPPort(1000)
+	for _, hello := range []struct {
+		typ  byte
+		body []byte
+	}{{1, chromeHello}, {2, ja3ServerHello}} {
+		for n := 1; n < len(hello.body); n++ {
+			sessions = append(sessions, tcpSession(port, tlsRecords(22, 1<<14, tlsHandshakeMessage(hello.typ, hello.body[:n]))))
+			port++
+			corrupt := append([]byte(nil), hello.body...)
+			corrupt[n] ^= 0xff
+			sessions = append(sessions, tcpSession(port, tlsRecords(22, 1<<14, tlsHandshakeMessage(hello.typ, corrupt))))
+			port++
+		}
+	}
+	// garbage record headers and handshake lengths
+	sessions = append(sessions,
+		tcpSession(port, []byte{22, 3, 1, 0xff, 0xff, 1, 0xff, 0xff, 0xff}),
+		tcpSession(port+1, []byte{22, 3, 1, 0, 4, 1, 0, 0, 0}),
+		tcpSession(port+2, []byte{22, 3, 1, 0, 0}),
+	)
+	lines := sessionFlows(t, tlsHelloFeatures, sessions...)
+	if len(lines) != len(sessions) {
+		t.Errorf("got %d records, expected %d", len(lines), len(sessions))
+	}
+}
@@ -0,0 +1,85 @@
+package custom
+
+import (
+	"bytes"
+	"crypto/x509"
+
+	"github.com/CN-TU/go-flows/flows"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+const tlsCertificate = 11
+
+// __tlsCertificate emits the first certificate (*x509.Certificate) of every Certificate message sent by the server.
+// Only the handshake of TLS 1.2 and older is unencrypted.
+type _tlsCertificate struct {
+	flows.BaseFeature
+}
+
+func (f *_tlsCertificate) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	message, ok := new.(*tlsHandshakeMessage)
+	if !ok || message.forward || message.msgType != tlsCertificate {
+		return
+	}
+	r := tlsReader(message.body)
+	list, ok := r.vector(3)
+	if !ok {
+		return
+	}
+	der, ok := list.vector(3)
+	if !ok {
+		return
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return
+	}
+	f.Emit(cert, context, f)
+}
+
+// tlsCertificateSANCount returns the number of subject alternative names
+func tlsCertificateSANCount(cert *x509.Certificate) uint64 {
+	return uint64(len(cert.DNSNames) + len(cert.EmailAddresses) + len(cert.IPAddresses) + len(cert.URIs))
+}
+
+// tlsCertificateSelfSigned returns true, if issuer and subject are the same and the certificate is signed by its own key
+func tlsCertificateSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+type _tlsCertificateField struct {
+	flows.BaseFeature
+	field func(*x509.Certificate) interface{}
+}
+
+func (f *_tlsCertificateField) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f.SetValue(f.field(new.(*x509.Certificate)), context, f)
+	}
+}
+
+// Checkpoint saves or restores the value; field is set when the feature is made
+func (f *_tlsCertificateField) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+}
+
+// registerTLSCertificateField registers name as composite feature of the field of the first server certificate
+func registerTLSCertificateField(name, description string, t ipfix.Type, field func(*x509.Certificate) interface{}) {
+	flows.RegisterTemporaryFeature("_"+name, description, t, 0, flows.FlowFeature, func() flows.Feature { return &_tlsCertificateField{field: field} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature(name, description, t, 0, "_"+name, []interface{}{"__tlsCertificate", []interface{}{"__tls", "__tcpStream"}})
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__tlsCertificate", "returns the first certificate of the server certificate chains", ipfix.OctetArrayType, 0, flows.PacketFeature, func() flows.Feature { return &_tlsCertificate{} }, flows.PacketFeature)
+
+	registerTLSCertificateField("_tlsCertificateSubjectCommonName", "common name of the subject of the server certificate (TLS 1.2 and older)", ipfix.StringType, func(cert *x509.Certificate) interface{} { return cert.Subject.CommonName })
+	registerTLSCertificateField("_tlsCertificateIssuer", "issuer of the server certificate (TLS 1.2 and older)", ipfix.StringType, func(cert *x509.Certificate) interface{} { return cert.Issuer.String() })
+	registerTLSCertificateField("_tlsCertificateNotBefore", "start of the validity period of the server certificate (TLS 1.2 and older)", ipfix.DateTimeSecondsType, func(cert *x509.Certificate) interface{} { return flows.DateTimeSeconds(cert.NotBefore.Unix()) })
+	registerTLSCertificateField("_tlsCertificateNotAfter", "end of the validity period of the server certificate (TLS 1.2 and older)", ipfix.DateTimeSecondsType, func(cert *x509.Certificate) interface{} { return flows.DateTimeSeconds(cert.NotAfter.Unix()) })
+	registerTLSCertificateField("_tlsCertificateSANCount", "number of subject alternative names of the server certificate (TLS 1.2 and older)", ipfix.Unsigned64Type, func(cert *x509.Certificate) interface{} { return tlsCertificateSANCount(cert) })
+	registerTLSCertificateField("_tlsCertificateSelfSigned", "true if the server certificate is self-signed (TLS 1.2 and older)", ipfix.BooleanType, func(cert *x509.Certificate) interface{} { return tlsCertificateSelfSigned(cert) })
+	registerTLSCertificateField("_tlsCertificateKeyType", "public key algorithm of the server certificate (TLS 1.2 and older)", ipfix.StringType, func(cert *x509.Certificate) interface{} { return cert.PublicKeyAlgorithm.String() })
+}
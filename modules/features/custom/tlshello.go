@@ -0,0 +1,432 @@
+package custom
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CN-TU/go-flows/flows"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+const (
+	tlsClientHello = 1
+	tlsServerHello = 2
+
+	tlsExtensionServerName          = 0
+	tlsExtensionSupportedGroups     = 10
+	tlsExtensionECPointFormats      = 11
+	tlsExtensionSignatureAlgorithms = 13
+	tlsExtensionALPN                = 16
+	tlsExtensionSupportedVersions   = 43
+)
+
+// tlsHello holds the fields of a ClientHello or ServerHello
+type tlsHello struct {
+	client              bool
+	quic                bool
+	version             uint16
+	cipherSuites        []uint16 // offered cipher suites, or the chosen one
+	extensions          []uint16
+	serverName          string
+	alpn                []string
+	supportedGroups     []uint16
+	pointFormats        []uint8
+	signatureAlgorithms []uint16
+	supportedVersions   []uint16 // offered versions, or the chosen one
+}
+
+// tlsReader reads the fields of TLS messages. All methods return false, if the data is too short.
+type tlsReader []byte
+
+func (r *tlsReader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+func (r *tlsReader) uint8() (uint8, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	ret := (*r)[0]
+	*r = (*r)[1:]
+	return ret, true
+}
+
+func (r *tlsReader) uint16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	ret := uint16((*r)[0])<<8 | uint16((*r)[1])
+	*r = (*r)[2:]
+	return ret, true
+}
+
+// vector reads a vector with a length field of the given size in bytes
+func (r *tlsReader) vector(size int) (tlsReader, bool) {
+	if len(*r) < size {
+		return nil, false
+	}
+	n := 0
+	for i := 0; i < size; i++ {
+		n = n<<8 | int((*r)[i])
+	}
+	if len(*r) < size+n {
+		return nil, false
+	}
+	ret := (*r)[size : size+n]
+	*r = (*r)[size+n:]
+	return ret, true
+}
+
+func (r *tlsReader) uint16s() ([]uint16, bool) {
+	var ret []uint16
+	for len(*r) > 0 {
+		v, ok := r.uint16()
+		if !ok {
+			return nil, false
+		}
+		ret = append(ret, v)
+	}
+	return ret, true
+}
+
+// parseTLSHello returns the parsed ClientHello or ServerHello message body, or nil if the message is invalid
+func parseTLSHello(body []byte, client bool, quic bool) *tlsHello {
+	r := tlsReader(body)
+	ret := &tlsHello{client: client, quic: quic}
+	var ok bool
+	if ret.version, ok = r.uint16(); !ok {
+		return nil
+	}
+	if !r.skip(32) { // random
+		return nil
+	}
+	if _, ok = r.vector(1); !ok { // session id
+		return nil
+	}
+	if client {
+		ciphers, ok := r.vector(2)
+		if !ok {
+			return nil
+		}
+		if ret.cipherSuites, ok = ciphers.uint16s(); !ok {
+			return nil
+		}
+		if _, ok = r.vector(1); !ok { // compression methods
+			return nil
+		}
+	} else {
+		cipher, ok := r.uint16()
+		if !ok {
+			return nil
+		}
+		ret.cipherSuites = []uint16{cipher}
+		if !r.skip(1) { // compression method
+			return nil
+		}
+	}
+	if len(r) == 0 {
+		// no extensions
+		return ret
+	}
+	extensions, ok := r.vector(2)
+	if !ok {
+		return nil
+	}
+	for len(extensions) > 0 {
+		typ, ok := extensions.uint16()
+		if !ok {
+			return nil
+		}
+		data, ok := extensions.vector(2)
+		if !ok {
+			return nil
+		}
+		ret.extensions = append(ret.extensions, typ)
+		ret.extension(typ, data)
+	}
+	return ret
+}
+
+// extension parses the data of an extension; invalid extensions are ignored
+func (h *tlsHello) extension(typ uint16, data tlsReader) {
+	switch typ {
+	case tlsExtensionServerName:
+		list, _ := data.vector(2)
+		for len(list) > 0 {
+			nameType, _ := list.uint8()
+			name, ok := list.vector(2)
+			if !ok {
+				return
+			}
+			if nameType == 0 {
+				h.serverName = string(name)
+				return
+			}
+		}
+	case tlsExtensionSupportedGroups:
+		list, _ := data.vector(2)
+		h.supportedGroups, _ = list.uint16s()
+	case tlsExtensionECPointFormats:
+		list, _ := data.vector(1)
+		h.pointFormats = append([]uint8(nil), list...)
+	case tlsExtensionSignatureAlgorithms:
+		list, _ := data.vector(2)
+		h.signatureAlgorithms, _ = list.uint16s()
+	case tlsExtensionALPN:
+		list, _ := data.vector(2)
+		for len(list) > 0 {
+			protocol, ok := list.vector(1)
+			if !ok {
+				return
+			}
+			h.alpn = append(h.alpn, string(protocol))
+		}
+	case tlsExtensionSupportedVersions:
+		if h.client {
+			list, _ := data.vector(1)
+			h.supportedVersions, _ = list.uint16s()
+		} else {
+			h.supportedVersions, _ = data.uint16s()
+		}
+	}
+}
+
+// tlsGREASE returns true for the reserved GREASE values (RFC 8701)
+func tlsGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	ret := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !tlsGREASE(v) {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// negotiatedVersion returns the highest offered version of a ClientHello, or the chosen version of a ServerHello
+func (h *tlsHello) negotiatedVersion() uint16 {
+	versions := withoutGREASE(h.supportedVersions)
+	if len(versions) == 0 {
+		return h.version
+	}
+	ret := versions[0]
+	for _, v := range versions[1:] {
+		if v > ret {
+			ret = v
+		}
+	}
+	return ret
+}
+
+func joinDecimal(values []uint16) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, "-")
+}
+
+func joinHex(values []uint16) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// ja3 returns the JA3 (ClientHello) or JA3S (ServerHello) string
+func (h *tlsHello) ja3() string {
+	if !h.client {
+		return fmt.Sprintf("%d,%s,%s", h.version, joinDecimal(h.cipherSuites), joinDecimal(withoutGREASE(h.extensions)))
+	}
+	formats := make([]uint16, len(h.pointFormats))
+	for i, v := range h.pointFormats {
+		formats[i] = uint16(v)
+	}
+	return fmt.Sprintf("%d,%s,%s,%s,%s", h.version, joinDecimal(withoutGREASE(h.cipherSuites)),
+		joinDecimal(withoutGREASE(h.extensions)), joinDecimal(withoutGREASE(h.supportedGroups)), joinDecimal(formats))
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	case 0xfeff:
+		return "d1"
+	case 0xfefd:
+		return "d2"
+	case 0xfefc:
+		return "d3"
+	}
+	return "00"
+}
+
+func alphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ja4 returns the JA4 fingerprint of a ClientHello
+func (h *tlsHello) ja4() string {
+	var a strings.Builder
+	if h.quic {
+		a.WriteByte('q')
+	} else {
+		a.WriteByte('t')
+	}
+	a.WriteString(ja4Version(h.negotiatedVersion()))
+	if h.serverName != "" {
+		a.WriteByte('d')
+	} else {
+		a.WriteByte('i')
+	}
+	ciphers := withoutGREASE(h.cipherSuites)
+	extensions := withoutGREASE(h.extensions)
+	count := func(n int) {
+		if n > 99 {
+			n = 99
+		}
+		fmt.Fprintf(&a, "%02d", n)
+	}
+	count(len(ciphers))
+	count(len(extensions))
+	if len(h.alpn) == 0 || h.alpn[0] == "" {
+		a.WriteString("00")
+	} else if alpn := h.alpn[0]; alphanumeric(alpn[0]) && alphanumeric(alpn[len(alpn)-1]) {
+		a.WriteByte(alpn[0])
+		a.WriteByte(alpn[len(alpn)-1])
+	} else {
+		x := hex.EncodeToString([]byte(alpn))
+		a.WriteByte(x[0])
+		a.WriteByte(x[len(x)-1])
+	}
+
+	sort.Slice(ciphers, func(i, j int) bool { return ciphers[i] < ciphers[j] })
+	sorted := extensions[:0]
+	for _, e := range extensions {
+		if e != tlsExtensionServerName && e != tlsExtensionALPN {
+			sorted = append(sorted, e)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	c := joinHex(sorted)
+	if len(h.signatureAlgorithms) > 0 && c != "" {
+		c += "_" + joinHex(h.signatureAlgorithms)
+	}
+	return a.String() + "_" + ja4Hash(joinHex(ciphers)) + "_" + ja4Hash(c)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// tlsField returns a field of a hello message and if the field is present
+type tlsField func(*tlsHello) (interface{}, bool)
+
+type _tlsFieldPacket struct {
+	flows.BaseFeature
+	field tlsField
+}
+
+func (f *_tlsFieldPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if hello, ok := new.(*tlsHello); ok {
+		if value, ok := f.field(hello); ok {
+			f.SetValue(value, context, f)
+		}
+	}
+}
+
+// Checkpoint saves or restores the value; field is set when the feature is made
+func (f *_tlsFieldPacket) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+}
+
+type _tlsFieldFlow struct {
+	_tlsFieldPacket
+}
+
+func (f *_tlsFieldFlow) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f._tlsFieldPacket.Event(new, context, src)
+	}
+}
+
+// registerTLSField registers name as composite feature of the field from the first hello message (FlowFeature) or
+// every hello message (PacketFeature) having this field
+func registerTLSField(name, description string, t ipfix.Type, field tlsField) {
+	flows.RegisterTemporaryFeature("_"+name, description, t, 0, flows.FlowFeature, func() flows.Feature { return &_tlsFieldFlow{_tlsFieldPacket{field: field}} }, flows.PacketFeature)
+	flows.RegisterTemporaryFeature("_"+name, description, t, 0, flows.PacketFeature, func() flows.Feature { return &_tlsFieldPacket{field: field} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature(name, description, t, 0, "_"+name, []interface{}{"__tls", "__tcpStream"})
+}
+
+func tlsClientField(value func(*tlsHello) string) tlsField {
+	return func(h *tlsHello) (interface{}, bool) {
+		if !h.client {
+			return nil, false
+		}
+		ret := value(h)
+		return ret, ret != ""
+	}
+}
+
+func tlsServerField(value func(*tlsHello) string) tlsField {
+	return func(h *tlsHello) (interface{}, bool) {
+		if h.client {
+			return nil, false
+		}
+		ret := value(h)
+		return ret, ret != ""
+	}
+}
+
+func init() {
+	registerTLSField("_tlsServerName", "server name indication of the first ClientHello", ipfix.StringType, tlsClientField(func(h *tlsHello) string { return h.serverName }))
+	registerTLSField("_tlsClientALPN", "application protocols offered in the first ClientHello (comma separated)", ipfix.StringType, tlsClientField(func(h *tlsHello) string { return strings.Join(h.alpn, ",") }))
+	registerTLSField("_tlsALPN", "application protocol chosen in the first ServerHello", ipfix.StringType, tlsServerField(func(h *tlsHello) string { return strings.Join(h.alpn, ",") }))
+	registerTLSField("_tlsClientVersion", "highest TLS version offered in the first ClientHello", ipfix.Unsigned16Type, func(h *tlsHello) (interface{}, bool) { return h.negotiatedVersion(), h.client })
+	registerTLSField("_tlsVersion", "TLS version chosen in the first ServerHello", ipfix.Unsigned16Type, func(h *tlsHello) (interface{}, bool) { return h.negotiatedVersion(), !h.client })
+	registerTLSField("_tlsClientSupportedVersions", "TLS versions offered in the supported_versions extension of the first ClientHello (hex, comma separated)", ipfix.StringType, tlsClientField(func(h *tlsHello) string { return joinHex(h.supportedVersions) }))
+	registerTLSField("_tlsClientCipherSuites", "cipher suites offered in the first ClientHello (hex, comma separated)", ipfix.StringType, tlsClientField(func(h *tlsHello) string { return joinHex(h.cipherSuites) }))
+	registerTLSField("_tlsCipherSuite", "cipher suite chosen in the first ServerHello", ipfix.Unsigned16Type, func(h *tlsHello) (interface{}, bool) { return h.cipherSuites[0], !h.client })
+	registerTLSField("_tlsClientExtensions", "extension types of the first ClientHello (hex, comma separated)", ipfix.StringType, tlsClientField(func(h *tlsHello) string { return joinHex(h.extensions) }))
+	registerTLSField("_tlsServerExtensions", "extension types of the first ServerHello (hex, comma separated)", ipfix.StringType, tlsServerField(func(h *tlsHello) string { return joinHex(h.extensions) }))
+	registerTLSField("_tlsSupportedGroups", "supported groups of the first ClientHello (hex, comma separated)", ipfix.StringType, tlsClientField(func(h *tlsHello) string { return joinHex(h.supportedGroups) }))
+	registerTLSField("_tlsSignatureAlgorithms", "signature algorithms of the first ClientHello (hex, comma separated)", ipfix.StringType, tlsClientField(func(h *tlsHello) string { return joinHex(h.signatureAlgorithms) }))
+	registerTLSField("_tlsJA3String", "JA3 string of the first ClientHello", ipfix.StringType, tlsClientField(func(h *tlsHello) string { return h.ja3() }))
+	registerTLSField("_tlsJA3", "JA3 fingerprint (md5 of the JA3 string) of the first ClientHello", ipfix.StringType, tlsClientField(func(h *tlsHello) string { return md5Hex(h.ja3()) }))
+	registerTLSField("_tlsJA3SString", "JA3S string of the first ServerHello", ipfix.StringType, tlsServerField(func(h *tlsHello) string { return h.ja3() }))
+	registerTLSField("_tlsJA3S", "JA3S fingerprint (md5 of the JA3S string) of the first ServerHello", ipfix.StringType, tlsServerField(func(h *tlsHello) string { return md5Hex(h.ja3()) }))
+	registerTLSField("_tlsJA4", "JA4 fingerprint of the first ClientHello", ipfix.StringType, tlsClientField(func(h *tlsHello) string { return h.ja4() }))
+}
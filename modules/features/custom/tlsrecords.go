@@ -0,0 +1,140 @@
+package custom
+
+import (
+	"github.com/CN-TU/go-flows/flows"
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// __tlsRecords emits the records of __tls with the content type they had before encryption, as far as known. In TLS
+// 1.3, the handshake after the ServerHello is sent in application data records. These records are classified as
+// handshake records until the first application data record of the client (the client Finished message).
+type _tlsRecords struct {
+	flows.BaseFeature
+	tls13          bool
+	clientFinished bool
+}
+
+func (f *_tlsRecords) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.tls13 = false
+	f.clientFinished = false
+}
+
+func (f *_tlsRecords) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	switch event := new.(type) {
+	case *tlsHello:
+		if !event.client && event.negotiatedVersion() >= 0x0304 {
+			f.tls13 = true
+		}
+	case *tlsRecord:
+		record := *event
+		if record.contentType == tlsApplicationData && f.tls13 && !f.clientFinished {
+			if record.forward {
+				f.clientFinished = true
+			}
+			record.contentType = tlsHandshake
+		}
+		f.Emit(&record, context, f)
+	}
+}
+
+func (f *_tlsRecords) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Bool(&f.tls13)
+	c.Bool(&f.clientFinished)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _tlsRecordCount struct {
+	flows.BaseFeature
+	count       uint64
+	contentType uint8
+	forward     bool
+	octets      bool
+}
+
+func (f *_tlsRecordCount) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.count = 0
+}
+
+func (f *_tlsRecordCount) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	record := new.(*tlsRecord)
+	if record.contentType != f.contentType || record.forward != f.forward {
+		return
+	}
+	if f.octets {
+		f.count += uint64(record.length)
+	} else {
+		f.count++
+	}
+}
+
+func (f *_tlsRecordCount) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.SetValue(f.count, context, f)
+}
+
+func (f *_tlsRecordCount) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _tlsTimeToApplicationDataNanoseconds struct {
+	flows.BaseFeature
+	start flows.DateTimeNanoseconds
+}
+
+func (f *_tlsTimeToApplicationDataNanoseconds) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.start = context.When()
+}
+
+func (f *_tlsTimeToApplicationDataNanoseconds) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil && new.(*tlsRecord).contentType == tlsApplicationData {
+		f.SetValue(int64(context.When())-int64(f.start), context, f)
+	}
+}
+
+func (f *_tlsTimeToApplicationDataNanoseconds) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Time(&f.start)
+}
+
+var tlsRecordsDefinition = []interface{}{"__tlsRecords", []interface{}{"__tls", "__tcpStream"}}
+
+func registerTLSRecordCount(name, description string, contentType uint8, forward, octets bool) {
+	flows.RegisterTemporaryFeature("_"+name, description, ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature {
+		return &_tlsRecordCount{contentType: contentType, forward: forward, octets: octets}
+	}, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature(name, description, ipfix.Unsigned64Type, 0, "_"+name, tlsRecordsDefinition)
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__tlsRecords", "returns the TLS records of a TCP stream with the content type before encryption", ipfix.OctetArrayType, 0, flows.PacketFeature, func() flows.Feature { return &_tlsRecords{} }, flows.PacketFeature)
+
+	for _, direction := range []struct {
+		name    string
+		sender  string
+		forward bool
+	}{{"Client", "client", true}, {"Server", "server", false}} {
+		for _, content := range []struct {
+			name        string
+			description string
+			contentType uint8
+		}{
+			{"Handshake", "handshake", tlsHandshake},
+			{"ApplicationData", "application data", tlsApplicationData},
+			{"Alert", "alert", tlsAlert},
+		} {
+			registerTLSRecordCount("_tls"+direction.name+content.name+"RecordCount", "number of TLS "+content.description+" records sent by the "+direction.sender, content.contentType, direction.forward, false)
+			registerTLSRecordCount("_tls"+direction.name+content.name+"OctetCount", "sum of the lengths of the TLS "+content.description+" records sent by the "+direction.sender+" (without record headers)", content.contentType, direction.forward, true)
+		}
+	}
+
+	flows.RegisterTemporaryFeature("__tlsTimeToApplicationDataNanoseconds", "time from the start of the flow to the first TLS application data record", ipfix.Signed64Type, 0, flows.FlowFeature, func() flows.Feature { return &_tlsTimeToApplicationDataNanoseconds{} }, flows.PacketFeature)
+	flows.RegisterTemporaryCompositeFeature("_tlsTimeToApplicationDataNanoseconds", "time from the start of the flow to the first TLS application data record", ipfix.Signed64Type, 0, "__tlsTimeToApplicationDataNanoseconds", tlsRecordsDefinition)
+	flows.RegisterTemporaryCompositeFeature("_tlsTimeToApplicationDataMilliseconds", "time from the start of the flow to the first TLS application data record", ipfix.Signed64Type, 0, "divide", "_tlsTimeToApplicationDataNanoseconds", 1000000)
+}
@@ -0,0 +1,144 @@
+package custom_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func concat(data ...[]byte) []byte {
+	var ret []byte
+	for _, d := range data {
+		ret = append(ret, d...)
+	}
+	return ret
+}
+
+func TestTLSRecords(t *testing.T) {
+	features := []interface{}{
+		"_tlsClientHandshakeRecordCount", "_tlsClientHandshakeOctetCount", "_tlsServerHandshakeRecordCount", "_tlsServerHandshakeOctetCount",
+		"_tlsClientApplicationDataRecordCount", "_tlsClientApplicationDataOctetCount", "_tlsServerApplicationDataRecordCount", "_tlsServerApplicationDataOctetCount",
+		"_tlsClientAlertRecordCount", "_tlsServerAlertRecordCount", "_tlsServerAlertOctetCount", "_tlsTimeToApplicationDataMilliseconds",
+	}
+	clientHello := tlsHandshakeMessage(1, ja3Hello)
+	serverHello := concat(tlsHandshakeMessage(2, ja3ServerHello), tlsHandshakeMessage(14, nil))
+	tls12 := tcpSession(1000,
+		tlsRecords(22, 1<<14, clientHello),
+		tlsRecords(22, 1<<14, serverHello),
+		concat(tlsRecords(22, 1<<14, tlsHandshakeMessage(16, make([]byte, 33))), tlsRecords(20, 1, []byte{1}), tlsRecords(22, 1<<14, make([]byte, 40))),
+		concat(tlsRecords(20, 1, []byte{1}), tlsRecords(22, 1<<14, make([]byte, 40))),
+		tlsRecords(23, 1<<14, make([]byte, 100)),
+		concat(tlsRecords(23, 1<<14, make([]byte, 200)), tlsRecords(21, 1<<14, make([]byte, 26))),
+	)
+	// the encrypted handshake after the ServerHello and the client Finished are application data records in TLS 1.3
+	serverHello13 := tlsHandshakeMessage(2, tlsHelloBody(false, 0x0303, []uint16{0x1301}, tlsExtension(0x002b, tlsUint16s(0x0304))))
+	tls13 := tcpSession(1001,
+		tlsRecords(22, 1<<14, tlsHandshakeMessage(1, chromeHello)),
+		concat(tlsRecords(22, 1<<14, serverHello13), tlsRecords(20, 1, []byte{1}), tlsRecords(23, 1<<14, make([]byte, 500))),
+		concat(tlsRecords(20, 1, []byte{1}), tlsRecords(23, 1<<14, make([]byte, 50)), tlsRecords(23, 1<<14, make([]byte, 80))),
+		tlsRecords(23, 1<<14, make([]byte, 300)),
+	)
+	// records larger than 16k are split
+	large := tcpSession(1002, tlsRecords(23, 1<<14, make([]byte, 20000)))
+	lines := sessionFlows(t, features, tls12, tls13, large)
+	// application data is sent by the client in the 5th (TLS 1.2) and 3rd message (TLS 1.3), 20ms apart
+	expected := []string{
+		fmt.Sprintf("1000,3,%d,2,%d,1,100,1,200,0,1,26,90", len(clientHello)+len(tlsHandshakeMessage(16, make([]byte, 33)))+40, len(serverHello)+40),
+		fmt.Sprintf("1001,2,%d,2,%d,1,80,1,300,0,0,0,50", len(tlsHandshakeMessage(1, chromeHello))+50, len(serverHello13)+500),
+		"1002,0,0,0,0,2,20000,0,0,0,0,0,10",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+// tlsTestCertificate returns a DER encoded certificate for the given template, which is signed by parent (nil for a
+// self-signed certificate)
+func tlsTestCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
+
+func TestTLSCertificate(t *testing.T) {
+	notBefore := time.Unix(1500000000, 0)
+	notAfter := time.Unix(1600000000, 0)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA", Organization: []string{"go-flows"}},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, caKey := tlsTestCertificate(t, caTemplate, nil, nil)
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafDER, _ := tlsTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		DNSNames:     []string{"example.com", "www.example.com"},
+		IPAddresses:  []net.IP{{10, 0, 0, 2}},
+	}, ca, caKey)
+
+	certificates := func(der ...[]byte) []byte {
+		var list [][]byte
+		for _, d := range der {
+			list = append(list, tlsVector(3, d))
+		}
+		return tlsHandshakeMessage(11, tlsVector(3, list...))
+	}
+	session := func(port layers.TCPPort, certificate []byte) []tcpSegment {
+		return tcpSession(port,
+			tlsRecords(22, 1<<14, tlsHandshakeMessage(1, ja3Hello)),
+			tlsRecords(22, 1<<14, concat(tlsHandshakeMessage(2, ja3ServerHello), certificate, tlsHandshakeMessage(14, nil))))
+	}
+	corrupt := append([]byte(nil), leafDER...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	lines := sessionFlows(t, []interface{}{"_tlsCertificateSubjectCommonName", "_tlsCertificateIssuer", "_tlsCertificateNotBefore",
+		"_tlsCertificateNotAfter", "_tlsCertificateSANCount", "_tlsCertificateSelfSigned", "_tlsCertificateKeyType"},
+		session(1000, certificates(leafDER, caDER)),
+		session(1001, certificates(caDER)),
+		// invalid certificates and certificate lists
+		session(1002, certificates(corrupt)),
+		session(1003, certificates(leafDER[:100])),
+		session(1004, tlsHandshakeMessage(11, []byte{0, 0xff, 0xff, 0, 0, 1, 0})),
+		session(1005, tlsHandshakeMessage(11, nil)),
+	)
+	expected := []string{
+		"1000,example.com,CN=test CA,O=go-flows,1500000000,1600000000,3,false,ECDSA",
+		"1001,test CA,CN=test CA,O=go-flows,1500000000,1600000000,0,true,ECDSA",
+		"1002,<nil>,<nil>,<nil>,<nil>,<nil>,<nil>,<nil>",
+		"1003,<nil>,<nil>,<nil>,<nil>,<nil>,<nil>,<nil>",
+		"1004,<nil>,<nil>,<nil>,<nil>,<nil>,<nil>,<nil>",
+		"1005,<nil>,<nil>,<nil>,<nil>,<nil>,<nil>,<nil>",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
@@ -32,6 +32,11 @@ func (f *_tcpEceTotalCountFlow) Event(new interface{}, context *flows.EventConte
 	f.count += features.BoolInt(tcp.ECE)
 }
 
+func (f *_tcpEceTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_tcpEceTotalCount", "count of TCP packets with ECE flag", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_tcpEceTotalCountFlow{} }, flows.RawPacket)
 }
@@ -78,6 +83,11 @@ func (f *_tcpCwrTotalCountFlow) Event(new interface{}, context *flows.EventConte
 	f.count += features.BoolInt(tcp.CWR)
 }
 
+func (f *_tcpCwrTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_tcpCwrTotalCount", "count of TCP packets with CWR flag", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_tcpCwrTotalCountFlow{} }, flows.RawPacket)
 }
@@ -124,6 +134,11 @@ func (f *_tcpNsTotalCountFlow) Event(new interface{}, context *flows.EventContex
 	f.count += features.BoolInt(tcp.NS)
 }
 
+func (f *_tcpNsTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_tcpNsTotalCount", "count of TCP packets with NS flag", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_tcpNsTotalCountFlow{} }, flows.RawPacket)
 }
@@ -170,6 +185,19 @@ func (f *uniTCPStreamFragments) push(seq features.Sequence, plen int, packet pac
 	sort.Stable(f.fragments)
 }
 
+func (f *uniTCPStreamFragments) checkpoint(c *flows.Checkpoint) {
+	f.nextSeq.Checkpoint(c)
+	n := c.Len(len(f.fragments))
+	if c.Loading() {
+		f.fragments = make(tcpFragments, n)
+	}
+	for i := range f.fragments {
+		f.fragments[i].seq.Checkpoint(c)
+		c.Int(&f.fragments[i].plen)
+		packet.CheckpointBuffer(c, &f.fragments[i].packet)
+	}
+}
+
 func (f *uniTCPStreamFragments) forwardOld(context *flows.EventContext, src interface{}) {
 	if len(f.fragments) == 0 {
 		return
@@ -255,6 +283,11 @@ func (f *tcpReorder) Stop(reason flows.FlowEndReason, context *flows.EventContex
 	}*/
 }
 
+func (f *tcpReorder) Checkpoint(c *flows.Checkpoint) {
+	f.forward.checkpoint(c)
+	f.backward.checkpoint(c)
+}
+
 func (f *tcpReorder) Event(new interface{}, context *flows.EventContext, src interface{}) {
 	packet := new.(packet.Buffer)
 	tcp, ok := packet.TransportLayer().(*layers.TCP)
@@ -405,4 +438,20 @@ func (f *_tcpChecksum) Event(new interface{}, context *flows.EventContext, src i
 
 func init() {
 	flows.RegisterTemporaryFeature("_tcpChecksum", "returns a textual representation of the tcpchecksum", ipfix.StringType, 1, flows.PacketFeature, func() flows.Feature { return &_tcpChecksum{} }, flows.RawPacket)
-}
\ No newline at end of file
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _tcpState struct {
+	flows.BaseFeature
+}
+
+func (f *_tcpState) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if flow, ok := context.Flow().(packet.TCPFlow); ok {
+		f.SetValue(flow.TCPState().String(), context, f)
+	}
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("_tcpState", "returns the tcp connection state at the end of the flow", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &_tcpState{} }, flows.RawPacket)
+}
@@ -0,0 +1,165 @@
+package custom_test
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	_ "github.com/CN-TU/go-flows/modules/features/custom"
+	_ "github.com/CN-TU/go-flows/modules/features/iana"
+	"github.com/CN-TU/go-flows/packet_test"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// tcpSegment is a tcp packet between the client 10.0.0.1:port and the server 10.0.0.2:80
+type tcpSegment struct {
+	ms      int
+	reverse bool
+	port    layers.TCPPort
+	tcp     layers.TCP
+	payload []byte
+}
+
+// tcpSource returns a source with the given segments ordered by time
+func tcpSource(t *testing.T, segments []tcpSegment) *packet_test.MemorySource {
+	segments = append([]tcpSegment(nil), segments...)
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].ms < segments[j].ms })
+	source := &packet_test.MemorySource{}
+	client, server := []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}
+	for _, segment := range segments {
+		ip := &layers.IPv4{Version: 4, TTL: 64, SrcIP: client, DstIP: server, Protocol: layers.IPProtocolTCP}
+		tcp := segment.tcp
+		tcp.SrcPort, tcp.DstPort = segment.port, 80
+		if segment.reverse {
+			ip.SrcIP, ip.DstIP = server, client
+			tcp.SrcPort, tcp.DstPort = 80, segment.port
+		}
+		tcp.SetNetworkLayerForChecksum(ip)
+		if err := source.AppendLayers(flows.DateTimeNanoseconds(segment.ms)*flows.MillisecondsInNanoseconds+1, ip, &tcp, gopacket.Payload(segment.payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source
+}
+
+// sessionFlows returns the given features of the flows of the sessions as sorted lines with the client port instead of
+// the export time
+func sessionFlows(t *testing.T, features []interface{}, sessions ...[]tcpSegment) []string {
+	var segments []tcpSegment
+	for _, session := range sessions {
+		segments = append(segments, session...)
+	}
+	features = append([]interface{}{"sourceTransportPort"}, features...)
+	lines := packet_test.RunEngine(t, tcpSource(t, segments), []packet_test.EngineSpec{{Features: features}}, packet_test.EngineOptions{})
+	for i, line := range lines {
+		lines[i] = line[strings.Index(line, ",")+1:]
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// streamChunk is the payload of a single segment of a tcp connection
+type streamChunk struct {
+	reverse bool
+	data    []byte
+}
+
+// streamSession returns the segments of a tcp connection from port with a segment for every chunk, which are 10ms apart
+func streamSession(port layers.TCPPort, chunks ...streamChunk) []tcpSegment {
+	segments := []tcpSegment{
+		{0, false, port, layers.TCP{SYN: true, Seq: 99}, nil},
+		{1, true, port, layers.TCP{SYN: true, ACK: true, Seq: 499, Ack: 100}, nil},
+		{2, false, port, layers.TCP{ACK: true, Seq: 100, Ack: 500}, nil},
+	}
+	seq := [2]uint32{100, 500}
+	ms := 10
+	for _, chunk := range chunks {
+		dir := 0
+		if chunk.reverse {
+			dir = 1
+		}
+		tcp := layers.TCP{ACK: true, PSH: true, Seq: seq[dir], Ack: seq[1-dir]}
+		segments = append(segments, tcpSegment{ms, chunk.reverse, port, tcp, chunk.data})
+		seq[dir] += uint32(len(chunk.data))
+		ms += 10
+	}
+	return append(segments,
+		tcpSegment{ms, false, port, layers.TCP{FIN: true, ACK: true, Seq: seq[0], Ack: seq[1]}, nil},
+		tcpSegment{ms + 1, true, port, layers.TCP{FIN: true, ACK: true, Seq: seq[1], Ack: seq[0] + 1}, nil},
+	)
+}
+
+// tcpSession returns the segments of a tcp connection from port with the given messages, which alternate between
+// client and server. Every message is split into two segments.
+func tcpSession(port layers.TCPPort, messages ...[]byte) []tcpSegment {
+	var chunks []streamChunk
+	for i, message := range messages {
+		chunks = append(chunks, streamChunk{i%2 == 1, message[:len(message)/2]}, streamChunk{i%2 == 1, message[len(message)/2:]})
+	}
+	return streamSession(port, chunks...)
+}
+
+func TestTCPState(t *testing.T) {
+	segments := []tcpSegment{
+		// handshake, close, and a new connection during TIME_WAIT
+		{0, false, 1000, layers.TCP{SYN: true}, nil},
+		{100, true, 1000, layers.TCP{SYN: true, ACK: true}, nil},
+		{200, false, 1000, layers.TCP{ACK: true}, nil},
+		{1000, false, 1000, layers.TCP{FIN: true, ACK: true}, nil},
+		{1100, true, 1000, layers.TCP{FIN: true, ACK: true}, nil},
+		{1200, false, 1000, layers.TCP{ACK: true}, nil},
+		{2500, false, 1000, layers.TCP{SYN: true}, nil},
+		{2600, true, 1000, layers.TCP{SYN: true, ACK: true}, nil},
+		{2700, false, 1000, layers.TCP{ACK: true}, nil},
+		// unanswered SYN
+		{300, false, 1001, layers.TCP{SYN: true}, nil},
+		// midstream connection, which is reset
+		{400, false, 1002, layers.TCP{ACK: true}, nil},
+		{500, true, 1002, layers.TCP{RST: true}, nil},
+		// half closed connection, which is reused
+		{0, false, 1003, layers.TCP{SYN: true}, nil},
+		{100, true, 1003, layers.TCP{SYN: true, ACK: true}, nil},
+		{200, false, 1003, layers.TCP{ACK: true}, nil},
+		{1000, false, 1003, layers.TCP{FIN: true, ACK: true}, nil},
+		{1500, false, 1003, layers.TCP{SYN: true}, nil},
+		// missed SYN+ACK and a retransmitted SYN, which doesn't start a new flow
+		{0, false, 1004, layers.TCP{SYN: true}, nil},
+		{100, true, 1004, layers.TCP{ACK: true}, nil},
+		{200, false, 1004, layers.TCP{SYN: true}, nil},
+		// closed connection ending with TIME_WAIT timeout
+		{0, false, 1005, layers.TCP{SYN: true}, nil},
+		{100, true, 1005, layers.TCP{SYN: true, ACK: true}, nil},
+		{200, false, 1005, layers.TCP{ACK: true}, nil},
+		{300, true, 1005, layers.TCP{FIN: true, ACK: true}, nil},
+		{400, false, 1005, layers.TCP{FIN: true, ACK: true}, nil},
+		{500, true, 1005, layers.TCP{ACK: true}, nil},
+		// advances the time for expiry
+		{10000, false, 1006, layers.TCP{ACK: true}, nil},
+	}
+	specs := []packet_test.EngineSpec{{
+		Features: []interface{}{"sourceTransportPort", "flowStartNanoseconds", "flowEndNanoseconds", "packetTotalCount", "flowEndReason", "_tcpState"},
+		Options: flows.FlowOptions{ActiveTimeout: 1000 * flows.SecondsInNanoseconds, IdleTimeout: 100 * flows.SecondsInNanoseconds, TCPExpiry: true,
+			TCPTimeouts: flows.TCPTimeouts{SynSent: 5 * flows.SecondsInNanoseconds, TimeWait: 2 * flows.SecondsInNanoseconds}},
+	}}
+	lines := packet_test.RunEngine(t, tcpSource(t, segments), specs, packet_test.EngineOptions{Expire: flows.SecondsInNanoseconds})
+	// export time, port, start, end, packets, end reason, state
+	expected := []string{
+		"500000001,1002,400000001,500000001,2,3,CLOSED",
+		// SYN during FIN_WAIT and TIME_WAIT
+		"1500000001,1003,1,1000000001,4,3,FIN_WAIT",
+		"2500000001,1000,1,1200000001,6,3,TIME_WAIT",
+		// at 10s: TIME_WAIT ended the flow after 2s, SYN_SENT flows expired after 5s, and the rest ends with EOF
+		"10000000001,1000,2500000001,2700000001,3,4,ESTABLISHED",
+		"10000000001,1003,1500000001,1500000001,1,1,SYN_SENT",
+		"10000000001,1004,1,200000001,3,4,ESTABLISHED",
+		"10000000001,1005,1,500000001,6,3,TIME_WAIT",
+		"10000000001,1001,300000001,300000001,1,1,SYN_SENT",
+		"10000000001,1006,10000000001,10000000001,1,4,ESTABLISHED",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got records %q, expected %q", lines, expected)
+	}
+}
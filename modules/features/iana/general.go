@@ -16,6 +16,7 @@ func (f *flowEndReason) Stop(reason flows.FlowEndReason, context *flows.EventCon
 
 func init() {
 	flows.RegisterStandardFeature("flowEndReason", flows.FlowFeature, func() flows.Feature { return &flowEndReason{} }, flows.RawPacket)
+	flows.RegisterStandardFeature("flowEndReason", flows.FlowFeature, func() flows.Feature { return &flowEndReason{} }, flows.RawFlow)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -33,11 +34,20 @@ func (f *flowEndNanoseconds) Stop(reason flows.FlowEndReason, context *flows.Eve
 	f.SetValue(f.lastTime, context, f)
 }
 
+func (f *flowEndNanoseconds) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Time(&f.lastTime)
+}
+
 func init() {
 	flows.RegisterStandardFeature("flowEndNanoseconds", flows.FlowFeature, func() flows.Feature { return &flowEndNanoseconds{} }, flows.RawPacket)
+	flows.RegisterStandardFeature("flowEndNanoseconds", flows.FlowFeature, func() flows.Feature { return &flowEndNanoseconds{} }, flows.RawFlow)
 	flows.RegisterStandardFeature("flowEndMilliseconds", flows.FlowFeature, func() flows.Feature { return &flowEndNanoseconds{} }, flows.RawPacket)
+	flows.RegisterStandardFeature("flowEndMilliseconds", flows.FlowFeature, func() flows.Feature { return &flowEndNanoseconds{} }, flows.RawFlow)
 	flows.RegisterStandardFeature("flowEndMicroseconds", flows.FlowFeature, func() flows.Feature { return &flowEndNanoseconds{} }, flows.RawPacket)
+	flows.RegisterStandardFeature("flowEndMicroseconds", flows.FlowFeature, func() flows.Feature { return &flowEndNanoseconds{} }, flows.RawFlow)
 	flows.RegisterStandardFeature("flowEndSeconds", flows.FlowFeature, func() flows.Feature { return &flowEndNanoseconds{} }, flows.RawPacket)
+	flows.RegisterStandardFeature("flowEndSeconds", flows.FlowFeature, func() flows.Feature { return &flowEndNanoseconds{} }, flows.RawFlow)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -53,9 +63,13 @@ func (f *flowStartNanoseconds) Start(context *flows.EventContext) {
 
 func init() {
 	flows.RegisterStandardFeature("flowStartNanoseconds", flows.FlowFeature, func() flows.Feature { return &flowStartNanoseconds{} }, flows.RawPacket)
+	flows.RegisterStandardFeature("flowStartNanoseconds", flows.FlowFeature, func() flows.Feature { return &flowStartNanoseconds{} }, flows.RawFlow)
 	flows.RegisterStandardFeature("flowStartMicroseconds", flows.FlowFeature, func() flows.Feature { return &flowStartNanoseconds{} }, flows.RawPacket)
+	flows.RegisterStandardFeature("flowStartMicroseconds", flows.FlowFeature, func() flows.Feature { return &flowStartNanoseconds{} }, flows.RawFlow)
 	flows.RegisterStandardFeature("flowStartMilliseconds", flows.FlowFeature, func() flows.Feature { return &flowStartNanoseconds{} }, flows.RawPacket)
+	flows.RegisterStandardFeature("flowStartMilliseconds", flows.FlowFeature, func() flows.Feature { return &flowStartNanoseconds{} }, flows.RawFlow)
 	flows.RegisterStandardFeature("flowStartSeconds", flows.FlowFeature, func() flows.Feature { return &flowStartNanoseconds{} }, flows.RawPacket)
+	flows.RegisterStandardFeature("flowStartSeconds", flows.FlowFeature, func() flows.Feature { return &flowStartNanoseconds{} }, flows.RawFlow)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -91,16 +105,21 @@ type flowID struct {
 
 func (f *flowID) Event(new interface{}, context *flows.EventContext, src interface{}) {
 	if f.Value() == nil {
-		// flowId is a per table flow counter ored with the tableId in the highest byte
 		flow := context.Flow()
-		flowid := flow.ID() & 0x00FFFFFFFFFFFFFF
-		flowid |= uint64(flow.Table().ID()) << 56
+		flowid := flow.ID()
+		if !flow.Table().Deterministic {
+			// flowId is a per table flow counter ored with the tableId in the highest byte
+			flowid &= 0x00FFFFFFFFFFFFFF
+			flowid |= uint64(flow.Table().ID()) << 56
+		}
+		// deterministic: flowId is the packet number of the first packet
 		f.SetValue(flowid, context, f)
 	}
 }
 
 func init() {
 	flows.RegisterStandardFeature("flowId", flows.FlowFeature, func() flows.Feature { return &flowID{} }, flows.RawPacket)
+	flows.RegisterStandardFeature("flowId", flows.FlowFeature, func() flows.Feature { return &flowID{} }, flows.RawFlow)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -120,7 +139,12 @@ func (f *packetTotalCount) Event(new interface{}, context *flows.EventContext, s
 }
 
 func (f *packetTotalCount) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
-	f.SetValue(f.count, context, f)
+	f.SetValue(f.count*context.Flow().Table().Sampling.ScaleFactor(), context, f)
+}
+
+func (f *packetTotalCount) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
 }
 
 func init() {
@@ -129,6 +153,59 @@ func init() {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+type samplingInterval struct {
+	flows.BaseFeature
+}
+
+func (f *samplingInterval) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		sampling := context.Flow().Table().Sampling
+		interval := uint32(1)
+		if sampling.Mode != flows.SamplingNone && sampling.Interval != 0 {
+			interval = sampling.Interval
+		}
+		f.SetValue(interval, context, f)
+	}
+}
+
+func init() {
+	flows.RegisterStandardFeature("samplingInterval", flows.FlowFeature, func() flows.Feature { return &samplingInterval{} }, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type samplingAlgorithm struct {
+	flows.BaseFeature
+}
+
+func (f *samplingAlgorithm) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f.SetValue(context.Flow().Table().Sampling.Algorithm(), context, f)
+	}
+}
+
+func init() {
+	flows.RegisterStandardFeature("samplingAlgorithm", flows.FlowFeature, func() flows.Feature { return &samplingAlgorithm{} }, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type selectorAlgorithm struct {
+	flows.BaseFeature
+}
+
+func (f *selectorAlgorithm) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f.SetValue(context.Flow().Table().Sampling.SelectorAlgorithm(), context, f)
+	}
+}
+
+func init() {
+	flows.RegisterStandardFeature("selectorAlgorithm", flows.FlowFeature, func() flows.Feature { return &selectorAlgorithm{} }, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 type flowDurationNanoseconds struct {
 	flows.BaseFeature
 	start    flows.DateTimeNanoseconds
@@ -149,8 +226,15 @@ func (f *flowDurationNanoseconds) Stop(reason flows.FlowEndReason, context *flow
 	f.SetValue(uint64(f.lastTime-f.start), context, f)
 }
 
+func (f *flowDurationNanoseconds) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Time(&f.start)
+	c.Time(&f.lastTime)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("flowDurationNanoseconds", "flow duration in nanoseconds", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &flowDurationNanoseconds{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("flowDurationNanoseconds", "flow duration in nanoseconds", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &flowDurationNanoseconds{} }, flows.RawFlow)
 	flows.RegisterStandardCompositeFeature("flowDurationMicroseconds", "divide", "flowDurationNanoseconds", 1000)
 	flows.RegisterStandardCompositeFeature("flowDurationMilliseconds", "divide", "flowDurationNanoseconds", 1000000)
 }
@@ -39,6 +39,11 @@ func (f *layer2OctetTotalCountFlow) Stop(reason flows.FlowEndReason, context *fl
 	f.SetValue(f.total, context, f)
 }
 
+func (f *layer2OctetTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.total)
+}
+
 func init() {
 	flows.RegisterStandardFeature("layer2OctetTotalCount", flows.FlowFeature, func() flows.Feature { return &layer2OctetTotalCountFlow{} }, flows.RawPacket)
 	flows.RegisterStandardCompositeFeature("minimumLayer2TotalLength", "min", "layer2OctetTotalCount")
@@ -237,7 +237,12 @@ func (f *octetTotalCountFlow) Event(new interface{}, context *flows.EventContext
 }
 
 func (f *octetTotalCountFlow) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
-	f.SetValue(f.total, context, f)
+	f.SetValue(f.total*context.Flow().Table().Sampling.ScaleFactor(), context, f)
+}
+
+func (f *octetTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.total)
 }
 
 func init() {
@@ -322,6 +327,11 @@ func (f *ipTotalLengthFlow) Stop(reason flows.FlowEndReason, context *flows.Even
 	f.SetValue(f.total, context, f)
 }
 
+func (f *ipTotalLengthFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.total)
+}
+
 func init() {
 	flows.RegisterStandardFeature("ipTotalLength", flows.FlowFeature, func() flows.Feature { return &ipTotalLengthFlow{} }, flows.RawPacket)
 	flows.RegisterStandardCompositeFeature("minimumIpTotalLength", "min", "ipTotalLength")
@@ -164,6 +164,11 @@ func (f *tcpSynTotalCountFlow) Event(new interface{}, context *flows.EventContex
 	f.count += features.BoolInt(tcp.SYN)
 }
 
+func (f *tcpSynTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterStandardFeature("tcpSynTotalCount", flows.FlowFeature, func() flows.Feature { return &tcpSynTotalCountFlow{} }, flows.RawPacket)
 }
@@ -210,6 +215,11 @@ func (f *tcpFinTotalCountFlow) Event(new interface{}, context *flows.EventContex
 	f.count += features.BoolInt(tcp.FIN)
 }
 
+func (f *tcpFinTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterStandardFeature("tcpFinTotalCount", flows.FlowFeature, func() flows.Feature { return &tcpFinTotalCountFlow{} }, flows.RawPacket)
 }
@@ -256,6 +266,11 @@ func (f *tcpRstTotalCountFlow) Event(new interface{}, context *flows.EventContex
 	f.count += features.BoolInt(tcp.RST)
 }
 
+func (f *tcpRstTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterStandardFeature("tcpRstTotalCount", flows.FlowFeature, func() flows.Feature { return &tcpRstTotalCountFlow{} }, flows.RawPacket)
 }
@@ -302,6 +317,11 @@ func (f *tcpPshTotalCountFlow) Event(new interface{}, context *flows.EventContex
 	f.count += features.BoolInt(tcp.PSH)
 }
 
+func (f *tcpPshTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterStandardFeature("tcpPshTotalCount", flows.FlowFeature, func() flows.Feature { return &tcpPshTotalCountFlow{} }, flows.RawPacket)
 }
@@ -348,6 +368,11 @@ func (f *tcpAckTotalCountFlow) Event(new interface{}, context *flows.EventContex
 	f.count += features.BoolInt(tcp.ACK)
 }
 
+func (f *tcpAckTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterStandardFeature("tcpAckTotalCount", flows.FlowFeature, func() flows.Feature { return &tcpAckTotalCountFlow{} }, flows.RawPacket)
 }
@@ -393,6 +418,11 @@ func (f *tcpUrgTotalCountFlow) Event(new interface{}, context *flows.EventContex
 	f.count += features.BoolInt(tcp.URG)
 }
 
+func (f *tcpUrgTotalCountFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterStandardFeature("tcpUrgTotalCount", flows.FlowFeature, func() flows.Feature { return &tcpUrgTotalCountFlow{} }, flows.RawPacket)
 }
@@ -461,6 +491,12 @@ func (f *tcpSequenceNumber) Event(new interface{}, context *flows.EventContext,
 	}
 }
 
+func (f *tcpSequenceNumber) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.isn.Checkpoint(c)
+	c.Bool(&f.cutoff)
+}
+
 type reverseTCPSequenceNumber struct {
 	flows.BaseFeature
 	isn    features.Sequence
@@ -505,6 +541,12 @@ func (f *reverseTCPSequenceNumber) Event(new interface{}, context *flows.EventCo
 	}
 }
 
+func (f *reverseTCPSequenceNumber) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.isn.Checkpoint(c)
+	c.Bool(&f.cutoff)
+}
+
 func init() {
 	flows.RegisterStandardFeature("tcpSequenceNumber", flows.FlowFeature, func() flows.Feature { return &tcpSequenceNumber{} }, flows.RawPacket)
 	flows.RegisterStandardReverseFeature("tcpSequenceNumber", flows.FlowFeature, func() flows.Feature { return &reverseTCPSequenceNumber{} }, flows.RawPacket)
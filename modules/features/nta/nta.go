@@ -120,6 +120,15 @@ func (f *ports) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
 	}
 }
 
+func (f *ports) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint16(&f.src)
+	c.Uint16(&f.dst)
+	c.Uint8(&f.proto)
+	c.Bool(&f.initPort)
+	c.Bool(&f.initProto)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("__NTAPorts", "srcport:dstport or -1 if multiple ports", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &ports{} }, flows.RawPacket)
 }
@@ -155,6 +164,11 @@ func (f *tdata) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
 	}
 }
 
+func (f *tdata) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.bytes)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("__NTATData", "sum of packet lengths (=payload length + transport header if nontcp/nonudp)", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &tdata{} }, flows.RawPacket)
 }
@@ -178,6 +192,10 @@ func (f *secwindow) Event(new interface{}, context *flows.EventContext, src inte
 	}
 }
 
+func (f *secwindow) Checkpoint(c *flows.Checkpoint) {
+	c.Uint32(&f.tsec)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("__NTASecWindow", "1s time window for packets", ipfix.Unsigned64Type, 0, flows.Selection, func() flows.Feature { return &secwindow{} }, flows.RawPacket)
 }
@@ -219,6 +237,12 @@ func (f *ton) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
 	}
 }
 
+func (f *ton) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint32(&f.last)
+	c.Uint32(&f.ton)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("__NTATOn", "returns on time (consec. seconds with packet)", ipfix.Unsigned64Type, 0, flows.PacketFeature, func() flows.Feature { return &ton{} }, flows.Selection)
 }
@@ -255,6 +279,11 @@ func (f *toff) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
 	}
 }
 
+func (f *toff) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint32(&f.last)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("__NTATOff", "returns off time (consec. seconds without packet)", ipfix.Unsigned64Type, 0, flows.PacketFeature, func() flows.Feature { return &toff{} }, flows.Selection)
 }
@@ -278,6 +307,10 @@ func (f *milliSecwindow) Event(new interface{}, context *flows.EventContext, src
 	}
 }
 
+func (f *milliSecwindow) Checkpoint(c *flows.Checkpoint) {
+	c.Uint32(&f.tsec)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("__NTAMilliSecWindow", "1ms time window for packets", ipfix.Unsigned64Type, 0, flows.Selection, func() flows.Feature { return &milliSecwindow{} }, flows.RawPacket)
 }
@@ -54,6 +54,17 @@ func resolveAccumulate(args []ipfix.InformationElement) (ipfix.InformationElemen
 	return ipfix.NewBasicList("accumulate", args[0], 0), nil
 }
 
+func (f *accumulate) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	n := c.Len(len(f.vector))
+	if c.Loading() {
+		f.vector = make([]interface{}, n)
+	}
+	for i := range f.vector {
+		c.Value(&f.vector[i])
+	}
+}
+
 //FIXME: this has a bad name
 func init() {
 	flows.RegisterCustomFunction("accumulate", "returns per-packet values as a list", resolveAccumulate, flows.FlowFeature, func() flows.Feature { return &accumulate{} }, flows.PacketFeature)
@@ -83,6 +94,18 @@ func (f *concatenate) Stop(reason flows.FlowEndReason, context *flows.EventConte
 	f.SetValue(f.buffer.Bytes(), context, f)
 }
 
+func (f *concatenate) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	var data []byte
+	if f.buffer != nil {
+		data = f.buffer.Bytes()
+	}
+	c.Bytes(&data)
+	if c.Loading() {
+		f.buffer = bytes.NewBuffer(data)
+	}
+}
+
 func init() {
 	flows.RegisterTypedFunction("concatenate", "concatenates per-packet values into a string", ipfix.OctetArrayType, 0, flows.FlowFeature, func() flows.Feature { return &concatenate{} }, flows.PacketFeature)
 }
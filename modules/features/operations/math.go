@@ -31,6 +31,11 @@ func (f *addPacketFlow) Stop(reason flows.FlowEndReason, context *flows.EventCon
 	}
 }
 
+func (f *addPacketFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Value(&f.current)
+}
+
 func init() {
 	flows.RegisterFunction("add", "returns ∑ a", flows.FlowFeature, func() flows.Feature { return &addPacketFlow{} }, flows.PacketFeature)
 	flows.RegisterFunction("sum", "returns ∑ a", flows.FlowFeature, func() flows.Feature { return &addPacketFlow{} }, flows.PacketFeature)
@@ -67,6 +72,11 @@ func (f *multiplyPacketFlow) Stop(reason flows.FlowEndReason, context *flows.Eve
 	}
 }
 
+func (f *multiplyPacketFlow) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Value(&f.current)
+}
+
 func init() {
 	flows.RegisterFunction("multiply", "returns ∏ a", flows.FlowFeature, func() flows.Feature { return &multiplyPacketFlow{} }, flows.PacketFeature)
 }
@@ -37,6 +37,11 @@ func resolveGet(args []ipfix.InformationElement) (ipfix.InformationElement, erro
 	return args[1], nil
 }
 
+func (f *get) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Int64(&f.current)
+}
+
 func init() {
 	flows.RegisterCustomFunction("get", "gets the <value>-th element of the second argument; indexing is like in Python", resolveGet, flows.FlowFeature, func() flows.Feature { return &get{} }, flows.Const, flows.PacketFeature)
 }
@@ -61,6 +66,11 @@ func (f *count) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
 	f.SetValue(f.count, context, f)
 }
 
+func (f *count) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterTypedFunction("count", "returns number of selected objects", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &count{} }, flows.Selection)
 	flows.RegisterTypedFunction("count", "returns number of selected objects", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &count{} }, flows.PacketFeature)
@@ -91,6 +101,12 @@ func (f *mean) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
 	}
 }
 
+func (f *mean) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Float64(&f.total)
+	c.Uint64(&f.count)
+}
+
 func init() {
 	flows.RegisterTypedFunction("mean", "returns mean of input", ipfix.Float64Type, 0, flows.FlowFeature, func() flows.Feature { return &mean{} }, flows.PacketFeature)
 }
@@ -133,6 +149,11 @@ func (f *min) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
 	f.SetValue(f.current, context, f)
 }
 
+func (f *min) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Value(&f.current)
+}
+
 func init() {
 	flows.RegisterFunction("min", "returns min of input", flows.FlowFeature, func() flows.Feature { return &min{} }, flows.PacketFeature)
 	flows.RegisterFunction("minimum", "returns min of input", flows.FlowFeature, func() flows.Feature { return &min{} }, flows.PacketFeature)
@@ -176,6 +197,11 @@ func (f *max) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
 	f.SetValue(f.current, context, f)
 }
 
+func (f *max) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Value(&f.current)
+}
+
 func init() {
 	flows.RegisterFunction("max", "returns max of input", flows.FlowFeature, func() flows.Feature { return &max{} }, flows.PacketFeature)
 	flows.RegisterFunction("maximum", "returns max of input", flows.FlowFeature, func() flows.Feature { return &max{} }, flows.PacketFeature)
@@ -212,6 +238,13 @@ func (f *stdev) Event(new interface{}, context *flows.EventContext, src interfac
 	f.m2 = f.m2 + delta*delta2
 }
 
+func (f *stdev) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+	c.Float64(&f.mean)
+	c.Float64(&f.m2)
+}
+
 func init() {
 	flows.RegisterTypedFunction("stdev", "", ipfix.Float64Type, 0, flows.FlowFeature, func() flows.Feature { return &stdev{} }, flows.PacketFeature)
 }
@@ -247,6 +280,13 @@ func (f *variance) Event(new interface{}, context *flows.EventContext, src inter
 	f.m2 = f.m2 + delta*delta2
 }
 
+func (f *variance) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+	c.Float64(&f.mean)
+	c.Float64(&f.m2)
+}
+
 func init() {
 	flows.RegisterTypedFunction("variance", "", ipfix.Float64Type, 0, flows.FlowFeature, func() flows.Feature { return &variance{} }, flows.PacketFeature)
 }
@@ -315,12 +355,56 @@ func (f *median) Event(new interface{}, context *flows.EventContext, src interfa
 	}
 }
 
+func (f *median) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	n := 0
+	if f.vector != nil {
+		n = f.vector.Len()
+	}
+	n = c.Len(n)
+	for i := 0; i < n; i++ {
+		var val interface{}
+		if !c.Loading() {
+			val = f.vector.Get(i)
+		}
+		c.Value(&val)
+		if c.Loading() {
+			if i == 0 {
+				f.vector = features.NewTypedSlice(val)
+			} else {
+				f.vector.Append(val)
+			}
+		}
+	}
+}
+
 func init() {
 	flows.RegisterFunction("median", "median; numeric even: arithmetic mean of two middle values; non-numeric even: lower of the two middle values", flows.FlowFeature, func() flows.Feature { return &median{} }, flows.PacketFeature)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// checkpointCounts saves or restores a map of value counts and returns the (restored) map.
+func checkpointCounts(c *flows.Checkpoint, vector map[interface{}]uint64) map[interface{}]uint64 {
+	n := c.Len(len(vector))
+	if !c.Loading() {
+		for val, num := range vector {
+			c.Value(&val)
+			c.Uint64(&num)
+		}
+		return vector
+	}
+	vector = make(map[interface{}]uint64, n)
+	for i := 0; i < n; i++ {
+		var val interface{}
+		var num uint64
+		c.Value(&val)
+		c.Uint64(&num)
+		vector[val] = num
+	}
+	return vector
+}
+
 type mode struct {
 	flows.BaseFeature
 	vector map[interface{}]uint64
@@ -358,6 +442,11 @@ func (f *mode) Event(new interface{}, context *flows.EventContext, src interface
 	}
 }
 
+func (f *mode) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.vector = checkpointCounts(c, f.vector)
+}
+
 func init() {
 	flows.RegisterFunction("mode", "mode of value; if multimodal then smallest value; no special handling for continous", flows.FlowFeature, func() flows.Feature { return &mode{} }, flows.PacketFeature)
 }
@@ -403,6 +492,11 @@ func (f *modeCount) Event(new interface{}, context *flows.EventContext, src inte
 	}
 }
 
+func (f *modeCount) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.vector = checkpointCounts(c, f.vector)
+}
+
 func init() {
 	flows.RegisterFunction("modeCount", "NUmber of packets for the mode of value; if multimodal then smallest value; no special handling for continous", flows.FlowFeature, func() flows.Feature { return &modeCount{} }, flows.PacketFeature)
 }
@@ -434,6 +528,11 @@ func (f *distinct) Event(new interface{}, context *flows.EventContext, src inter
 	}
 }
 
+func (f *distinct) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	f.vector = checkpointCounts(c, f.vector)
+}
+
 func init() {
 	flows.RegisterFunction("distinct", "number of distinct elements in a list", flows.FlowFeature, func() flows.Feature { return &distinct{} }, flows.PacketFeature)
 }
@@ -476,6 +575,30 @@ func (f *set) Event(new interface{}, context *flows.EventContext, src interface{
 	}
 }
 
+func (f *set) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	n := c.Len(len(f.vector))
+	if !c.Loading() {
+		for val := range f.vector {
+			c.Value(&val)
+		}
+	} else {
+		f.vector = make(map[interface{}]bool, n)
+		for i := 0; i < n; i++ {
+			var val interface{}
+			c.Value(&val)
+			f.vector[val] = true
+		}
+	}
+	n = c.Len(len(f.set))
+	if c.Loading() {
+		f.set = make([]interface{}, n)
+	}
+	for i := range f.set {
+		c.Value(&f.set[i])
+	}
+}
+
 func init() {
 	flows.RegisterFunction("set", "distinct elements in a list", flows.FlowFeature, func() flows.Feature { return &set{} }, flows.PacketFeature)
 }
@@ -35,6 +35,11 @@ func resolveSlice(args []ipfix.InformationElement) (ipfix.InformationElement, er
 	return args[2], nil
 }
 
+func (f *slice) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Int64(&f.current)
+}
+
 func init() {
 	flows.RegisterCustomFunction("slice", "gets third_argument[first_argument, second_argument]; indexing is like in Python", resolveSlice, flows.PacketFeature, func() flows.Feature { return &slice{} }, flows.Const, flows.Const, flows.PacketFeature)
 }
@@ -0,0 +1,321 @@
+package features
+
+import (
+	"sort"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/google/gopacket/layers"
+)
+
+// OverlapPolicy decides which bytes are kept, if buffered segments of a TCP stream overlap
+type OverlapPolicy uint8
+
+const (
+	// OverlapFirst keeps the bytes that were received first
+	OverlapFirst OverlapPolicy = iota
+	// OverlapLast replaces already buffered bytes with the bytes received last
+	OverlapLast
+)
+
+// maxStreamWindow is the maximum distance of a segment from the current stream position; segments further away are
+// considered invalid
+const maxStreamWindow = 1 << 30
+
+// StreamOptions holds the configuration of a TCPStream
+type StreamOptions struct {
+	// Policy is used for overlapping out of order segments. Bytes that were already handed to the application are
+	// never changed.
+	Policy OverlapPolicy
+	// MaxBuffered is the maximum number of out of order bytes buffered per direction. If more bytes are buffered, the
+	// stream skips the missing bytes (see StreamChunk.Gap).
+	MaxBuffered int
+}
+
+// DefaultStreamOptions are the options used by the stream features
+var DefaultStreamOptions = StreamOptions{
+	Policy:      OverlapFirst,
+	MaxBuffered: 1 << 20,
+}
+
+// StreamChunk is a part of the reassembled byte stream of one direction of a TCP connection.
+type StreamChunk struct {
+	// Data holds the next bytes of the stream. Data is only valid during the event and must be copied if needed later.
+	Data []byte
+	// Gap is the number of bytes missing in the stream before Data (e.g. lost packets, or packets that were not captured)
+	Gap int
+	// Forward is true if the bytes were sent in forward direction of the flow. This can be different from the
+	// direction of the current packet, since buffered bytes are delivered if the packets in front of them arrive.
+	Forward bool
+	// End is true if this direction of the stream ended (FIN or RST). Data is empty in this case.
+	End bool
+}
+
+type streamSegment struct {
+	seq  Sequence
+	data []byte
+}
+
+// halfStream is one direction of a TCPStream
+type halfStream struct {
+	nextSeq  Sequence
+	segments []streamSegment // sorted by sequence number and without overlaps
+	buffered int
+	finSeq   Sequence
+	closed   bool
+}
+
+func (h *halfStream) reset() {
+	h.nextSeq = InvalidSequence
+	h.segments = nil
+	h.buffered = 0
+	h.finSeq = InvalidSequence
+	h.closed = false
+}
+
+// offset returns the distance of seq from the current position of the stream
+func (h *halfStream) offset(seq Sequence) int {
+	return h.nextSeq.Difference(seq)
+}
+
+func (h *halfStream) deliver(data []byte, gap int, forward bool, emit func(*StreamChunk)) {
+	h.nextSeq = h.nextSeq.Add(gap + len(data))
+	if len(data) != 0 || gap != 0 {
+		emit(&StreamChunk{Data: data, Gap: gap, Forward: forward})
+	}
+}
+
+// drain delivers all the buffered segments that are in order now and checks for the end of the stream
+func (h *halfStream) drain(forward bool, emit func(*StreamChunk)) {
+	for len(h.segments) > 0 {
+		segment := h.segments[0]
+		start := h.offset(segment.seq)
+		if start > 0 {
+			break
+		}
+		h.segments = h.segments[1:]
+		h.buffered -= len(segment.data)
+		if start+len(segment.data) > 0 {
+			h.deliver(segment.data[-start:], 0, forward, emit)
+		}
+	}
+	if h.finSeq != InvalidSequence && h.offset(h.finSeq) <= 0 {
+		h.close(forward, emit)
+	}
+}
+
+// skip delivers the first buffered segment including the gap in front of it
+func (h *halfStream) skip(forward bool, emit func(*StreamChunk)) {
+	segment := h.segments[0]
+	h.segments = h.segments[1:]
+	h.buffered -= len(segment.data)
+	h.deliver(segment.data, h.offset(segment.seq), forward, emit)
+	h.drain(forward, emit)
+}
+
+func (h *halfStream) close(forward bool, emit func(*StreamChunk)) {
+	h.closed = true
+	h.segments = nil
+	h.buffered = 0
+	emit(&StreamChunk{Forward: forward, End: true})
+}
+
+// insert buffers an out of order segment starting at offset start. The segments stay sorted, and buffered is updated
+// with the number of added and replaced bytes.
+func (h *halfStream) insert(start int, data []byte, policy OverlapPolicy) {
+	data = append([]byte(nil), data...)
+	end := start + len(data)
+	// the segments first to last-1 overlap the new segment
+	first := sort.Search(len(h.segments), func(i int) bool {
+		return h.offset(h.segments[i].seq)+len(h.segments[i].data) > start
+	})
+	last := first
+	for last < len(h.segments) && h.offset(h.segments[last].seq) < end {
+		last++
+	}
+	var replace []streamSegment
+	if policy == OverlapLast {
+		if first < last {
+			if s := h.offset(h.segments[first].seq); s < start {
+				replace = append(replace, streamSegment{h.segments[first].seq, h.segments[first].data[:start-s]})
+			}
+		}
+		replace = append(replace, streamSegment{h.nextSeq.Add(start), data})
+		if first < last {
+			segment := h.segments[last-1]
+			if s := h.offset(segment.seq); s+len(segment.data) > end {
+				replace = append(replace, streamSegment{segment.seq.Add(end - s), segment.data[end-s:]})
+			}
+		}
+		for _, segment := range h.segments[first:last] {
+			h.buffered -= len(segment.data)
+		}
+		for _, segment := range replace {
+			h.buffered += len(segment.data)
+		}
+	} else {
+		pos := start
+		for _, segment := range h.segments[first:last] {
+			if s := h.offset(segment.seq); s > pos {
+				replace = append(replace, streamSegment{h.nextSeq.Add(pos), data[pos-start : s-start]})
+				h.buffered += s - pos
+			}
+			replace = append(replace, segment)
+			pos = h.offset(segment.seq) + len(segment.data)
+		}
+		if pos < end {
+			replace = append(replace, streamSegment{h.nextSeq.Add(pos), data[pos-start:]})
+			h.buffered += end - pos
+		}
+	}
+	h.segments = append(h.segments[:first], append(replace, h.segments[last:]...)...)
+}
+
+// acked skips the missing bytes before ack, since the receiver already acknowledged them
+func (h *halfStream) acked(ack Sequence, forward bool, emit func(*StreamChunk)) {
+	if h.nextSeq == InvalidSequence || h.closed {
+		return
+	}
+	gap := h.offset(ack)
+	if gap <= 0 || gap > maxStreamWindow {
+		return
+	}
+	for len(h.segments) > 0 && !h.closed && h.offset(h.segments[0].seq) < gap {
+		h.skip(forward, emit)
+	}
+	if h.closed {
+		return
+	}
+	if h.finSeq != InvalidSequence && h.offset(h.finSeq) < gap {
+		// the FIN consumes one sequence number
+		ack = h.finSeq
+	}
+	if gap = h.offset(ack); gap > 0 {
+		h.deliver(nil, gap, forward, emit)
+	}
+	h.drain(forward, emit)
+}
+
+func (h *halfStream) packet(tcp *layers.TCP, forward bool, options *StreamOptions, emit func(*StreamChunk)) {
+	if h.closed {
+		return
+	}
+	seq := Sequence(tcp.Seq)
+	if tcp.SYN {
+		seq = seq.Add(1)
+	}
+	if h.nextSeq == InvalidSequence {
+		// first packet; the stream starts after the SYN or in the middle of the connection
+		h.nextSeq = seq
+	}
+	data := tcp.LayerPayload()
+	start := h.offset(seq)
+	if start > maxStreamWindow || start < -maxStreamWindow {
+		return
+	}
+	if tcp.FIN {
+		h.finSeq = seq.Add(len(data))
+	}
+	if tcp.RST {
+		for len(h.segments) > 0 {
+			h.skip(forward, emit)
+		}
+		if !h.closed {
+			h.close(forward, emit)
+		}
+		return
+	}
+	if start < 0 {
+		// retransmission; drop the bytes that were already delivered
+		if -start >= len(data) {
+			data = nil
+		} else {
+			data = data[-start:]
+		}
+		start = 0
+	}
+	if len(data) == 0 {
+		h.drain(forward, emit)
+		return
+	}
+	if start == 0 {
+		h.deliver(data, 0, forward, emit)
+		h.drain(forward, emit)
+		return
+	}
+	h.insert(start, data, options.Policy)
+	for h.buffered > options.MaxBuffered && len(h.segments) > 0 {
+		h.skip(forward, emit)
+	}
+}
+
+// flush delivers all the buffered segments including the gaps between them
+func (h *halfStream) flush(forward bool, emit func(*StreamChunk)) {
+	for len(h.segments) > 0 && !h.closed {
+		h.skip(forward, emit)
+	}
+}
+
+func (h *halfStream) checkpoint(c *flows.Checkpoint) {
+	h.nextSeq.Checkpoint(c)
+	h.finSeq.Checkpoint(c)
+	c.Bool(&h.closed)
+	c.Int(&h.buffered)
+	n := c.Len(len(h.segments))
+	if c.Loading() {
+		h.segments = make([]streamSegment, n)
+	}
+	for i := range h.segments {
+		h.segments[i].seq.Checkpoint(c)
+		c.Bytes(&h.segments[i].data)
+	}
+}
+
+// TCPStream reassembles both directions of a TCP connection into ordered byte streams.
+//
+// Each direction starts after the SYN, or with the first seen packet if the connection started before the capture.
+// Retransmitted bytes are dropped, and out of order segments are buffered until the missing bytes arrive. Missing
+// bytes are skipped (and reported as gap) if the receiver acknowledges bytes after them, if more than
+// StreamOptions.MaxBuffered bytes are buffered, or if the stream is flushed.
+type TCPStream struct {
+	forward  halfStream
+	backward halfStream
+	options  StreamOptions
+}
+
+// NewTCPStream returns a new, empty stream with the given options
+func NewTCPStream(options StreamOptions) *TCPStream {
+	ret := &TCPStream{options: options}
+	ret.Reset()
+	return ret
+}
+
+// Reset clears the stream
+func (s *TCPStream) Reset() {
+	s.forward.reset()
+	s.backward.reset()
+}
+
+// Packet adds a packet to the stream and calls emit for every chunk of the stream that is in order now. forward is
+// the direction of the packet within the flow.
+func (s *TCPStream) Packet(tcp *layers.TCP, forward bool, emit func(*StreamChunk)) {
+	own, other := &s.forward, &s.backward
+	if !forward {
+		own, other = other, own
+	}
+	if tcp.ACK {
+		other.acked(Sequence(tcp.Ack), !forward, emit)
+	}
+	own.packet(tcp, forward, &s.options, emit)
+}
+
+// Flush delivers all the buffered bytes of both directions including the gaps between them
+func (s *TCPStream) Flush(emit func(*StreamChunk)) {
+	s.forward.flush(true, emit)
+	s.backward.flush(false, emit)
+}
+
+// Checkpoint saves or restores the stream
+func (s *TCPStream) Checkpoint(c *flows.Checkpoint) {
+	s.forward.checkpoint(c)
+	s.backward.checkpoint(c)
+}
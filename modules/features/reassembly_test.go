@@ -0,0 +1,136 @@
+package features
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+// streamPacket is a tcp packet of a stream test; flags are S(YN), F(IN), R(ST), and A(CK) with the given ack
+type streamPacket struct {
+	forward bool
+	flags   string
+	seq     uint32
+	ack     uint32
+	data    string
+}
+
+// runStream hands the packets to a new stream, flushes it, and returns the chunks as ">data" or "<data" (direction),
+// with a "gapN:" prefix for gaps, and ">end" for the end of a direction
+func runStream(t *testing.T, options StreamOptions, packets []streamPacket) []string {
+	t.Helper()
+	var chunks []string
+	emit := func(chunk *StreamChunk) {
+		dir := "<"
+		if chunk.Forward {
+			dir = ">"
+		}
+		switch {
+		case chunk.End:
+			chunks = append(chunks, dir+"end")
+		case chunk.Gap != 0:
+			chunks = append(chunks, fmt.Sprintf("%sgap%d:%s", dir, chunk.Gap, chunk.Data))
+		default:
+			chunks = append(chunks, dir+string(chunk.Data))
+		}
+	}
+	stream := NewTCPStream(options)
+	for _, packet := range packets {
+		tcp := &layers.TCP{Seq: packet.seq, Ack: packet.ack,
+			SYN: strings.Contains(packet.flags, "S"), FIN: strings.Contains(packet.flags, "F"),
+			RST: strings.Contains(packet.flags, "R"), ACK: strings.Contains(packet.flags, "A")}
+		tcp.Payload = []byte(packet.data)
+		stream.Packet(tcp, packet.forward, emit)
+		for _, h := range []*halfStream{&stream.forward, &stream.backward} {
+			checkHalfStream(t, h)
+		}
+	}
+	stream.Flush(emit)
+	return chunks
+}
+
+// checkHalfStream verifies that the buffered segments are sorted, don't overlap, and add up to buffered
+func checkHalfStream(t *testing.T, h *halfStream) {
+	t.Helper()
+	buffered := 0
+	pos := 0
+	for i, segment := range h.segments {
+		start := h.offset(segment.seq)
+		if start <= 0 || (i > 0 && start < pos) || len(segment.data) == 0 {
+			t.Fatalf("invalid segment %d at offset %d (previous segment ends at %d)", i, start, pos)
+		}
+		pos = start + len(segment.data)
+		buffered += len(segment.data)
+	}
+	if buffered != h.buffered {
+		t.Fatalf("%d bytes buffered, but counted %d", buffered, h.buffered)
+	}
+}
+
+func TestStream(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		policy  OverlapPolicy
+		max     int
+		packets []streamPacket
+		chunks  []string
+	}{
+		{"in order", OverlapFirst, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {false, "SA", 499, 100, ""},
+			{true, "A", 100, 500, "abc"}, {false, "A", 500, 103, "xyz"}, {true, "A", 103, 503, "def"},
+			{true, "FA", 106, 503, ""}, {false, "FA", 503, 107, ""}, {true, "A", 107, 504, "ignored"},
+		}, []string{">abc", "<xyz", ">def", ">end", "<end"}},
+		{"out of order", OverlapFirst, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {true, "", 106, 0, "ghi"}, {true, "", 103, 0, "def"}, {true, "", 100, 0, "abc"},
+		}, []string{">abc", ">def", ">ghi"}},
+		{"retransmission", OverlapFirst, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {true, "", 100, 0, "abc"}, {true, "", 100, 0, "abc"}, {true, "", 101, 0, "BCde"},
+		}, []string{">abc", ">de"}},
+		{"overlap first wins", OverlapFirst, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {true, "", 102, 0, "XXXX"}, {true, "", 104, 0, "YYYY"},
+			{true, "", 109, 0, "AA"}, {true, "", 113, 0, "BB"}, {true, "", 108, 0, "cccccccc"}, {true, "", 100, 0, "ab"},
+		}, []string{">ab", ">XXXX", ">YY", ">c", ">AA", ">cc", ">BB", ">c"}},
+		{"overlap last wins", OverlapLast, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {true, "", 102, 0, "XXXX"}, {true, "", 104, 0, "YYYY"},
+			{true, "", 109, 0, "AA"}, {true, "", 113, 0, "BB"}, {true, "", 108, 0, "cccccccc"}, {true, "", 100, 0, "ab"},
+		}, []string{">ab", ">XX", ">YYYY", ">cccccccc"}},
+		{"overlap last wins within a segment", OverlapLast, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {true, "", 102, 0, "AAAAAA"}, {true, "", 104, 0, "bb"}, {true, "", 100, 0, "xy"},
+		}, []string{">xy", ">AA", ">bb", ">AA"}},
+		{"gap acknowledged", OverlapFirst, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {false, "SA", 499, 100, ""}, {true, "A", 110, 500, "xyz"}, {false, "A", 500, 113, ""},
+		}, []string{">gap10:xyz"}},
+		{"gap acknowledged up to a segment", OverlapFirst, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {false, "SA", 499, 100, ""}, {true, "A", 110, 500, "xyz"}, {false, "A", 500, 110, ""},
+		}, []string{">gap10:", ">xyz"}},
+		{"gap flushed", OverlapFirst, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {true, "", 105, 0, "b"}, {true, "", 110, 0, "c"},
+		}, []string{">gap5:b", ">gap4:c"}},
+		{"max buffered", OverlapFirst, 4, []streamPacket{
+			{true, "S", 99, 0, ""}, {true, "", 105, 0, "aaa"}, {true, "", 110, 0, "bbb"}, {true, "", 113, 0, "c"},
+		}, []string{">gap5:aaa", ">gap2:bbb", ">c"}},
+		{"sequence wraparound", OverlapFirst, 100, []streamPacket{
+			{true, "S", 0xfffffff0, 0, ""}, {true, "", 2, 0, "WXYZ"}, {true, "", 0xfffffffe, 0, "LMNO"},
+			{true, "", 0xfffffff1, 0, "0123456789abc"},
+		}, []string{">0123456789abc", ">LMNO", ">WXYZ"}},
+		{"fin out of order", OverlapFirst, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {true, "F", 103, 0, "d"}, {true, "", 100, 0, "abc"},
+		}, []string{">abc", ">d", ">end"}},
+		{"rst", OverlapFirst, 100, []streamPacket{
+			{true, "S", 99, 0, ""}, {true, "", 105, 0, "x"}, {true, "R", 100, 0, ""}, {true, "", 100, 0, "ignored"},
+		}, []string{">gap5:x", ">end"}},
+		{"midstream", OverlapFirst, 100, []streamPacket{
+			{false, "A", 1000, 2000, "response"}, {true, "A", 2000, 1008, "request"},
+		}, []string{"<response", ">request"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			chunks := runStream(t, StreamOptions{Policy: test.policy, MaxBuffered: test.max}, test.packets)
+			if !reflect.DeepEqual(chunks, test.chunks) {
+				t.Errorf("got chunks %q, expected %q", chunks, test.chunks)
+			}
+		})
+	}
+}
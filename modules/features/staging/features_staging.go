@@ -53,6 +53,12 @@ func (f *_characters) Event(new interface{}, context *flows.EventContext, src in
 	f.SetValue(buffer.String(), context, f)
 }
 
+func (f *_characters) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Time(&f.time)
+	c.Bytes(&f.src)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_characters", "returns a textual representation of a packet", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_characters{} }, flows.RawPacket)
 }
@@ -141,6 +147,12 @@ func (f *_characters2) Event(new interface{}, context *flows.EventContext, src i
 	f.SetValue(buffer.String(), context, f)
 }
 
+func (f *_characters2) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Time(&f.time)
+	c.Bytes(&f.src)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_characters2", "returns a textual representation of a packet", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_characters2{} }, flows.RawPacket)
 }
@@ -183,6 +195,12 @@ func (f *_consecutiveSeconds) Stop(reason flows.FlowEndReason, context *flows.Ev
 	f.SetValue(f.count, context, f)
 }
 
+func (f *_consecutiveSeconds) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+	c.Time(&f.lastTime)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("__consecutiveSeconds", "outputs number of consecutive seconds in which there was at least one packet", ipfix.Unsigned64Type, 0, flows.PacketFeature, func() flows.Feature { return &_consecutiveSeconds{} }, flows.RawPacket)
 	flows.RegisterTemporaryCompositeFeature("__maximumConsecutiveSeconds", "", ipfix.Unsigned64Type, 0, "maximum", "_consecutiveSeconds")
@@ -221,6 +239,12 @@ func (f *_activeForSeconds) Stop(reason flows.FlowEndReason, context *flows.Even
 	f.SetValue(f.count, context, f)
 }
 
+func (f *_activeForSeconds) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Uint64(&f.count)
+	c.Time(&f.lastTime)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_activeForSeconds", "outputs number of seconds in which there was at least one packet", ipfix.Unsigned64Type, 0, flows.FlowFeature, func() flows.Feature { return &_activeForSeconds{} }, flows.RawPacket)
 }
@@ -252,6 +276,11 @@ func (f *_tcpOptionsFirstPacket) Event(new interface{}, context *flows.EventCont
 	f.done = true
 }
 
+func (f *_tcpOptionsFirstPacket) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Bool(&f.done)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_tcpOptionsFirstPacket", "textual representation of TCP options in first packet", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &_tcpOptionsFirstPacket{} }, flows.RawPacket)
 }
@@ -285,6 +314,11 @@ func (f *_tcpTimestampFirstPacket) Event(new interface{}, context *flows.EventCo
 	f.done = true
 }
 
+func (f *_tcpTimestampFirstPacket) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Bool(&f.done)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_tcpTimestampFirstPacket", "TCP timestamp of first packet", ipfix.Unsigned32Type, 0, flows.FlowFeature, func() flows.Feature { return &_tcpTimestampFirstPacket{} }, flows.RawPacket)
 }
@@ -320,6 +354,11 @@ func (f *_tcpOptionDataFirstPacket) Event(new interface{}, context *flows.EventC
 	f.done = true
 }
 
+func (f *_tcpOptionDataFirstPacket) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	c.Bool(&f.done)
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_tcpOptionDataFirstPacket", "textual representation of TCP options in first packet", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &_tcpOptionDataFirstPacket{} }, flows.RawPacket)
 }
@@ -370,6 +409,19 @@ func (f *_tcpTimestampsPerSeconds) Stop(reason flows.FlowEndReason, context *flo
 	}
 }
 
+func (f *_tcpTimestampsPerSeconds) Checkpoint(c *flows.Checkpoint) {
+	f.BaseFeature.CheckpointValue(c)
+	n := c.Len(len(f.timestamps))
+	if c.Loading() {
+		f.timestamps = make([]uint32, n)
+		f.times = make([]uint32, n)
+	}
+	for i := range f.timestamps {
+		c.Uint32(&f.timestamps[i])
+		c.Uint32(&f.times[i])
+	}
+}
+
 func init() {
 	flows.RegisterTemporaryFeature("_tcpTimestampsPerSeconds", "list of the difference of tcp timestamp divided by actual time in the packets in the flow", ipfix.Float64Type, 0, flows.PacketFeature, func() flows.Feature { return &_tcpTimestampsPerSeconds{} }, flows.RawPacket)
 }
@@ -1,6 +1,7 @@
 package features
 
 import (
+	"github.com/CN-TU/go-flows/flows"
 	"github.com/CN-TU/go-flows/packet"
 	"github.com/google/gopacket/layers"
 )
@@ -55,3 +56,8 @@ func (s Sequence) Add(t int) Sequence {
 }
 
 // ^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^^
+
+// Checkpoint saves or restores the sequence number
+func (s *Sequence) Checkpoint(c *flows.Checkpoint) {
+	c.Int64((*int64)(s))
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/CN-TU/go-flows/flows"
 	"github.com/CN-TU/go-flows/packet"
 	"github.com/CN-TU/go-flows/util"
 )
@@ -20,6 +21,7 @@ type csvLabels struct {
 	csv      *csv.Reader
 	nextData interface{}
 	nextPos  uint64
+	line     uint64 // number of records read from the current file
 }
 
 func (cl *csvLabels) ID() string {
@@ -43,6 +45,7 @@ func (cl *csvLabels) open() {
 		panic(err)
 	}
 	cl.file = r
+	cl.line = 0
 	cl.csv = csv.NewReader(r)
 	_, err = cl.csv.Read() // Read title line
 	if err != nil {
@@ -82,6 +85,7 @@ func (cl *csvLabels) GetLabel(packet packet.Buffer) (interface{}, error) {
 	if record == nil && err != nil {
 		panic(err)
 	}
+	cl.line++
 	if len(record) == 1 {
 		return record, nil
 	}
@@ -99,6 +103,39 @@ func (cl *csvLabels) GetLabel(packet packet.Buffer) (interface{}, error) {
 	return nil, nil
 }
 
+// Checkpoint saves the current file and record position, or skips to the restored position
+func (cl *csvLabels) Checkpoint(c *flows.Checkpoint) {
+	c.Check("csv label", cl.id)
+	remaining := c.Len(len(cl.labels))
+	open := cl.csv != nil
+	c.Bool(&open)
+	c.Uint64(&cl.line)
+	c.Uint64(&cl.nextPos)
+	c.Value(&cl.nextData)
+	if !c.Loading() || c.Err() != nil {
+		return
+	}
+	if open {
+		remaining++
+	}
+	if remaining > len(cl.labels) {
+		c.Fail(fmt.Errorf("checkpoint has %d remaining label files, but only %d are specified", remaining, len(cl.labels)))
+		return
+	}
+	cl.labels = cl.labels[len(cl.labels)-remaining:]
+	if !open {
+		return
+	}
+	skip := cl.line
+	cl.open()
+	for cl.line = 0; cl.line < skip; cl.line++ {
+		if _, err := cl.csv.Read(); err != nil {
+			c.Fail(fmt.Errorf("couldn't skip to record %d in label file: %s", skip, err))
+			return
+		}
+	}
+}
+
 func newcsvLabels(args []string) (arguments []string, ret util.Module, err error) {
 	var files []string
 
@@ -13,6 +13,7 @@ import (
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 
+	"github.com/CN-TU/go-flows/flows"
 	"github.com/CN-TU/go-flows/packet"
 	"github.com/CN-TU/go-flows/util"
 	"github.com/google/gopacket"
@@ -27,6 +28,7 @@ type libpcapSource struct {
 	promisc       bool
 	snaplen       int
 	which         int
+	read          uint64 // number of packets read from the current file
 	lt            gopacket.LayerType
 	currentHandle *pcap.Handle
 	currentFilter *pcap.BPF
@@ -105,6 +107,7 @@ func (ps *libpcapSource) openNext() error {
 		ps.currentHandle.Close()
 	}
 
+	ps.read = 0
 	ps.currentHandle, err = pcap.OpenOffline(ps.files[ps.which])
 	if err != nil {
 		return fmt.Errorf("couldn't open file '%s': %s", ps.files[ps.which], err)
@@ -149,6 +152,7 @@ RETRY:
 		}
 		goto RETRY
 	}
+	ps.read++
 
 	if ps.currentFilter != nil && !ps.currentFilter.Matches(ci, data) {
 		filtered++
@@ -159,6 +163,33 @@ RETRY:
 	return
 }
 
+// Checkpoint saves the current file and packet position, or skips to the restored position. Live captures just
+// continue capturing.
+func (ps *libpcapSource) Checkpoint(c *flows.Checkpoint) {
+	c.Check("libpcap source", ps.id)
+	if ps.live {
+		return
+	}
+	which := ps.which
+	c.Int(&which)
+	c.Uint64(&ps.read)
+	if !c.Loading() || which == -1 || c.Err() != nil {
+		return
+	}
+	skip := ps.read
+	ps.which = which - 1
+	if err := ps.openNext(); err != nil {
+		c.Fail(err)
+		return
+	}
+	for ps.read = 0; ps.read < skip; ps.read++ {
+		if _, _, err := ps.currentHandle.ZeroCopyReadPacketData(); err != nil {
+			c.Fail(fmt.Errorf("couldn't skip to packet %d in '%s': %s", skip, ps.files[ps.which], err))
+			return
+		}
+	}
+}
+
 // Stop shuts down the source
 func (ps *libpcapSource) Stop() {
 	if ps.currentHandle != nil {
@@ -2,9 +2,12 @@ package packet
 
 import (
 	"encoding/binary"
+	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/CN-TU/go-flows/flows"
 	"github.com/google/gopacket"
@@ -32,6 +35,8 @@ type Buffer interface {
 	Label() interface{}
 	// PacketNr returns the the number of this packet
 	PacketNr() uint64
+	// PassiveDNS returns the passive DNS cache of the engine or nil, if passive DNS is disabled
+	PassiveDNS() *PassiveDNS
 	//// Convenience functions for packet size calculations
 	//// ------------------------------------------------------------------
 	// LinkLayerLength returns the length of the link layer (=header + payload) or 0 if there is no link layer
@@ -52,6 +57,8 @@ type Buffer interface {
 	SetInfo(string, bool)
 
 	decode() bool
+	// shared returns the packet holding the data, which might be shared with the other table groups
+	shared() *packetBuffer
 }
 
 type packetBuffer struct {
@@ -78,8 +85,11 @@ type packetBuffer struct {
 	failure     gopacket.ErrorLayer
 	ci          gopacket.PacketMetadata
 	label       interface{}
+	passiveDNS  *PassiveDNS
+	views       []groupBuffer
+	dissect     sync.Once
 	ip6headers  int
-	refcnt      int
+	refcnt      int32
 	packetnr    uint64
 	window      uint64
 	ethertype   layers.EthernetType
@@ -234,7 +244,11 @@ func (pb *packetBuffer) EventNr() uint64 {
 }
 
 func (pb *packetBuffer) Copy() Buffer {
-	pb.refcnt++
+	atomic.AddInt32(&pb.refcnt, 1)
+	return pb
+}
+
+func (pb *packetBuffer) shared() *packetBuffer {
 	return pb
 }
 
@@ -243,6 +257,7 @@ func (pb *packetBuffer) assign(data []byte, ci gopacket.CaptureInfo, lt gopacket
 	pb.network = nil
 	pb.transport = nil
 	pb.application = nil
+	pb.dissect = sync.Once{}
 	pb.failure = nil
 	pb.tcp.Payload = nil
 	pb.proto = 0
@@ -266,21 +281,77 @@ func (pb *packetBuffer) assign(data []byte, ci gopacket.CaptureInfo, lt gopacket
 }
 
 func (pb *packetBuffer) canRecycle() bool {
-	pb.refcnt--
-	if pb.refcnt > 0 {
-		return false
-	}
-	return true
+	return atomic.AddInt32(&pb.refcnt, -1) <= 0
 }
 
 func (pb *packetBuffer) Recycle() {
 	if !pb.canRecycle() {
 		return
 	}
+	if pb.owner == nil {
+		// restored from a checkpoint -> not part of a multiPacketBuffer
+		return
+	}
 	atomic.StoreInt32(&pb.inUse, 0)
 	pb.owner.free(1)
 }
 
+// CheckpointBuffer saves or restores a packet, which is held by a feature (see Copy). Restored packets are decoded
+// again and must be released with Recycle like every other copied packet.
+func CheckpointBuffer(c *flows.Checkpoint, buffer *Buffer) {
+	var pb *packetBuffer
+	var data []byte
+	var when flows.DateTimeNanoseconds
+	var caplen, length, iface int
+	var first int64
+	var truncated bool
+	var window uint64
+	var key string
+	var forward bool
+	if c.Loading() {
+		pb = &packetBuffer{resize: true}
+	} else {
+		// the key, the direction, and the window belong to the table group of the buffer
+		pb = (*buffer).shared()
+		window, key, forward = (*buffer).Window(), (*buffer).Key(), (*buffer).LowToHigh()
+		data = pb.buffer
+		when = flows.DateTimeNanoseconds(pb.ci.Timestamp.UnixNano())
+		caplen, length, iface = pb.ci.CaptureLength, pb.ci.Length, pb.ci.InterfaceIndex
+		first = int64(pb.first)
+		truncated = pb.ci.Truncated
+	}
+	c.Bytes(&data)
+	c.Time(&when)
+	c.Int(&caplen)
+	c.Int(&length)
+	c.Int(&iface)
+	c.Int64(&first)
+	c.Bool(&truncated)
+	c.Uint64(&pb.packetnr)
+	c.Uint64(&window)
+	c.String(&key)
+	c.Bool(&forward)
+	c.Value(&pb.label)
+	if !c.Loading() || c.Err() != nil {
+		return
+	}
+	ci := gopacket.CaptureInfo{
+		Timestamp:      time.Unix(0, int64(when)),
+		CaptureLength:  caplen,
+		Length:         length,
+		InterfaceIndex: iface,
+	}
+	packetnr := pb.packetnr
+	pb.assign(data, ci, gopacket.LayerType(first), packetnr)
+	pb.ci.Truncated = pb.ci.Truncated || truncated
+	if !pb.decode() {
+		c.Fail(fmt.Errorf("couldn't decode packet %d from checkpoint", packetnr))
+		return
+	}
+	pb.window, pb.key, pb.forward = window, key, forward
+	*buffer = pb
+}
+
 func (pb *packetBuffer) Key() string {
 	return pb.key
 }
@@ -298,10 +369,76 @@ func (pb *packetBuffer) SetInfo(key string, forward bool) {
 	pb.forward = forward
 }
 
-//DecodeFeedback
+// groupBuffer is the view of a table group onto a packet shared with other groups. The decoded packet is read-only;
+// only the key, the direction, and the window belong to the group.
+type groupBuffer struct {
+	*packetBuffer
+	key     string
+	window  uint64
+	forward bool
+}
+
+// view returns the view of the given group out of num groups onto this packet
+func (pb *packetBuffer) view(group, num int) *groupBuffer {
+	if len(pb.views) < num {
+		pb.views = make([]groupBuffer, num)
+		for i := range pb.views {
+			pb.views[i].packetBuffer = pb
+		}
+	}
+	return &pb.views[group]
+}
+
+func (gb *groupBuffer) Key() string        { return gb.key }
+func (gb *groupBuffer) LowToHigh() bool    { return gb.forward }
+func (gb *groupBuffer) SetWindow(w uint64) { gb.window = w }
+func (gb *groupBuffer) Window() uint64     { return gb.window }
+func (gb *groupBuffer) Recycle()           { gb.packetBuffer.Recycle() }
+func (gb *groupBuffer) SetInfo(key string, forward bool) {
+	gb.key = key
+	gb.forward = forward
+}
+
+func (gb *groupBuffer) Copy() Buffer {
+	atomic.AddInt32(&gb.refcnt, 1)
+	return gb
+}
+
+var _ flows.TimeoutEvent = (*packetBuffer)(nil)
+
+// TimeoutInfo returns the protocol, the ports, and the tcp state for matching timeout rules
+func (pb *packetBuffer) TimeoutInfo() (proto uint8, srcPort, dstPort uint16, state flows.TCPState) {
+	proto = pb.proto
+	switch transport := pb.transport.(type) {
+	case *layers.TCP:
+		srcPort, dstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+		if transport.SYN {
+			state = flows.TCPStateNew
+		} else {
+			state = flows.TCPStateMidstream
+		}
+	case *layers.UDP:
+		srcPort, dstPort = uint16(transport.SrcPort), uint16(transport.DstPort)
+	}
+	return
+}
+
+var _ flows.ConnectionEvent = (*packetBuffer)(nil)
+
+// ConnectionInfo returns the addresses, the protocol, and the ports for recording connections in a connection context
+func (pb *packetBuffer) ConnectionInfo() (src, dst []byte, proto uint8, srcPort, dstPort uint16, ok bool) {
+	if pb.network == nil {
+		return
+	}
+	network := pb.network.NetworkFlow()
+	proto, srcPort, dstPort, _ = pb.TimeoutInfo()
+	return network.Src().Raw(), network.Dst().Raw(), proto, srcPort, dstPort, true
+}
+
+// DecodeFeedback
 func (pb *packetBuffer) SetTruncated() { pb.ci.Truncated = true }
 
-//gopacket.Packet
+// gopacket.Packet
 func (pb *packetBuffer) String() string { return "PacketBuffer" }
 func (pb *packetBuffer) Dump() string   { return "" }
 func (pb *packetBuffer) Layers() []gopacket.Layer {
@@ -318,6 +455,9 @@ func (pb *packetBuffer) Layers() []gopacket.Layer {
 	if pb.transport != nil {
 		ret = append(ret, pb.transport)
 	}
+	if application := pb.ApplicationLayer(); application != nil {
+		ret = append(ret, application)
+	}
 	return ret
 }
 func (pb *packetBuffer) Layer(lt gopacket.LayerType) gopacket.Layer {
@@ -335,6 +475,9 @@ func (pb *packetBuffer) Layer(lt gopacket.LayerType) gopacket.Layer {
 	if pb.transport != nil && pb.transport.LayerType() == lt {
 		return pb.transport
 	}
+	if application := pb.ApplicationLayer(); application != nil && application.LayerType() == lt {
+		return application
+	}
 	return nil
 }
 func (pb *packetBuffer) LayerClass(lc gopacket.LayerClass) gopacket.Layer {
@@ -352,17 +495,30 @@ func (pb *packetBuffer) LayerClass(lc gopacket.LayerClass) gopacket.Layer {
 	if pb.transport != nil && lc.Contains(pb.transport.LayerType()) {
 		return pb.transport
 	}
+	if application := pb.ApplicationLayer(); application != nil && lc.Contains(application.LayerType()) {
+		return application
+	}
 	return nil
 }
-func (pb *packetBuffer) LinkLayer() gopacket.LinkLayer               { return pb.link }
-func (pb *packetBuffer) NetworkLayer() gopacket.NetworkLayer         { return pb.network }
-func (pb *packetBuffer) TransportLayer() gopacket.TransportLayer     { return pb.transport }
-func (pb *packetBuffer) Dot1QLayers() []layers.Dot1Q                 { return pb.dot1q }
-func (pb *packetBuffer) ApplicationLayer() gopacket.ApplicationLayer { return nil }
-func (pb *packetBuffer) ErrorLayer() gopacket.ErrorLayer             { return nil }
-func (pb *packetBuffer) Data() []byte                                { return pb.buffer }
-func (pb *packetBuffer) Metadata() *gopacket.PacketMetadata          { return &pb.ci }
-func (pb *packetBuffer) Label() interface{}                          { return pb.label }
+func (pb *packetBuffer) LinkLayer() gopacket.LinkLayer           { return pb.link }
+func (pb *packetBuffer) NetworkLayer() gopacket.NetworkLayer     { return pb.network }
+func (pb *packetBuffer) TransportLayer() gopacket.TransportLayer { return pb.transport }
+func (pb *packetBuffer) Dot1QLayers() []layers.Dot1Q             { return pb.dot1q }
+func (pb *packetBuffer) ErrorLayer() gopacket.ErrorLayer         { return nil }
+func (pb *packetBuffer) Data() []byte                            { return pb.buffer }
+func (pb *packetBuffer) Metadata() *gopacket.PacketMetadata      { return &pb.ci }
+func (pb *packetBuffer) Label() interface{}                      { return pb.label }
+func (pb *packetBuffer) PassiveDNS() *PassiveDNS                 { return pb.passiveDNS }
+
+// ApplicationLayer returns the payload decoded by the registered dissectors (see RegisterPortDissector), or nil if no
+// dissector matches. The payload is decoded once on the first call.
+func (pb *packetBuffer) ApplicationLayer() gopacket.ApplicationLayer {
+	// the tables of every group might ask concurrently
+	pb.dissect.Do(func() {
+		pb.application = dissect(pb.transport)
+	})
+	return pb.application
+}
 
 func (pb *packetBuffer) LinkLayerLength() int {
 	if eth, ok := pb.link.(*layers.Ethernet); ok && eth.Length != 0 {
@@ -412,7 +568,7 @@ func (pb *packetBuffer) PayloadLength() int {
 	return 0
 }
 
-//custom decoder for fun and speed. Borrowed from DecodingLayerParser
+// custom decoder for fun and speed. Borrowed from DecodingLayerParser
 func (pb *packetBuffer) decode() (ret bool) {
 	typ := pb.first
 	data := pb.buffer
@@ -0,0 +1,137 @@
+package packet
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// DissectFunc decodes the payload of a transport layer (layers.LayerTypeTCP or layers.LayerTypeUDP) into an
+// application layer. It must return nil, if the payload can't be decoded. The returned layer is cached in the packet
+// and can reference payload.
+type DissectFunc func(payload []byte, transport gopacket.LayerType) gopacket.ApplicationLayer
+
+// HeuristicFunc must return true, if the payload looks like the protocol of the dissector
+type HeuristicFunc func(payload []byte, transport gopacket.LayerType) bool
+
+type dissector struct {
+	name        string
+	description string
+	transport   gopacket.LayerType
+	ports       []uint16
+	heuristic   HeuristicFunc
+	dissect     DissectFunc
+}
+
+type dissectorPort struct {
+	transport gopacket.LayerType
+	port      uint16
+}
+
+var dissectorRegistry []*dissector
+var portDissectors = make(map[dissectorPort][]*dissector)
+var heuristicDissectors = make(map[gopacket.LayerType][]*dissector)
+
+func registerDissector(d *dissector) {
+	if d.transport != layers.LayerTypeTCP && d.transport != layers.LayerTypeUDP {
+		panic(fmt.Sprintf("Dissector '%s' must use tcp or udp", d.name))
+	}
+	dissectorRegistry = append(dissectorRegistry, d)
+}
+
+// RegisterPortDissector registers a dissector for the payload of the given transport (layers.LayerTypeTCP or
+// layers.LayerTypeUDP), if the source or destination port is one of ports.
+func RegisterPortDissector(name, description string, transport gopacket.LayerType, ports []uint16, dissect DissectFunc) {
+	d := &dissector{
+		name:        name,
+		description: description,
+		transport:   transport,
+		ports:       ports,
+		dissect:     dissect,
+	}
+	registerDissector(d)
+	for _, port := range ports {
+		key := dissectorPort{transport, port}
+		portDissectors[key] = append(portDissectors[key], d)
+	}
+}
+
+// RegisterHeuristicDissector registers a dissector for the payload of the given transport (layers.LayerTypeTCP or
+// layers.LayerTypeUDP), which is used if heuristic returns true. Heuristic dissectors are only tried, if no port
+// dissector could decode the payload.
+func RegisterHeuristicDissector(name, description string, transport gopacket.LayerType, heuristic HeuristicFunc, dissect DissectFunc) {
+	d := &dissector{
+		name:        name,
+		description: description,
+		transport:   transport,
+		heuristic:   heuristic,
+		dissect:     dissect,
+	}
+	registerDissector(d)
+	heuristicDissectors[transport] = append(heuristicDissectors[transport], d)
+}
+
+// dissect returns the application layer of the packet decoded by the first matching dissector. Destination port
+// dissectors are tried before source port dissectors, and heuristic dissectors last.
+func dissect(transport gopacket.TransportLayer) gopacket.ApplicationLayer {
+	if transport == nil {
+		return nil
+	}
+	payload := transport.LayerPayload()
+	if len(payload) == 0 {
+		return nil
+	}
+	var src, dst uint16
+	typ := transport.LayerType()
+	switch t := transport.(type) {
+	case *layers.TCP:
+		src, dst = uint16(t.SrcPort), uint16(t.DstPort)
+	case *layers.UDP:
+		src, dst = uint16(t.SrcPort), uint16(t.DstPort)
+	default:
+		return nil
+	}
+	for _, port := range [2]uint16{dst, src} {
+		for _, d := range portDissectors[dissectorPort{typ, port}] {
+			if ret := d.dissect(payload, typ); ret != nil {
+				return ret
+			}
+		}
+		if src == dst {
+			break
+		}
+	}
+	for _, d := range heuristicDissectors[typ] {
+		if d.heuristic(payload, typ) {
+			if ret := d.dissect(payload, typ); ret != nil {
+				return ret
+			}
+		}
+	}
+	return nil
+}
+
+// ListDissectors writes a list of dissectors to w
+func ListDissectors(w io.Writer) {
+	list := make([]*dissector, len(dissectorRegistry))
+	copy(list, dissectorRegistry)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	for _, d := range list {
+		var match string
+		if d.heuristic != nil {
+			match = "heuristic"
+		} else {
+			ports := make([]string, len(d.ports))
+			for i, port := range d.ports {
+				ports[i] = fmt.Sprint(port)
+			}
+			match = "port " + strings.Join(ports, ",")
+		}
+		fmt.Fprintf(w, "%s (%s %s): %s\n", d.name, strings.ToLower(d.transport.String()), match, d.description)
+	}
+}
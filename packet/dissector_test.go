@@ -0,0 +1,181 @@
+package packet
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// dissectedLayer is the application layer returned by the test dissectors
+type dissectedLayer struct {
+	data      []byte
+	dissector string
+}
+
+func (d *dissectedLayer) LayerType() gopacket.LayerType { return gopacket.LayerTypePayload }
+func (d *dissectedLayer) LayerContents() []byte         { return d.data }
+func (d *dissectedLayer) LayerPayload() []byte          { return nil }
+func (d *dissectedLayer) Payload() []byte               { return d.data }
+
+// testDissectors holds the test dissectors and counts their calls
+type testDissectors struct {
+	mutex sync.Mutex
+	calls map[string]int
+}
+
+// dissector returns a dissector, which decodes payloads starting with prefix
+func (td *testDissectors) dissector(name, prefix string) DissectFunc {
+	return func(payload []byte, transport gopacket.LayerType) gopacket.ApplicationLayer {
+		td.mutex.Lock()
+		td.calls[name]++
+		td.mutex.Unlock()
+		if !bytes.HasPrefix(payload, []byte(prefix)) {
+			return nil
+		}
+		return &dissectedLayer{payload, name}
+	}
+}
+
+// registerTestDissectors replaces the registered dissectors with test dissectors until the end of the test
+func registerTestDissectors(t *testing.T) *testDissectors {
+	registry, ports, heuristic := dissectorRegistry, portDissectors, heuristicDissectors
+	dissectorRegistry = nil
+	portDissectors = make(map[dissectorPort][]*dissector)
+	heuristicDissectors = make(map[gopacket.LayerType][]*dissector)
+	t.Cleanup(func() {
+		dissectorRegistry, portDissectors, heuristicDissectors = registry, ports, heuristic
+	})
+	td := &testDissectors{calls: make(map[string]int)}
+	RegisterPortDissector("first", "first dissector of port 1000", layers.LayerTypeUDP, []uint16{1000, 1001}, td.dissector("first", "first"))
+	RegisterPortDissector("second", "second dissector of port 1000", layers.LayerTypeUDP, []uint16{1000}, td.dissector("second", "second"))
+	RegisterPortDissector("any", "dissector of port 1002", layers.LayerTypeUDP, []uint16{1002}, td.dissector("any", ""))
+	RegisterPortDissector("tcp", "dissector of tcp port 1000", layers.LayerTypeTCP, []uint16{1000}, td.dissector("tcp", ""))
+	RegisterHeuristicDissector("heuristic", "dissector for payloads starting with h", layers.LayerTypeUDP,
+		func(payload []byte, transport gopacket.LayerType) bool { return payload[0] == 'h' }, td.dissector("heuristic", ""))
+	return td
+}
+
+func udpTransport(src, dst layers.UDPPort, payload string) gopacket.TransportLayer {
+	udp := &layers.UDP{SrcPort: src, DstPort: dst}
+	udp.Payload = []byte(payload)
+	return udp
+}
+
+func dissectorName(layer gopacket.ApplicationLayer) string {
+	if layer == nil {
+		return "<nil>"
+	}
+	return layer.(*dissectedLayer).dissector
+}
+
+func TestDissectorLookup(t *testing.T) {
+	td := registerTestDissectors(t)
+	tcp := &layers.TCP{SrcPort: 2000, DstPort: 1000}
+	tcp.Payload = []byte("anything")
+	for _, test := range []struct {
+		transport gopacket.TransportLayer
+		dissector string
+	}{
+		{udpTransport(2000, 1000, "first"), "first"},
+		// the first dissector of the port fails -> the next one is tried
+		{udpTransport(2000, 1000, "second"), "second"},
+		// destination port before source port, and source port if the destination port dissectors fail
+		{udpTransport(1002, 1000, "first"), "first"},
+		{udpTransport(1000, 1002, "first"), "any"},
+		{udpTransport(1000, 1001, "second"), "second"},
+		{udpTransport(1000, 2000, "first"), "first"},
+		// heuristic dissectors are tried after the port dissectors, and only if the heuristic matches
+		{udpTransport(2000, 1000, "h"), "heuristic"},
+		{udpTransport(2000, 3000, "first"), "<nil>"},
+		{udpTransport(2000, 3000, ""), "<nil>"},
+		{tcp, "tcp"},
+		{nil, "<nil>"},
+	} {
+		if got := dissectorName(dissect(test.transport)); got != test.dissector {
+			t.Errorf("%v: dissected by %s, expected %s", test.transport, got, test.dissector)
+		}
+	}
+	// the heuristic dissector only runs if the heuristic matches, and never for empty payloads
+	if calls := td.calls["heuristic"]; calls != 1 {
+		t.Errorf("heuristic dissector called %d times, expected 1", calls)
+	}
+
+	var list bytes.Buffer
+	ListDissectors(&list)
+	expected := []string{
+		"any (udp port 1002): dissector of port 1002",
+		"first (udp port 1000,1001): first dissector of port 1000",
+		"heuristic (udp heuristic): dissector for payloads starting with h",
+		"second (udp port 1000): second dissector of port 1000",
+		"tcp (tcp port 1000): dissector of tcp port 1000",
+		"",
+	}
+	if got := list.String(); got != strings.Join(expected, "\n") {
+		t.Errorf("got dissector list\n%s\nexpected\n%s", got, strings.Join(expected, "\n"))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("dissector for ip was registered")
+		}
+	}()
+	RegisterPortDissector("ip", "", layers.LayerTypeIPv4, []uint16{1}, td.dissector("ip", ""))
+}
+
+// assignPacket serializes an udp packet and assigns it to pb as packet nr
+func assignPacket(t *testing.T, pb *packetBuffer, nr uint64, dst layers.UDPPort, payload string) {
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP}
+	udp := &layers.UDP{SrcPort: 2000, DstPort: dst}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, udp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	pb.assign(data, gopacket.CaptureInfo{Timestamp: time.Unix(0, int64(nr)), CaptureLength: len(data), Length: len(data)}, layers.LayerTypeIPv4, nr)
+	if !pb.decode() {
+		t.Fatalf("couldn't decode packet %d", nr)
+	}
+}
+
+func TestDissectorCache(t *testing.T) {
+	td := registerTestDissectors(t)
+	pb := &packetBuffer{resize: true}
+	assignPacket(t, pb, 1, 1000, "first packet")
+
+	// every feature of the record (possibly in parallel tables) gets the layer decoded on the first call
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pb.ApplicationLayer()
+		}()
+	}
+	wg.Wait()
+	first := pb.ApplicationLayer()
+	if dissectorName(first) != "first" || pb.Layer(first.LayerType()) != first || pb.LayerClass(first.LayerType()) != first {
+		t.Fatalf("got application layer %v", first)
+	}
+	if td.calls["first"] != 1 {
+		t.Errorf("first dissector called %d times for one packet, expected 1", td.calls["first"])
+	}
+
+	// the next packet in the same buffer is dissected again
+	assignPacket(t, pb, 2, 1000, "second packet")
+	if second := pb.ApplicationLayer(); dissectorName(second) != "second" || string(second.Payload()) != "second packet" {
+		t.Errorf("second packet: got application layer %v", second)
+	}
+	assignPacket(t, pb, 3, 3000, "no dissector")
+	if third := pb.ApplicationLayer(); third != nil {
+		t.Errorf("third packet: got application layer %v", third)
+	}
+	if td.calls["first"] != 2 {
+		t.Errorf("first dissector called %d times for two packets to its port, expected 2", td.calls["first"])
+	}
+}
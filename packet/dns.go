@@ -0,0 +1,234 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// passiveDNSHistory is the number of names remembered per address
+	passiveDNSHistory = 4
+	// passiveDNSCleanup is the period of removing expired addresses
+	passiveDNSCleanup = 60 * flows.SecondsInNanoseconds
+	// passiveDNSGrace is the time expired addresses are kept for tables lagging behind the engine
+	passiveDNSGrace = 60 * flows.SecondsInNanoseconds
+	// maxCNAMEChain is the maximum number of CNAME records followed back to the queried name
+	maxCNAMEChain = 8
+)
+
+type passiveDNSEntry struct {
+	name    string
+	nr      uint64
+	expires flows.DateTimeNanoseconds
+}
+
+// PassiveDNS is a cache of the names resolved by the DNS answers observed by the engine (see Engine.EnablePassiveDNS).
+//
+// The cache is filled with the A and AAAA records of every DNS answer (UDP or TCP from port 53), while the packets are
+// decoded. Addresses reached via CNAME records are assigned the queried name. Since the tables run behind the
+// engine, every name is stored with the number of the packet containing the answer, and Lookup only returns names
+// from answers seen before the given packet. This keeps lookups independent of the number of tables.
+type PassiveDNS struct {
+	mutex       sync.RWMutex
+	entries     map[string][]passiveDNSEntry
+	nextCleanup flows.DateTimeNanoseconds
+	answers     uint64
+	dns         layers.DNS
+	origin      map[string]string
+}
+
+// FeatureWithPassiveDNS is implemented by features, which need the passive DNS cache (see Buffer.PassiveDNS)
+type FeatureWithPassiveDNS interface {
+	// NeedsPassiveDNS is a marker method
+	NeedsPassiveDNS()
+}
+
+// CheckPassiveDNS returns an error, if the passive DNS cache is disabled, but the records contain a feature needing it
+func CheckPassiveDNS(records flows.RecordListMaker, enabled bool) error {
+	if enabled {
+		return nil
+	}
+	return records.CheckFeatures(func(feature flows.Feature) error {
+		if _, ok := feature.(FeatureWithPassiveDNS); ok {
+			return errors.New("needs -passiveDNS")
+		}
+		return nil
+	})
+}
+
+// NewPassiveDNS returns a new, empty passive DNS cache
+func NewPassiveDNS() *PassiveDNS {
+	return &PassiveDNS{
+		entries: make(map[string][]passiveDNSEntry),
+		origin:  make(map[string]string),
+	}
+}
+
+// dnsPayload returns the DNS message in the payload of a tcp or udp packet
+func dnsPayload(transport gopacket.TransportLayer) []byte {
+	payload := transport.LayerPayload()
+	if transport.LayerType() == layers.LayerTypeTCP {
+		// DNS over TCP has a two byte length prefix; only messages starting in this segment are used
+		if len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) > len(payload)-2 {
+			return nil
+		}
+		payload = payload[2:]
+	}
+	return payload
+}
+
+// observe adds the answers of a DNS response to the cache. Must only be called from the decoding goroutine.
+func (p *PassiveDNS) observe(pb *packetBuffer) {
+	if pb.time > p.nextCleanup {
+		if p.nextCleanup != 0 {
+			p.cleanup(pb.time)
+		}
+		p.nextCleanup = pb.time + passiveDNSCleanup
+	}
+	var port layers.UDPPort
+	switch transport := pb.transport.(type) {
+	case *layers.UDP:
+		port = transport.SrcPort
+	case *layers.TCP:
+		port = layers.UDPPort(transport.SrcPort)
+	default:
+		return
+	}
+	if port != 53 {
+		return
+	}
+	payload := dnsPayload(pb.transport)
+	if len(payload) == 0 {
+		return
+	}
+	dns := &p.dns
+	if err := dns.DecodeFromBytes(payload, gopacket.NilDecodeFeedback); err != nil {
+		return
+	}
+	if !dns.QR || dns.ResponseCode != layers.DNSResponseCodeNoErr || len(dns.Answers) == 0 {
+		return
+	}
+	for name := range p.origin {
+		delete(p.origin, name)
+	}
+	for _, answer := range dns.Answers {
+		if answer.Type == layers.DNSTypeCNAME {
+			p.origin[string(answer.CNAME)] = string(answer.Name)
+		}
+	}
+	p.mutex.Lock()
+	for _, answer := range dns.Answers {
+		var ip net.IP
+		switch answer.Type {
+		case layers.DNSTypeA:
+			ip = answer.IP.To4()
+		case layers.DNSTypeAAAA:
+			ip = answer.IP.To16()
+		}
+		if ip == nil {
+			continue
+		}
+		name := string(answer.Name)
+		for i := 0; i < maxCNAMEChain; i++ {
+			alias, ok := p.origin[name]
+			if !ok {
+				break
+			}
+			name = alias
+		}
+		p.add(string(ip), passiveDNSEntry{
+			name:    name,
+			nr:      pb.packetnr,
+			expires: pb.time + flows.DateTimeNanoseconds(answer.TTL)*flows.SecondsInNanoseconds,
+		})
+	}
+	p.mutex.Unlock()
+}
+
+// add appends a name to the history of an address. Must be called with the mutex held.
+func (p *PassiveDNS) add(ip string, entry passiveDNSEntry) {
+	p.answers++
+	history := p.entries[ip]
+	if len(history) == passiveDNSHistory {
+		copy(history, history[1:])
+		history = history[:len(history)-1]
+	}
+	p.entries[ip] = append(history, entry)
+}
+
+// cleanup removes the addresses, whose newest name expired before now - passiveDNSGrace
+func (p *PassiveDNS) cleanup(now flows.DateTimeNanoseconds) {
+	p.mutex.Lock()
+	for ip, history := range p.entries {
+		if history[len(history)-1].expires+passiveDNSGrace < now {
+			delete(p.entries, ip)
+		}
+	}
+	p.mutex.Unlock()
+}
+
+// Lookup returns the most recent name resolved to the address ip by an answer before packet nr. ok is false if there
+// is no such answer, or if the TTL of the answer expired before when.
+func (p *PassiveDNS) Lookup(ip []byte, nr uint64, when flows.DateTimeNanoseconds) (name string, ok bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	history := p.entries[string(ip)]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].nr < nr {
+			if when > history[i].expires {
+				return "", false
+			}
+			return history[i].name, true
+		}
+	}
+	return "", false
+}
+
+// Stats returns the number of observed answers and the number of addresses in the cache
+func (p *PassiveDNS) Stats() (answers uint64, addresses int) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.answers, len(p.entries)
+}
+
+// checkpoint saves or restores the cache
+func (p *PassiveDNS) checkpoint(c *flows.Checkpoint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	c.Time(&p.nextCleanup)
+	c.Uint64(&p.answers)
+	n := c.Len(len(p.entries))
+	if !c.Loading() {
+		for ip, history := range p.entries {
+			checkpointDNSHistory(c, &ip, &history)
+		}
+		return
+	}
+	p.entries = make(map[string][]passiveDNSEntry, n)
+	for i := 0; i < n && c.Err() == nil; i++ {
+		var ip string
+		var history []passiveDNSEntry
+		checkpointDNSHistory(c, &ip, &history)
+		p.entries[ip] = history
+	}
+}
+
+func checkpointDNSHistory(c *flows.Checkpoint, ip *string, history *[]passiveDNSEntry) {
+	c.String(ip)
+	n := c.Len(len(*history))
+	if c.Loading() {
+		*history = make([]passiveDNSEntry, n)
+	}
+	for i := range *history {
+		entry := &(*history)[i]
+		c.String(&entry.name)
+		c.Uint64(&entry.nr)
+		c.Time(&entry.expires)
+	}
+}
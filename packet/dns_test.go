@@ -0,0 +1,161 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// dnsResponse returns a DNS response with the given answers and response code
+func dnsResponse(t *testing.T, qr bool, code layers.DNSResponseCode, answers ...layers.DNSResourceRecord) []byte {
+	dns := &layers.DNS{ID: 1, QR: qr, ResponseCode: code, Answers: answers, ANCount: uint16(len(answers))}
+	buf := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buf, gopacket.SerializeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func dnsA(name string, ip string, ttl uint32) layers.DNSResourceRecord {
+	typ := layers.DNSTypeA
+	if net.ParseIP(ip).To4() == nil {
+		typ = layers.DNSTypeAAAA
+	}
+	return layers.DNSResourceRecord{Name: []byte(name), Type: typ, Class: layers.DNSClassIN, TTL: ttl, IP: net.ParseIP(ip)}
+}
+
+func dnsCNAME(name, cname string) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{Name: []byte(name), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 60, CNAME: []byte(cname)}
+}
+
+// observeDNS hands a DNS message from port to the cache as packet nr at second s
+func observeDNS(p *PassiveDNS, nr uint64, s flows.DateTimeNanoseconds, port uint16, tcp bool, message []byte) {
+	ip := &layers.IPv4{SrcIP: net.IP{10, 0, 0, 53}, DstIP: net.IP{10, 0, 0, 1}}
+	var transport SerializableLayerType
+	if tcp {
+		ip.Protocol = layers.IPProtocolTCP
+		prefix := make([]byte, 2, 2+len(message))
+		binary.BigEndian.PutUint16(prefix, uint16(len(message)))
+		segment := &layers.TCP{SrcPort: layers.TCPPort(port), DstPort: 1000}
+		segment.Payload = append(prefix, message...)
+		transport = segment
+	} else {
+		ip.Protocol = layers.IPProtocolUDP
+		datagram := &layers.UDP{SrcPort: layers.UDPPort(port), DstPort: 1000}
+		datagram.Payload = message
+		transport = datagram
+	}
+	pb := BufferFromLayers(s*flows.SecondsInNanoseconds, ip, transport).(*packetBuffer)
+	pb.packetnr = nr
+	p.observe(pb)
+}
+
+type dnsLookup struct {
+	ip   string
+	nr   uint64
+	s    flows.DateTimeNanoseconds
+	name string
+}
+
+func checkLookups(t *testing.T, p *PassiveDNS, lookups []dnsLookup) {
+	t.Helper()
+	for _, lookup := range lookups {
+		ip := net.ParseIP(lookup.ip)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		name, ok := p.Lookup(ip, lookup.nr, lookup.s*flows.SecondsInNanoseconds)
+		if !ok {
+			name = "<none>"
+		}
+		if name != lookup.name {
+			t.Errorf("lookup of %s at packet %d, %ds: got %s, expected %s", lookup.ip, lookup.nr, lookup.s, name, lookup.name)
+		}
+	}
+}
+
+func TestPassiveDNS(t *testing.T) {
+	p := NewPassiveDNS()
+	// www.example.com -> cdn.example.net (CNAME) -> 192.0.2.1 and 2001:db8::1
+	observeDNS(p, 1, 1, 53, false, dnsResponse(t, true, layers.DNSResponseCodeNoErr,
+		dnsCNAME("www.example.com", "cdn.example.net"), dnsA("cdn.example.net", "192.0.2.1", 60), dnsA("cdn.example.net", "2001:db8::1", 60)))
+	// the same address with a short TTL over TCP
+	observeDNS(p, 5, 2, 53, true, dnsResponse(t, true, layers.DNSResponseCodeNoErr, dnsA("other.example.org", "192.0.2.1", 10)))
+	// queries, errors, and other ports are ignored
+	observeDNS(p, 6, 2, 53, false, dnsResponse(t, false, layers.DNSResponseCodeNoErr, dnsA("query.example.org", "192.0.2.2", 60)))
+	observeDNS(p, 7, 2, 53, false, dnsResponse(t, true, layers.DNSResponseCodeNXDomain, dnsA("error.example.org", "192.0.2.2", 60)))
+	observeDNS(p, 8, 2, 5353, false, dnsResponse(t, true, layers.DNSResponseCodeNoErr, dnsA("mdns.example.org", "192.0.2.2", 60)))
+	observeDNS(p, 9, 2, 53, false, []byte{1, 2, 3})
+	checkLookups(t, p, []dnsLookup{
+		// only answers before the packet count
+		{"192.0.2.1", 1, 1, "<none>"},
+		{"192.0.2.1", 2, 1, "www.example.com"},
+		{"2001:db8::1", 2, 1, "www.example.com"},
+		{"192.0.2.1", 5, 30, "www.example.com"},
+		{"192.0.2.1", 6, 3, "other.example.org"},
+		// the most recent answer expired
+		{"192.0.2.1", 6, 13, "<none>"},
+		{"2001:db8::1", 6, 62, "<none>"},
+		{"192.0.2.2", 10, 3, "<none>"},
+		{"192.0.2.99", 10, 3, "<none>"},
+	})
+	if answers, addresses := p.Stats(); answers != 3 || addresses != 2 {
+		t.Errorf("got %d answers for %d addresses, expected 3 answers for 2 addresses", answers, addresses)
+	}
+
+	// checkpoints restore the cache
+	var buf bytes.Buffer
+	c := flows.NewCheckpointWriter(&buf)
+	p.checkpoint(c)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewPassiveDNS()
+	c = flows.NewCheckpointReader(&buf)
+	restored.checkpoint(c)
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	}
+	checkLookups(t, restored, []dnsLookup{
+		{"192.0.2.1", 2, 1, "www.example.com"},
+		{"2001:db8::1", 2, 1, "www.example.com"},
+		{"192.0.2.1", 6, 3, "other.example.org"},
+	})
+	if answers, addresses := restored.Stats(); answers != 3 || addresses != 2 {
+		t.Errorf("restored %d answers for %d addresses, expected 3 answers for 2 addresses", answers, addresses)
+	}
+}
+
+func TestPassiveDNSLimits(t *testing.T) {
+	p := NewPassiveDNS()
+	// only the last names of an address are kept
+	names := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com", "f.example.com"}
+	for i, name := range names {
+		observeDNS(p, uint64(i+1), 1, 53, false, dnsResponse(t, true, layers.DNSResponseCodeNoErr, dnsA(name, "192.0.2.1", 1000)))
+	}
+	lookups := []dnsLookup{{"192.0.2.1", 2, 1, "<none>"}}
+	for i := len(names) - passiveDNSHistory; i < len(names); i++ {
+		lookups = append(lookups, dnsLookup{"192.0.2.1", uint64(i + 2), 1, names[i]})
+	}
+	checkLookups(t, p, lookups)
+
+	// expired addresses are removed after the grace period
+	observeDNS(p, 10, 2, 53, false, dnsResponse(t, true, layers.DNSResponseCodeNoErr, dnsA("short.example.com", "192.0.2.2", 1)))
+	if _, addresses := p.Stats(); addresses != 2 {
+		t.Fatalf("got %d addresses, expected 2", addresses)
+	}
+	expiry := (passiveDNSCleanup + passiveDNSGrace) / flows.SecondsInNanoseconds
+	observeDNS(p, 11, 2+expiry, 53, false, nil)
+	if _, addresses := p.Stats(); addresses != 1 {
+		t.Errorf("got %d addresses after the expiry of one address, expected 1", addresses)
+	}
+	checkLookups(t, p, []dnsLookup{
+		{"192.0.2.1", 12, 3 + expiry, "f.example.com"},
+		{"192.0.2.2", 12, 2, "<none>"},
+	})
+}
@@ -5,15 +5,63 @@ import (
 	"github.com/google/gopacket/layers"
 )
 
+// TCPConnectionState is the state of a tcp connection as tracked by tcp flows
+type TCPConnectionState uint8
+
+const (
+	// TCPStateSynSent is a connection starting with a SYN, which wasn't answered yet
+	TCPStateSynSent TCPConnectionState = iota
+	// TCPStateSynReceived is a connection, where the SYN was answered with a SYN+ACK
+	TCPStateSynReceived
+	// TCPStateEstablished is a connection after the handshake, or a connection picked up in the middle
+	TCPStateEstablished
+	// TCPStateFinWait is a connection, where at least one side sent a FIN
+	TCPStateFinWait
+	// TCPStateTimeWait is a connection, where both sides sent a FIN, which was acknowledged
+	TCPStateTimeWait
+	// TCPStateClosed is a connection, which was reset
+	TCPStateClosed
+)
+
+func (s TCPConnectionState) String() string {
+	switch s {
+	case TCPStateSynSent:
+		return "SYN_SENT"
+	case TCPStateSynReceived:
+		return "SYN_RECEIVED"
+	case TCPStateEstablished:
+		return "ESTABLISHED"
+	case TCPStateFinWait:
+		return "FIN_WAIT"
+	case TCPStateTimeWait:
+		return "TIME_WAIT"
+	case TCPStateClosed:
+		return "CLOSED"
+	}
+	return "UNKNOWN"
+}
+
+// TCPFlow is a flow tracking the tcp connection state
+type TCPFlow interface {
+	flows.Flow
+	// TCPState returns the current state of the tcp connection
+	TCPState() TCPConnectionState
+}
+
 type tcpFlow struct {
 	flows.BaseFlow
 	srcFIN, dstFIN, dstACK, srcACK bool
+	state                          TCPConnectionState
+	// idle is the idle timeout of the flow without tcp state timeouts
+	idle flows.DateTimeNanoseconds
 }
 
 type uniFlow struct {
 	flows.BaseFlow
 }
 
+var timerTimeWait = flows.RegisterTimer()
+
 // NewFlow creates a new flow based on a given event, table, key, context, and flow-id
 //
 // Depending on the event this will either be a tcp flow, or a standard flow
@@ -23,6 +71,17 @@ func NewFlow(event flows.Event, table *flows.FlowTable, key string, lowToHigh bo
 		if tp != nil && tp.LayerType() == layers.LayerTypeTCP {
 			ret := new(tcpFlow)
 			ret.Init(table, key, lowToHigh, context, id)
+			ret.idle = ret.IdleTimeout()
+			tcp := tp.(*layers.TCP)
+			switch {
+			case tcp.SYN && !tcp.ACK:
+				ret.state = TCPStateSynSent
+			case tcp.SYN:
+				ret.state = TCPStateSynReceived
+			default:
+				ret.state = TCPStateEstablished
+			}
+			ret.stateTimeout()
 			return ret
 		}
 	}
@@ -31,18 +90,57 @@ func NewFlow(event flows.Event, table *flows.FlowTable, key string, lowToHigh bo
 	return ret
 }
 
-func (flow *tcpFlow) Event(event flows.Event, context *flows.EventContext) {
-	flow.BaseFlow.Event(event, context)
-	if !flow.Active() {
-		return
+// TCPState returns the current state of the tcp connection
+func (flow *tcpFlow) TCPState() TCPConnectionState {
+	return flow.state
+}
+
+// stateTimeout sets the idle timeout according to the connection state
+func (flow *tcpFlow) stateTimeout() {
+	timeouts := flow.Table().TCPTimeouts
+	var timeout flows.DateTimeNanoseconds
+	switch flow.state {
+	case TCPStateSynSent, TCPStateSynReceived:
+		timeout = timeouts.SynSent
+	case TCPStateEstablished:
+		timeout = timeouts.Established
+	case TCPStateFinWait:
+		timeout = timeouts.FinWait
 	}
-	buffer := event.(Buffer)
-	tcp := buffer.TransportLayer().(*layers.TCP)
+	if timeout == 0 {
+		timeout = flow.idle
+	}
+	flow.SetIdleTimeout(timeout)
+}
+
+func (flow *tcpFlow) timeWaitEvent(expires, now flows.DateTimeNanoseconds) {
+	flow.ExportWithoutContext(flows.FlowEndReasonEnd, expires, now)
+}
+
+// update advances the connection state with the given packet
+func (flow *tcpFlow) update(tcp *layers.TCP, context *flows.EventContext) {
+	old := flow.state
 	if tcp.RST {
-		flow.Export(flows.FlowEndReasonEnd, context, context.When())
+		flow.state = TCPStateClosed
 		return
 	}
-	if context.Forward() {
+	forward := context.Forward()
+	switch flow.state {
+	case TCPStateSynSent:
+		if !forward {
+			if tcp.SYN && tcp.ACK {
+				flow.state = TCPStateSynReceived
+			} else if !tcp.SYN {
+				// missed the SYN+ACK
+				flow.state = TCPStateEstablished
+			}
+		}
+	case TCPStateSynReceived:
+		if forward && !tcp.SYN && tcp.ACK {
+			flow.state = TCPStateEstablished
+		}
+	}
+	if forward {
 		if tcp.FIN {
 			flow.srcFIN = true
 		}
@@ -57,8 +155,68 @@ func (flow *tcpFlow) Event(event flows.Event, context *flows.EventContext) {
 			flow.srcACK = true
 		}
 	}
-
 	if flow.srcFIN && flow.srcACK && flow.dstFIN && flow.dstACK {
+		flow.state = TCPStateTimeWait
+	} else if flow.srcFIN || flow.dstFIN {
+		flow.state = TCPStateFinWait
+	}
+	if flow.state == old {
+		return
+	}
+	if flow.state == TCPStateTimeWait {
+		if timeWait := flow.Table().TCPTimeouts.TimeWait; timeWait != 0 {
+			flow.SetIdleTimeout(0)
+			flow.RemoveTimer(flows.TimerIdle)
+			flow.AddTimer(timerTimeWait, flow.timeWaitEvent, context.When()+timeWait)
+		}
+		return
+	}
+	flow.stateTimeout()
+}
+
+// StartsNewFlow returns true for a SYN after the connection was closed (or half closed)
+func (flow *tcpFlow) StartsNewFlow(event flows.Event, context *flows.EventContext) bool {
+	switch flow.state {
+	case TCPStateFinWait, TCPStateTimeWait, TCPStateClosed:
+		tcp := event.(Buffer).TransportLayer().(*layers.TCP)
+		return tcp.SYN && !tcp.ACK
+	}
+	return false
+}
+
+func (flow *tcpFlow) Event(event flows.Event, context *flows.EventContext) {
+	buffer := event.(Buffer)
+	tcp := buffer.TransportLayer().(*layers.TCP)
+	flow.update(tcp, context)
+	flow.BaseFlow.Event(event, context)
+	if !flow.Active() {
+		return
+	}
+	switch flow.state {
+	case TCPStateClosed:
 		flow.Export(flows.FlowEndReasonEnd, context, context.When())
+	case TCPStateTimeWait:
+		if flow.Table().TCPTimeouts.TimeWait == 0 {
+			flow.Export(flows.FlowEndReasonEnd, context, context.When())
+		}
+	}
+}
+
+// Checkpoint saves or restores the flow including the tcp connection state
+func (flow *tcpFlow) Checkpoint(c *flows.Checkpoint) {
+	flow.BaseFlow.Checkpoint(c)
+	c.Bool(&flow.srcFIN)
+	c.Bool(&flow.dstFIN)
+	c.Bool(&flow.dstACK)
+	c.Bool(&flow.srcACK)
+	c.Uint8((*uint8)(&flow.state))
+	c.Time(&flow.idle)
+	if c.Loading() {
+		flow.RestoreTimer(timerTimeWait, flow.timeWaitEvent)
 	}
 }
+
+func init() {
+	flows.RegisterFlowType(func() flows.Flow { return new(tcpFlow) })
+	flows.RegisterFlowType(func() flows.Flow { return new(uniFlow) })
+}
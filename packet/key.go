@@ -3,6 +3,7 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -84,7 +85,14 @@ MAIN:
 		if len(isFivetuple) == 0 {
 			ret.fivetuple = true
 		}
-		for _, pair := range pairs {
+		// the key layout must not depend on map order, since flow keys are part of checkpoints
+		ids := make([]int, 0, len(pairs))
+		for id := range pairs {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			pair := pairs[id]
 			if len(pair) != 2 {
 				continue
 			}
@@ -39,3 +39,20 @@ func BenchmarkDynamicFiveTuple6(b *testing.B) {
 		key.Key(buffer6)
 	}
 }
+
+func TestDynamicKeyLayout(t *testing.T) {
+	buffer := BufferFromLayers(0,
+		&layers.IPv4{SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolTCP},
+		&layers.TCP{SrcPort: 1000, DstPort: 80},
+	)
+	fivetuple := []string{"sourceIPAddress", "destinationIPAddress", "protocolIdentifier", "sourceTransportPort", "destinationTransportPort"}
+	first := MakeDynamicKeySelector(fivetuple, true, false)
+	want, _, _ := first.Key(buffer)
+	// flow keys are part of checkpoints -> every selector with the same specification must produce the same key
+	for i := 0; i < 20; i++ {
+		selector := MakeDynamicKeySelector(fivetuple, true, false)
+		if key, _, _ := selector.Key(buffer); key != want {
+			t.Fatalf("key %x differs from key %x of the same specification", key, want)
+		}
+	}
+}
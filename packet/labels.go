@@ -1,8 +1,10 @@
 package packet
 
 import (
+	"fmt"
 	"io"
 
+	"github.com/CN-TU/go-flows/flows"
 	"github.com/CN-TU/go-flows/util"
 )
 
@@ -15,6 +17,13 @@ type Label interface {
 	GetLabel(packet Buffer) (interface{}, error)
 }
 
+// CheckpointLabel is a Label that supports checkpoints
+type CheckpointLabel interface {
+	Label
+	// Checkpoint saves the current read position, or continues reading at the restored position
+	Checkpoint(*flows.Checkpoint)
+}
+
 // Labels holds a collection of labels that are tried one after another
 type Labels []Label
 
@@ -32,6 +41,26 @@ RETRY:
 	goto RETRY
 }
 
+// checkpoint saves or restores the current label and its read position
+func (l *Labels) checkpoint(c *flows.Checkpoint) {
+	remaining := c.Len(len(*l))
+	if c.Loading() {
+		if remaining > len(*l) {
+			c.Fail(fmt.Errorf("checkpoint has %d remaining labels, but only %d labels are specified", remaining, len(*l)))
+			return
+		}
+		*l = (*l)[len(*l)-remaining:]
+	}
+	if len(*l) == 0 {
+		return
+	}
+	if label, ok := (*l)[0].(CheckpointLabel); ok {
+		label.Checkpoint(c)
+	} else {
+		c.Fail(fmt.Errorf("label %s doesn't support checkpoints", (*l)[0].ID()))
+	}
+}
+
 // RegisterLabel registers an label (see module system in util)
 func RegisterLabel(name, desc string, new util.ModuleCreator, help util.ModuleHelp) {
 	util.RegisterModule(labelName, name, desc, new, help)
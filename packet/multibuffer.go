@@ -110,17 +110,22 @@ func (mpb *multiPacketBuffer) Pop(buffer *shallowMultiPacketBuffer, low func(int
 }
 
 type shallowMultiPacketBuffer struct {
-	buffers   []*packetBuffer
+	// buffers are either packets or group views onto them (see groupBuffer)
+	buffers   []Buffer
 	owner     *shallowMultiPacketBufferRing
 	rindex    int
 	windex    int
 	timestamp flows.DateTimeNanoseconds
 	expire    bool
+	// progress is the packet number of the last packet in the original buffer (used for watermark sorting)
+	progress uint64
+	// barrier is released after this buffer was handled (used for checkpoints)
+	barrier *sync.WaitGroup
 }
 
 func newShallowMultiPacketBuffer(size int, owner *shallowMultiPacketBufferRing) *shallowMultiPacketBuffer {
 	return &shallowMultiPacketBuffer{
-		buffers: make([]*packetBuffer, size),
+		buffers: make([]Buffer, size),
 		owner:   owner,
 	}
 }
@@ -138,7 +143,7 @@ func (smpb *shallowMultiPacketBuffer) reset() {
 	smpb.windex = 0
 }
 
-func (smpb *shallowMultiPacketBuffer) push(buffer *packetBuffer) bool {
+func (smpb *shallowMultiPacketBuffer) push(buffer Buffer) bool {
 	if smpb.windex >= len(smpb.buffers) || smpb.windex < 0 {
 		return false
 	}
@@ -147,7 +152,7 @@ func (smpb *shallowMultiPacketBuffer) push(buffer *packetBuffer) bool {
 	return true
 }
 
-func (smpb *shallowMultiPacketBuffer) read() (ret *packetBuffer) {
+func (smpb *shallowMultiPacketBuffer) read() (ret Buffer) {
 	if smpb.rindex >= len(smpb.buffers) || smpb.rindex >= smpb.windex || smpb.rindex < 0 {
 		return nil
 	}
@@ -184,11 +189,11 @@ func (smpb *shallowMultiPacketBuffer) recycleEmpty() {
 func (smpb *shallowMultiPacketBuffer) recycle() {
 	if !smpb.empty() {
 		var num int32
-		mpb := smpb.buffers[0].owner
+		mpb := smpb.buffers[0].shared().owner
 		buf := smpb.buffers[:smpb.windex]
-		for i, b := range buf {
-			if b.canRecycle() {
-				atomic.StoreInt32(&buf[i].inUse, 0)
+		for _, b := range buf {
+			if pb := b.shared(); pb.canRecycle() {
+				atomic.StoreInt32(&pb.inUse, 0)
 				num++
 			}
 		}
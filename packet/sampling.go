@@ -0,0 +1,73 @@
+package packet
+
+import (
+	"hash/crc32"
+
+	"github.com/CN-TU/go-flows/flows"
+)
+
+// sampler decides which packets are used according to the sampling configuration
+type sampler struct {
+	flows.Sampling
+	// count is the number of packets since the last sampled packet (deterministic sampling)
+	count uint64
+	// state is the state of the random number generator (random sampling)
+	state uint64
+	// dropped is the number of packets not used due to deterministic or random sampling
+	dropped uint64
+	// scratch holds the flow key for hashing (hash sampling)
+	scratch []byte
+}
+
+func newSampler(s flows.Sampling) sampler {
+	if s.Interval == 0 {
+		s.Interval = 1
+	}
+	return sampler{Sampling: s, state: s.Seed}
+}
+
+// mix64 is the finalizer of splitmix64
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// packet returns true if the next packet should be used (deterministic and random sampling)
+func (s *sampler) packet() bool {
+	var ok bool
+	switch s.Mode {
+	case flows.SamplingDeterministic:
+		ok = s.count == 0
+		s.count++
+		if s.count == uint64(s.Interval) {
+			s.count = 0
+		}
+	case flows.SamplingRandom:
+		s.state += 0x9e3779b97f4a7c15
+		ok = mix64(s.state)%uint64(s.Interval) == 0
+	default:
+		return true
+	}
+	if !ok {
+		s.dropped++
+	}
+	return ok
+}
+
+// flow returns true if packets with the given flow key should be used (hash sampling)
+func (s *sampler) flow(key string) bool {
+	if s.Mode != flows.SamplingHash {
+		return true
+	}
+	// hash-based filtering using CRC (RFC 5475): the seed is the initial value of the CRC
+	s.scratch = append(s.scratch[:0], key...)
+	return crc32.Update(uint32(s.Seed^s.Seed>>32), crc32.IEEETable, s.scratch)%s.Interval == 0
+}
+
+func (s *sampler) checkpoint(c *flows.Checkpoint) {
+	c.Check("sampling", s.Sampling)
+	c.Uint64(&s.count)
+	c.Uint64(&s.state)
+	c.Uint64(&s.dropped)
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"sync"
+	"sync/atomic"
 
 	"github.com/CN-TU/go-flows/flows"
 	"github.com/google/gopacket"
@@ -45,7 +48,7 @@ type Stats struct {
 	buffersReleased  int
 }
 
-// Engine holds and manages buffers, sources, filters and forwards packets to the flowtable
+// Engine holds and manages buffers, sources, filters and forwards packets to the flowtables
 type Engine struct {
 	empty       *multiPacketBuffer
 	todecode    *shallowMultiPacketBufferRing
@@ -53,68 +56,128 @@ type Engine struct {
 	packetStats Stats
 	full        int
 	plen        int
-	flowtable   EventTable
+	flowtables  []EventTable
 	done        chan struct{}
 	sources     Sources
 	filters     Filters
 	labels      Labels
+	sampler     sampler
+	passiveDNS  *PassiveDNS
+	lastTime    flows.DateTimeNanoseconds
+	// checkpointFile is the file checkpoints are written to
+	checkpointFile string
+	// checkpointRequest is checkpointNone, checkpointContinue, or checkpointStop
+	checkpointRequest int32
+	suspended         bool
 }
 
+const (
+	checkpointNone int32 = iota
+	checkpointContinue
+	checkpointStop
+)
+
 // NewEngine initializes a new packet handling engine.
-// Packets of plen size are handled (0 means automatic). Packets are read from sources, filtered with filter, and forwarded to flowtable. Labels are assigned to the packets from the labels provider.
-func NewEngine(plen int, flowtable EventTable, filters Filters, sources Sources, labels Labels) *Engine {
+// Packets of plen size are handled (0 means automatic). Packets are read from sources, filtered with filter, and forwarded to every flowtable. Labels are assigned to the packets from the labels provider.
+//
+// Every packet is decoded and labeled once and then shared by all the flow tables, which only compute their own flow
+// key. Shared packets must not be modified by the tables.
+func NewEngine(plen int, flowtables []EventTable, filters Filters, sources Sources, labels Labels) *Engine {
 	prealloc := plen
 	if plen == 0 {
 		prealloc = 1500
 	}
 	ret := &Engine{
-		empty:     newMultiPacketBuffer(batchSize, prealloc, plen == 0),
-		todecode:  newShallowMultiPacketBufferRing(fullBuffers, batchSize),
-		plen:      plen,
-		flowtable: flowtable,
-		done:      make(chan struct{}),
-		sources:   sources,
-		filters:   filters,
-		labels:    labels,
+		empty:      newMultiPacketBuffer(batchSize, prealloc, plen == 0),
+		todecode:   newShallowMultiPacketBufferRing(fullBuffers, batchSize),
+		plen:       plen,
+		flowtables: flowtables,
+		done:       make(chan struct{}),
+		sources:    sources,
+		filters:    filters,
+		labels:     labels,
+		sampler:    newSampler(flows.Sampling{}),
 	}
 
 	go func() {
 		defer close(ret.done)
 		discard := newShallowMultiPacketBuffer(batchSize, nil)
-		forward := newShallowMultiPacketBuffer(batchSize, nil)
-		stats := flowtable.getDecodeStats()
-		selector := flowtable.getSelector()
-		labels := ret.labels
+		forward := make([]*shallowMultiPacketBuffer, len(flowtables))
+		stats := make([]*decodeStats, len(flowtables))
+		selectors := make([]DynamicKeySelector, len(flowtables))
+		for i, flowtable := range flowtables {
+			forward[i] = newShallowMultiPacketBuffer(batchSize, nil)
+			stats[i] = flowtable.getDecodeStats()
+			selectors[i] = flowtable.getSelector()
+		}
+		labels := &ret.labels
+		sampler := &ret.sampler
+		passiveDNS := &ret.passiveDNS
+		groups := len(flowtables)
 		for {
 			multibuffer, ok := ret.todecode.popFull()
 			if !ok {
 				return
 			}
-			forward.setTimestamp(multibuffer.Timestamp())
+			barrier := multibuffer.barrier
+			multibuffer.barrier = nil
+			for _, f := range forward {
+				f.setTimestamp(multibuffer.Timestamp())
+			}
 			for {
-				buffer := multibuffer.read()
-				if buffer == nil {
+				b := multibuffer.read()
+				if b == nil {
 					break
 				}
+				buffer := b.shared()
+				buffer.passiveDNS = *passiveDNS
 				if !buffer.decode() {
-					stats.decodeError++
+					for _, s := range stats {
+						s.decodeError++
+					}
 					discard.push(buffer)
-				} else {
-					buffer.label = labels.GetLabel(buffer)
-					key, fw, ok := selector.Key(buffer)
-					if ok {
-						buffer.SetInfo(key, fw)
-						forward.push(buffer)
+					continue
+				}
+				buffer.label = labels.GetLabel(buffer)
+				if *passiveDNS != nil {
+					(*passiveDNS).observe(buffer)
+				}
+				// every group that accepts the packet holds one reference to it
+				var refs int32
+				for table := range flowtables {
+					key, fw, ok := selectors[table].Key(buffer)
+					if !ok {
+						stats[table].keyError++
+					} else if !sampler.flow(key) {
+						stats[table].unsampled++
 					} else {
-						stats.keyError++
-						discard.push(buffer)
+						var target Buffer = buffer
+						if groups > 1 {
+							target = buffer.view(table, groups)
+						}
+						target.SetInfo(key, fw)
+						forward[table].push(target)
+						refs++
 					}
 				}
+				if refs == 0 {
+					discard.push(buffer)
+				} else {
+					atomic.StoreInt32(&buffer.refcnt, refs)
+				}
 			}
 			multibuffer.recycleEmpty()
-			flowtable.event(forward)
-			forward.reset()
+			for i, flowtable := range flowtables {
+				flowtable.event(forward[i])
+				forward[i].reset()
+			}
 			discard.recycle()
+			if barrier != nil {
+				for _, flowtable := range flowtables {
+					flowtable.barrier()
+				}
+				barrier.Done()
+			}
 		}
 	}()
 
@@ -123,6 +186,27 @@ func NewEngine(plen int, flowtable EventTable, filters Filters, sources Sources,
 	return ret
 }
 
+// unsampled returns the number of packets not used due to sampling
+func (input *Engine) unsampled() uint64 {
+	ret := input.sampler.dropped
+	for _, flowtable := range input.flowtables {
+		ret += flowtable.getDecodeStats().unsampled
+	}
+	return ret
+}
+
+// tableUsage returns the buffer usage of all the tables
+func (input *Engine) tableUsage() []bufferUsage {
+	if len(input.flowtables) == 1 {
+		return input.flowtables[0].usage()
+	}
+	var ret []bufferUsage
+	for _, flowtable := range input.flowtables {
+		ret = append(ret, flowtable.usage()...)
+	}
+	return ret
+}
+
 // PrintStats writes the packet statistics to w
 func (input *Engine) PrintStats(w io.Writer) {
 	fmt.Fprintf(w,
@@ -130,11 +214,20 @@ func (input *Engine) PrintStats(w io.Writer) {
 	overall: %d
 	skipped: %d
 	filtered: %d
+	not sampled: %d
 Buffer statistics:
 	peak: %d
 	allocated: %d
 	freed: %d
-`, input.packetStats.packets, input.packetStats.skipped, input.packetStats.filtered, input.packetStats.maxBuffers, input.packetStats.buffersAllocated, input.packetStats.buffersReleased)
+`, input.packetStats.packets, input.packetStats.skipped, input.packetStats.filtered, input.unsampled(), input.packetStats.maxBuffers, input.packetStats.buffersAllocated, input.packetStats.buffersReleased)
+	if input.passiveDNS != nil {
+		answers, addresses := input.passiveDNS.Stats()
+		fmt.Fprintf(w,
+			`Passive DNS statistics:
+	answers: %d
+	addresses: %d
+`, answers, addresses)
+	}
 }
 
 // Finish submits eventual partially filled buffers, flushes the packet handling pipeline and waits for everything to finish.
@@ -145,13 +238,15 @@ func (input *Engine) Finish() {
 	input.todecode.close()
 	<-input.done
 
-	input.flowtable.flush()
+	for _, flowtable := range input.flowtables {
+		flowtable.flush()
+	}
 }
 
 // starved gets executed if we couldn't get batchSizes empty packets in one go
 func (input *Engine) starved(have int, max int) {
 	todecode := input.todecode.usage()
-	table := input.flowtable.usage()
+	table := input.tableUsage()
 	alloc := false
 	stop := false
 	if todecode.buffers < lowMark {
@@ -189,7 +284,7 @@ func (input *Engine) ok(have int, max int) {
 		input.full++
 		if debugBuffers {
 			todecode := input.todecode.usage()
-			table := input.flowtable.usage()
+			table := input.tableUsage()
 			fmt.Println("     high ", have, max, todecode.buffers, todecode.packets, table, input.full > releaseMark)
 		}
 		if input.full > releaseMark {
@@ -202,15 +297,34 @@ func (input *Engine) ok(have int, max int) {
 	}
 }
 
-// Run reads all the packets from the sources and forwards those to the flowtable
+// Run reads all the packets from the sources and forwards those to the flowtables
 func (input *Engine) Run() (time flows.DateTimeNanoseconds) {
-	var npackets, nskipped, nfiltered uint64
-	var lastTime flows.DateTimeNanoseconds
+	npackets, nskipped, nfiltered := input.packetStats.packets, input.packetStats.skipped, input.packetStats.filtered
+	lastTime := input.lastTime
+	time = lastTime
 	warned := false
 
 	input.sources.Init()
 
 	for {
+		if request := atomic.SwapInt32(&input.checkpointRequest, checkpointNone); request != checkpointNone {
+			input.packetStats.packets = npackets
+			input.packetStats.filtered = nfiltered
+			input.packetStats.skipped = nskipped
+			input.lastTime = lastTime
+			if !input.sync() {
+				break
+			}
+			if err := input.writeCheckpoint(); err != nil {
+				log.Println("Couldn't write checkpoint: ", err)
+			} else {
+				log.Printf("Wrote checkpoint after %d packets to %s\n", npackets, input.checkpointFile)
+				input.suspended = request == checkpointStop
+			}
+			if request == checkpointStop {
+				break
+			}
+		}
 		lt, data, ci, skipped, filtered, err := input.sources.ReadPacket()
 		if err != nil {
 			if err == ErrTimeout {
@@ -236,23 +350,19 @@ func (input *Engine) Run() (time flows.DateTimeNanoseconds) {
 			continue
 		}
 
-		if input.current.empty() {
-			input.empty.Pop(input.current, input.starved, input.ok)
+		if !input.sampler.packet() {
+			continue
 		}
-		buffer := input.current.read()
-		time = buffer.assign(data, ci, lt, npackets)
+
+		var ok bool
+		time, ok = input.push(data, ci, lt, npackets)
 		if !warned && time < lastTime {
 			log.Printf("Warning: Jump back in time (from %d to %d)\n", lastTime, time)
 			warned = true
 		}
 		lastTime = time
-		if input.current.full() {
-			input.current.setTimestamp(time)
-			input.current.finalize()
-			var ok bool
-			if input.current, ok = input.todecode.popEmpty(); !ok {
-				break
-			}
+		if !ok {
+			break
 		}
 	}
 	input.packetStats.packets = npackets
@@ -261,6 +371,123 @@ func (input *Engine) Run() (time flows.DateTimeNanoseconds) {
 	return
 }
 
+// push copies the packet into a buffer, which is shared by all the flow tables, and submits full batches for
+// decoding. Returns the packet time and false if the pipeline was closed.
+func (input *Engine) push(data []byte, ci gopacket.CaptureInfo, lt gopacket.LayerType, packetnr uint64) (time flows.DateTimeNanoseconds, ok bool) {
+	if input.current.empty() {
+		input.empty.Pop(input.current, input.starved, input.ok)
+	}
+	time = input.current.read().shared().assign(data, ci, lt, packetnr)
+	if input.current.full() {
+		input.current.setTimestamp(time)
+		input.current.finalize()
+		if input.current, ok = input.todecode.popEmpty(); !ok {
+			return
+		}
+	}
+	return time, true
+}
+
+// EnableSampling enables sampling of the packets according to s. Must be called before Run.
+func (input *Engine) EnableSampling(s flows.Sampling) {
+	input.sampler = newSampler(s)
+}
+
+// EnablePassiveDNS fills cache with the DNS answers of all the packets. Packets handed to the tables provide the cache
+// with Buffer.PassiveDNS. Must be called before Run.
+func (input *Engine) EnablePassiveDNS(cache *PassiveDNS) {
+	input.passiveDNS = cache
+}
+
+// EnableCheckpoints enables writing checkpoints to file (see Checkpoint)
+func (input *Engine) EnableCheckpoints(file string) {
+	input.checkpointFile = file
+}
+
+// Checkpoint requests writing a checkpoint before the next packet is read. If stop is true, Run returns after the
+// checkpoint was written. Can be called from any go routine.
+func (input *Engine) Checkpoint(stop bool) {
+	request := checkpointContinue
+	if stop {
+		request = checkpointStop
+	}
+	atomic.StoreInt32(&input.checkpointRequest, request)
+}
+
+// Suspended returns true if Run returned after writing a checkpoint. In this case, the flows are part of the
+// checkpoint and must not be exported (i.e., EOF must not be called).
+func (input *Engine) Suspended() bool {
+	return input.suspended
+}
+
+// checkpoint saves or restores the packet counters, the source position, and the state of the flow tables
+func (input *Engine) checkpoint(c *flows.Checkpoint) {
+	c.Uint64(&input.packetStats.packets)
+	c.Uint64(&input.packetStats.skipped)
+	c.Uint64(&input.packetStats.filtered)
+	input.sampler.checkpoint(c)
+	c.Time(&input.lastTime)
+	input.sources.checkpoint(c)
+	input.labels.checkpoint(c)
+	c.Check("passive dns", input.passiveDNS != nil)
+	if input.passiveDNS != nil {
+		input.passiveDNS.checkpoint(c)
+	}
+	c.Check("number of flow table groups", len(input.flowtables))
+	for _, flowtable := range input.flowtables {
+		stats := flowtable.getDecodeStats()
+		c.Uint64(&stats.decodeError)
+		c.Uint64(&stats.keyError)
+		c.Uint64(&stats.unsampled)
+		flowtable.checkpoint(c)
+	}
+}
+
+// sync pushes all packets read so far through the tables and waits until they are handled
+func (input *Engine) sync() bool {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	input.current.setTimestamp(input.lastTime)
+	input.current.barrier = &wg
+	input.current.finalizeWritten()
+	wg.Wait()
+	var ok bool
+	input.current, ok = input.todecode.popEmpty()
+	return ok
+}
+
+// writeCheckpoint writes the checkpoint to a temporary file, which replaces the checkpoint file on success
+func (input *Engine) writeCheckpoint() error {
+	tmp := input.checkpointFile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	c := flows.NewCheckpointWriter(f)
+	input.checkpoint(c)
+	err = c.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, input.checkpointFile)
+}
+
+// Resume restores the state from the given checkpoint file. Must be called before Run with the same
+// specification and the same sources as in the run that wrote the checkpoint.
+func (input *Engine) Resume(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c := flows.NewCheckpointReader(f)
+	input.checkpoint(c)
+	return c.Err()
+}
+
 // Stop cancels the whole process and stops packet input
 func (input *Engine) Stop() {
 	input.sources.Stop()
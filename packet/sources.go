@@ -1,9 +1,11 @@
 package packet
 
 import (
+	"fmt"
 	"io"
 	"sync/atomic"
 
+	"github.com/CN-TU/go-flows/flows"
 	"github.com/CN-TU/go-flows/util"
 	"github.com/google/gopacket"
 )
@@ -20,6 +22,13 @@ type Source interface {
 	Stop()
 }
 
+// CheckpointSource is a Source that supports checkpoints
+type CheckpointSource interface {
+	Source
+	// Checkpoint saves the current read position, or continues reading at the restored position
+	Checkpoint(*flows.Checkpoint)
+}
+
 // Sources holds a collection of sources that are queried one after another
 type Sources struct {
 	stopped uint64
@@ -56,6 +65,23 @@ func (s *Sources) Stop() {
 	s.sources[0].Stop()
 }
 
+// checkpoint saves or restores the current source and its read position
+func (s *Sources) checkpoint(c *flows.Checkpoint) {
+	remaining := c.Len(len(s.sources))
+	if c.Loading() {
+		if remaining == 0 || remaining > len(s.sources) {
+			c.Fail(fmt.Errorf("checkpoint has %d remaining sources, but only %d sources are specified", remaining, len(s.sources)))
+			return
+		}
+		s.sources = s.sources[len(s.sources)-remaining:]
+	}
+	if source, ok := s.sources[0].(CheckpointSource); ok {
+		source.Checkpoint(c)
+	} else {
+		c.Fail(fmt.Errorf("source %s doesn't support checkpoints", s.sources[0].ID()))
+	}
+}
+
 // Init initializes the sources
 func (s *Sources) Init() {
 	for _, source := range s.sources {
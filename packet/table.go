@@ -13,6 +13,8 @@ import (
 type decodeStats struct {
 	decodeError uint64
 	keyError    uint64
+	// unsampled is the number of packets not used due to hash sampling
+	unsampled uint64
 }
 
 // EventTable represents a flow table that can handle multiple events in one go
@@ -23,6 +25,10 @@ type EventTable interface {
 	PrintStats(io.Writer)
 	usage() []bufferUsage
 	event(buffer *shallowMultiPacketBuffer)
+	// barrier waits until every table handled all outstanding packets
+	barrier()
+	// checkpoint saves or restores the state of every table. Only allowed after barrier or before the first event.
+	checkpoint(*flows.Checkpoint)
 	flush()
 	getDecodeStats() *decodeStats
 	getSelector() DynamicKeySelector
@@ -95,6 +101,25 @@ func (sft *singleFlowTable) event(buffer *shallowMultiPacketBuffer) {
 	b.finalize()
 }
 
+func (sft *singleFlowTable) barrier() {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	b, _ := sft.buffer.popEmpty()
+	b.barrier = &wg
+	b.expire = false
+	b.finalize()
+	wg.Wait()
+}
+
+func (sft *singleFlowTable) checkpoint(c *flows.Checkpoint) {
+	c.Check("number of tables", 1)
+	c.Time(&sft.nextExpire)
+	sft.table.Checkpoint(c)
+	if sft.table.ConnectionContext != nil {
+		sft.table.ConnectionContext.Checkpoint(c)
+	}
+}
+
 func (sft *singleFlowTable) flush() {
 	close(sft.buffer.full)
 	<-sft.done
@@ -112,16 +137,24 @@ func (sft *singleFlowTable) usage() []bufferUsage {
 // NewFlowTable creates a new flowtable with the given record list, a flow creator, flow options,
 // expire time, a key selector, if empty values in the key are allowed and if automatic gc should be used.
 //
-// num specifies the number of parallel flow tables.
-func NewFlowTable(num int, features flows.RecordListMaker, newflow flows.FlowCreator, options flows.FlowOptions, expire flows.DateTimeNanoseconds, selector DynamicKeySelector, autoGC bool) EventTable {
+// num specifies the number of parallel flow tables, which get the ids firstID to firstID+num-1. The ids must be unique
+// among all tables sharing an export pipeline.
+func NewFlowTable(num, firstID int, features flows.RecordListMaker, newflow flows.FlowCreator, options flows.FlowOptions, expire flows.DateTimeNanoseconds, selector DynamicKeySelector, autoGC bool) EventTable {
 	bt := baseTable{
 		selector: selector,
 		autoGC:   autoGC,
 	}
+	if firstID+num > 256 {
+		panic("Maximum of 256 tables allowed")
+	}
+	if options.Context.Enabled() && options.ConnectionContext == nil {
+		// all the tables share the context, since a host can be hashed to every table
+		options.ConnectionContext = flows.NewConnectionContext(options.Context)
+	}
 	if num == 1 {
 		ret := &singleFlowTable{
 			baseTable:  bt,
-			table:      flows.NewFlowTable(features, newflow, options, selector.fivetuple && options.TCPExpiry, 0),
+			table:      flows.NewFlowTable(features, newflow, options, selector.fivetuple && options.TCPExpiry, uint8(firstID)),
 			expireTime: expire,
 		}
 		ret.buffer = newShallowMultiPacketBufferRing(fullBuffers, batchSize)
@@ -146,14 +179,16 @@ func NewFlowTable(num int, features flows.RecordListMaker, newflow flows.FlowCre
 						go runtime.GC()
 					}
 				}
+				barrier := buffer.barrier
+				buffer.barrier = nil
 				buffer.recycle()
+				if barrier != nil {
+					barrier.Done()
+				}
 			}
 		}()
 		return ret
 	}
-	if num > 256 {
-		panic("Maximum of 256 tables allowed")
-	}
 	ret := &parallelFlowTable{
 		baseTable:   bt,
 		tables:      make([]*flows.FlowTable, num),
@@ -165,7 +200,7 @@ func NewFlowTable(num int, features flows.RecordListMaker, newflow flows.FlowCre
 	for i := 0; i < num; i++ {
 		c := newShallowMultiPacketBufferRing(fullBuffers, batchSize)
 		ret.buffers[i] = c
-		t := flows.NewFlowTable(features, newflow, options, selector.fivetuple && options.TCPExpiry, uint8(i))
+		t := flows.NewFlowTable(features, newflow, options, selector.fivetuple && options.TCPExpiry, uint8(firstID+i))
 		ret.tables[i] = t
 		ret.wg.Add(1)
 		go func() {
@@ -181,13 +216,21 @@ func NewFlowTable(num int, features flows.RecordListMaker, newflow flows.FlowCre
 					}
 					t.Event(b)
 				}
+				if options.SortWatermark || options.ConnectionContext != nil {
+					t.Progress(buffer.progress)
+				}
 				if buffer.expire {
 					t.Expire(buffer.timestamp)
 					if !autoGC {
 						ret.expirewg.Done()
 					}
 				}
+				barrier := buffer.barrier
+				buffer.barrier = nil
 				buffer.recycle()
+				if barrier != nil {
+					barrier.Done()
+				}
 			}
 		}()
 	}
@@ -247,6 +290,7 @@ func (pft *parallelFlowTable) event(buffer *shallowMultiPacketBuffer) {
 		tmp[i].timestamp = current
 		tmp[i].expire = expire
 	}
+	var progress uint64
 	for {
 		b := buffer.read()
 		if b == nil {
@@ -254,8 +298,10 @@ func (pft *parallelFlowTable) event(buffer *shallowMultiPacketBuffer) {
 		}
 		h := fnvHash(b.Key()) % uint64(len(tmp))
 		tmp[h].push(b)
+		progress = b.PacketNr()
 	}
 	for _, buf := range pft.tmp {
+		buf.progress = progress
 		buf.finalize()
 	}
 	if expire && !pft.autoGC {
@@ -266,6 +312,30 @@ func (pft *parallelFlowTable) event(buffer *shallowMultiPacketBuffer) {
 	}
 }
 
+func (pft *parallelFlowTable) barrier() {
+	var wg sync.WaitGroup
+	wg.Add(len(pft.buffers))
+	for _, buf := range pft.buffers {
+		b, _ := buf.popEmpty()
+		b.barrier = &wg
+		b.expire = false
+		b.progress = 0
+		b.finalize()
+	}
+	wg.Wait()
+}
+
+func (pft *parallelFlowTable) checkpoint(c *flows.Checkpoint) {
+	c.Check("number of tables", len(pft.tables))
+	c.Time(&pft.nextExpire)
+	for _, table := range pft.tables {
+		table.Checkpoint(c)
+	}
+	if context := pft.tables[0].ConnectionContext; context != nil {
+		context.Checkpoint(c)
+	}
+}
+
 func (pft *parallelFlowTable) flush() {
 	for _, c := range pft.buffers {
 		close(c.full)
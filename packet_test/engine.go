@@ -0,0 +1,197 @@
+package packet_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/CN-TU/go-flows/packet"
+)
+
+// EngineSpec is a feature specification for RunEngine. Every specification is handled by its own group of tables.
+type EngineSpec struct {
+	// Features, Control, and Filter are the features, control features, and filter features of the specification
+	Features, Control, Filter []interface{}
+	// Key is the flow key (default: bidirectional five tuple)
+	Key []string
+	// Options are the flow options of this specification. Sorting options are taken from EngineOptions.
+	Options flows.FlowOptions
+	// Aggregate is the flow key of an aggregated specification, which uses the records of the preceding specification
+	// From as input instead of packets (nil for packet input). Aggregated specifications use a single table.
+	Aggregate []string
+	From      int
+}
+
+// EngineOptions holds the settings for RunEngine
+type EngineOptions struct {
+	// Tables is the number of parallel tables per specification (default: 1)
+	Tables int
+	// Encoders is the number of parallel export encoders (default: 1)
+	Encoders uint
+	// Sort, Watermark, MaxSortDelay and Deterministic are the sort settings of the export pipeline and the tables
+	Sort          flows.SortType
+	Watermark     bool
+	MaxSortDelay  flows.DateTimeNanoseconds
+	Deterministic bool
+	// Expire is the interval for expiring flows (default: 10s)
+	Expire flows.DateTimeNanoseconds
+	// Sampling is the packet sampling configuration
+	Sampling flows.Sampling
+	// Checkpoint is the file a checkpoint is written to after CheckpointAfter packets were read. Processing stops
+	// after writing the checkpoint, and the remaining flows are not exported.
+	Checkpoint      string
+	CheckpointAfter int
+	// Resume is the checkpoint file to resume from
+	Resume string
+	// Labels are assigned to the packets
+	Labels packet.Labels
+	// PassiveDNS enables the passive DNS cache
+	PassiveDNS bool
+}
+
+// lineExporter stores every exported record as line of comma separated values prefixed with the export time
+type lineExporter struct {
+	sync.Mutex
+	lines []string
+}
+
+func (le *lineExporter) Fields([]string) {}
+
+func (le *lineExporter) Export(template flows.Template, features []interface{}, when flows.DateTimeNanoseconds) {
+	line := make([]string, len(features)+1)
+	line[0] = fmt.Sprint(when)
+	for i, feature := range features {
+		line[i+1] = fmt.Sprint(feature)
+	}
+	le.Lock()
+	le.lines = append(le.lines, strings.Join(line, ","))
+	le.Unlock()
+}
+
+func (le *lineExporter) Finish() {}
+
+func (le *lineExporter) ID() string {
+	return "lines"
+}
+
+func (le *lineExporter) Init() {}
+
+var defaultKey = []string{
+	"sourceIPAddress",
+	"destinationIPAddress",
+	"protocolIdentifier",
+	"sourceTransportPort",
+	"destinationTransportPort",
+}
+
+// RunEngine reads all the packets from source with the packet engine, handles them with the given specifications,
+// and returns the exported records as lines in export order (see lineExporter). The source is read from the start,
+// unless a checkpoint is resumed.
+func RunEngine(t *testing.T, source *MemorySource, specs []EngineSpec, opt EngineOptions) []string {
+	if opt.Tables == 0 {
+		opt.Tables = 1
+	}
+	if opt.Encoders == 0 {
+		opt.Encoders = 1
+	}
+	if opt.Expire == 0 {
+		opt.Expire = 10 * flows.SecondsInNanoseconds
+	}
+	exporter := &lineExporter{}
+	packetSpecs := 0
+	for _, spec := range specs {
+		if spec.Aggregate == nil {
+			packetSpecs++
+		}
+	}
+	pipeline, _ := flows.MakeExportPipeline([]flows.Exporter{exporter}, opt.Sort, uint(opt.Tables*packetSpecs), opt.Encoders, opt.Watermark)
+	var recordList flows.RecordListMaker
+	for _, spec := range specs {
+		var err error
+		if spec.Aggregate != nil {
+			// aggregated records can't share the pipeline of their source
+			aggregated, _ := flows.MakeExportPipeline([]flows.Exporter{exporter}, opt.Sort, 1, opt.Encoders, opt.Watermark)
+			err = recordList.AppendAggregateRecord(spec.From, spec.Features, spec.Control, spec.Filter, aggregated, false)
+		} else {
+			err = recordList.AppendRecord(spec.Features, spec.Control, spec.Filter, pipeline, false)
+		}
+		if err != nil {
+			t.Fatalf("Couldn't parse features: %s", err)
+		}
+	}
+	var flowtables []packet.EventTable
+	firstID := 0
+	for i, spec := range specs {
+		opts := spec.Options
+		if opts.ActiveTimeout == 0 {
+			opts.ActiveTimeout = flows.SecondsInNanoseconds * 1800
+		}
+		if opts.IdleTimeout == 0 {
+			opts.IdleTimeout = flows.SecondsInNanoseconds * 300
+		}
+		opts.SortOutput = opt.Sort
+		opts.SortWatermark = opt.Watermark
+		opts.SortMaxDelay = opt.MaxSortDelay
+		opts.Deterministic = opt.Deterministic
+		opts.Sampling = opt.Sampling
+		if spec.Aggregate != nil {
+			table := flows.NewFlowTable(recordList.Subset([]int{i}), flows.NewRecordFlow, opts, false, uint8(firstID))
+			if _, err := recordList.Aggregate(spec.From, table, spec.Aggregate, opt.Expire); err != nil {
+				t.Fatalf("Couldn't aggregate records: %s", err)
+			}
+			firstID++
+			continue
+		}
+		key := spec.Key
+		if key == nil {
+			key = defaultKey
+		}
+		if err := recordList.Subset([]int{i}).CheckSettings(opts.CustomSettings); err != nil {
+			t.Fatalf("Couldn't parse features: %s", err)
+		}
+		selector := packet.MakeDynamicKeySelector(key, true, true)
+		flowtables = append(flowtables, packet.NewFlowTable(opt.Tables, firstID, recordList.Subset([]int{i}), packet.NewFlow, opts, opt.Expire, selector, true))
+		firstID += opt.Tables
+	}
+	if err := packet.CheckPassiveDNS(recordList, opt.PassiveDNS); err != nil {
+		t.Fatalf("Couldn't parse features: %s", err)
+	}
+	recordList.Init()
+
+	source.pos = 0
+	var sources packet.Sources
+	sources.Append(source)
+	engine := packet.NewEngine(0, flowtables, nil, sources, opt.Labels)
+	engine.EnableSampling(opt.Sampling)
+	if opt.PassiveDNS {
+		engine.EnablePassiveDNS(packet.NewPassiveDNS())
+	}
+	if opt.Resume != "" {
+		if err := engine.Resume(opt.Resume); err != nil {
+			t.Fatalf("Couldn't resume from checkpoint: %s", err)
+		}
+	}
+	if opt.Checkpoint != "" {
+		engine.EnableCheckpoints(opt.Checkpoint)
+		source.onRead = func(n int) {
+			if n == opt.CheckpointAfter {
+				engine.Checkpoint(true)
+			}
+		}
+		defer func() { source.onRead = nil }()
+	}
+	stopped := engine.Run()
+	engine.Finish()
+	if opt.Checkpoint != "" && !engine.Suspended() {
+		t.Fatalf("Couldn't write checkpoint after %d packets", opt.CheckpointAfter)
+	}
+	if !engine.Suspended() {
+		for _, flowtable := range flowtables {
+			flowtable.EOF(stopped)
+		}
+	}
+	recordList.Flush()
+	return exporter.lines
+}
@@ -0,0 +1,90 @@
+package packet_test
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/CN-TU/go-flows/flows"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type memoryPacket struct {
+	lt   gopacket.LayerType
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// MemorySource is a packet source, which returns serialized packets from memory. This can be used for testing the
+// packet engine. Checkpoints are supported.
+type MemorySource struct {
+	packets []memoryPacket
+	pos     int
+	// onRead gets called with the number of packets read so far after reading a packet (used by RunEngine)
+	onRead func(int)
+}
+
+// AppendLayers serializes the given layers and appends the resulting packet with the given timestamp to the source.
+// The first layer must be an Ethernet, IPv4, or IPv6 layer.
+func (s *MemorySource) AppendLayers(when flows.DateTimeNanoseconds, layerList ...gopacket.SerializableLayer) error {
+	if len(layerList) == 0 {
+		return errors.New("at least one layer is needed")
+	}
+	lt := layerList[0].LayerType()
+	switch lt {
+	case layers.LayerTypeEthernet, layers.LayerTypeIPv4, layers.LayerTypeIPv6:
+	default:
+		return errors.New("first layer must be an Ethernet, IPv4, or IPv6 layer")
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, layerList...); err != nil {
+		return err
+	}
+	data := append([]byte(nil), buf.Bytes()...)
+	s.packets = append(s.packets, memoryPacket{
+		lt:   lt,
+		data: data,
+		ci: gopacket.CaptureInfo{
+			Timestamp:     time.Unix(0, int64(when)),
+			CaptureLength: len(data),
+			Length:        len(data),
+		},
+	})
+	return nil
+}
+
+// Len returns the number of packets in this source
+func (s *MemorySource) Len() int {
+	return len(s.packets)
+}
+
+// ReadPacket returns the next packet
+func (s *MemorySource) ReadPacket() (lt gopacket.LayerType, data []byte, ci gopacket.CaptureInfo, skipped uint64, filtered uint64, err error) {
+	if s.pos >= len(s.packets) {
+		err = io.EOF
+		return
+	}
+	p := s.packets[s.pos]
+	s.pos++
+	if s.onRead != nil {
+		s.onRead(s.pos)
+	}
+	return p.lt, p.data, p.ci, 0, 0, nil
+}
+
+// Checkpoint saves or restores the read position
+func (s *MemorySource) Checkpoint(c *flows.Checkpoint) {
+	c.Int(&s.pos)
+}
+
+// Stop does nothing
+func (s *MemorySource) Stop() {}
+
+// Init does nothing
+func (s *MemorySource) Init() {}
+
+// ID returns the name of this source
+func (s *MemorySource) ID() string {
+	return "memory"
+}
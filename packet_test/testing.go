@@ -68,7 +68,13 @@ type TestTable struct {
 }
 
 // MakeFeatureTest creates a flow table for testing purposes with the given features, wanted return type, and flow options
-func MakeFeatureTest(t *testing.T, features []string, ft flows.FeatureType, opt flows.FlowOptions) (ret TestTable) {
+func MakeFeatureTest(t *testing.T, features []string, ft flows.FeatureType, opt flows.FlowOptions) TestTable {
+	return MakeControlFeatureTest(t, features, nil, nil, opt)
+}
+
+// MakeControlFeatureTest creates a flow table for testing purposes with the given features, control features, filter
+// features, and flow options. Parameters of control features can be provided via opt.CustomSettings.
+func MakeControlFeatureTest(t *testing.T, features []string, control, filter []interface{}, opt flows.FlowOptions) (ret TestTable) {
 	ret.t = t
 	if opt.ActiveTimeout == 0 {
 		opt.ActiveTimeout = flows.SecondsInNanoseconds * 1800
@@ -82,8 +88,11 @@ func MakeFeatureTest(t *testing.T, features []string, ft flows.FeatureType, opt
 		featuresI[i] = feature
 	}
 	var f flows.RecordListMaker
-	ret.pipe, _ = flows.MakeExportPipeline([]flows.Exporter{ret.exporter}, flows.SortTypeNone, 1)
-	if err := f.AppendRecord(featuresI, nil, nil, ret.pipe, false); err != nil {
+	ret.pipe, _ = flows.MakeExportPipeline([]flows.Exporter{ret.exporter}, flows.SortTypeNone, 1, 1, false)
+	if err := f.AppendRecord(featuresI, control, filter, ret.pipe, false); err != nil {
+		t.Fatalf("Couldn't parse features: %s", err)
+	}
+	if err := f.CheckSettings(opt.CustomSettings); err != nil {
 		t.Fatalf("Couldn't parse features: %s", err)
 	}
 	f.Init()
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/CN-TU/go-flows/flows"
@@ -64,7 +65,7 @@ const (
 	jsonSimple
 )
 
-func decodeV2(decoded featureJSONv2, id int) (features []interface{}, control, filter, key []string, bidirectional, allowZero bool, opt flows.FlowOptions) {
+func decodeV2(decoded featureJSONv2, id int) (features, control, filter []interface{}, key []string, bidirectional, allowZero bool, opt flows.FlowOptions) {
 	flows := decoded.Preprocessing.Flows
 	if id < 0 || id >= len(flows) {
 		log.Fatalf("Only %d flows in the file ⇒ id must be between 0 and %d (is %d)\n", len(flows), len(flows)-1, id)
@@ -72,6 +73,18 @@ func decodeV2(decoded featureJSONv2, id int) (features []interface{}, control, f
 	return decodeSimple(flows[id], id)
 }
 
+// decodeV2All decodes the flow with the given id, or every flow if all is true
+func decodeV2All(decoded featureJSONv2, id int, all bool) []featureSpec {
+	if !all {
+		return []featureSpec{newFeatureSpec(decodeV2(decoded, id))}
+	}
+	ret := make([]featureSpec, len(decoded.Preprocessing.Flows))
+	for i := range ret {
+		ret[i] = newFeatureSpec(decodeV2(decoded, i))
+	}
+	return ret
+}
+
 var requiredKeys = []string{"active_timeout", "idle_timeout", "bidirectional", "features", "key_features"}
 
 func toStringArray(decoded featureJSONsimple, name string) []string {
@@ -101,7 +114,178 @@ func toTimeout(decoded featureJSONsimple, name string) flows.DateTimeNanoseconds
 	return 0
 }
 
-func decodeSimple(decoded featureJSONsimple, _ int) (features []interface{}, control, filter, key []string, bidirectional, allowZero bool, opt flows.FlowOptions) {
+func toTimeoutRules(decoded featureJSONsimple) []flows.TimeoutRule {
+	arr, ok := decoded["_timeouts"].([]interface{})
+	if !ok {
+		log.Fatal("_timeouts must be an array of rules")
+	}
+	ret := make([]flows.TimeoutRule, len(arr))
+	for i := range arr {
+		rule, ok := arr[i].(map[string]interface{})
+		if !ok {
+			log.Fatalf("_timeouts[%d] must be an object", i)
+		}
+		ret[i] = flows.TimeoutRule{Protocol: -1, Port: -1, ActiveTimeout: flows.KeepTimeout, IdleTimeout: flows.KeepTimeout}
+		for key, val := range rule {
+			switch key {
+			case "protocol", "port":
+				num, ok := val.(json.Number)
+				if !ok {
+					log.Fatalf("_timeouts[%d].%s must be a number", i, key)
+				}
+				n, err := num.Int64()
+				if err != nil || n < 0 || (key == "protocol" && n > 255) || n > 65535 {
+					log.Fatalf("_timeouts[%d].%s must be a valid %s number", i, key, key)
+				}
+				if key == "protocol" {
+					ret[i].Protocol = int(n)
+				} else {
+					ret[i].Port = int(n)
+				}
+			case "state":
+				str, ok := val.(string)
+				if !ok {
+					log.Fatalf("_timeouts[%d].state must be a string", i)
+				}
+				state, err := flows.AtoTCPState(str)
+				if err != nil {
+					log.Fatalf("_timeouts[%d].state: %s", i, err)
+				}
+				ret[i].State = state
+			case "active", "idle":
+				if _, ok := val.(json.Number); !ok {
+					log.Fatalf("_timeouts[%d].%s must be a number", i, key)
+				}
+				if key == "active" {
+					ret[i].ActiveTimeout = toTimeout(rule, key)
+				} else {
+					ret[i].IdleTimeout = toTimeout(rule, key)
+				}
+			default:
+				log.Fatalf("_timeouts[%d]: unknown key %s", i, key)
+			}
+		}
+		if ret[i].ActiveTimeout == flows.KeepTimeout && ret[i].IdleTimeout == flows.KeepTimeout {
+			log.Fatalf("_timeouts[%d] needs at least one of active or idle", i)
+		}
+	}
+	return ret
+}
+
+func toTCPTimeouts(decoded featureJSONsimple) (ret flows.TCPTimeouts) {
+	timeouts, ok := decoded["_tcp_timeouts"].(map[string]interface{})
+	if !ok {
+		log.Fatal("_tcp_timeouts must be an object")
+	}
+	for key := range timeouts {
+		if _, ok := timeouts[key].(json.Number); !ok {
+			log.Fatalf("_tcp_timeouts.%s must be a number", key)
+		}
+		switch key {
+		case "syn_sent":
+			ret.SynSent = toTimeout(timeouts, key)
+		case "established":
+			ret.Established = toTimeout(timeouts, key)
+		case "fin_wait":
+			ret.FinWait = toTimeout(timeouts, key)
+		case "time_wait":
+			ret.TimeWait = toTimeout(timeouts, key)
+		default:
+			log.Fatalf("_tcp_timeouts: unknown key %s", key)
+		}
+	}
+	return
+}
+
+func toInterim(decoded featureJSONsimple) (packets []uint64, interval flows.DateTimeNanoseconds) {
+	interim, ok := decoded["_interim"].(map[string]interface{})
+	if !ok {
+		log.Fatal("_interim must be an object")
+	}
+	for key, val := range interim {
+		switch key {
+		case "packets":
+			arr, ok := val.([]interface{})
+			if !ok {
+				log.Fatal("_interim.packets must be an array of numbers")
+			}
+			packets = make([]uint64, len(arr))
+			for i := range arr {
+				num, ok := arr[i].(json.Number)
+				if !ok {
+					log.Fatalf("_interim.packets[%d] must be a number", i)
+				}
+				n, err := strconv.ParseUint(string(num), 10, 64)
+				if err != nil || n == 0 {
+					log.Fatalf("_interim.packets[%d] must be a positive integer", i)
+				}
+				if i > 0 && n <= packets[i-1] {
+					log.Fatal("_interim.packets must be in ascending order")
+				}
+				packets[i] = n
+			}
+		case "interval":
+			if _, ok := val.(json.Number); !ok {
+				log.Fatal("_interim.interval must be a number")
+			}
+			interval = toTimeout(interim, key)
+			if interval <= 0 {
+				log.Fatal("_interim.interval must be positive")
+			}
+		default:
+			log.Fatalf("_interim: unknown key %s", key)
+		}
+	}
+	return
+}
+
+func toContext(decoded featureJSONsimple) (ret flows.ContextOptions) {
+	context, ok := decoded["_context"].(map[string]interface{})
+	if !ok {
+		log.Fatal("_context must be an object")
+	}
+	ret.Window = 2 * flows.SecondsInNanoseconds
+	ret.Connections = 100
+	for key, val := range context {
+		switch key {
+		case "window":
+			if _, ok := val.(json.Number); !ok {
+				log.Fatal("_context.window must be a number")
+			}
+			ret.Window = toTimeout(context, key)
+			if ret.Window < 0 {
+				log.Fatal("_context.window must not be negative")
+			}
+		case "connections":
+			num, ok := val.(json.Number)
+			if !ok {
+				log.Fatal("_context.connections must be a number")
+			}
+			n, err := strconv.ParseUint(string(num), 10, 31)
+			if err != nil {
+				log.Fatal("_context.connections must be a non-negative integer")
+			}
+			ret.Connections = int(n)
+		case "evaluate":
+			switch val {
+			case "start":
+				ret.AtEnd = false
+			case "end":
+				ret.AtEnd = true
+			default:
+				log.Fatal("_context.evaluate must be \"start\" or \"end\"")
+			}
+		default:
+			log.Fatalf("_context: unknown key %s", key)
+		}
+	}
+	if !ret.Enabled() {
+		log.Fatal("_context needs a window or connections")
+	}
+	return
+}
+
+func decodeSimple(decoded featureJSONsimple, _ int) (features, control, filter []interface{}, key []string, bidirectional, allowZero bool, opt flows.FlowOptions) {
 	// Check if we have every required value
 	for _, val := range requiredKeys {
 		if _, ok := decoded[val]; !ok {
@@ -116,10 +300,10 @@ func decodeSimple(decoded featureJSONsimple, _ int) (features []interface{}, con
 	}
 
 	if _, ok := decoded["_control_features"]; ok {
-		control = toStringArray(decoded, "_control_features")
+		control = decodeFeatures(decoded["_control_features"])
 	}
 	if _, ok := decoded["_filter_features"]; ok {
-		filter = toStringArray(decoded, "_filter_features")
+		filter = decodeFeatures(decoded["_filter_features"])
 	}
 
 	if zero, ok := decoded["_allow_zero"]; ok {
@@ -141,6 +325,10 @@ func decodeSimple(decoded featureJSONsimple, _ int) (features []interface{}, con
 	opt.ActiveTimeout = toTimeout(decoded, "active_timeout")
 	opt.IdleTimeout = toTimeout(decoded, "idle_timeout")
 
+	if _, ok := decoded["_timeouts"]; ok {
+		opt.TimeoutRules = toTimeoutRules(decoded)
+	}
+
 	opt.TCPExpiry = true
 
 	if expiry, ok := decoded["_expire_TCP"]; ok {
@@ -151,6 +339,18 @@ func decodeSimple(decoded featureJSONsimple, _ int) (features []interface{}, con
 		}
 	}
 
+	if _, ok := decoded["_tcp_timeouts"]; ok {
+		opt.TCPTimeouts = toTCPTimeouts(decoded)
+	}
+
+	if _, ok := decoded["_interim"]; ok {
+		opt.InterimPackets, opt.InterimInterval = toInterim(decoded)
+	}
+
+	if _, ok := decoded["_context"]; ok {
+		opt.Context = toContext(decoded)
+	}
+
 	opt.CustomSettings = decoded
 
 	return
@@ -167,12 +367,21 @@ func decodeSimple(decoded featureJSONsimple, _ int) (features []interface{}, con
 		"_filter_features": [...],
 		"_per_packet": <bool>,
 		"_allow_zero": <bool>,
-		"_expire_TCP": <bool>
+		"_expire_TCP": <bool>,
+		"_timeouts": [{"protocol": <Number>, "port": <Number>, "state": <String>, "active": <Number>, "idle": <Number>}, ...],
+		"_tcp_timeouts": {"syn_sent": <Number>, "established": <Number>, "fin_wait": <Number>, "time_wait": <Number>},
+		"_interim": {"packets": [<Number>, ...], "interval": <Number>},
+		"_context": {"window": <Number>, "connections": <Number>, "evaluate": "start"|"end"}
 	}
 
 	timeouts, features, key_features and bidirectional are required
 	_per_packet, _allow_zero are assumed false if missing
 	_expire_TCP is assumed true if missing (tcp expire works only if at least the five tuple is present in the key)
+	_timeouts rules are checked in order upon flow creation; the first matching rule overrides the timeouts
+	_tcp_timeouts override the idle timeout depending on the tcp connection state (only with _expire_TCP)
+	_interim exports records with flowEndReason 6 after the given packet counts and/or every interval without ending the flow
+	_control_features and _filter_features use the feature syntax; filters can also be expressions returning a boolean
+	packet feature, and control features can have constant arguments
 	further keys can be queried from features
 */
 
@@ -196,7 +405,7 @@ type featureJSONv2 struct {
 	}
 }
 
-func decodeJSON(inputfile string, format jsonType, id int) (features []interface{}, control, filter, key []string, bidirectional, allowZero bool, opt flows.FlowOptions) {
+func decodeJSON(inputfile string, format jsonType, id int, all bool) []featureSpec {
 	f, err := os.Open(inputfile)
 	if err != nil {
 		log.Fatalln("Can't open ", inputfile)
@@ -211,13 +420,13 @@ func decodeJSON(inputfile string, format jsonType, id int) (features []interface
 		if err := dec.Decode(&decoded); err != nil {
 			log.Fatalln("Couldn' parse feature spec:", err)
 		}
-		return decodeV2(decoded, id)
+		return decodeV2All(decoded, id, all)
 	case jsonSimple:
 		var decoded featureJSONsimple
 		if err := dec.Decode(&decoded); err != nil {
 			log.Fatalln("Couldn' parse feature spec:", err)
 		}
-		return decodeSimple(decoded, id)
+		return []featureSpec{newFeatureSpec(decodeSimple(decoded, id))}
 	case jsonAuto:
 		//first see if we have a version in the file
 		var decoded featureJSONv2
@@ -226,7 +435,7 @@ func decodeJSON(inputfile string, format jsonType, id int) (features []interface
 		}
 		if decoded.Version != "" {
 			if strings.HasPrefix(decoded.Version, "v2") {
-				return decodeV2(decoded, id)
+				return decodeV2All(decoded, id, all)
 			}
 			log.Fatalf("Unknown file format version '%s'\n", decoded.Version)
 		}
@@ -236,7 +445,7 @@ func decodeJSON(inputfile string, format jsonType, id int) (features []interface
 			log.Fatalln("Couldn' parse feature spec:", err)
 		}
 		//should be simple - or something we don't know
-		return decodeSimple(decodedSimple, id)
+		return []featureSpec{newFeatureSpec(decodeSimple(decodedSimple, id))}
 	}
 	panic("Unknown format specification")
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/CN-TU/go-flows/flows"
+)
+
+func decodeTestSpecs(t *testing.T, format jsonType, spec string) []featureSpec {
+	file := filepath.Join(t.TempDir(), "spec.json")
+	if err := ioutil.WriteFile(file, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return decodeJSON(file, format, 0, true)
+}
+
+func decodeTestSpec(t *testing.T, spec string) flows.FlowOptions {
+	specs := decodeTestSpecs(t, jsonSimple, spec)
+	if len(specs) != 1 {
+		t.Fatalf("expected one specification, got %d", len(specs))
+	}
+	return specs[0].opt
+}
+
+func TestTimeoutRulesParse(t *testing.T) {
+	opt := decodeTestSpec(t, `{
+		"features": ["sourceTransportPort"],
+		"key_features": ["sourceTransportPort"],
+		"bidirectional": false,
+		"active_timeout": 1800,
+		"idle_timeout": 300,
+		"_timeouts": [
+			{"protocol": 17, "port": 53, "idle": 10},
+			{"protocol": 6, "state": "midstream", "active": 60, "idle": 0.5},
+			{"port": 443, "state": "new", "active": 120},
+			{"state": "any", "idle": 30}
+		]
+	}`)
+	want := []flows.TimeoutRule{
+		{Protocol: 17, Port: 53, State: flows.TCPStateAny, ActiveTimeout: flows.KeepTimeout, IdleTimeout: 10 * flows.SecondsInNanoseconds},
+		{Protocol: 6, Port: -1, State: flows.TCPStateMidstream, ActiveTimeout: 60 * flows.SecondsInNanoseconds, IdleTimeout: flows.SecondsInNanoseconds / 2},
+		{Protocol: -1, Port: 443, State: flows.TCPStateNew, ActiveTimeout: 120 * flows.SecondsInNanoseconds, IdleTimeout: flows.KeepTimeout},
+		{Protocol: -1, Port: -1, State: flows.TCPStateAny, ActiveTimeout: flows.KeepTimeout, IdleTimeout: 30 * flows.SecondsInNanoseconds},
+	}
+	if !reflect.DeepEqual(opt.TimeoutRules, want) {
+		t.Errorf("parsed timeout rules %+v, expected %+v", opt.TimeoutRules, want)
+	}
+	if opt.ActiveTimeout != 1800*flows.SecondsInNanoseconds || opt.IdleTimeout != 300*flows.SecondsInNanoseconds {
+		t.Errorf("timeout rules changed the default timeouts to %d/%d", opt.ActiveTimeout, opt.IdleTimeout)
+	}
+}
+
+func TestTableGroups(t *testing.T) {
+	specs := decodeTestSpecs(t, jsonV2, `{
+		"version": "v2",
+		"preprocessing": {"flows": [
+			{"features": ["sourceTransportPort"], "key_features": ["sourceIPAddress"], "bidirectional": false, "active_timeout": 1800, "idle_timeout": 300},
+			{"features": ["destinationTransportPort", "octetTotalCount"], "_control_features": ["_tcpEnd"], "key_features": ["sourceIPAddress"], "bidirectional": false, "active_timeout": 1800, "idle_timeout": 300},
+			{"features": ["sourceTransportPort"], "key_features": ["sourceIPAddress"], "bidirectional": false, "active_timeout": 1800, "idle_timeout": 60},
+			{"features": ["sourceTransportPort"], "key_features": ["destinationIPAddress"], "bidirectional": false, "active_timeout": 1800, "idle_timeout": 300}
+		]}
+	}`)
+	if len(specs) != 4 {
+		t.Fatalf("expected four specifications, got %d", len(specs))
+	}
+	features := specs[1].opt.CustomSettings["features"]
+	groups := makeTableGroups([]exportedFeatures{{featureset: specs}})
+	if len(groups) != 3 {
+		t.Fatalf("expected three flow tables, got %d", len(groups))
+	}
+	var got []int
+	for _, spec := range specs {
+		got = append(got, spec.group)
+	}
+	if want := []int{0, 0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("specifications were assigned to tables %v, expected %v", got, want)
+	}
+	if _, ok := groups[0].opts.CustomSettings["features"]; ok {
+		t.Error("table options contain the features of a specification")
+	}
+	if !reflect.DeepEqual(specs[1].opt.CustomSettings["features"], features) {
+		t.Error("grouping changed the settings of a specification")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/signal"
 	"reflect"
@@ -11,6 +12,7 @@ import (
 	"runtime/pprof"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/CN-TU/go-flows/flows"
 	"github.com/CN-TU/go-flows/packet"
@@ -79,26 +81,37 @@ func init() {
 	addCommand("callgraph", "Create a callgraph from a flowspecification", parseArguments)
 }
 
-func parseFeatures(cmd string, args []string) (arguments []string, features []interface{}, control, filter, key []string, bidirectional, allowZero bool, opt flows.FlowOptions) {
+func parseFeatures(cmd string, args []string) (arguments []string, specs []featureSpec) {
 	set := flag.NewFlagSet("features", flag.ExitOnError)
 	set.Usage = func() {
 		fmt.Fprint(os.Stderr, `
 Usage:
   features [args] spec.json
 
-Reads feature list, as specified in spec.json.
+Reads feature list, as specified in spec.json. Only the first flow of a v2
+specification is used, unless -select or -all is given.
+
+With -from n, the records exported by the nth feature specification of the
+command line (counting from 0, every flow selection of -all counts) are used
+as input instead of packets. The fields of these records can be used as
+features and key features (e.g. per-host statistics of 5-tuple flows).
 
 Args:
 `)
 		set.PrintDefaults()
 	}
 	selection := set.Uint("select", 0, "Use nth flow selection (key:nth flow in specification)")
+	all := set.Bool("all", false, "Use every flow selection of a v2 specification (each one with its own flow key and options)")
+	from := set.Int("from", -1, "Aggregate the records exported by the nth feature specification instead of packets")
 	v2 := set.Bool("v2", false, "Force v2 format")
 	simple := set.Bool("simple", false, "Treat file as if it only contains the flow specification")
 	set.Parse(args)
 	if *v2 && *simple {
 		log.Fatalf("Only one of -v2, or -simple can be chosen\n")
 	}
+	if *all && *selection != 0 {
+		log.Fatalf("Only one of -all, or -select can be chosen\n")
+	}
 	if set.NArg() == 0 {
 		log.Fatalln("features needs a json file as input.")
 	}
@@ -112,24 +125,114 @@ Args:
 		format = jsonSimple
 	}
 
-	features, control, filter, key, bidirectional, allowZero, opt = decodeJSON(set.Arg(0), format, int(*selection))
-	if features == nil {
-		log.Fatalf("Couldn't parse %s (%d) - features missing\n", set.Arg(0), *selection)
-	}
-	if key == nil {
-		log.Fatalf("Couldn't parse %s (%d) - flow key missing\n", set.Arg(0), *selection)
+	specs = decodeJSON(set.Arg(0), format, int(*selection), *all)
+	for i, spec := range specs {
+		id := int(*selection)
+		if *all {
+			id = i
+		}
+		if spec.features == nil {
+			log.Fatalf("Couldn't parse %s (%d) - features missing\n", set.Arg(0), id)
+		}
+		if spec.key == nil {
+			log.Fatalf("Couldn't parse %s (%d) - flow key missing\n", set.Arg(0), id)
+		}
+		if *from >= 0 && spec.bidirectional {
+			log.Fatalf("Couldn't parse %s (%d) - aggregated flows can't be bidirectional\n", set.Arg(0), id)
+		}
+		specs[i].from = *from
 	}
 	return
 }
 
 type featureSpec struct {
 	features      []interface{}
-	control       []string
-	filter        []string
+	control       []interface{}
+	filter        []interface{}
 	key           []string
 	bidirectional bool
 	allowZero     bool
 	opt           flows.FlowOptions
+	group         int
+	from          int
+}
+
+func newFeatureSpec(features, control, filter []interface{}, key []string, bidirectional, allowZero bool, opt flows.FlowOptions) featureSpec {
+	return featureSpec{features, control, filter, key, bidirectional, allowZero, opt, 0, -1}
+}
+
+// tableGroup holds the feature specifications sharing a flow key and flow options (i.e., a flow table)
+type tableGroup struct {
+	key           []string
+	bidirectional bool
+	allowZero     bool
+	opts          flows.FlowOptions
+	from          int
+	firstID       int
+	records       []int
+}
+
+// specificationKeys are the keys of a feature specification, which don't influence the flow table
+var specificationKeys = []string{"features", "_control_features", "_filter_features"}
+
+// tableOptions returns opt without the feature lists in the custom settings, since specifications with different
+// features can share a flow table
+func tableOptions(opt flows.FlowOptions) flows.FlowOptions {
+	if opt.CustomSettings == nil {
+		return opt
+	}
+	settings := make(map[string]interface{}, len(opt.CustomSettings))
+	for key, value := range opt.CustomSettings {
+		settings[key] = value
+	}
+	for _, key := range specificationKeys {
+		delete(settings, key)
+	}
+	opt.CustomSettings = settings
+	return opt
+}
+
+func (g tableGroup) matches(f featureSpec) bool {
+	return reflect.DeepEqual(g.key, f.key) && g.bidirectional == f.bidirectional &&
+		g.allowZero == f.allowZero && reflect.DeepEqual(g.opts, tableOptions(f.opt)) && g.from == f.from
+}
+
+// tables returns the number of flow tables needed for this group (aggregated flows use a single table)
+func (g tableGroup) tables(numProcessing int) int {
+	if g.from >= 0 {
+		return 1
+	}
+	return numProcessing
+}
+
+// makeTableGroups assigns the feature specifications with the same key and options to a shared flow table
+func makeTableGroups(result []exportedFeatures) []tableGroup {
+	var groups []tableGroup
+	for _, featureset := range result {
+		for i := range featureset.featureset {
+			feature := &featureset.featureset[i]
+			sort.Strings(feature.key)
+			group := -1
+			for g := range groups {
+				if groups[g].matches(*feature) {
+					group = g
+					break
+				}
+			}
+			if group < 0 {
+				group = len(groups)
+				groups = append(groups, tableGroup{
+					key:           feature.key,
+					bidirectional: feature.bidirectional,
+					allowZero:     feature.allowZero,
+					opts:          tableOptions(feature.opt),
+					from:          feature.from,
+				})
+			}
+			feature.group = group
+		}
+	}
+	return groups
 }
 
 type exportedFeatures struct {
@@ -159,9 +262,9 @@ func parseCommandLine(cmd string, args []string) (result []exportedFeatures, exp
 				featureset = nil
 				exportset = nil
 			}
-			var f featureSpec
-			args, f.features, f.control, f.filter, f.key, f.bidirectional, f.allowZero, f.opt = parseFeatures(cmd, args[1:])
-			featureset = append(featureset, f)
+			var specs []featureSpec
+			args, specs = parseFeatures(cmd, args[1:])
+			featureset = append(featureset, specs...)
 		case "export":
 			if firstexporter == nil {
 				firstexporter = args
@@ -240,8 +343,28 @@ func parseArguments(cmd string, args []string) {
 	sortOrderStr := set.String("sort", "stop", `Sort output by "start" time, "stop" time, "expiry" time, or unsorted ("none")
 Beware: Worst case performance of start/stop sorting is O(1), while expiry is O(flow log(flow)) (with average O(flow)).
 Both need an additional O(flow) merge part if multiple tables are used.
-Additionally, stop might lead to very high memory usage (and longer execution times) in case one long lasting flow keeps all other flows from expiring (active/idle timeout!).`)
+Additionally, stop might lead to very high memory usage (and longer execution times) in case one long lasting flow keeps all other flows from expiring (active/idle timeout!).
+See -watermark and -maxSortDelay for bounding this.`)
+	watermark := set.Bool("watermark", false, `Release sorted output as soon as no earlier record can arrive instead of waiting for every table.
+Together with -maxSortDelay this bounds the memory usage of "start" and "stop" sorting.`)
+	maxSortDelay := set.Duration("maxSortDelay", 0, `Maximum time an active flow can hold back the output with -watermark (0 = unlimited).
+Records released after this delay can be out of order. The number of such records is reported with -stats`)
+	deterministic := set.Bool("deterministic", false, "Use the packet number of the first packet as flowId. Together with sorting, this results in identical output regardless of -n")
+	samplingStr := set.String("sampling", "none", `Sample packets with the given mode: "deterministic" (every n-th packet), "random" (every packet with probability 1/n),
+"hash" (every flow with probability 1/n based on a hash of the flow key), or "none"`)
+	samplingInterval := set.Uint("samplingInterval", 0, "Sampling interval n for -sampling")
+	samplingSeed := set.Uint64("samplingSeed", 0, "Seed for random and hash sampling")
+	scaleSampled := set.Bool("scaleSampled", false, "Scale octetTotalCount and packetTotalCount up by the sampling interval")
+	passiveDNS := set.Bool("passiveDNS", false, "Build a passive DNS cache from the observed DNS answers for the features _sourceDomainName and _destinationDomainName")
+	encoders := set.Uint("encoders", 1, "Number of parallel encoding workers per exporter. Only used by exporters supporting parallel encoding (e.g. csv)")
 	verbose := set.Bool("verbose", false, "Verbose output")
+	profileFeatures := set.Bool("profileFeatures", false, "Record time spent and number of events per feature and print a ranked report to stderr at the end. Slows down processing considerably")
+	profileCallgraph := set.String("profileCallgraph", "", "Write the callgraph annotated with the feature profile to this file at the end. Enables profiling like -profileFeatures")
+	checkpoint := set.String("checkpoint", "", `Write a checkpoint to this file on SIGUSR1, every -checkpointInterval, and on interrupt (which stops processing).
+The checkpoint contains the active flows and the source position and can be continued with -resume`)
+	checkpointInterval := set.Duration("checkpointInterval", 0, "Write a checkpoint with this period (wall clock time). Needs -checkpoint")
+	resume := set.String("resume", "", `Continue processing from this checkpoint. Specification, sources, and arguments must be the same as in the run that wrote the checkpoint.
+Only records exported after the checkpoint are written to the exporters`)
 
 	set.Parse(args)
 	if set.NArg() == 0 {
@@ -266,57 +389,143 @@ Additionally, stop might lead to very high memory usage (and longer execution ti
 	}
 
 	var recordList flows.RecordListMaker
-
-	var key []string
-	var bidirectional, allowZero bool
-	var opts flows.FlowOptions
-	first := true
+	if *profileFeatures || *profileCallgraph != "" {
+		recordList.EnableProfiling()
+	}
 
 	sortOrder, err := flows.AtoSort(*sortOrderStr)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	if *watermark && sortOrder == flows.SortTypeNone {
+		log.Fatalln("-watermark needs a sort order")
+	}
+	if *deterministic && sortOrder == flows.SortTypeNone {
+		log.Fatalln("-deterministic needs a sort order")
+	}
+	if *maxSortDelay != 0 && !*watermark {
+		log.Fatalln("-maxSortDelay needs -watermark")
+	}
+	samplingMode, err := flows.AtoSamplingMode(*samplingStr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if samplingMode != flows.SamplingNone && *samplingInterval == 0 {
+		log.Fatalln("-sampling needs -samplingInterval")
+	}
+	if samplingMode == flows.SamplingNone && (*samplingInterval != 0 || *scaleSampled) {
+		log.Fatalln("-samplingInterval and -scaleSampled need -sampling")
+	}
+	if *samplingInterval > math.MaxUint32 {
+		log.Fatalln("-samplingInterval must be smaller than 2^32")
+	}
+	if *checkpointInterval != 0 && *checkpoint == "" {
+		log.Fatalln("-checkpointInterval needs -checkpoint")
+	}
+	if *expireWindow && (*checkpoint != "" || *resume != "") {
+		log.Fatalln("-expireWindow can't be used with checkpoints")
+	}
+
+	groups := makeTableGroups(result)
+
+	numTables := 0
+	aggregated := false
+	for i := range groups {
+		groups[i].firstID = numTables
+		numTables += groups[i].tables(int(*numProcessing))
+		aggregated = aggregated || groups[i].from >= 0
+	}
+	if numTables > 256 {
+		log.Fatalf("Too many flow tables: %d different flow keys/options with %d processing tables each (at most 256 tables are possible)\n", len(groups), *numProcessing)
+	}
+	if aggregated && (*checkpoint != "" || *resume != "") {
+		log.Fatalln("-from can't be used with checkpoints")
+	}
 
 	for _, featureset := range result {
-		pipeline, err := flows.MakeExportPipeline(featureset.exporter, sortOrder, *numProcessing)
+		used := make(map[int]bool)
+		tables := 0
+		for _, feature := range featureset.featureset {
+			if !used[feature.group] {
+				used[feature.group] = true
+				tables += groups[feature.group].tables(int(*numProcessing))
+			}
+		}
+		for group := range used {
+			if groups[group].from >= 0 && len(used) > 1 && sortOrder != flows.SortTypeNone {
+				// the aggregated table would block the sorted output of the other tables
+				log.Fatalln("Sorted aggregated flows (-from) can only be exported together with feature specifications with the same key and options")
+			}
+		}
+		pipeline, err := flows.MakeExportPipeline(featureset.exporter, sortOrder, uint(tables), *encoders, *watermark)
 		if err != nil {
 			log.Fatalln(err)
 		}
 		for _, feature := range featureset.featureset {
-			sort.Strings(feature.key)
-			if first {
-				first = false
-				key = feature.key
-				bidirectional = feature.bidirectional
-				allowZero = feature.allowZero
-				opts = feature.opt
-			} else {
-				if !reflect.DeepEqual(key, feature.key) {
-					log.Fatalln("key_features of every flowspec must match")
-				}
-				if bidirectional != feature.bidirectional {
-					log.Fatalln("bidirectional of every flow must match")
-				}
-				if allowZero != feature.allowZero {
-					log.Fatalln("allowZero of every flow must match")
-				}
-				if !reflect.DeepEqual(opts, feature.opt) {
-					log.Fatalln("timeouts and per packet of every flow must match")
+			groups[feature.group].records = append(groups[feature.group].records, recordList.Len())
+			if feature.from >= 0 {
+				if feature.from >= recordList.Len() {
+					log.Fatalf("-from %d must refer to a preceding feature specification\n", feature.from)
 				}
+				err = recordList.AppendAggregateRecord(feature.from, feature.features, feature.control, feature.filter, pipeline, *verbose)
+			} else {
+				err = recordList.AppendRecord(feature.features, feature.control, feature.filter, pipeline, *verbose)
 			}
-			if err := recordList.AppendRecord(feature.features, feature.control, feature.filter, pipeline, *verbose); err != nil {
+			if err != nil {
 				log.Fatalf("Couldn't parse feature specification: %s\n", err)
 			}
 		}
 	}
 
-	keyselector := packet.MakeDynamicKeySelector(key, bidirectional, allowZero)
-
 	if cmd == "callgraph" {
 		recordList.CallGraph(os.Stdout)
 		return
 	}
 
+	sampling := flows.Sampling{
+		Mode:     samplingMode,
+		Interval: uint32(*samplingInterval),
+		Seed:     *samplingSeed,
+		Scale:    *scaleSampled,
+	}
+
+	for _, group := range groups {
+		if err := recordList.Subset(group.records).CheckSettings(group.opts.CustomSettings); err != nil {
+			log.Fatalf("Couldn't parse feature specification: %s\n", err)
+		}
+	}
+	if err := packet.CheckPassiveDNS(recordList, *passiveDNS); err != nil {
+		log.Fatalf("Couldn't parse feature specification: %s\n", err)
+	}
+
+	var flowtables []packet.EventTable
+	var aggregators []*flows.Aggregator
+	for _, group := range groups {
+		opts := group.opts
+		opts.SortOutput = sortOrder
+		opts.SortWatermark = *watermark
+		opts.Deterministic = *deterministic
+		opts.SortMaxDelay = flows.DateTimeNanoseconds(*maxSortDelay)
+
+		if group.from >= 0 {
+			// aggregated flows are fed by the exporter of their source records
+			table := flows.NewFlowTable(recordList.Subset(group.records), flows.NewRecordFlow, opts, false, uint8(group.firstID))
+			aggregator, err := recordList.Aggregate(group.from, table, group.key, flows.DateTimeNanoseconds(*flowExpire)*flows.SecondsInNanoseconds)
+			if err != nil {
+				log.Fatalf("Couldn't aggregate feature specification %d: %s\n", group.from, err)
+			}
+			aggregators = append(aggregators, aggregator)
+			continue
+		}
+
+		opts.WindowExpiry = *expireWindow
+		opts.Sampling = sampling
+
+		keyselector := packet.MakeDynamicKeySelector(group.key, group.bidirectional, group.allowZero)
+		flowtables = append(flowtables, packet.NewFlowTable(int(*numProcessing), group.firstID, recordList.Subset(group.records), packet.NewFlow, opts,
+			flows.DateTimeNanoseconds(*flowExpire)*flows.SecondsInNanoseconds, keyselector, *autoGC))
+	}
+
 	for _, exporter := range exporters {
 		exporter.Init()
 	}
@@ -325,32 +534,68 @@ Additionally, stop might lead to very high memory usage (and longer execution ti
 
 	flows.CleanupFeatures()
 	util.CleanupModules()
-	recordList.Clean()
+	if *profileCallgraph == "" {
+		recordList.Clean()
+	}
 
 	if !*autoGC {
 		debug.SetGCPercent(10000000) //We manually call gc after timing out flows; make that optional?
 	}
 
-	opts.WindowExpiry = *expireWindow
-	opts.SortOutput = sortOrder
-
-	flowtable := packet.NewFlowTable(int(*numProcessing), recordList, packet.NewFlow, opts,
-		flows.DateTimeNanoseconds(*flowExpire)*flows.SecondsInNanoseconds, keyselector, *autoGC)
+	engine := packet.NewEngine(int(*maxPacket), flowtables, filters, sources, labels)
+	engine.EnableSampling(sampling)
+	if *passiveDNS {
+		engine.EnablePassiveDNS(packet.NewPassiveDNS())
+	}
 
-	engine := packet.NewEngine(int(*maxPacket), flowtable, filters, sources, labels)
+	if *resume != "" {
+		if err := engine.Resume(*resume); err != nil {
+			log.Fatalln("Couldn't resume from checkpoint: ", err)
+		}
+	}
 
 	cancel := make(chan os.Signal, 1)
 	signal.Notify(cancel, os.Interrupt)
 
 	go func() {
 		<-cancel
+		if *checkpoint != "" {
+			log.Println("Writing checkpoint... (interrupt again to cancel without checkpoint)")
+			engine.Checkpoint(true)
+			<-cancel
+		}
 		log.Println("Canceling...")
 		engine.Stop()
 	}()
 
+	requestCheckpoint := make(chan os.Signal, 1)
+	var ticker *time.Ticker
+	if *checkpoint != "" {
+		engine.EnableCheckpoints(*checkpoint)
+		notifyCheckpoint(requestCheckpoint)
+		var tick <-chan time.Time
+		if *checkpointInterval != 0 {
+			ticker = time.NewTicker(*checkpointInterval)
+			tick = ticker.C
+		}
+		go func() {
+			for {
+				select {
+				case <-requestCheckpoint:
+				case <-tick:
+				}
+				engine.Checkpoint(false)
+			}
+		}()
+	}
+
 	stopped := engine.Run()
 
 	signal.Stop(cancel)
+	signal.Stop(requestCheckpoint)
+	if ticker != nil {
+		ticker.Stop()
+	}
 
 	engine.Finish()
 
@@ -365,7 +610,11 @@ Additionally, stop might lead to very high memory usage (and longer execution ti
 		f.Close()
 	}
 
-	flowtable.EOF(stopped)
+	if !engine.Suspended() {
+		for _, flowtable := range flowtables {
+			flowtable.EOF(stopped)
+		}
+	}
 
 	recordList.Flush()
 
@@ -374,6 +623,23 @@ Additionally, stop might lead to very high memory usage (and longer execution ti
 	}
 	if *printStats {
 		engine.PrintStats(os.Stderr)
-		flowtable.PrintStats(os.Stderr)
+		for _, flowtable := range flowtables {
+			flowtable.PrintStats(os.Stderr)
+		}
+		for _, aggregator := range aggregators {
+			aggregator.PrintStats(os.Stderr)
+		}
+		recordList.PrintStats(os.Stderr)
+	}
+	if *profileFeatures {
+		recordList.PrintProfile(os.Stderr)
+	}
+	if *profileCallgraph != "" {
+		f, err := os.Create(*profileCallgraph)
+		if err != nil {
+			log.Fatalln("could not create profile callgraph: ", err)
+		}
+		recordList.CallGraph(f)
+		f.Close()
 	}
 }